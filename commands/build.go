@@ -0,0 +1,316 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+	"alexi.ch/pcms/processor"
+	"alexi.ch/pcms/webserver"
+)
+
+// imageResizerURLPattern finds image resizer URLs in rendered HTML. The first
+// group is the part after the prefix: "<params>/<image-path>".
+var imageResizerURLPattern = regexp.MustCompile(`/_imageResizer/([^"'\s<>()?#]+)`)
+
+// Run the 'build' sub-command:
+// syncs the index, then exports all enabled pages and files as a static site
+// into outDir. The site is written below outDir/<server.prefix>, so that outDir
+// can be used as the document root of a plain static web server.
+func RunBuildCmd(config model.Config, outDir string) error {
+	start := time.Now()
+	if outDir == "" {
+		return fmt.Errorf("missing output dir (-out)")
+	}
+	outDir, err := filepath.Abs(outDir)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(config.SourcePath, outDir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("output dir %s must not be inside the source dir %s", outDir, config.SourcePath)
+	}
+
+	dbh, shouldCloseDBH, err := lib.GetDBHForConfig(config)
+	if err != nil {
+		return err
+	}
+	if shouldCloseDBH {
+		defer dbh.Close()
+	}
+
+	// export what is on disk right now, not what was indexed last time:
+	result, err := runIndex(config, dbh, false, nil)
+	if err != nil {
+		return err
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	siteFS := os.DirFS(config.SourcePath)
+	webRoot := filepath.Join(outDir, filepath.FromSlash(strings.TrimPrefix(path.Clean("/"+config.Server.Prefix), "/")))
+
+	handler := webserver.NewRequestHandler(config, nil, nil, siteFS, dbh)
+	baseURL := config.Server.BaseURL
+	if baseURL == "" {
+		fmt.Fprintf(os.Stderr, "warning: server.base_url is not set, feeds and the sitemap will contain URLs without host\n")
+	}
+
+	// pages and their feeds:
+	resizerPaths := make(map[string]bool)
+	feedCount := 0
+	pages := lib.NewPageQueryBuilder(dbh).Language(config.DefaultLanguage()).OrderBy("route", "asc").FetchAll()
+	for _, page := range pages {
+		rendered, err := renderBuildPage(config, siteFS, page, config.DefaultLanguage())
+		if err != nil {
+			return err
+		}
+		outFile := filepath.Join(webRoot, filepath.FromSlash(strings.TrimPrefix(page.Route, "/")), "index.html")
+		if err := writeBuildFile(outFile, rendered); err != nil {
+			return err
+		}
+		for _, match := range imageResizerURLPattern.FindAllStringSubmatch(string(rendered), -1) {
+			resizerPaths[match[1]] = true
+		}
+		fmt.Printf("type=page route=%s out=%s\n", page.Route, outFile)
+
+		for _, feedName := range webserver.FeedNames {
+			feedRoute := path.Join(page.Route, feedName)
+			content, _, found, err := handler.RenderFeed(feedRoute, "", baseURL)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			outFile := filepath.Join(webRoot, filepath.FromSlash(strings.TrimPrefix(feedRoute, "/")))
+			if err := writeBuildFile(outFile, content); err != nil {
+				return err
+			}
+			feedCount++
+			fmt.Printf("type=feed route=%s out=%s\n", feedRoute, outFile)
+		}
+	}
+
+	// on a multilingual site, the pages and their feeds are exported in every
+	// language below the language prefixes, too. The default language as well:
+	// LanguageUrl() links to its prefix, as the routes without prefix negotiate
+	// the language when served.
+	for _, language := range config.Languages {
+		for _, page := range lib.NewPageQueryBuilder(dbh).Language(language).OrderBy("route", "asc").FetchAll() {
+			rendered, err := renderBuildPage(config, siteFS, page, language)
+			if err != nil {
+				return err
+			}
+			outFile := filepath.Join(webRoot, language, filepath.FromSlash(strings.TrimPrefix(page.Route, "/")), "index.html")
+			if err := writeBuildFile(outFile, rendered); err != nil {
+				return err
+			}
+			for _, match := range imageResizerURLPattern.FindAllStringSubmatch(string(rendered), -1) {
+				resizerPaths[match[1]] = true
+			}
+			fmt.Printf("type=page route=%s language=%s out=%s\n", page.Route, language, outFile)
+
+			for _, feedName := range webserver.FeedNames {
+				feedRoute := path.Join(page.Route, feedName)
+				content, _, found, err := handler.RenderFeed(feedRoute, language, baseURL)
+				if err != nil {
+					return err
+				}
+				if !found {
+					continue
+				}
+				outFile := filepath.Join(webRoot, language, filepath.FromSlash(strings.TrimPrefix(feedRoute, "/")))
+				if err := writeBuildFile(outFile, content); err != nil {
+					return err
+				}
+				feedCount++
+				fmt.Printf("type=feed route=%s language=%s out=%s\n", feedRoute, language, outFile)
+			}
+		}
+	}
+
+	// sitemap and robots.txt, before the files, so that real files replace them:
+	sitemap, err := handler.RenderSitemap(baseURL)
+	if err != nil {
+		return err
+	}
+	generated := []struct {
+		route   string
+		content []byte
+	}{
+		{webserver.SitemapRoute, sitemap},
+		{webserver.RobotsTxtRoute, handler.RenderRobotsTxt(baseURL)},
+	}
+	for _, g := range generated {
+		outFile := filepath.Join(webRoot, filepath.FromSlash(strings.TrimPrefix(g.route, "/")))
+		if err := writeBuildFile(outFile, g.content); err != nil {
+			return err
+		}
+		fmt.Printf("type=generated route=%s out=%s\n", g.route, outFile)
+	}
+
+	// files:
+	files, err := dbh.GetEnabledFiles()
+	if err != nil {
+		return err
+	}
+	stylesheetCount := 0
+	for _, file := range files {
+		// .scss sources are exported compiled, by their .css route:
+		if processor.IsScssSource(file.FileName) {
+			exported, err := exportStylesheet(handler, dbh, webRoot, file)
+			if err != nil {
+				return err
+			}
+			if exported {
+				stylesheetCount++
+			}
+			continue
+		}
+		outFile := filepath.Join(webRoot, filepath.FromSlash(strings.TrimPrefix(file.Route, "/")))
+		if err := copyBuildFile(siteFS, strings.TrimPrefix(file.Route, "/"), outFile); err != nil {
+			return err
+		}
+		fmt.Printf("type=file route=%s out=%s\n", file.Route, outFile)
+	}
+
+	// resized images referenced by the pages. Broken resizer URLs would also
+	// fail on the live site, so they are reported, but do not fail the build:
+	sortedResizerPaths := make([]string, 0, len(resizerPaths))
+	for resizerPath := range resizerPaths {
+		sortedResizerPaths = append(sortedResizerPaths, resizerPath)
+	}
+	sort.Strings(sortedResizerPaths)
+	imageCount := 0
+	for _, resizerPath := range sortedResizerPaths {
+		outFile, err := resizedImageOutFile(webRoot, resizerPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping image resizer URL %s: %s\n", resizerPath, err)
+			continue
+		}
+		cachePath, _, err := handler.RenderResizedImage(resizerPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping image resizer URL %s: %s\n", resizerPath, err)
+			continue
+		}
+		if err := copyBuildFile(os.DirFS(filepath.Dir(cachePath)), filepath.Base(cachePath), outFile); err != nil {
+			return err
+		}
+		imageCount++
+		fmt.Printf("type=image url=/_imageResizer/%s out=%s\n", resizerPath, outFile)
+	}
+
+	fmt.Printf("Build done: %d pages, %d feeds, %d files, %d stylesheets, %d resized images (%s)\n", len(pages), feedCount, len(files)-stylesheetCount, stylesheetCount, imageCount, time.Since(start).Round(time.Millisecond))
+	fmt.Printf("Output: %s\n", webRoot)
+	return nil
+}
+
+// exportStylesheet compiles a .scss source file to its .css route. Partials
+// are not exported, and neither is a .scss file whose .css route is a real file
+// of the source tree. Returns whether a stylesheet was exported.
+func exportStylesheet(handler *webserver.RequestHandler, dbh *lib.DBH, webRoot string, file model.IndexedFile) (bool, error) {
+	if processor.IsScssPartial(file.FileName) {
+		return false, nil
+	}
+	cssRoute := strings.TrimSuffix(file.Route, path.Ext(file.Route)) + ".css"
+	if _, found, err := dbh.GetFileByRoute(cssRoute); err != nil || found {
+		return false, err
+	}
+	cachePath, found, err := handler.RenderStylesheet(cssRoute)
+	if err != nil {
+		return false, fmt.Errorf("compile %s: %w", file.Route, err)
+	}
+	if !found {
+		return false, nil
+	}
+	outFile := filepath.Join(webRoot, filepath.FromSlash(strings.TrimPrefix(cssRoute, "/")))
+	if err := copyBuildFile(os.DirFS(filepath.Dir(cachePath)), filepath.Base(cachePath), outFile); err != nil {
+		return false, err
+	}
+	fmt.Printf("type=stylesheet route=%s source=%s out=%s\n", cssRoute, file.Route, outFile)
+	return true, nil
+}
+
+// renderBuildPage renders a single page the same way the serve command does,
+// in the given language ("" on a site without languages).
+func renderBuildPage(config model.Config, siteFS fs.FS, page model.IndexedPage, language string) ([]byte, error) {
+	sourceFSPath := page.IndexFile
+	if page.Route != "/" {
+		sourceFSPath = path.Join(strings.TrimPrefix(page.Route, "/"), page.IndexFile)
+	}
+
+	renderer, err := processor.GetProcessor(page.IndexFile)
+	if err != nil {
+		return nil, err
+	}
+	pageInfo, err := processor.BuildPageTemplateVariables(page.Route, page.IndexFile, config, page)
+	if err != nil {
+		return nil, err
+	}
+	pageInfo.Language = language
+	rendered, err := renderer.RenderFileForServe(siteFS, sourceFSPath, pageInfo.AbsSourcePath, config, pageInfo, nil)
+	if err != nil {
+		return nil, fmt.Errorf("render page %s: %w", page.Route, err)
+	}
+	return rendered, nil
+}
+
+// resizedImageOutFile returns the output file of a resized image, so that a static
+// web server finds it under the same URL as the image resizer would serve it.
+func resizedImageOutFile(webRoot string, resizerPath string) (string, error) {
+	unescaped, err := url.PathUnescape(resizerPath)
+	if err != nil {
+		return "", err
+	}
+	params, imagePath, found := strings.Cut(unescaped, "/")
+	if !found || params == "" || params == "." || params == ".." {
+		return "", fmt.Errorf("malformed image resizer path")
+	}
+	imagePath = strings.TrimPrefix(path.Clean("/"+imagePath), "/")
+	return filepath.Join(webRoot, "_imageResizer", params, filepath.FromSlash(imagePath)), nil
+}
+
+func writeBuildFile(outFile string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(outFile), 0o777); err != nil {
+		return fmt.Errorf("create output dir for %s: %w", outFile, err)
+	}
+	if err := os.WriteFile(outFile, content, 0o666); err != nil {
+		return fmt.Errorf("write %s: %w", outFile, err)
+	}
+	return nil
+}
+
+func copyBuildFile(srcFS fs.FS, srcPath string, outFile string) error {
+	src, err := srcFS.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", srcPath, err)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(outFile), 0o777); err != nil {
+		return fmt.Errorf("create output dir for %s: %w", outFile, err)
+	}
+	dst, err := os.Create(outFile)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", outFile, err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("copy %s to %s: %w", srcPath, outFile, err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", outFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+)
+
+func TestMain(m *testing.M) {
+	// the commands work with the global DBH: keep it off the disk.
+	lib.SetDBPath(":memory:")
+	os.Exit(m.Run())
+}
+
+func TestRunBuildCmdLanguages(t *testing.T) {
+	sourceDir := t.TempDir()
+	sources := map[string]string{
+		"index.html":          "<p>Home</p>",
+		"index.de.html":       "<p>Startseite</p>",
+		"about/index.html":    "<p>About</p>",
+		"about/index.de.html": "<p>Über uns</p>",
+	}
+	for name, content := range sources {
+		file := filepath.Join(sourceDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := model.Config{SourcePath: sourceDir, Languages: []string{"en", "de"}}
+	config.Server.CacheDir = t.TempDir()
+	config.Server.BaseURL = "https://example.com"
+	config.Feeds = map[string]model.FeedConfig{"/": {}}
+	outDir := t.TempDir()
+	if err := RunBuildCmd(config, outDir); err != nil {
+		t.Fatalf("RunBuildCmd() error = %v", err)
+	}
+
+	// the default language is exported without and with its prefix, as
+	// LanguageUrl() links to the prefixed routes:
+	for outFile, want := range map[string]string{
+		"index.html":          "Home",
+		"about/index.html":    "About",
+		"en/index.html":       "Home",
+		"en/about/index.html": "About",
+		"de/index.html":       "Startseite",
+		"de/about/index.html": "Über uns",
+		// and so are the feeds, linking to the pages of their language:
+		"feed.json":    `"url": "https://example.com/about/"`,
+		"en/feed.json": `"url": "https://example.com/en/about/"`,
+		"de/feed.json": `"url": "https://example.com/de/about/"`,
+	} {
+		content, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(outFile)))
+		if err != nil {
+			t.Fatalf("read %s: %v", outFile, err)
+		}
+		if !strings.Contains(string(content), want) {
+			t.Fatalf("%s = %q, want it to contain %q", outFile, content, want)
+		}
+	}
+}
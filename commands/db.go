@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+)
+
+// RunDBMigrateCmd applies all pending schema migrations to the index DB.
+// With statusOnly, it lists all migrations and whether they are applied,
+// without changing the DB.
+func RunDBMigrateCmd(config model.Config, statusOnly bool) error {
+	dbh, err := lib.OpenDBHUnmigrated(config.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer dbh.Close()
+
+	if statusOnly {
+		return printMigrationStatus(dbh)
+	}
+
+	applied, err := dbh.Migrate()
+	for _, m := range applied {
+		fmt.Printf("applied migration %d: %s\n", m.Version, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		fmt.Printf("DB is up to date: %s (schema version %d)\n", dbh.Path(), dbh.SchemaVersion())
+	} else {
+		fmt.Printf("DB migrated: %s (schema version %d)\n", dbh.Path(), dbh.SchemaVersion())
+	}
+	return nil
+}
+
+func printMigrationStatus(dbh *lib.DBH) error {
+	version, err := dbh.DBVersion()
+	if err != nil {
+		return err
+	}
+	migrations, err := dbh.Migrations()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("DB: %s (schema version %d, current version %d)\n\n", dbh.Path(), version, dbh.SchemaVersion())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	pending := 0
+	for _, m := range migrations {
+		status := "applied"
+		if !m.Applied {
+			status = "pending"
+			pending++
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", m.Version, status, m.AppliedAt, m.Description)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if pending > 0 {
+		fmt.Printf("\n%d pending migration(s): run \"pcms db migrate\" to apply them\n", pending)
+	}
+	return nil
+}
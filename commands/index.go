@@ -2,6 +2,7 @@ package commands
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"time"
@@ -11,69 +12,119 @@ import (
 )
 
 // RunIndexCmd initializes or updates the local db schema.
-func RunIndexCmd(config model.Config) error {
+// By default, the index is synced incrementally: only new, changed and vanished
+// pages and files are written. With full, the index is wiped and rebuilt from scratch.
+func RunIndexCmd(config model.Config, full bool) error {
 	start := time.Now()
 
-	sourceFS, sourceLabel, err := getIndexSourceFS(config)
+	dbh, shouldClose, err := lib.GetDBHForConfig(config)
 	if err != nil {
 		return err
 	}
+	if shouldClose {
+		defer dbh.Close()
+	}
 
-	snapshot, err := lib.BuildIndexSnapshot(sourceFS, config.ExcludePatterns)
+	result, err := runIndex(config, dbh, full, os.Stdout)
 	if err != nil {
 		return err
 	}
 
-	dbh, shouldClose, err := lib.GetDBHForConfig(config)
+	duration := time.Since(start).Round(time.Millisecond)
+	if full {
+		fmt.Printf("Full index done: %d pages, %d files (%s)\n", result.Pages, result.Files, duration)
+	} else {
+		fmt.Printf("Index sync done: %d pages, %d files (%s)\n", result.Pages, result.Files, duration)
+		fmt.Printf("Pages: %d added, %d changed, %d removed\n", result.Stats.PagesAdded, result.Stats.PagesChanged, result.Stats.PagesRemoved)
+		fmt.Printf("Files: %d added, %d changed, %d removed\n", result.Stats.FilesAdded, result.Stats.FilesChanged, result.Stats.FilesRemoved)
+	}
+	fmt.Printf("DB: %s (schema version %d)\n", dbh.Path(), dbh.SchemaVersion())
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	return nil
+}
+
+type indexResult struct {
+	Pages int
+	Files int
+	// only filled for incremental runs
+	Stats model.IndexSyncStats
+	// ignored index files, and page aliases and redirect rules that hide an
+	// indexed route
+	Warnings []string
+}
+
+// runIndex walks the source tree and writes the result to the index in a single
+// index transaction. entryLog receives one line per indexed page and file (nil = quiet).
+func runIndex(config model.Config, dbh *lib.DBH, full bool, entryLog io.Writer) (indexResult, error) {
+	result := indexResult{}
+
+	sourceFS, sourceLabel, err := getIndexSourceFS(config)
 	if err != nil {
-		return err
+		return result, err
 	}
-	if shouldClose {
-		defer dbh.Close()
+
+	var known *model.IndexSnapshot
+	if !full {
+		known, err = dbh.LoadIndexSnapshot()
+		if err != nil {
+			return result, err
+		}
+	}
+
+	// The tree walk runs before the index transaction is opened, so that
+	// request-time writes are only blocked for the DB part of the run:
+	snapshot, err := lib.BuildIncrementalIndexSnapshot(sourceFS, config.ExcludePatterns, config.Images.ExifGPS, known, entryLog)
+	if err != nil {
+		return result, err
 	}
 
 	if err := dbh.BeginIndexRun(); err != nil {
-		return err
+		return result, err
 	}
 	defer dbh.RollbackIndexRun()
 
-	if err := dbh.CleanIndex(); err != nil {
-		return err
-	}
+	if full {
+		if err := dbh.CleanIndex(); err != nil {
+			return result, err
+		}
 
-	for _, page := range snapshot.Pages {
-		if err := dbh.ReplacePage(page); err != nil {
-			return err
+		for _, page := range snapshot.Pages {
+			if err := dbh.ReplacePage(page); err != nil {
+				return result, err
+			}
 		}
-	}
 
-	for _, file := range snapshot.Files {
-		if err := dbh.ReplaceFile(file); err != nil {
-			return err
+		for _, file := range snapshot.Files {
+			if err := dbh.ReplaceFile(file); err != nil {
+				return result, err
+			}
+		}
+	} else {
+		result.Stats, err = dbh.SyncIndex(snapshot)
+		if err != nil {
+			return result, err
 		}
 	}
 
 	if err := dbh.SetLastIndexInfo(sourceLabel, len(snapshot.Pages), len(snapshot.Files)); err != nil {
-		return err
+		return result, err
 	}
 
 	if err := dbh.CommitIndexRun(); err != nil {
-		return err
+		return result, err
 	}
 
-	pagesCount, err := dbh.CountPages()
-	if err != nil {
-		return err
-	}
-	filesCount, err := dbh.CountFiles()
+	collisions, err := dbh.RedirectCollisions(config.Redirects)
 	if err != nil {
-		return err
+		return result, err
 	}
+	result.Warnings = append(snapshot.Warnings, collisions...)
 
-	duration := time.Since(start).Round(time.Millisecond)
-	fmt.Printf("Index done: %d pages, %d files (%s)\n", pagesCount, filesCount, duration)
-	fmt.Printf("DB: %s (schema version %d)\n", dbh.Path(), dbh.SchemaVersion())
-	return nil
+	result.Pages = len(snapshot.Pages)
+	result.Files = len(snapshot.Files)
+	return result, nil
 }
 
 func getIndexSourceFS(config model.Config) (fs.FS, string, error) {
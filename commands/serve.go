@@ -1,11 +1,14 @@
 package commands
 
 import (
+	"context"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path"
+	"time"
 
 	"alexi.ch/pcms/lib"
 	"alexi.ch/pcms/logging"
@@ -19,6 +22,7 @@ func RunServeCmd(config model.Config) error {
 	var err error = nil
 	var dbh *lib.DBH
 	var shouldCloseDBH bool
+	var runIndexWorker, syncOnStart bool
 	// setup logging:
 	accessLogger := logging.NewLogger(
 		config.Server.Logging.Access.File,
@@ -32,6 +36,10 @@ func RunServeCmd(config model.Config) error {
 	)
 
 	log.Printf("Server is starting. System log goes to %s\n", errorLogger.Filepath)
+	if config.Server.BaseURL == "" {
+		log.Println("warning: server.base_url is not set, feeds and the sitemap will contain URLs without host")
+		errorLogger.Warning("server.base_url is not set, feeds and the sitemap will contain URLs without host")
+	}
 
 	// serve mode: either by the configured file folder,
 	// or serve the embedded doc:
@@ -44,7 +52,7 @@ func RunServeCmd(config model.Config) error {
 			os.RemoveAll(config.Server.CacheDir)
 		}
 
-		if err := RunIndexCmd(config); err != nil {
+		if err := RunIndexCmd(config, false); err != nil {
 			return err
 		}
 
@@ -66,7 +74,9 @@ func RunServeCmd(config model.Config) error {
 			return err
 		}
 
-		// Auto-index on first startup: if the pages table is empty, build the index.
+		// Auto-index on first startup: if the pages table is empty, build the index
+		// before serving, as there is no old index state to answer from. Otherwise,
+		// the background index worker syncs the index right after startup.
 		pageCount, err := dbh.CountPages()
 		if err != nil {
 			return err
@@ -74,10 +84,12 @@ func RunServeCmd(config model.Config) error {
 		if pageCount == 0 {
 			errorLogger.Info("No pages indexed yet — running initial index automatically")
 			log.Println("No pages indexed yet — running initial index automatically")
-			if err := RunIndexCmd(config); err != nil {
+			if err := RunIndexCmd(config, false); err != nil {
 				return err
 			}
 		}
+		syncOnStart = pageCount > 0
+		runIndexWorker = true
 	}
 	if shouldCloseDBH {
 		defer dbh.Close()
@@ -102,13 +114,93 @@ func RunServeCmd(config model.Config) error {
 		Handler: h,
 	}
 
+	listener, err := net.Listen("tcp", config.Server.Listen)
+	if err != nil {
+		errorLogger.Fatal("%s", err.Error())
+		return err
+	}
+
+	// The index worker starts once the server socket is bound, so that requests
+	// are answered from the existing index while it syncs:
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if runIndexWorker {
+		go indexWorker(ctx, config, dbh, errorLogger, syncOnStart)
+
+		if config.Server.Watch {
+			if err := startWatcher(ctx, config, dbh, errorLogger); err != nil {
+				errorLogger.Error("File watcher not started: %s", err.Error())
+			}
+		}
+	}
+
 	errorLogger.Info("Server starting, listening to %s", config.Server.Listen)
 	errorLogger.Info("Serving site from %s", config.SourcePath)
 	log.Printf("Server starting, listening to %s\n", config.Server.Listen)
 	log.Printf("Serving site from %s%s", config.Server.Listen, path.Join("/", config.Server.Prefix))
-	err = server.ListenAndServe()
+	err = server.Serve(listener)
 	if err != nil {
 		errorLogger.Fatal("%s", err.Error())
 	}
 	return err
 }
+
+// indexWorker keeps the index in sync with the source folder while serving:
+// once right away (if syncOnStart is set), then every server.index_interval.
+// Each sync only writes inside an index transaction, so requests keep being
+// served from the previous index state until the sync commits.
+// Errors are logged; the worker keeps running until ctx is cancelled.
+func indexWorker(ctx context.Context, config model.Config, dbh *lib.DBH, errorLogger *logging.Logger, syncOnStart bool) {
+	if syncOnStart {
+		backgroundIndexSync(config, dbh, errorLogger, true)
+	}
+
+	interval := config.Server.IndexInterval
+	if interval <= 0 {
+		return
+	}
+	errorLogger.Info("Background index sync runs every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			backgroundIndexSync(config, dbh, errorLogger, false)
+		}
+	}
+}
+
+// backgroundIndexSync runs a single index sync. Its warnings (e.g. alias
+// collisions) are logged after a sync with changes, and after the first sync on
+// start, as the index may have been synced by another command before.
+func backgroundIndexSync(config model.Config, dbh *lib.DBH, errorLogger *logging.Logger, onStart bool) {
+	start := time.Now()
+	errorLogger.Debug("Background index sync started")
+
+	result, err := runIndex(config, dbh, false, nil)
+	if err != nil {
+		errorLogger.Error("Background index sync failed: %s", err.Error())
+		return
+	}
+
+	stats := result.Stats
+	if onStart || stats != (model.IndexSyncStats{}) {
+		for _, warning := range result.Warnings {
+			errorLogger.Warning("%s", warning)
+		}
+	}
+	duration := time.Since(start).Round(time.Millisecond)
+	if stats == (model.IndexSyncStats{}) {
+		errorLogger.Debug("Background index sync done, no changes (%s)", duration)
+		return
+	}
+	errorLogger.Info(
+		"Background index sync done (%s): pages %d added, %d changed, %d removed; files %d added, %d changed, %d removed",
+		duration,
+		stats.PagesAdded, stats.PagesChanged, stats.PagesRemoved,
+		stats.FilesAdded, stats.FilesChanged, stats.FilesRemoved,
+	)
+}
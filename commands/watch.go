@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/logging"
+	"alexi.ch/pcms/model"
+	"alexi.ch/pcms/processor"
+	"alexi.ch/pcms/webserver"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is the quiet time after the last filesystem event before the
+// collected changes are applied. Editors and copy operations usually fire a
+// burst of events for a single change.
+const watchDebounce = 200 * time.Millisecond
+
+// startWatcher watches the source folder and the template folder (server.watch):
+// changes in the source folder are synced to the index right away, route by route,
+// and the affected cached pages are removed. A template change clears the parsed
+// templates and the whole page cache, as any page may be rendered with it.
+// The watcher runs until ctx is cancelled.
+func startWatcher(ctx context.Context, config model.Config, dbh *lib.DBH, errorLogger *logging.Logger) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("create file watcher: %w", err)
+	}
+
+	watchRoots := []string{config.SourcePath}
+	if config.TemplateDir != "" {
+		watchRoots = append(watchRoots, config.TemplateDir)
+	}
+	for _, root := range watchRoots {
+		if err := addWatchDirs(watcher, root); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	errorLogger.Info("Watching %s for changes", strings.Join(watchRoots, ", "))
+	go watchLoop(ctx, watcher, config, dbh, errorLogger)
+	return nil
+}
+
+// addWatchDirs adds the given dir and all its sub dirs to the watcher:
+// fsnotify does not watch recursively.
+func addWatchDirs(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("walk %s: %w", p, err)
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := watcher.Add(p); err != nil {
+			return fmt.Errorf("watch %s: %w", p, err)
+		}
+		return nil
+	})
+}
+
+func watchLoop(ctx context.Context, watcher *fsnotify.Watcher, config model.Config, dbh *lib.DBH, errorLogger *logging.Logger) {
+	defer watcher.Close()
+
+	pendingRoutes := make(map[string]bool)
+	templatesChanged := false
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			debounce.Stop()
+			return
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			errorLogger.Error("File watcher error: %s", err.Error())
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) && !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			errorLogger.Debug("File watcher event: %s %s", event.Op.String(), event.Name)
+
+			// new folders need their own watch. A moved-away folder keeps its
+			// watch under the old name, so it is dropped:
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := addWatchDirs(watcher, event.Name); err != nil {
+						errorLogger.Error("File watcher: %s", err.Error())
+					}
+				}
+			}
+			if event.Has(fsnotify.Rename) {
+				watcher.Remove(event.Name)
+			}
+
+			if route, ok := routeForWatchedPath(config.SourcePath, event.Name); ok {
+				pendingRoutes[route] = true
+			}
+			if _, ok := routeForWatchedPath(config.TemplateDir, event.Name); ok {
+				templatesChanged = true
+			}
+			debounce.Reset(watchDebounce)
+		case <-debounce.C:
+			for _, route := range collapseRoutes(pendingRoutes) {
+				watchSyncRoute(config, dbh, errorLogger, route)
+			}
+			pendingRoutes = make(map[string]bool)
+
+			if templatesChanged {
+				templatesChanged = false
+				processor.InvalidateTemplates()
+				if err := webserver.InvalidatePageCache(config.Server.CacheDir, "/"); err != nil {
+					errorLogger.Error("Clearing page cache after template change failed: %s", err.Error())
+				} else {
+					errorLogger.Info("Templates changed, page cache cleared")
+				}
+			}
+		}
+	}
+}
+
+// watchSyncRoute syncs a single changed route with the index and drops the
+// cached pages it affects.
+func watchSyncRoute(config model.Config, dbh *lib.DBH, errorLogger *logging.Logger, route string) {
+	stats, warnings, err := runRouteIndex(config, dbh, route)
+	if err != nil {
+		errorLogger.Error("Index sync of %s failed: %s", route, err.Error())
+		return
+	}
+	for _, warning := range warnings {
+		errorLogger.Warning("%s", warning)
+	}
+	if stats == (model.IndexSyncStats{}) {
+		errorLogger.Debug("Index sync of %s done, no changes", route)
+		return
+	}
+
+	errorLogger.Info(
+		"Index sync of %s done: pages %d added, %d changed, %d removed; files %d added, %d changed, %d removed",
+		route,
+		stats.PagesAdded, stats.PagesChanged, stats.PagesRemoved,
+		stats.FilesAdded, stats.FilesChanged, stats.FilesRemoved,
+	)
+	if err := webserver.InvalidatePageCache(config.Server.CacheDir, route); err != nil {
+		errorLogger.Error("Clearing page cache for %s failed: %s", route, err.Error())
+	}
+}
+
+// runRouteIndex syncs the given route of the source tree (and everything below it)
+// with the index in a single index transaction. Returns the warnings of the sync,
+// and the redirect collisions, if the sync changed the index.
+func runRouteIndex(config model.Config, dbh *lib.DBH, route string) (model.IndexSyncStats, []string, error) {
+	sourceFS, _, err := getIndexSourceFS(config)
+	if err != nil {
+		return model.IndexSyncStats{}, nil, err
+	}
+
+	if err := dbh.BeginIndexRun(); err != nil {
+		return model.IndexSyncStats{}, nil, err
+	}
+	defer dbh.RollbackIndexRun()
+
+	stats, warnings, err := dbh.SyncIndexRoute(sourceFS, config.ExcludePatterns, config.Images.ExifGPS, route)
+	if err != nil {
+		return stats, warnings, err
+	}
+	if err := dbh.CommitIndexRun(); err != nil {
+		return stats, warnings, err
+	}
+	if stats != (model.IndexSyncStats{}) {
+		collisions, err := dbh.RedirectCollisions(config.Redirects)
+		if err != nil {
+			return stats, warnings, err
+		}
+		warnings = append(warnings, collisions...)
+	}
+	return stats, warnings, nil
+}
+
+// routeForWatchedPath converts a path reported by the watcher to a route relative
+// to root. Returns false if the path is not located in root.
+func routeForWatchedPath(root string, name string) (string, bool) {
+	if root == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path.Clean("/" + filepath.ToSlash(rel)), true
+}
+
+// collapseRoutes returns the given routes in sorted order, without the ones
+// located below another given route, as syncing a route covers its sub-routes.
+func collapseRoutes(routes map[string]bool) []string {
+	sorted := make([]string, 0, len(routes))
+	for route := range routes {
+		sorted = append(sorted, route)
+	}
+	sort.Strings(sorted)
+
+	collapsed := make([]string, 0, len(sorted))
+	for _, route := range sorted {
+		covered := false
+		for _, parent := range collapsed {
+			if parent == "/" || strings.HasPrefix(route, parent+"/") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			collapsed = append(collapsed, route)
+		}
+	}
+	return collapsed
+}
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+)
+
+func TestRunRouteIndexReportsAliasCollisions(t *testing.T) {
+	dbh, err := lib.OpenDBH(filepath.Join(t.TempDir(), "pcms-watch-test.db"))
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	defer dbh.Close()
+
+	sourceDir := t.TempDir()
+	writeSource := func(name string, content string) {
+		t.Helper()
+		file := filepath.Join(sourceDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSource("index.md", "# Home\n")
+	writeSource("a/index.md", "---\naliases: [/x]\n---\n")
+	config := model.Config{SourcePath: sourceDir}
+	if _, err := runIndex(config, dbh, false, nil); err != nil {
+		t.Fatalf("runIndex() error = %v", err)
+	}
+
+	// a watched page that claims the alias of another page:
+	writeSource("b/index.md", "---\naliases: [/x]\n---\n")
+	_, warnings, err := runRouteIndex(config, dbh, "/b")
+	if err != nil {
+		t.Fatalf("runRouteIndex() error = %v", err)
+	}
+	if len(warnings) != 1 || warnings[0] != "alias /x is claimed by the pages /a, /b, it redirects to /a" {
+		t.Fatalf("runRouteIndex() warnings = %v", warnings)
+	}
+
+	// unchanged, so nothing to report again:
+	if _, warnings, err := runRouteIndex(config, dbh, "/b"); err != nil || len(warnings) != 0 {
+		t.Fatalf("runRouteIndex() without changes = %v, %v", warnings, err)
+	}
+}
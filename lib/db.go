@@ -1,26 +1,88 @@
 package lib
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"alexi.ch/pcms/model"
+	"alexi.ch/pcms/stdlib"
 	_ "modernc.org/sqlite"
 )
 
 const (
-	defaultDBPath   = "pcms.db"
-	currentDBSchema = 2
+	defaultDBPath = "pcms.db"
+
+	// scheduleDateLayout is the format of pages.publish_date and pages.expiry_date:
+	// UTC with a fixed precision, so that the dates compare as text with
+	// strftime('%Y-%m-%dT%H:%M:%fZ','now').
+	scheduleDateLayout = "2006-01-02T15:04:05.000Z"
+
+	// visiblePagesCondition selects the pages that are served right now: enabled,
+	// published and not expired. It is evaluated at query time, so scheduled pages
+	// appear and disappear without a re-index.
+	visiblePagesCondition = `enabled = 1
+		  AND (publish_date IS NULL OR publish_date <= strftime('%Y-%m-%dT%H:%M:%fZ','now'))
+		  AND (expiry_date IS NULL OR expiry_date > strftime('%Y-%m-%dT%H:%M:%fZ','now'))`
+
+	// visibleFilesCondition selects the files that are served right now: enabled
+	// files of visible pages.
+	visibleFilesCondition = `enabled = 1
+		  AND parent_page_route IN (SELECT route FROM pages WHERE ` + visiblePagesCondition + `)`
 )
 
+// pageVariantsTable replaces the pages table in queries for a language, given as
+// first argument: it holds the pages with the title, index file, metadata,
+// summary and language of their language variant, if they have one, and the pages as they
+// are otherwise. It is named "pages", so that all pages columns can be used as
+// usual.
+const pageVariantsTable = `(
+		SELECT p.route, p.parent_page_route,
+			coalesce(t.title, p.title) AS title,
+			coalesce(t.index_file, p.index_file) AS index_file,
+			p.enabled,
+			coalesce(t.metadata_json, p.metadata_json) AS metadata_json,
+			coalesce(t.metadata_sort_json, p.metadata_sort_json) AS metadata_sort_json,
+			p.publish_date, p.expiry_date,
+			coalesce(t.language, p.language) AS language,
+			coalesce(t.summary, p.summary) AS summary,
+			coalesce(t.word_count, p.word_count) AS word_count,
+			coalesce(t.reading_time, p.reading_time) AS reading_time,
+			p.created_at, p.updated_at, p.source_mtime
+		FROM pages p
+		LEFT JOIN page_translations t ON t.route = p.route AND t.language = ?
+	) AS pages`
+
+// fileSelectColumns are the files columns read into an IndexedFile, see scanFile.
+const fileSelectColumns = `route, parent_page_route, file_name, mime_type, file_size, enabled, metadata_json,
+		width, height, orientation, capture_date, camera, latitude, longitude`
+
 type DBH struct {
-	db      *sql.DB
-	path    string
+	db   *sql.DB
+	path string
+
+	// indexMu is held for the whole lifetime of an index run (BeginIndexRun until
+	// CommitIndexRun / RollbackIndexRun), and by WithIndexLock. It serializes all
+	// index writers; request-time readers never take it.
+	indexMu sync.Mutex
 	indexTx *sql.Tx
+	// set by the first write of the active index run that changed a row
+	indexChanged bool
+
+	// indexGeneration counts the index changes, see IndexGeneration.
+	indexGeneration atomic.Uint64
+	// dataVersionConn reads PRAGMA data_version, which changes with every commit
+	// of another connection, including those of other processes. nil for an
+	// in-memory DB, which no other connection can change.
+	dataVersionConn *sql.Conn
+	dataVersionMu   sync.Mutex
+	dataVersion     int64
 }
 
 var (
@@ -53,7 +115,26 @@ func GetDBH() (*DBH, error) {
 	return dbhInstance, nil
 }
 
+// OpenDBH opens the sqlite DB at dbPath and brings its schema up to date by
+// applying all pending migrations (see dbMigrations).
 func OpenDBH(dbPath string) (*DBH, error) {
+	h, err := OpenDBHUnmigrated(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := h.Migrate(); err != nil {
+		_ = h.Close()
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// OpenDBHUnmigrated opens the sqlite DB at dbPath without applying pending
+// migrations, e.g. to report them. Like OpenDBH, it refuses a DB whose schema is
+// newer than this pcms version (ErrDBSchemaTooNew).
+func OpenDBHUnmigrated(dbPath string) (*DBH, error) {
 	db, err := sql.Open("sqlite", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("open sqlite db: %w", err)
@@ -66,8 +147,21 @@ func OpenDBH(dbPath string) (*DBH, error) {
 	}
 
 	h := &DBH{db: db, path: dbPath}
-	if err := h.ensureSchema(); err != nil {
-		_ = db.Close()
+	// reserved before the connection is configured: the configured connection
+	// stays in the pool for all other queries.
+	if dbPath != ":memory:" {
+		if h.dataVersionConn, err = db.Conn(context.Background()); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("open data version connection: %w", err)
+		}
+	}
+	if err := h.configureConnection(); err != nil {
+		_ = h.Close()
+		return nil, err
+	}
+
+	if _, err := h.checkDBVersion(); err != nil {
+		_ = h.Close()
 		return nil, err
 	}
 
@@ -78,8 +172,10 @@ func (h *DBH) Path() string {
 	return h.path
 }
 
+// SchemaVersion returns the schema version of this pcms version, which is the
+// version of a DB opened with OpenDBH.
 func (h *DBH) SchemaVersion() int {
-	return currentDBSchema
+	return currentDBSchema()
 }
 
 func (h *DBH) Close() error {
@@ -91,20 +187,30 @@ func (h *DBH) Close() error {
 	if h.db == nil {
 		return nil
 	}
+	if h.dataVersionConn != nil {
+		_ = h.dataVersionConn.Close()
+	}
 	return h.db.Close()
 }
 
+// BeginIndexRun starts an index transaction. All index write methods (ReplacePage,
+// ReplaceFile, SyncIndex, ...) go through it until it is committed or rolled back.
+// If another index run is active, BeginIndexRun blocks until that one has finished.
+//
+// Request-time lookups (GetPageByRoute, PageQueryBuilder, ...) do not see the
+// transaction: they keep reading the last committed index state, and see all
+// changes of the run at once after CommitIndexRun.
 func (h *DBH) BeginIndexRun() error {
-	if h.indexTx != nil {
-		return fmt.Errorf("index transaction already active")
-	}
+	h.indexMu.Lock()
 
 	tx, err := h.db.Begin()
 	if err != nil {
+		h.indexMu.Unlock()
 		return fmt.Errorf("begin index transaction: %w", err)
 	}
 	if _, err := tx.Exec("PRAGMA foreign_keys = ON"); err != nil {
 		_ = tx.Rollback()
+		h.indexMu.Unlock()
 		return fmt.Errorf("enable foreign keys for index transaction: %w", err)
 	}
 
@@ -119,6 +225,11 @@ func (h *DBH) CommitIndexRun() error {
 
 	err := h.indexTx.Commit()
 	h.indexTx = nil
+	if err == nil && h.indexChanged {
+		h.indexGeneration.Add(1)
+	}
+	h.indexChanged = false
+	h.indexMu.Unlock()
 	if err != nil {
 		return fmt.Errorf("commit index transaction: %w", err)
 	}
@@ -133,6 +244,8 @@ func (h *DBH) RollbackIndexRun() error {
 
 	err := h.indexTx.Rollback()
 	h.indexTx = nil
+	h.indexChanged = false
+	h.indexMu.Unlock()
 	if err != nil && err != sql.ErrTxDone {
 		return fmt.Errorf("rollback index transaction: %w", err)
 	}
@@ -140,11 +253,32 @@ func (h *DBH) RollbackIndexRun() error {
 	return nil
 }
 
+// WithIndexLock runs fn outside of any index run: it waits for an active run to
+// finish, and keeps new runs from starting until fn returns. Use it for single
+// index writes at request time, e.g. ReplacePage for a re-indexed stale page.
+func (h *DBH) WithIndexLock(fn func() error) error {
+	h.indexMu.Lock()
+	defer h.indexMu.Unlock()
+	return fn()
+}
+
 func (h *DBH) CleanIndex() error {
 	if _, err := h.execIndex("DELETE FROM files"); err != nil {
 		return fmt.Errorf("clean files index: %w", err)
 	}
 
+	if _, err := h.execIndex("DELETE FROM page_texts"); err != nil {
+		return fmt.Errorf("clean page texts index: %w", err)
+	}
+
+	if _, err := h.execIndex("DELETE FROM redirects"); err != nil {
+		return fmt.Errorf("clean redirects index: %w", err)
+	}
+
+	if _, err := h.execIndex("DELETE FROM page_translations"); err != nil {
+		return fmt.Errorf("clean page translations index: %w", err)
+	}
+
 	if _, err := h.execIndex("DELETE FROM pages"); err != nil {
 		return fmt.Errorf("clean pages index: %w", err)
 	}
@@ -154,14 +288,24 @@ func (h *DBH) CleanIndex() error {
 
 func (h *DBH) ReplacePage(record model.IndexedPage) error {
 	stmt := `
-		INSERT INTO pages (route, parent_page_route, title, index_file, enabled, metadata_json)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO pages (route, parent_page_route, title, index_file, enabled, metadata_json, metadata_sort_json, publish_date, expiry_date, language, summary, word_count, reading_time, source_mtime, source_size, source_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(route) DO UPDATE SET
 			parent_page_route = excluded.parent_page_route,
 			title = excluded.title,
 			index_file = excluded.index_file,
 			enabled = excluded.enabled,
 			metadata_json = excluded.metadata_json,
+			metadata_sort_json = excluded.metadata_sort_json,
+			publish_date = excluded.publish_date,
+			expiry_date = excluded.expiry_date,
+			language = excluded.language,
+			summary = excluded.summary,
+			word_count = excluded.word_count,
+			reading_time = excluded.reading_time,
+			source_mtime = excluded.source_mtime,
+			source_size = excluded.source_size,
+			source_hash = excluded.source_hash,
 			updated_at = strftime('%Y-%m-%dT%H:%M:%fZ','now')
 	`
 
@@ -169,24 +313,98 @@ func (h *DBH) ReplacePage(record model.IndexedPage) error {
 	if err != nil {
 		return fmt.Errorf("marshal metadata for page %s: %w", record.Route, err)
 	}
+	metadataSortJSON, err := marshalMetadata(sortableMetadata(record.Metadata))
+	if err != nil {
+		return fmt.Errorf("marshal sortable metadata for page %s: %w", record.Route, err)
+	}
 
-	if _, err := h.execIndex(stmt, record.Route, record.ParentPageRoute, record.Title, record.IndexFile, record.Enabled, metadataJSON); err != nil {
+	if _, err := h.execIndex(stmt, record.Route, record.ParentPageRoute, record.Title, record.IndexFile, record.Enabled, metadataJSON, metadataSortJSON,
+		formatScheduleDate(record.PublishDate), formatScheduleDate(record.ExpiryDate), record.Language,
+		record.Summary, record.WordCount, record.ReadingTime,
+		formatSourceModTime(record.SourceModTime), record.SourceSize, record.SourceHash); err != nil {
 		return fmt.Errorf("replace page %s: %w", record.Route, err)
 	}
 
+	if _, err := h.execIndex("DELETE FROM page_translations WHERE route = ?", record.Route); err != nil {
+		return fmt.Errorf("delete translations of page %s: %w", record.Route, err)
+	}
+	translationStmt := `
+		INSERT INTO page_translations (route, language, title, index_file, metadata_json, metadata_sort_json, summary, word_count, reading_time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	// the full-text entry of the page covers all its languages:
+	titles := []string{record.Title}
+	texts := []string{record.PlainText}
+	for _, translation := range record.Translations {
+		metadataJSON, err := marshalMetadata(translation.Metadata)
+		if err != nil {
+			return fmt.Errorf("marshal metadata for page %s (%s): %w", record.Route, translation.Language, err)
+		}
+		metadataSortJSON, err := marshalMetadata(sortableMetadata(translation.Metadata))
+		if err != nil {
+			return fmt.Errorf("marshal sortable metadata for page %s (%s): %w", record.Route, translation.Language, err)
+		}
+		if _, err := h.execIndex(translationStmt, record.Route, translation.Language, translation.Title, translation.IndexFile, metadataJSON, metadataSortJSON,
+			translation.Summary, translation.WordCount, translation.ReadingTime); err != nil {
+			return fmt.Errorf("replace translation %s of page %s: %w", translation.Language, record.Route, err)
+		}
+		titles = append(titles, translation.Title)
+		texts = append(texts, translation.PlainText)
+	}
+
+	textStmt := `
+		INSERT INTO page_texts (route, title, body)
+		VALUES (?, ?, ?)
+		ON CONFLICT(route) DO UPDATE SET
+			title = excluded.title,
+			body = excluded.body
+	`
+	if _, err := h.execIndex(textStmt, record.Route, strings.Join(titles, "\n"), strings.Join(texts, "\n\n")); err != nil {
+		return fmt.Errorf("replace full-text entry of page %s: %w", record.Route, err)
+	}
+
+	if _, err := h.execIndex("DELETE FROM redirects WHERE target_route = ?", record.Route); err != nil {
+		return fmt.Errorf("delete aliases of page %s: %w", record.Route, err)
+	}
+	aliasStmt := `
+		INSERT INTO redirects (source_route, target_route)
+		VALUES (?, ?)
+		ON CONFLICT(source_route, target_route) DO NOTHING
+	`
+	for _, alias := range record.Aliases {
+		if alias == record.Route {
+			continue
+		}
+		if _, err := h.execIndex(aliasStmt, alias, record.Route); err != nil {
+			return fmt.Errorf("replace alias %s of page %s: %w", alias, record.Route, err)
+		}
+	}
+
 	return nil
 }
 
 func (h *DBH) ReplaceFile(record model.IndexedFile) error {
 	stmt := `
-		INSERT INTO files (route, parent_page_route, file_name, mime_type, file_size, enabled)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO files (route, parent_page_route, file_name, mime_type, file_size, enabled, metadata_json, metadata_sort_json,
+			width, height, orientation, capture_date, camera, latitude, longitude, source_mtime, source_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(route) DO UPDATE SET
 			parent_page_route = excluded.parent_page_route,
 			file_name = excluded.file_name,
 			mime_type = excluded.mime_type,
 			file_size = excluded.file_size,
 			enabled = excluded.enabled,
+			metadata_json = excluded.metadata_json,
+			metadata_sort_json = excluded.metadata_sort_json,
+			width = excluded.width,
+			height = excluded.height,
+			orientation = excluded.orientation,
+			capture_date = excluded.capture_date,
+			camera = excluded.camera,
+			latitude = excluded.latitude,
+			longitude = excluded.longitude,
+			source_mtime = excluded.source_mtime,
+			source_hash = excluded.source_hash,
 			updated_at = strftime('%Y-%m-%dT%H:%M:%fZ','now')
 	`
 
@@ -194,13 +412,72 @@ func (h *DBH) ReplaceFile(record model.IndexedFile) error {
 	if !record.Enabled {
 		enabled = 0
 	}
-	if _, err := h.execIndex(stmt, record.Route, record.ParentPageRoute, record.FileName, record.MimeType, record.FileSize, enabled); err != nil {
+	metadataJSON, err := marshalMetadata(record.Metadata)
+	if err != nil {
+		return fmt.Errorf("marshal metadata for file %s: %w", record.Route, err)
+	}
+	metadataSortJSON, err := marshalMetadata(sortableMetadata(record.Metadata))
+	if err != nil {
+		return fmt.Errorf("marshal sortable metadata for file %s: %w", record.Route, err)
+	}
+	var latitude, longitude any
+	if record.HasLocation {
+		latitude, longitude = record.Latitude, record.Longitude
+	}
+	if _, err := h.execIndex(stmt, record.Route, record.ParentPageRoute, record.FileName, record.MimeType, record.FileSize, enabled,
+		metadataJSON, metadataSortJSON, record.Width, record.Height, record.Orientation, formatScheduleDate(record.CaptureDate), record.Camera,
+		latitude, longitude, formatSourceModTime(record.SourceModTime), record.SourceHash); err != nil {
 		return fmt.Errorf("replace file %s: %w", record.Route, err)
 	}
 
 	return nil
 }
 
+// updatePageSourceModTime stores the source mtime of an indexed page whose
+// content did not change. Unlike ReplacePage, it keeps its updated_at.
+func (h *DBH) updatePageSourceModTime(route string, modTime time.Time) error {
+	if _, err := h.execIndex("UPDATE pages SET source_mtime = ? WHERE route = ?", formatSourceModTime(modTime), route); err != nil {
+		return fmt.Errorf("update source mtime of page %s: %w", route, err)
+	}
+	return nil
+}
+
+// updateFileSourceModTime stores the source mtime of an indexed file whose
+// content did not change. Unlike ReplaceFile, it keeps its updated_at.
+func (h *DBH) updateFileSourceModTime(route string, modTime time.Time) error {
+	if _, err := h.execIndex("UPDATE files SET source_mtime = ? WHERE route = ?", formatSourceModTime(modTime), route); err != nil {
+		return fmt.Errorf("update source mtime of file %s: %w", route, err)
+	}
+	return nil
+}
+
+// DeletePage removes a single page from the index. Files of the page are removed
+// by the foreign key cascade, child pages lose their parent reference.
+func (h *DBH) DeletePage(route string) error {
+	// page_texts, page_translations and redirects cascade as well, but only with foreign keys enabled on the connection:
+	if _, err := h.execIndex("DELETE FROM page_texts WHERE route = ?", route); err != nil {
+		return fmt.Errorf("delete full-text entry of page %s: %w", route, err)
+	}
+	if _, err := h.execIndex("DELETE FROM page_translations WHERE route = ?", route); err != nil {
+		return fmt.Errorf("delete translations of page %s: %w", route, err)
+	}
+	if _, err := h.execIndex("DELETE FROM redirects WHERE target_route = ?", route); err != nil {
+		return fmt.Errorf("delete aliases of page %s: %w", route, err)
+	}
+	if _, err := h.execIndex("DELETE FROM pages WHERE route = ?", route); err != nil {
+		return fmt.Errorf("delete page %s: %w", route, err)
+	}
+	return nil
+}
+
+// DeleteFile removes a single file from the index.
+func (h *DBH) DeleteFile(route string) error {
+	if _, err := h.execIndex("DELETE FROM files WHERE route = ?", route); err != nil {
+		return fmt.Errorf("delete file %s: %w", route, err)
+	}
+	return nil
+}
+
 func (h *DBH) SetLastIndexInfo(source string, pageCount int, fileCount int) error {
 	stmt := `
 		UPDATE app_settings
@@ -224,7 +501,7 @@ func (h *DBH) SetLastIndexInfo(source string, pageCount int, fileCount int) erro
 }
 
 func (h *DBH) CountPages() (int, error) {
-	row := h.queryRowIndex("SELECT COUNT(1) FROM pages")
+	row := h.db.QueryRow("SELECT COUNT(1) FROM pages")
 	var count int
 	if err := row.Scan(&count); err != nil {
 		return 0, fmt.Errorf("count pages: %w", err)
@@ -233,7 +510,7 @@ func (h *DBH) CountPages() (int, error) {
 }
 
 func (h *DBH) CountFiles() (int, error) {
-	row := h.queryRowIndex("SELECT COUNT(1) FROM files")
+	row := h.db.QueryRow("SELECT COUNT(1) FROM files")
 	var count int
 	if err := row.Scan(&count); err != nil {
 		return 0, fmt.Errorf("count files: %w", err)
@@ -243,17 +520,34 @@ func (h *DBH) CountFiles() (int, error) {
 
 func (h *DBH) GetPageByRoute(route string) (model.IndexedPage, bool, error) {
 	stmt := `
-		SELECT route, parent_page_route, title, index_file, enabled, metadata_json, updated_at
+		SELECT route, parent_page_route, title, index_file, enabled, metadata_json, updated_at, publish_date, expiry_date, language, summary, word_count, reading_time, source_mtime
 		FROM pages
 		WHERE route = ?
 	`
+	return h.getPage(route, stmt, route)
+}
+
+// GetPageVariant returns the page at route in the given language: with the
+// title, index file, metadata and summary of its language variant, or as it is, if it
+// has no variant in that language.
+func (h *DBH) GetPageVariant(route string, language string) (model.IndexedPage, bool, error) {
+	stmt := `
+		SELECT route, parent_page_route, title, index_file, enabled, metadata_json, updated_at, publish_date, expiry_date, language, summary, word_count, reading_time, source_mtime
+		FROM ` + pageVariantsTable + `
+		WHERE route = ?
+	`
+	return h.getPage(route, stmt, language, route)
+}
 
+// getPage runs a query for a single page with the standard pages column set.
+func (h *DBH) getPage(route string, stmt string, args ...any) (model.IndexedPage, bool, error) {
 	var record model.IndexedPage
 	var parentRoute sql.NullString
 	var metadataJSON string
-	var updatedAtStr string
+	var updatedAtStr, sourceModTime string
+	var publishDate, expiryDate sql.NullString
 	var enabledInt int
-	err := h.queryRowIndex(stmt, route).Scan(
+	err := h.db.QueryRow(stmt, args...).Scan(
 		&record.Route,
 		&parentRoute,
 		&record.Title,
@@ -261,6 +555,13 @@ func (h *DBH) GetPageByRoute(route string) (model.IndexedPage, bool, error) {
 		&enabledInt,
 		&metadataJSON,
 		&updatedAtStr,
+		&publishDate,
+		&expiryDate,
+		&record.Language,
+		&record.Summary,
+		&record.WordCount,
+		&record.ReadingTime,
+		&sourceModTime,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -284,48 +585,58 @@ func (h *DBH) GetPageByRoute(route string) (model.IndexedPage, bool, error) {
 	if err != nil {
 		return model.IndexedPage{}, false, fmt.Errorf("parse updated_at for page %s: %w", route, err)
 	}
+	if record.SourceModTime, err = parseSourceModTime(sourceModTime); err != nil {
+		return model.IndexedPage{}, false, fmt.Errorf("parse source_mtime for page %s: %w", route, err)
+	}
+
+	if record.PublishDate, record.ExpiryDate, err = parseScheduleDates(publishDate, expiryDate); err != nil {
+		return model.IndexedPage{}, false, fmt.Errorf("parse schedule dates for page %s: %w", route, err)
+	}
 
 	return record, true, nil
 }
 
 func (h *DBH) GetFileByRoute(route string) (model.IndexedFile, bool, error) {
 	stmt := `
-		SELECT route, parent_page_route, file_name, mime_type, file_size, enabled
+		SELECT ` + fileSelectColumns + `
 		FROM files
 		WHERE route = ?
 	`
 
-	var record model.IndexedFile
-	var enabledInt int
-	err := h.queryRowIndex(stmt, route).Scan(
-		&record.Route,
-		&record.ParentPageRoute,
-		&record.FileName,
-		&record.MimeType,
-		&record.FileSize,
-		&enabledInt,
-	)
+	record, err := scanFile(h.db.QueryRow(stmt, route))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return model.IndexedFile{}, false, nil
 		}
 		return model.IndexedFile{}, false, fmt.Errorf("query file by route %s: %w", route, err)
 	}
-	record.Enabled = enabledInt != 0
 
 	return record, true, nil
 }
 
-func (h *DBH) GetChildPages(route string) ([]model.IndexedPage, error) {
-	stmt := `
-		SELECT route, parent_page_route, title, index_file, enabled, metadata_json
-		FROM pages
+// childPagesQuery selects the visible child pages of a page in a language, see
+// GetChildPages.
+const childPagesQuery = `
+		SELECT route, parent_page_route, title, index_file, enabled, metadata_json, publish_date, expiry_date, language, summary, word_count, reading_time
+		FROM ` + pageVariantsTable + `
+		WHERE parent_page_route = ?
+		  AND ` + visiblePagesCondition + `
+		ORDER BY route
+	`
+
+// childFilesQuery selects the enabled files of a page, see GetChildFiles.
+const childFilesQuery = `
+		SELECT ` + fileSelectColumns + `
+		FROM files
 		WHERE parent_page_route = ?
 		  AND enabled = 1
 		ORDER BY route
 	`
 
-	rows, err := h.queryIndex(stmt, route)
+// GetChildPages returns the visible child pages of the page at route, in the
+// given language (see GetPageVariant). Pass "" for the pages as they are.
+func (h *DBH) GetChildPages(route string, language string) ([]model.IndexedPage, error) {
+	rows, err := h.db.Query(childPagesQuery, language, route)
 	if err != nil {
 		return nil, fmt.Errorf("query child pages for %s: %w", route, err)
 	}
@@ -336,6 +647,7 @@ func (h *DBH) GetChildPages(route string) ([]model.IndexedPage, error) {
 		var record model.IndexedPage
 		var parentRoute sql.NullString
 		var metadataJSON string
+		var publishDate, expiryDate sql.NullString
 		var enabledInt int
 		if err := rows.Scan(
 			&record.Route,
@@ -344,6 +656,12 @@ func (h *DBH) GetChildPages(route string) ([]model.IndexedPage, error) {
 			&record.IndexFile,
 			&enabledInt,
 			&metadataJSON,
+			&publishDate,
+			&expiryDate,
+			&record.Language,
+			&record.Summary,
+			&record.WordCount,
+			&record.ReadingTime,
 		); err != nil {
 			return nil, fmt.Errorf("scan child page for %s: %w", route, err)
 		}
@@ -357,6 +675,9 @@ func (h *DBH) GetChildPages(route string) ([]model.IndexedPage, error) {
 		if err != nil {
 			return nil, fmt.Errorf("unmarshal metadata for child page %s: %w", record.Route, err)
 		}
+		if record.PublishDate, record.ExpiryDate, err = parseScheduleDates(publishDate, expiryDate); err != nil {
+			return nil, fmt.Errorf("parse schedule dates for child page %s: %w", record.Route, err)
+		}
 		pages = append(pages, record)
 	}
 
@@ -369,45 +690,74 @@ func (h *DBH) GetChildPages(route string) ([]model.IndexedPage, error) {
 
 
 func (h *DBH) GetChildFiles(route string) ([]model.IndexedFile, error) {
+	rows, err := h.db.Query(childFilesQuery, route)
+	if err != nil {
+		return nil, fmt.Errorf("query child files for %s: %w", route, err)
+	}
+	defer rows.Close()
+
+	var files []model.IndexedFile
+	for rows.Next() {
+		record, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan child file for %s: %w", route, err)
+		}
+		files = append(files, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate child files for %s: %w", route, err)
+	}
+
+	return files, nil
+}
+
+// GetEnabledFiles returns all enabled files of currently visible pages, ordered
+// by route.
+func (h *DBH) GetEnabledFiles() ([]model.IndexedFile, error) {
 	stmt := `
-		SELECT route, parent_page_route, file_name, mime_type, file_size, enabled
+		SELECT ` + fileSelectColumns + `
 		FROM files
-		WHERE parent_page_route = ?
-		  AND enabled = 1
+		WHERE ` + visibleFilesCondition + `
 		ORDER BY route
 	`
 
-	rows, err := h.queryIndex(stmt, route)
+	rows, err := h.db.Query(stmt)
 	if err != nil {
-		return nil, fmt.Errorf("query child files for %s: %w", route, err)
+		return nil, fmt.Errorf("query enabled files: %w", err)
 	}
 	defer rows.Close()
 
 	var files []model.IndexedFile
 	for rows.Next() {
-		var record model.IndexedFile
-		var enabledInt int
-		if err := rows.Scan(
-			&record.Route,
-			&record.ParentPageRoute,
-			&record.FileName,
-			&record.MimeType,
-			&record.FileSize,
-			&enabledInt,
-		); err != nil {
-			return nil, fmt.Errorf("scan child file for %s: %w", route, err)
+		record, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan enabled file: %w", err)
 		}
-		record.Enabled = enabledInt != 0
 		files = append(files, record)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate child files for %s: %w", route, err)
+		return nil, fmt.Errorf("iterate enabled files: %w", err)
 	}
 
 	return files, nil
 }
 
+// GetPageText returns the plain text of the given page, as stored in the
+// full-text index. Returns an empty string if the page has no text.
+func (h *DBH) GetPageText(route string) (string, error) {
+	var body string
+	err := h.db.QueryRow("SELECT body FROM page_texts WHERE route = ?", route).Scan(&body)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("query page text for %s: %w", route, err)
+	}
+	return body, nil
+}
+
 // SetPageEnabled updates the enabled flag for the given page and all its direct
 // files. When enabled is false, all descendant pages and their files are also
 // disabled (always recursive for disable). When enabled is true, descendants are
@@ -416,6 +766,9 @@ func (h *DBH) GetChildFiles(route string) ([]model.IndexedFile, error) {
 //
 // Returns an error if the page does not exist.
 func (h *DBH) SetPageEnabled(route string, enabled bool, recursive bool) error {
+	h.indexMu.Lock()
+	defer h.indexMu.Unlock()
+
 	_, found, err := h.GetPageByRoute(route)
 	if err != nil {
 		return err
@@ -476,10 +829,12 @@ func (h *DBH) SetPageEnabled(route string, enabled bool, recursive bool) error {
 		}
 	}
 
+	h.indexGeneration.Add(1)
 	return nil
 }
 
-func (h *DBH) ensureSchema() error {
+// configureConnection sets the sqlite pragmas pcms relies on.
+func (h *DBH) configureConnection() error {
 	if _, err := h.db.Exec("PRAGMA foreign_keys = ON"); err != nil {
 		return fmt.Errorf("enable foreign keys: %w", err)
 	}
@@ -492,250 +847,204 @@ func (h *DBH) ensureSchema() error {
 		return fmt.Errorf("set synchronous mode: %w", err)
 	}
 
-	if err := h.ensurePagesTable(); err != nil {
-		return err
-	}
-
-	if err := h.ensureFilesTable(); err != nil {
-		return err
-	}
-
-	if err := h.ensureAppSettingsTable(); err != nil {
-		return err
-	}
-
-	if err := h.ensureDBVersionSetting(); err != nil {
-		return err
-	}
-
 	return nil
 }
 
-func (h *DBH) ensurePagesTable() error {
-	stmt := `
-		CREATE TABLE IF NOT EXISTS pages (
-			route             TEXT PRIMARY KEY,
-			parent_page_route TEXT NULL REFERENCES pages(route)
-				ON UPDATE CASCADE
-				ON DELETE SET NULL,
-			title             TEXT NOT NULL DEFAULT '',
-			index_file        TEXT NOT NULL DEFAULT '',
-			enabled           INTEGER NOT NULL DEFAULT 1,
-			metadata_json     TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(metadata_json)),
-			created_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
-			updated_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
-		)
-	`
-
-	if _, err := h.db.Exec(stmt); err != nil {
-		return fmt.Errorf("create pages table: %w", err)
-	}
-
-	if err := h.ensureTableColumn("pages", "parent_page_route", "TEXT NULL REFERENCES pages(route) ON UPDATE CASCADE ON DELETE SET NULL"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("pages", "title", "TEXT NOT NULL DEFAULT ''"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("pages", "index_file", "TEXT NOT NULL DEFAULT ''"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("pages", "enabled", "INTEGER NOT NULL DEFAULT 1"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("pages", "metadata_json", "TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(metadata_json))"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("pages", "created_at", "TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("pages", "updated_at", "TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))"); err != nil {
-		return err
+func (h *DBH) execIndex(query string, args ...any) (sql.Result, error) {
+	if h.indexTx != nil {
+		result, err := h.indexTx.Exec(query, args...)
+		if err == nil && rowsChanged(result) {
+			h.indexChanged = true
+		}
+		return result, err
 	}
 
-	if _, err := h.db.Exec("CREATE INDEX IF NOT EXISTS idx_pages_parent_page_route ON pages(parent_page_route)"); err != nil {
-		return fmt.Errorf("create pages parent index: %w", err)
+	result, err := h.db.Exec(query, args...)
+	if err == nil && rowsChanged(result) {
+		h.indexGeneration.Add(1)
 	}
-
-	return nil
+	return result, err
 }
 
-func (h *DBH) ensureFilesTable() error {
-	stmt := `
-		CREATE TABLE IF NOT EXISTS files (
-			route             TEXT PRIMARY KEY,
-			parent_page_route TEXT NOT NULL REFERENCES pages(route)
-				ON UPDATE CASCADE
-				ON DELETE CASCADE,
-			file_name         TEXT NOT NULL,
-			mime_type         TEXT NOT NULL DEFAULT 'application/octet-stream',
-			file_size         INTEGER NOT NULL DEFAULT 0 CHECK (file_size >= 0),
-			enabled           INTEGER NOT NULL DEFAULT 1,
-			created_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
-			updated_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
-		)
-	`
+func rowsChanged(result sql.Result) bool {
+	n, err := result.RowsAffected()
+	return err != nil || n > 0
+}
 
-	if _, err := h.db.Exec(stmt); err != nil {
-		return fmt.Errorf("create files table: %w", err)
-	}
+// IndexGeneration returns a number that changes whenever the index changes: with
+// every index run or single index write that changed a row, and with the commits
+// of other processes (e.g. pcms disable-page while pcms serve runs). As long as
+// it is the same, all index queries return the same result, except for pages
+// that are published or expire in between (see NextScheduleChange).
+func (h *DBH) IndexGeneration() uint64 {
+	if h.dataVersionConn == nil {
+		return h.indexGeneration.Load()
+	}
+	h.dataVersionMu.Lock()
+	defer h.dataVersionMu.Unlock()
+	var version int64
+	if err := h.dataVersionConn.QueryRowContext(context.Background(), "PRAGMA data_version").Scan(&version); err != nil {
+		// unknown, so count it as a change:
+		return h.indexGeneration.Add(1)
+	}
+	if version != h.dataVersion {
+		h.dataVersion = version
+		h.indexGeneration.Add(1)
+	}
+	return h.indexGeneration.Load()
+}
 
-	if err := h.ensureTableColumn("files", "parent_page_route", "TEXT NOT NULL REFERENCES pages(route) ON UPDATE CASCADE ON DELETE CASCADE"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("files", "file_name", "TEXT NOT NULL DEFAULT ''"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("files", "mime_type", "TEXT NOT NULL DEFAULT 'application/octet-stream'"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("files", "file_size", "INTEGER NOT NULL DEFAULT 0 CHECK (file_size >= 0)"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("files", "enabled", "INTEGER NOT NULL DEFAULT 1"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("files", "created_at", "TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))"); err != nil {
-		return err
-	}
-	if err := h.ensureTableColumn("files", "updated_at", "TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))"); err != nil {
-		return err
+func (h *DBH) queryIndex(query string, args ...any) (*sql.Rows, error) {
+	if h.indexTx != nil {
+		return h.indexTx.Query(query, args...)
 	}
 
-	if _, err := h.db.Exec("CREATE INDEX IF NOT EXISTS idx_files_parent_page_route ON files(parent_page_route)"); err != nil {
-		return fmt.Errorf("create files parent index: %w", err)
-	}
+	return h.db.Query(query, args...)
+}
 
-	return nil
+// rowScanner is a single result row: *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func (h *DBH) ensureAppSettingsTable() error {
-	stmt := `
-		CREATE TABLE IF NOT EXISTS app_settings (
-			id            INTEGER PRIMARY KEY CHECK (id = 1),
-			settings_json TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(settings_json)),
-			updated_at    TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
-		)
-	`
+// scanFile scans a row with the fileSelectColumns, followed by the given extra
+// columns.
+func scanFile(row rowScanner, extra ...any) (model.IndexedFile, error) {
+	var record model.IndexedFile
+	var metadataJSON string
+	var captureDate sql.NullString
+	var latitude, longitude sql.NullFloat64
+	var enabledInt int
 
-	if _, err := h.db.Exec(stmt); err != nil {
-		return fmt.Errorf("create app_settings table: %w", err)
+	dest := []any{
+		&record.Route,
+		&record.ParentPageRoute,
+		&record.FileName,
+		&record.MimeType,
+		&record.FileSize,
+		&enabledInt,
+		&metadataJSON,
+		&record.Width,
+		&record.Height,
+		&record.Orientation,
+		&captureDate,
+		&record.Camera,
+		&latitude,
+		&longitude,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return model.IndexedFile{}, err
 	}
 
-	if err := h.ensureTableColumn("app_settings", "settings_json", "TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(settings_json))"); err != nil {
-		return err
+	record.Enabled = enabledInt != 0
+	var err error
+	if record.Metadata, err = unmarshalMetadata(metadataJSON); err != nil {
+		return model.IndexedFile{}, fmt.Errorf("unmarshal metadata for file %s: %w", record.Route, err)
 	}
-	if err := h.ensureTableColumn("app_settings", "updated_at", "TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))"); err != nil {
-		return err
+	if captureDate.Valid {
+		if record.CaptureDate, err = time.Parse(scheduleDateLayout, captureDate.String); err != nil {
+			return model.IndexedFile{}, fmt.Errorf("parse capture date for file %s: %w", record.Route, err)
+		}
 	}
-
-	if _, err := h.db.Exec("INSERT INTO app_settings (id, settings_json) VALUES (1, '{}') ON CONFLICT(id) DO NOTHING"); err != nil {
-		return fmt.Errorf("ensure app_settings singleton row: %w", err)
+	if latitude.Valid && longitude.Valid {
+		record.HasLocation = true
+		record.Latitude, record.Longitude = latitude.Float64, longitude.Float64
 	}
-
-	return nil
+	return record, nil
 }
 
-func (h *DBH) ensureDBVersionSetting() error {
-	stmt := `
-		UPDATE app_settings
-		SET
-			settings_json = json_set(coalesce(settings_json, '{}'), '$.db_version', ?),
-			updated_at = strftime('%Y-%m-%dT%H:%M:%fZ','now')
-		WHERE id = 1
-	`
-
-	if _, err := h.db.Exec(stmt, currentDBSchema); err != nil {
-		return fmt.Errorf("update db_version setting: %w", err)
+func marshalMetadata(m map[string]any) (string, error) {
+	if m == nil {
+		return "{}", nil
 	}
-
-	return nil
-}
-
-func (h *DBH) ensureTableColumn(tableName string, columnName string, columnDefinition string) error {
-	query := fmt.Sprintf("PRAGMA table_info(%s)", tableName)
-	rows, err := h.db.Query(query)
+	raw, err := json.Marshal(m)
 	if err != nil {
-		return fmt.Errorf("read table info for %s: %w", tableName, err)
-	}
-	defer rows.Close()
-
-	hasColumn := false
-	for rows.Next() {
-		var cid int
-		var name string
-		var ctype string
-		var notnull int
-		var dfltValue sql.NullString
-		var pk int
-
-		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
-			return fmt.Errorf("scan table info for %s: %w", tableName, err)
-		}
-
-		if name == columnName {
-			hasColumn = true
-			break
-		}
+		return "", err
 	}
+	return string(raw), nil
+}
 
-	if err := rows.Err(); err != nil {
-		return fmt.Errorf("iterate table info for %s: %w", tableName, err)
+func unmarshalMetadata(s string) (map[string]any, error) {
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, err
 	}
+	return m, nil
+}
 
-	if hasColumn {
+// sortableMetadata returns a copy of the page or file metadata for typed
+// comparisons and ordering (metadata_sort_json): all values that are dates (see
+// stdlib.ParseDate) are normalized to UTC in the scheduleDateLayout format, so
+// that they compare and sort correctly as text, whatever format they were
+// written in. Other values are kept as they are.
+func sortableMetadata(metadata map[string]any) map[string]any {
+	if metadata == nil {
 		return nil
 	}
-
-	alterStmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, columnName, columnDefinition)
-	if _, err := h.db.Exec(alterStmt); err != nil {
-		return fmt.Errorf("add missing column %s.%s: %w", tableName, columnName, err)
+	sortable := make(map[string]any, len(metadata))
+	for key, value := range metadata {
+		sortable[key] = sortableMetadataValue(value)
 	}
-
-	return nil
+	return sortable
 }
 
-func (h *DBH) execIndex(query string, args ...any) (sql.Result, error) {
-	if h.indexTx != nil {
-		return h.indexTx.Exec(query, args...)
+func sortableMetadataValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return sortableMetadata(v)
+	case []any:
+		values := make([]any, len(v))
+		for i, item := range v {
+			values[i] = sortableMetadataValue(item)
+		}
+		return values
+	case string, time.Time:
+		if date, ok := stdlib.ParseDate(v); ok {
+			return date.UTC().Format(scheduleDateLayout)
+		}
 	}
-
-	return h.db.Exec(query, args...)
+	return value
 }
 
-func (h *DBH) queryRowIndex(query string, args ...any) *sql.Row {
-	if h.indexTx != nil {
-		return h.indexTx.QueryRow(query, args...)
+// formatSourceModTime stores source mtimes in UTC with full precision, so that
+// they compare equal to a fresh fs.Stat() after a round trip through the DB.
+func formatSourceModTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
 	}
-
-	return h.db.QueryRow(query, args...)
+	return t.UTC().Format(time.RFC3339Nano)
 }
 
-func (h *DBH) queryIndex(query string, args ...any) (*sql.Rows, error) {
-	if h.indexTx != nil {
-		return h.indexTx.Query(query, args...)
+func parseSourceModTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
 	}
-
-	return h.db.Query(query, args...)
+	return time.Parse(time.RFC3339Nano, s)
 }
 
-func marshalMetadata(m map[string]any) (string, error) {
-	if m == nil {
-		return "{}", nil
-	}
-	raw, err := json.Marshal(m)
-	if err != nil {
-		return "", err
+// formatScheduleDate stores a publish or expiry date, see scheduleDateLayout.
+// An unset date is stored as NULL.
+func formatScheduleDate(t time.Time) any {
+	if t.IsZero() {
+		return nil
 	}
-	return string(raw), nil
+	return t.UTC().Format(scheduleDateLayout)
 }
 
-func unmarshalMetadata(s string) (map[string]any, error) {
-	var m map[string]any
-	if err := json.Unmarshal([]byte(s), &m); err != nil {
-		return nil, err
+func parseScheduleDates(publishDate sql.NullString, expiryDate sql.NullString) (time.Time, time.Time, error) {
+	var publish, expiry time.Time
+	var err error
+	if publishDate.Valid {
+		if publish, err = time.Parse(scheduleDateLayout, publishDate.String); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
 	}
-	return m, nil
+	if expiryDate.Valid {
+		if expiry, err = time.Parse(scheduleDateLayout, expiryDate.String); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return publish, expiry, nil
 }
 
 func GetDBHForConfig(config model.Config) (*DBH, bool, error) {
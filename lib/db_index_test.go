@@ -3,6 +3,7 @@ package lib
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
 	"alexi.ch/pcms/model"
 )
@@ -184,3 +185,63 @@ func TestDBHGetByRoute(t *testing.T) {
 		t.Fatalf("GetFileByRoute(/missing.txt) found = true, want false")
 	}
 }
+
+func TestDBHIndexRunIsolation(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "pcms-test-isolation.db")
+	dbh, err := OpenDBH(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	defer dbh.Close()
+
+	if err := dbh.ReplacePage(model.IndexedPage{Route: "/", Title: "old", IndexFile: "index.md", Enabled: true}); err != nil {
+		t.Fatalf("ReplacePage(root) error = %v", err)
+	}
+
+	if err := dbh.BeginIndexRun(); err != nil {
+		t.Fatalf("BeginIndexRun() error = %v", err)
+	}
+	if err := dbh.ReplacePage(model.IndexedPage{Route: "/", Title: "new", IndexFile: "index.md", Enabled: true}); err != nil {
+		t.Fatalf("ReplacePage(root) in run error = %v", err)
+	}
+
+	// request-time readers keep seeing the committed state during the run:
+	page, _, err := dbh.GetPageByRoute("/")
+	if err != nil {
+		t.Fatalf("GetPageByRoute(/) error = %v", err)
+	}
+	if page.Title != "old" {
+		t.Fatalf("GetPageByRoute(/).Title during run = %q, want %q", page.Title, "old")
+	}
+
+	// request-time writers wait for the run to finish:
+	lockAcquired := make(chan struct{})
+	go func() {
+		_ = dbh.WithIndexLock(func() error {
+			close(lockAcquired)
+			return nil
+		})
+	}()
+	select {
+	case <-lockAcquired:
+		t.Fatalf("WithIndexLock() ran during an active index run")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := dbh.CommitIndexRun(); err != nil {
+		t.Fatalf("CommitIndexRun() error = %v", err)
+	}
+	select {
+	case <-lockAcquired:
+	case <-time.After(time.Second):
+		t.Fatalf("WithIndexLock() did not run after the index run was committed")
+	}
+
+	page, _, err = dbh.GetPageByRoute("/")
+	if err != nil {
+		t.Fatalf("GetPageByRoute(/) error = %v", err)
+	}
+	if page.Title != "new" {
+		t.Fatalf("GetPageByRoute(/).Title after commit = %q, want %q", page.Title, "new")
+	}
+}
@@ -0,0 +1,511 @@
+package lib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrDBSchemaTooNew is returned when opening a DB whose schema was migrated by a
+// newer pcms version: its tables may have changed in ways this version does not
+// know, so it is refused instead of being used half-working.
+var ErrDBSchemaTooNew = errors.New("db schema is newer than this pcms version supports")
+
+// dbMigration is a single, numbered step of the DB schema. The applied
+// migrations are recorded in the app_settings table: "db_version" in the
+// settings_json holds the version of the last applied migration, "migrations"
+// the time each migration was applied.
+type dbMigration struct {
+	version     int
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// Migration describes a schema migration and whether it is applied to the DB.
+type Migration struct {
+	Version     int
+	Description string
+	Applied     bool
+	// AppliedAt is empty for pending migrations, and for migrations that were
+	// applied before pcms recorded the time.
+	AppliedAt string
+}
+
+// dbMigrations is the ordered list of all schema migrations. Each one is applied
+// in its own transaction, exactly once: add new migrations at the end, with the
+// next version number, and never change a released one.
+//
+// The list starts at version 2, the schema version of the first pcms releases
+// that recorded the db_version: their DBs continue with the next migration.
+// Migrations that add derived data reset the stored source hashes, so that the
+// next index sync re-reads all pages or files and fills it.
+var dbMigrations = []dbMigration{
+	{version: 2, description: "create the pages, files and app_settings tables", apply: migrateBaseTables},
+	{version: 3, description: "add source signatures to pages and files", apply: migrateSourceSignatures},
+	{version: 4, description: "create the page full-text index", apply: migratePageTexts},
+	{version: 5, description: "add publish and expiry dates to pages", apply: migrateScheduleDates},
+	{version: 6, description: "add sortable page metadata", apply: migrateSortableMetadata},
+	{version: 7, description: "create the redirects table for page aliases", apply: migrateRedirects},
+	{version: 8, description: "add page languages and the page_translations table", apply: migratePageTranslations},
+	{version: 9, description: "add sidecar metadata to files", apply: migrateFileMetadata},
+	{version: 10, description: "add image properties to files", apply: migrateImageProperties},
+	{version: 11, description: "add summaries, word counts and reading times to pages", apply: migratePageSummaries},
+}
+
+// currentDBSchema returns the schema version this pcms version migrates to.
+func currentDBSchema() int {
+	return dbMigrations[len(dbMigrations)-1].version
+}
+
+// DBVersion returns the schema version of the DB: the version of the last
+// applied migration, 0 for a new DB.
+func (h *DBH) DBVersion() (int, error) {
+	return readDBVersion(h.db)
+}
+
+// Migrations returns all schema migrations in order, each with its status in
+// the DB.
+func (h *DBH) Migrations() ([]Migration, error) {
+	version, err := readDBVersion(h.db)
+	if err != nil {
+		return nil, err
+	}
+	appliedAt, err := readMigrationTimes(h.db)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(dbMigrations))
+	for _, m := range dbMigrations {
+		migrations = append(migrations, Migration{
+			Version:     m.version,
+			Description: m.description,
+			Applied:     m.version <= version,
+			AppliedAt:   appliedAt[strconv.Itoa(m.version)],
+		})
+	}
+	return migrations, nil
+}
+
+// Migrate applies all pending schema migrations in order and returns the applied
+// ones. It stops at the first failing migration, whose changes are rolled back;
+// the migrations before it stay applied.
+func (h *DBH) Migrate() ([]Migration, error) {
+	version, err := h.checkDBVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range dbMigrations {
+		if m.version <= version {
+			continue
+		}
+		appliedAt, err := h.applyMigration(m)
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, Migration{Version: m.version, Description: m.description, Applied: true, AppliedAt: appliedAt})
+	}
+	return applied, nil
+}
+
+// checkDBVersion returns the schema version of the DB, or ErrDBSchemaTooNew if
+// it is newer than the last known migration.
+func (h *DBH) checkDBVersion() (int, error) {
+	version, err := readDBVersion(h.db)
+	if err != nil {
+		return 0, err
+	}
+	if version > currentDBSchema() {
+		return 0, fmt.Errorf("%w: %s has schema version %d, this pcms version supports up to %d", ErrDBSchemaTooNew, h.path, version, currentDBSchema())
+	}
+	return version, nil
+}
+
+// applyMigration applies a single migration in a transaction, and records it in
+// the same transaction. A migration that another process applied in the meantime
+// is skipped.
+func (h *DBH) applyMigration(m dbMigration) (string, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("begin migration %d: %w", m.version, err)
+	}
+	defer tx.Rollback()
+
+	version, err := readDBVersion(tx)
+	if err != nil {
+		return "", err
+	}
+	if version >= m.version {
+		return "", tx.Commit()
+	}
+
+	if err := m.apply(tx); err != nil {
+		return "", fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+	}
+
+	var appliedAt string
+	if err := tx.QueryRow("SELECT strftime('%Y-%m-%dT%H:%M:%fZ','now')").Scan(&appliedAt); err != nil {
+		return "", fmt.Errorf("read migration %d time: %w", m.version, err)
+	}
+	stmt := `
+		UPDATE app_settings
+		SET
+			settings_json = json_set(settings_json, '$.db_version', ?, '$.migrations."' || ? || '"', ?),
+			updated_at = ?
+		WHERE id = 1
+	`
+	if _, err := tx.Exec(stmt, m.version, strconv.Itoa(m.version), appliedAt, appliedAt); err != nil {
+		return "", fmt.Errorf("record migration %d: %w", m.version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit migration %d: %w", m.version, err)
+	}
+	return appliedAt, nil
+}
+
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// readDBVersion returns the db_version recorded in the app_settings table, 0 if
+// there is none.
+func readDBVersion(q rowQuerier) (int, error) {
+	var tableCount int
+	if err := q.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = 'app_settings'").Scan(&tableCount); err != nil {
+		return 0, fmt.Errorf("check for app_settings table: %w", err)
+	}
+	if tableCount == 0 {
+		return 0, nil
+	}
+
+	var version int
+	err := q.QueryRow("SELECT coalesce(json_extract(settings_json, '$.db_version'), 0) FROM app_settings WHERE id = 1").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read db_version setting: %w", err)
+	}
+	return version, nil
+}
+
+// readMigrationTimes returns the recorded migration times by version.
+func readMigrationTimes(q rowQuerier) (map[string]string, error) {
+	times := map[string]string{}
+	var tableCount int
+	if err := q.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = 'app_settings'").Scan(&tableCount); err != nil {
+		return nil, fmt.Errorf("check for app_settings table: %w", err)
+	}
+	if tableCount == 0 {
+		return times, nil
+	}
+
+	var raw sql.NullString
+	err := q.QueryRow("SELECT json_extract(settings_json, '$.migrations') FROM app_settings WHERE id = 1").Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !raw.Valid) {
+		return times, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read migrations setting: %w", err)
+	}
+	if err := json.Unmarshal([]byte(raw.String), &times); err != nil {
+		return nil, fmt.Errorf("decode migrations setting: %w", err)
+	}
+	return times, nil
+}
+
+func migrateBaseTables(tx *sql.Tx) error {
+	stmts := []string{`
+		CREATE TABLE IF NOT EXISTS pages (
+			route             TEXT PRIMARY KEY,
+			parent_page_route TEXT NULL REFERENCES pages(route)
+				ON UPDATE CASCADE
+				ON DELETE SET NULL,
+			title             TEXT NOT NULL DEFAULT '',
+			index_file        TEXT NOT NULL DEFAULT '',
+			enabled           INTEGER NOT NULL DEFAULT 1,
+			metadata_json     TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(metadata_json)),
+			created_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+			updated_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
+		)
+	`, `
+		CREATE INDEX IF NOT EXISTS idx_pages_parent_page_route ON pages(parent_page_route)
+	`, `
+		CREATE TABLE IF NOT EXISTS files (
+			route             TEXT PRIMARY KEY,
+			parent_page_route TEXT NOT NULL REFERENCES pages(route)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			file_name         TEXT NOT NULL,
+			mime_type         TEXT NOT NULL DEFAULT 'application/octet-stream',
+			file_size         INTEGER NOT NULL DEFAULT 0 CHECK (file_size >= 0),
+			enabled           INTEGER NOT NULL DEFAULT 1,
+			created_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+			updated_at        TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
+		)
+	`, `
+		CREATE INDEX IF NOT EXISTS idx_files_parent_page_route ON files(parent_page_route)
+	`, `
+		CREATE TABLE IF NOT EXISTS app_settings (
+			id            INTEGER PRIMARY KEY CHECK (id = 1),
+			settings_json TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(settings_json)),
+			updated_at    TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now'))
+		)
+	`, `
+		INSERT INTO app_settings (id, settings_json) VALUES (1, '{}') ON CONFLICT(id) DO NOTHING
+	`}
+	return execAll(tx, stmts)
+}
+
+func migrateSourceSignatures(tx *sql.Tx) error {
+	return ensureTableColumns(tx, []tableColumn{
+		{"pages", "source_mtime", "TEXT NOT NULL DEFAULT ''"},
+		{"pages", "source_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"pages", "source_hash", "TEXT NOT NULL DEFAULT ''"},
+		{"files", "source_mtime", "TEXT NOT NULL DEFAULT ''"},
+		{"files", "source_hash", "TEXT NOT NULL DEFAULT ''"},
+	})
+}
+
+// migratePageTexts creates the full-text index of the pages: page_texts holds
+// the plain text of each page, pages_fts is an FTS5 index over it, kept up to date
+// by triggers.
+func migratePageTexts(tx *sql.Tx) error {
+	stmts := []string{`
+		CREATE TABLE IF NOT EXISTS page_texts (
+			id    INTEGER PRIMARY KEY,
+			route TEXT NOT NULL UNIQUE REFERENCES pages(route)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			title TEXT NOT NULL DEFAULT '',
+			body  TEXT NOT NULL DEFAULT ''
+		)
+	`, `
+		CREATE VIRTUAL TABLE IF NOT EXISTS pages_fts USING fts5(
+			title,
+			body,
+			content = 'page_texts',
+			content_rowid = 'id',
+			tokenize = 'unicode61 remove_diacritics 2'
+		)
+	`, `
+		CREATE TRIGGER IF NOT EXISTS page_texts_after_insert AFTER INSERT ON page_texts BEGIN
+			INSERT INTO pages_fts (rowid, title, body) VALUES (new.id, new.title, new.body);
+		END
+	`, `
+		CREATE TRIGGER IF NOT EXISTS page_texts_after_delete AFTER DELETE ON page_texts BEGIN
+			INSERT INTO pages_fts (pages_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+		END
+	`, `
+		CREATE TRIGGER IF NOT EXISTS page_texts_after_update AFTER UPDATE ON page_texts BEGIN
+			INSERT INTO pages_fts (pages_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+			INSERT INTO pages_fts (rowid, title, body) VALUES (new.id, new.title, new.body);
+		END
+	`}
+	if err := execAll(tx, stmts); err != nil {
+		return err
+	}
+	return resetSourceHashes(tx, "pages")
+}
+
+func migrateScheduleDates(tx *sql.Tx) error {
+	if err := ensureTableColumns(tx, []tableColumn{
+		{"pages", "publish_date", "TEXT NULL"},
+		{"pages", "expiry_date", "TEXT NULL"},
+	}); err != nil {
+		return err
+	}
+	return resetSourceHashes(tx, "pages")
+}
+
+func migrateSortableMetadata(tx *sql.Tx) error {
+	if err := ensureTableColumns(tx, []tableColumn{
+		{"pages", "metadata_sort_json", "TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(metadata_sort_json))"},
+	}); err != nil {
+		return err
+	}
+	return resetSourceHashes(tx, "pages")
+}
+
+// migrateRedirects creates the redirects table, which holds the aliases of the
+// pages: old routes that redirect to the page. An alias claimed by several pages
+// is kept for each of them: the first page by route wins, and the conflict can
+// be reported.
+func migrateRedirects(tx *sql.Tx) error {
+	stmts := []string{`
+		CREATE TABLE IF NOT EXISTS redirects (
+			source_route TEXT NOT NULL,
+			target_route TEXT NOT NULL REFERENCES pages(route)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			created_at   TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+			PRIMARY KEY (source_route, target_route)
+		)
+	`, `
+		CREATE INDEX IF NOT EXISTS idx_redirects_target_route ON redirects(target_route)
+	`}
+	if err := execAll(tx, stmts); err != nil {
+		return err
+	}
+	return resetSourceHashes(tx, "pages")
+}
+
+// migratePageTranslations adds the language of the pages, and creates the
+// page_translations table, which holds the language variants of the pages
+// (index.de.md, ...), one row per page and language.
+func migratePageTranslations(tx *sql.Tx) error {
+	if err := ensureTableColumns(tx, []tableColumn{
+		{"pages", "language", "TEXT NOT NULL DEFAULT ''"},
+	}); err != nil {
+		return err
+	}
+	stmts := []string{`
+		CREATE TABLE IF NOT EXISTS page_translations (
+			route              TEXT NOT NULL REFERENCES pages(route)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			language           TEXT NOT NULL,
+			title              TEXT NOT NULL DEFAULT '',
+			index_file         TEXT NOT NULL DEFAULT '',
+			metadata_json      TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(metadata_json)),
+			metadata_sort_json TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(metadata_sort_json)),
+			PRIMARY KEY (route, language)
+		)
+	`}
+	if err := execAll(tx, stmts); err != nil {
+		return err
+	}
+	return resetSourceHashes(tx, "pages")
+}
+
+// migrateFileMetadata adds the metadata of the sidecar files. The index sync
+// compares the file metadata itself, so no source hashes need to be reset.
+func migrateFileMetadata(tx *sql.Tx) error {
+	return ensureTableColumns(tx, []tableColumn{
+		{"files", "metadata_json", "TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(metadata_json))"},
+		{"files", "metadata_sort_json", "TEXT NOT NULL DEFAULT '{}' CHECK (json_valid(metadata_sort_json))"},
+	})
+}
+
+func migrateImageProperties(tx *sql.Tx) error {
+	if err := ensureTableColumns(tx, []tableColumn{
+		{"files", "width", "INTEGER NOT NULL DEFAULT 0"},
+		{"files", "height", "INTEGER NOT NULL DEFAULT 0"},
+		{"files", "orientation", "INTEGER NOT NULL DEFAULT 0"},
+		{"files", "capture_date", "TEXT NULL"},
+		{"files", "camera", "TEXT NOT NULL DEFAULT ''"},
+		{"files", "latitude", "REAL NULL"},
+		{"files", "longitude", "REAL NULL"},
+	}); err != nil {
+		return err
+	}
+	return resetSourceHashes(tx, "files")
+}
+
+// migratePageSummaries adds the summary, word count and reading time of the
+// pages and their language variants, derived from their index files.
+func migratePageSummaries(tx *sql.Tx) error {
+	var columns []tableColumn
+	for _, table := range []string{"pages", "page_translations"} {
+		columns = append(columns,
+			tableColumn{table, "summary", "TEXT NOT NULL DEFAULT ''"},
+			tableColumn{table, "word_count", "INTEGER NOT NULL DEFAULT 0"},
+			tableColumn{table, "reading_time", "INTEGER NOT NULL DEFAULT 0"},
+		)
+	}
+	if err := ensureTableColumns(tx, columns); err != nil {
+		return err
+	}
+	return resetSourceHashes(tx, "pages")
+}
+
+func execAll(tx *sql.Tx, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetSourceHashes makes the next index sync re-read all pages or files of an
+// existing index.
+func resetSourceHashes(tx *sql.Tx, tableName string) error {
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET source_hash = ''", tableName)); err != nil {
+		return fmt.Errorf("reset %s source hashes: %w", tableName, err)
+	}
+	return nil
+}
+
+type tableColumn struct {
+	table      string
+	column     string
+	definition string
+}
+
+// ensureTableColumns adds the columns that are missing, so that a migration can
+// also be applied to DBs that already have some of them.
+func ensureTableColumns(tx *sql.Tx, columns []tableColumn) error {
+	for _, c := range columns {
+		if err := ensureTableColumn(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ensureTableColumn(tx *sql.Tx, tableName string, columnName string, columnDefinition string) error {
+	hasColumn, err := hasTableColumn(tx, tableName, columnName)
+	if err != nil {
+		return err
+	}
+	if hasColumn {
+		return nil
+	}
+
+	alterStmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, columnName, columnDefinition)
+	if _, err := tx.Exec(alterStmt); err != nil {
+		return fmt.Errorf("add missing column %s.%s: %w", tableName, columnName, err)
+	}
+
+	return nil
+}
+
+func hasTableColumn(tx *sql.Tx, tableName string, columnName string) (bool, error) {
+	query := fmt.Sprintf("PRAGMA table_info(%s)", tableName)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return false, fmt.Errorf("read table info for %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	hasColumn := false
+	for rows.Next() {
+		var cid int
+		var name string
+		var ctype string
+		var notnull int
+		var dfltValue sql.NullString
+		var pk int
+
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
+			return false, fmt.Errorf("scan table info for %s: %w", tableName, err)
+		}
+
+		if name == columnName {
+			hasColumn = true
+			break
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("iterate table info for %s: %w", tableName, err)
+	}
+
+	return hasColumn, nil
+}
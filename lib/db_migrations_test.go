@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestDBHMigrate(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "pcms-migrate.db")
+	dbh, err := OpenDBHUnmigrated(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDBHUnmigrated() error = %v", err)
+	}
+	defer dbh.Close()
+
+	if version, err := dbh.DBVersion(); err != nil || version != 0 {
+		t.Fatalf("DBVersion() of a new DB = %d, %v, want 0", version, err)
+	}
+	migrations, err := dbh.Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+	if len(migrations) != len(dbMigrations) || migrations[0].Applied {
+		t.Fatalf("Migrations() of a new DB = %+v, want all pending", migrations)
+	}
+
+	// bring the DB to the schema of a pcms version that had no image properties:
+	for _, m := range dbMigrations {
+		if m.version > 9 {
+			break
+		}
+		if _, err := dbh.applyMigration(m); err != nil {
+			t.Fatalf("applyMigration(%d) error = %v", m.version, err)
+		}
+	}
+	if _, err := dbh.db.Exec("INSERT INTO pages (route, source_hash) VALUES ('/', 'page-hash')"); err != nil {
+		t.Fatalf("insert page: %v", err)
+	}
+	if _, err := dbh.db.Exec("INSERT INTO files (route, parent_page_route, file_name, source_hash) VALUES ('/a.jpg', '/', 'a.jpg', 'file-hash')"); err != nil {
+		t.Fatalf("insert file: %v", err)
+	}
+
+	// the image properties are derived from the files, the page data is untouched:
+	if _, err := dbh.applyMigration(dbMigrations[8]); err != nil {
+		t.Fatalf("applyMigration(%d) error = %v", dbMigrations[8].version, err)
+	}
+	var fileHash, pageHash string
+	var width int
+	if err := dbh.db.QueryRow("SELECT source_hash, width FROM files WHERE route = '/a.jpg'").Scan(&fileHash, &width); err != nil {
+		t.Fatalf("query file: %v", err)
+	}
+	if err := dbh.db.QueryRow("SELECT source_hash FROM pages WHERE route = '/'").Scan(&pageHash); err != nil {
+		t.Fatalf("query page: %v", err)
+	}
+	if fileHash != "" || width != 0 || pageHash != "page-hash" {
+		t.Fatalf("after migration 10: file hash %q, width %d, page hash %q", fileHash, width, pageHash)
+	}
+
+	applied, err := dbh.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if len(applied) != len(dbMigrations)-9 || applied[0].Version != 11 || applied[0].AppliedAt == "" {
+		t.Fatalf("Migrate() applied = %+v, want the migrations from 11", applied)
+	}
+	if version, err := dbh.DBVersion(); err != nil || version != dbh.SchemaVersion() {
+		t.Fatalf("DBVersion() = %d, %v, want %d", version, err, dbh.SchemaVersion())
+	}
+
+	// the page summaries are derived from the pages:
+	if err := dbh.db.QueryRow("SELECT source_hash FROM pages WHERE route = '/'").Scan(&pageHash); err != nil {
+		t.Fatalf("query page: %v", err)
+	}
+	if pageHash != "" {
+		t.Fatalf("after migration 11: page hash %q, want it reset", pageHash)
+	}
+
+	migrations, err = dbh.Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+	for _, m := range migrations {
+		if !m.Applied || m.AppliedAt == "" {
+			t.Fatalf("migration %d = %+v, want applied with time", m.Version, m)
+		}
+	}
+
+	if applied, err := dbh.Migrate(); err != nil || len(applied) != 0 {
+		t.Fatalf("second Migrate() = %+v, %v, want nothing applied", applied, err)
+	}
+}
+
+func TestOpenDBHRefusesNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "pcms-newer.db")
+	dbh, err := OpenDBH(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	if _, err := dbh.db.Exec("UPDATE app_settings SET settings_json = json_set(settings_json, '$.db_version', ?) WHERE id = 1", dbh.SchemaVersion()+1); err != nil {
+		t.Fatalf("set db_version: %v", err)
+	}
+	dbh.Close()
+	dbh.db.Close()
+
+	if _, err := OpenDBH(dbPath); !errors.Is(err, ErrDBSchemaTooNew) {
+		t.Fatalf("OpenDBH() of a newer DB error = %v, want ErrDBSchemaTooNew", err)
+	}
+	if _, err := OpenDBHUnmigrated(dbPath); !errors.Is(err, ErrDBSchemaTooNew) {
+		t.Fatalf("OpenDBHUnmigrated() of a newer DB error = %v, want ErrDBSchemaTooNew", err)
+	}
+}
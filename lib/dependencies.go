@@ -0,0 +1,229 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"alexi.ch/pcms/model"
+)
+
+// RenderDependencies lists what a rendered page depends on besides its own
+// source file: the template files it loaded, and the index queries it ran. It is
+// stored with the cached page, which stays valid as long as no template file
+// changed since RenderedAt, and all queries still return the same result.
+type RenderDependencies struct {
+	RenderedAt time.Time         `json:"renderedAt"`
+	Templates  []string          `json:"templates"`
+	Queries    []QueryDependency `json:"queries"`
+}
+
+// QueryDependency is an index query run during a page render.
+type QueryDependency struct {
+	SQL  string `json:"sql"`
+	Args []any  `json:"args"`
+	// hash of the query result at render time, see DBH.QueryFingerprint
+	Fingerprint string `json:"fingerprint"`
+}
+
+// UnmarshalJSON restores the query arguments with the types they were recorded
+// with: JSON numbers become int64 or float64 instead of float64 only.
+func (q *QueryDependency) UnmarshalJSON(data []byte) error {
+	type plainQueryDependency QueryDependency
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var plain plainQueryDependency
+	if err := decoder.Decode(&plain); err != nil {
+		return err
+	}
+	for i, arg := range plain.Args {
+		if number, ok := arg.(json.Number); ok {
+			if intValue, err := number.Int64(); err == nil {
+				plain.Args[i] = intValue
+			} else if floatValue, err := number.Float64(); err == nil {
+				plain.Args[i] = floatValue
+			}
+		}
+	}
+	*q = QueryDependency(plain)
+	return nil
+}
+
+// DependencyRecorder collects the RenderDependencies of a single page render.
+// It is safe for concurrent use. All methods accept a nil recorder, which
+// records nothing.
+type DependencyRecorder struct {
+	mu    sync.Mutex
+	deps  RenderDependencies
+	known map[string]bool
+}
+
+// NewDependencyRecorder creates a recorder for a render that starts now.
+func NewDependencyRecorder() *DependencyRecorder {
+	return &DependencyRecorder{
+		deps: RenderDependencies{
+			RenderedAt: time.Now(),
+			Templates:  make([]string, 0),
+			Queries:    make([]QueryDependency, 0),
+		},
+		known: make(map[string]bool),
+	}
+}
+
+// RecordTemplate records a template file the render loaded.
+func (r *DependencyRecorder) RecordTemplate(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.known["template:"+path] {
+		return
+	}
+	r.known["template:"+path] = true
+	r.deps.Templates = append(r.deps.Templates, path)
+}
+
+// recordQuery records an index query. It has to be called before the query
+// runs: if the index changes in between, the recorded fingerprint is the older
+// one, so the page is rendered again next time instead of staying stale.
+func (r *DependencyRecorder) recordQuery(dbh *DBH, query string, args []any) {
+	if r == nil {
+		return
+	}
+	key, _ := json.Marshal(QueryDependency{SQL: query, Args: args})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.known["query:"+string(key)] {
+		return
+	}
+	r.known["query:"+string(key)] = true
+
+	// an unknown fingerprint never matches, so the page is rendered again next time:
+	fingerprint, _ := dbh.QueryFingerprint(query, args)
+	r.deps.Queries = append(r.deps.Queries, QueryDependency{
+		SQL:         query,
+		Args:        append([]any{}, args...),
+		Fingerprint: fingerprint,
+	})
+}
+
+// PageQuery returns a new PageQueryBuilder whose queries are recorded.
+func (r *DependencyRecorder) PageQuery(dbh *DBH) *PageQueryBuilder {
+	b := NewPageQueryBuilder(dbh)
+	b.recorder = r
+	return b
+}
+
+// FileQuery returns a new FileQueryBuilder whose queries are recorded.
+func (r *DependencyRecorder) FileQuery(dbh *DBH) *FileQueryBuilder {
+	b := NewFileQueryBuilder(dbh)
+	b.recorder = r
+	return b
+}
+
+// ChildPages returns dbh.GetChildPages(route, language), and records the query.
+func (r *DependencyRecorder) ChildPages(dbh *DBH, route string, language string) ([]model.IndexedPage, error) {
+	r.recordQuery(dbh, childPagesQuery, []any{language, route})
+	return dbh.GetChildPages(route, language)
+}
+
+// ChildFiles returns dbh.GetChildFiles(route), and records the query.
+func (r *DependencyRecorder) ChildFiles(dbh *DBH, route string) ([]model.IndexedFile, error) {
+	r.recordQuery(dbh, childFilesQuery, []any{route})
+	return dbh.GetChildFiles(route)
+}
+
+// Dependencies returns the dependencies recorded so far.
+func (r *DependencyRecorder) Dependencies() RenderDependencies {
+	if r == nil {
+		return RenderDependencies{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deps := r.deps
+	deps.Templates = append([]string{}, r.deps.Templates...)
+	deps.Queries = append([]QueryDependency{}, r.deps.Queries...)
+	return deps
+}
+
+// QueryFingerprint runs a query and returns a hash of its complete result.
+func (h *DBH) QueryFingerprint(query string, args []any) (string, error) {
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return "", fmt.Errorf("query fingerprint: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", fmt.Errorf("query fingerprint: %w", err)
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	hash := sha256.New()
+	var length [8]byte
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", fmt.Errorf("query fingerprint: %w", err)
+		}
+		for _, value := range values {
+			// length-prefixed, so that column boundaries count; NULL differs from "":
+			if value == nil {
+				binary.BigEndian.PutUint64(length[:], ^uint64(0))
+			} else {
+				binary.BigEndian.PutUint64(length[:], uint64(len(value)))
+			}
+			hash.Write(length[:])
+			hash.Write(value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("query fingerprint: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// QueryDependenciesChanged reports whether one of the queries returns a
+// different result now than when it was recorded. A query that fails counts as
+// changed.
+func (h *DBH) QueryDependenciesChanged(queries []QueryDependency) bool {
+	for _, q := range queries {
+		fingerprint, err := h.QueryFingerprint(q.SQL, q.Args)
+		if err != nil || fingerprint != q.Fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// NextScheduleChange returns the first publishDate or expiryDate of a page after
+// the given time, when the result of index queries may change without an index
+// change. Zero if there is none.
+func (h *DBH) NextScheduleChange(after time.Time) (time.Time, error) {
+	afterDate := formatScheduleDate(after)
+	var next sql.NullString
+	err := h.db.QueryRow(`
+		SELECT min(date) FROM (
+			SELECT publish_date AS date FROM pages WHERE publish_date > ?
+			UNION ALL
+			SELECT expiry_date FROM pages WHERE expiry_date > ?
+		)`, afterDate, afterDate).Scan(&next)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("query next schedule change: %w", err)
+	}
+	if !next.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(scheduleDateLayout, next.String)
+}
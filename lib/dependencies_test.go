@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"alexi.ch/pcms/model"
+)
+
+func TestDependencyRecorder(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	deps := NewDependencyRecorder()
+
+	posts := deps.PageQuery(dbh).WhereParentRoute("/blog").OrderBy("title", "asc").PageSize(5).FetchAll()
+	if len(posts) != 2 {
+		t.Fatalf("FetchAll() = %d pages, want 2", len(posts))
+	}
+	deps.PageQuery(dbh).WhereRoute("/about").Count()
+	// the same query twice is recorded once:
+	deps.PageQuery(dbh).WhereRoute("/about").Count()
+	if _, err := deps.ChildPages(dbh, "/", ""); err != nil {
+		t.Fatalf("ChildPages() error = %v", err)
+	}
+	deps.RecordTemplate("/templates/base.html")
+	deps.RecordTemplate("/templates/base.html")
+
+	recorded := deps.Dependencies()
+	if len(recorded.Queries) != 3 || len(recorded.Templates) != 1 {
+		t.Fatalf("recorded %d queries, %d templates, want 3 and 1", len(recorded.Queries), len(recorded.Templates))
+	}
+
+	// the dependencies are stored as JSON with the cached page:
+	data, err := json.Marshal(recorded)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var stored RenderDependencies
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if dbh.QueryDependenciesChanged(stored.Queries) {
+		t.Fatalf("QueryDependenciesChanged() = true for an unchanged index")
+	}
+
+	// a page that none of the queries returns:
+	parent := "/hidden"
+	if err := dbh.ReplacePage(model.IndexedPage{Route: "/hidden/other", ParentPageRoute: &parent, Title: "Other", IndexFile: "index.md"}); err != nil {
+		t.Fatalf("ReplacePage() error = %v", err)
+	}
+	if dbh.QueryDependenciesChanged(stored.Queries) {
+		t.Fatalf("QueryDependenciesChanged() = true after an unrelated change")
+	}
+
+	// a new blog post changes the listing:
+	blog := "/blog"
+	if err := dbh.ReplacePage(model.IndexedPage{Route: "/blog/post-3", ParentPageRoute: &blog, Title: "Third Post", IndexFile: "index.md", Enabled: true}); err != nil {
+		t.Fatalf("ReplacePage() error = %v", err)
+	}
+	if !dbh.QueryDependenciesChanged(stored.Queries) {
+		t.Fatalf("QueryDependenciesChanged() = false after adding a listed page")
+	}
+}
+
+func TestDependencyRecorderNil(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	var deps *DependencyRecorder
+	deps.RecordTemplate("/templates/base.html")
+	if got := deps.PageQuery(dbh).WhereParentRoute("/blog").Count(); got != 2 {
+		t.Fatalf("Count() = %d, want 2", got)
+	}
+	if recorded := deps.Dependencies(); len(recorded.Queries) != 0 || len(recorded.Templates) != 0 {
+		t.Fatalf("nil recorder recorded %+v", recorded)
+	}
+}
+
+func TestDBHIndexGeneration(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "pcms-generation.db")
+	dbh, err := OpenDBH(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	defer dbh.Close()
+
+	srcFS := fstest.MapFS{
+		"index.md":      &fstest.MapFile{Data: []byte("# root")},
+		"blog/index.md": &fstest.MapFile{Data: []byte("# blog")},
+	}
+	syncSnapshot(t, dbh, srcFS)
+	generation := dbh.IndexGeneration()
+
+	// an index sync without changes keeps the generation:
+	syncSnapshot(t, dbh, srcFS)
+	if got := dbh.IndexGeneration(); got != generation {
+		t.Fatalf("IndexGeneration() = %d after a sync without changes, want %d", got, generation)
+	}
+
+	// a changing sync, or a single index write, changes it:
+	srcFS["blog/post/index.md"] = &fstest.MapFile{Data: []byte("# post")}
+	syncSnapshot(t, dbh, srcFS)
+	if got := dbh.IndexGeneration(); got == generation {
+		t.Fatalf("IndexGeneration() unchanged after a changing sync")
+	}
+	generation = dbh.IndexGeneration()
+	if err := dbh.DeletePage("/blog/post"); err != nil {
+		t.Fatalf("DeletePage() error = %v", err)
+	}
+	if got := dbh.IndexGeneration(); got == generation {
+		t.Fatalf("IndexGeneration() unchanged after DeletePage()")
+	}
+
+	// so does a change of another process:
+	generation = dbh.IndexGeneration()
+	other, err := OpenDBH(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	defer other.Close()
+	if err := other.SetPageEnabled("/blog", false, false); err != nil {
+		t.Fatalf("SetPageEnabled() error = %v", err)
+	}
+	if got := dbh.IndexGeneration(); got == generation {
+		t.Fatalf("IndexGeneration() unchanged after a change of another DBH")
+	}
+}
+
+func TestDBHNextScheduleChange(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if next, err := dbh.NextScheduleChange(now); err != nil || !next.IsZero() {
+		t.Fatalf("NextScheduleChange() = %v, %v, want zero", next, err)
+	}
+
+	blog := "/blog"
+	scheduled := []model.IndexedPage{
+		{Route: "/blog/past", ParentPageRoute: &blog, Title: "Past", IndexFile: "index.md", Enabled: true,
+			PublishDate: now.Add(-time.Hour), ExpiryDate: now.Add(3 * time.Hour)},
+		{Route: "/blog/upcoming", ParentPageRoute: &blog, Title: "Upcoming", IndexFile: "index.md", Enabled: true,
+			PublishDate: now.Add(2 * time.Hour)},
+	}
+	for _, p := range scheduled {
+		if err := dbh.ReplacePage(p); err != nil {
+			t.Fatalf("ReplacePage(%s) error = %v", p.Route, err)
+		}
+	}
+	next, err := dbh.NextScheduleChange(now)
+	if err != nil || !next.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("NextScheduleChange() = %v, %v, want the publish date of /blog/upcoming", next, err)
+	}
+	next, err = dbh.NextScheduleChange(now.Add(2 * time.Hour))
+	if err != nil || !next.Equal(now.Add(3*time.Hour)) {
+		t.Fatalf("NextScheduleChange() = %v, %v, want the expiry date of /blog/past", next, err)
+	}
+}
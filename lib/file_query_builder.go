@@ -0,0 +1,375 @@
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"alexi.ch/pcms/model"
+)
+
+// FileQueryBuilder provides a chainable, SQL-injection-safe query builder for
+// indexed files, the counterpart of PageQueryBuilder. It only returns files
+// that are served: enabled files of visible pages.
+//
+// Every filter/ordering/paging method returns a shallow copy so calls can be
+// chained without mutating the original builder.
+//
+// # Template usage
+//
+// The builder is exposed as the "FileQuery" factory function in pongo2 templates:
+//
+//	{% for img in FileQuery().WhereParentRoute("/gallery/*").WhereMimeType("image/").OrderBy("name","asc").FetchAll() %}
+//	    <img src="{{ Webroot(img.Route) }}" alt="{{ img.FileName }}">
+//	{% endfor %}
+//
+//	{{ FileQuery().WhereFileName("*.pdf").Count() }}
+type FileQueryBuilder struct {
+	dbh      *DBH
+	filters  []sqlFilter
+	orders   []sqlOrder
+	pageSize int // 0 = no limit
+	page     int // 1-based, default 1
+	// records the queries of a page render, see DependencyRecorder.FileQuery
+	recorder *DependencyRecorder
+}
+
+// NewFileQueryBuilder creates a new FileQueryBuilder using the given DBH instance.
+func NewFileQueryBuilder(dbh *DBH) *FileQueryBuilder {
+	return &FileQueryBuilder{
+		dbh:  dbh,
+		page: 1,
+	}
+}
+
+// copy returns a shallow copy of the builder with independent filter/order slices.
+func (b *FileQueryBuilder) copy() *FileQueryBuilder {
+	c := *b
+	c.filters = append([]sqlFilter{}, b.filters...)
+	c.orders = append([]sqlOrder{}, b.orders...)
+	return &c
+}
+
+// ---------- filter methods ----------
+
+// WhereRoute adds a filter that matches files by their route. Supports exact
+// match (e.g. "/downloads/report.pdf") or prefix match with a trailing wildcard
+// (e.g. "/downloads/*" matches all routes starting with "/downloads/").
+//
+// Template example:
+//
+//	FileQuery().WhereRoute("/downloads/*").FetchAll()
+func (b *FileQueryBuilder) WhereRoute(route string) *FileQueryBuilder {
+	c := b.copy()
+	c.filters = append(c.filters, routeFilter("route", route))
+	return c
+}
+
+// WhereParentRoute adds a filter that matches files by the route of their page.
+// Supports exact match (e.g. "/gallery": the files of this page only) or
+// subtree match with a trailing wildcard (e.g. "/gallery/*": the files of
+// "/gallery" and all pages below it).
+//
+// Template examples:
+//
+//	FileQuery().WhereParentRoute(Page.Route).FetchAll()
+//	FileQuery().WhereParentRoute("/gallery/*").FetchAll()
+func (b *FileQueryBuilder) WhereParentRoute(route string) *FileQueryBuilder {
+	c := b.copy()
+	c.filters = append(c.filters, routeFilter("parent_page_route", route))
+	return c
+}
+
+// WhereMimeType adds a filter that matches files whose MIME type starts with
+// the given prefix, e.g. "image/" for all images, or "application/pdf".
+//
+// Template example:
+//
+//	FileQuery().WhereMimeType("image/").FetchAll()
+func (b *FileQueryBuilder) WhereMimeType(prefix string) *FileQueryBuilder {
+	c := b.copy()
+	c.filters = append(c.filters, sqlFilter{
+		clause: "substr(mime_type, 1, length(?)) = ?",
+		args:   []any{prefix, prefix},
+	})
+	return c
+}
+
+// WhereFileName adds a filter that matches file names (without path) against a
+// glob pattern: "*" matches any number of characters, "?" a single character,
+// "[abc]" one of the given characters. The match is case-sensitive.
+//
+// Template example:
+//
+//	FileQuery().WhereFileName("*.pdf").FetchAll()
+func (b *FileQueryBuilder) WhereFileName(pattern string) *FileQueryBuilder {
+	c := b.copy()
+	c.filters = append(c.filters, sqlFilter{
+		clause: "file_name GLOB ?",
+		args:   []any{pattern},
+	})
+	return c
+}
+
+// WhereMetadataEquals adds a filter that matches files where at least one of the
+// given JSON field paths of their sidecar metadata has the exact value. Multiple
+// paths are ORed.
+//
+// Template example:
+//
+//	FileQuery().WhereMetadataEquals(List("credit"), "Jane Doe").FetchAll()
+func (b *FileQueryBuilder) WhereMetadataEquals(fields []string, value string) *FileQueryBuilder {
+	orParts, args := metadataOrClauses(fields, "= ?", value)
+	return b.withOrFilter(orParts, args)
+}
+
+// WhereMetadataContains adds a filter that matches files where at least one of
+// the given JSON field paths of their sidecar metadata contains the value: a
+// substring of a string value, or an element of an array value. Multiple paths
+// are ORed.
+//
+// Template example:
+//
+//	FileQuery().WhereMimeType("image/").WhereMetadataContains(List("tags"), "portrait").FetchAll()
+func (b *FileQueryBuilder) WhereMetadataContains(fields []string, value string) *FileQueryBuilder {
+	orParts, args := metadataContainsClauses(fields, value)
+	return b.withOrFilter(orParts, args)
+}
+
+// WhereMetadataIsOneOf adds a filter that matches files where at least one of
+// the given JSON field paths of their sidecar metadata matches at least one of
+// the given values. Works for both string and JSON array metadata values.
+//
+// Template example:
+//
+//	FileQuery().WhereMetadataIsOneOf(List("category"), List("manual", "datasheet")).FetchAll()
+func (b *FileQueryBuilder) WhereMetadataIsOneOf(fields []string, values []string) *FileQueryBuilder {
+	orParts, args := metadataIsOneOfClauses(fields, values)
+	return b.withOrFilter(orParts, args)
+}
+
+// withOrFilter returns a copy of the builder with the given clauses, ORed, as
+// an additional filter. No clauses add no filter.
+func (b *FileQueryBuilder) withOrFilter(orParts []string, args []any) *FileQueryBuilder {
+	c := b.copy()
+	if len(orParts) > 0 {
+		c.filters = append(c.filters, sqlFilter{
+			clause: "(" + strings.Join(orParts, " OR ") + ")",
+			args:   args,
+		})
+	}
+	return c
+}
+
+// ---------- ordering and paging ----------
+
+// fileColumns lists the file table columns that can be used in OrderBy, with
+// the short aliases "name" and "size".
+var fileColumns = map[string]string{
+	"route":        "route",
+	"name":         "file_name",
+	"file_name":    "file_name",
+	"size":         "file_size",
+	"file_size":    "file_size",
+	"mime_type":    "mime_type",
+	"width":        "width",
+	"height":       "height",
+	"capture_date": "capture_date",
+	"updated_at":   "updated_at",
+	"created_at":   "created_at",
+}
+
+// OrderBy adds a sort clause. The field can be "route", "name" / "file_name",
+// "size" / "file_size", "mime_type", "width", "height", "capture_date" (of
+// images, see model.IndexedFile), "updated_at" or "created_at", or a JSON path
+// of the sidecar metadata (e.g. "sort"), which sorts by type like in
+// PageQueryBuilder.OrderBy. Files without the metadata field come first in
+// ascending order. The direction must be "asc" or "desc". Multiple calls are
+// cumulative.
+//
+// Template example:
+//
+//	FileQuery().OrderBy("size", "desc").FetchAll()
+//	FileQuery().WhereParentRoute(Page.Route).OrderBy("sort", "asc").OrderBy("name", "asc").FetchAll()
+func (b *FileQueryBuilder) OrderBy(field string, direction string) *FileQueryBuilder {
+	dir := strings.ToUpper(strings.TrimSpace(direction))
+	if dir != "ASC" && dir != "DESC" {
+		dir = "ASC"
+	}
+
+	c := b.copy()
+	if col, ok := fileColumns[field]; ok {
+		c.orders = append(c.orders, sqlOrder{expr: col, direction: dir})
+	} else if validJSONPath.MatchString(field) {
+		c.orders = append(c.orders, sqlOrder{
+			expr:      fmt.Sprintf("json_extract(metadata_sort_json, '$.%s')", field),
+			direction: dir,
+		})
+	}
+	return c
+}
+
+// PageSize sets the maximum number of results per page. A value <= 0 removes
+// the limit. Non-cumulative: the last call wins.
+func (b *FileQueryBuilder) PageSize(size int) *FileQueryBuilder {
+	c := b.copy()
+	if size <= 0 {
+		c.pageSize = 0
+	} else {
+		c.pageSize = size
+	}
+	return c
+}
+
+// Page sets the 1-based page number. Has no effect unless PageSize is set.
+//
+// Template example:
+//
+//	FileQuery().WhereMimeType("image/").PageSize(20).Page(2).FetchAll()
+func (b *FileQueryBuilder) Page(page int) *FileQueryBuilder {
+	c := b.copy()
+	if page < 1 {
+		c.page = 1
+	} else {
+		c.page = page
+	}
+	return c
+}
+
+// ---------- terminal methods ----------
+
+// FetchAll executes the query and returns all matching files.
+//
+// Template example:
+//
+//	{% for f in FileQuery().WhereParentRoute("/downloads").OrderBy("name","asc").FetchAll() %}
+//	    <a href="{{ Webroot(f.Route) }}">{{ f.FileName }}</a> ({{ f.FileSize }} bytes)
+//	{% endfor %}
+func (b *FileQueryBuilder) FetchAll() []model.IndexedFile {
+	query, args := b.buildSelectSQL()
+	b.recorder.recordQuery(b.dbh, query, args)
+	rows, err := b.dbh.db.Query(query, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var files []model.IndexedFile
+	for rows.Next() {
+		if file, ok := scanFileRow(rows); ok {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// First executes the query and returns the first matching result, or nil if
+// no result is found.
+//
+// Template example:
+//
+//	{% with cover=FileQuery().WhereParentRoute(Page.Route).WhereFileName("cover.*").First() %}
+//	    {% if cover %}<img src="{{ Webroot(cover.Route) }}">{% endif %}
+//	{% endwith %}
+func (b *FileQueryBuilder) First() *model.IndexedFile {
+	c := b.copy()
+	c.pageSize = 1
+	c.page = 1
+
+	query, args := c.buildSelectSQL()
+	c.recorder.recordQuery(c.dbh, query, args)
+	rows, err := c.dbh.db.Query(query, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil
+	}
+	file, ok := scanFileRow(rows)
+	if !ok {
+		return nil
+	}
+	return &file
+}
+
+// Count returns the total number of matching files (ignoring PageSize/Page).
+//
+// Template example:
+//
+//	{{ FileQuery().WhereMimeType("image/").Count() }}
+func (b *FileQueryBuilder) Count() int {
+	query, args := b.buildCountSQL()
+	b.recorder.recordQuery(b.dbh, query, args)
+	rows, err := b.dbh.db.Query(query, args...)
+	if err != nil {
+		return 0
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0
+	}
+	var count int
+	if err := rows.Scan(&count); err != nil {
+		return 0
+	}
+	return count
+}
+
+// NrOfPages returns the number of available result pages based on Count() and
+// the configured PageSize. Returns 1 if PageSize is not set.
+func (b *FileQueryBuilder) NrOfPages() int {
+	if b.pageSize <= 0 {
+		return 1
+	}
+	total := b.Count()
+	return int(math.Ceil(float64(total) / float64(b.pageSize)))
+}
+
+// ---------- SQL building ----------
+
+func (b *FileQueryBuilder) buildWhereClause() (string, []any) {
+	// Only files that are served: enabled, and of a visible page.
+	clauses := []string{visibleFilesCondition}
+	var args []any
+
+	for _, f := range b.filters {
+		clauses = append(clauses, f.clause)
+		args = append(args, f.args...)
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+func (b *FileQueryBuilder) buildSelectSQL() (string, []any) {
+	where, args := b.buildWhereClause()
+	query := "SELECT " + fileSelectColumns + " FROM files WHERE " + where
+
+	var parts []string
+	for _, o := range b.orders {
+		parts = append(parts, o.expr+" "+o.direction)
+	}
+	if len(parts) > 0 {
+		query += " ORDER BY " + strings.Join(parts, ", ")
+	}
+
+	limitSQL, limitArgs := limitOffsetSQL(b.pageSize, b.page)
+	query += limitSQL
+	return query, append(args, limitArgs...)
+}
+
+func (b *FileQueryBuilder) buildCountSQL() (string, []any) {
+	where, args := b.buildWhereClause()
+	return "SELECT COUNT(1) FROM files WHERE " + where, args
+}
+
+// scanFileRow scans a single row with the standard files column set.
+func scanFileRow(rows *sql.Rows) (model.IndexedFile, bool) {
+	record, err := scanFile(rows)
+	if err != nil {
+		return model.IndexedFile{}, false
+	}
+	return record, true
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"testing"
+
+	"alexi.ch/pcms/model"
+)
+
+func setupFileQueryBuilderDB(t *testing.T) *DBH {
+	t.Helper()
+	dbh := setupQueryBuilderDB(t)
+
+	files := []model.IndexedFile{
+		{Route: "/logo.svg", ParentPageRoute: "/", FileName: "logo.svg", MimeType: "image/svg+xml", FileSize: 300, Enabled: true},
+		{Route: "/blog/header.jpg", ParentPageRoute: "/blog", FileName: "header.jpg", MimeType: "image/jpeg", FileSize: 5000, Enabled: true,
+			Metadata: map[string]any{"caption": "Header", "sort": 10, "tags": []any{"banner"}}},
+		{Route: "/blog/post-1/photo.png", ParentPageRoute: "/blog/post-1", FileName: "photo.png", MimeType: "image/png", FileSize: 2000, Enabled: true,
+			Metadata: map[string]any{"caption": "Photo", "sort": 2, "tags": []any{"portrait", "banner"}}},
+		{Route: "/blog/post-1/slides.pdf", ParentPageRoute: "/blog/post-1", FileName: "slides.pdf", MimeType: "application/pdf", FileSize: 9000, Enabled: true},
+		{Route: "/blog/post-2/old.png", ParentPageRoute: "/blog/post-2", FileName: "old.png", MimeType: "image/png", FileSize: 100, Enabled: false},
+		{Route: "/blog/draft/draft.png", ParentPageRoute: "/blog/draft", FileName: "draft.png", MimeType: "image/png", FileSize: 100, Enabled: true},
+	}
+	for _, f := range files {
+		if err := dbh.ReplaceFile(f); err != nil {
+			t.Fatalf("ReplaceFile(%s) error = %v", f.Route, err)
+		}
+	}
+	return dbh
+}
+
+func fileRoutes(files []model.IndexedFile) []string {
+	var routes []string
+	for _, f := range files {
+		routes = append(routes, f.Route)
+	}
+	return routes
+}
+
+func TestFileQueryBuilder_Filters(t *testing.T) {
+	dbh := setupFileQueryBuilderDB(t)
+	defer dbh.Close()
+
+	// disabled files and files of disabled pages are never returned:
+	if n := NewFileQueryBuilder(dbh).Count(); n != 4 {
+		t.Fatalf("Count() = %d, want 4", n)
+	}
+
+	images := fileRoutes(NewFileQueryBuilder(dbh).WhereParentRoute("/blog/*").WhereMimeType("image/").OrderBy("name", "asc").FetchAll())
+	if len(images) != 2 || images[0] != "/blog/header.jpg" || images[1] != "/blog/post-1/photo.png" {
+		t.Fatalf("images below /blog = %v", images)
+	}
+
+	direct := fileRoutes(NewFileQueryBuilder(dbh).WhereParentRoute("/blog/post-1").FetchAll())
+	if len(direct) != 2 {
+		t.Fatalf("files of /blog/post-1 = %v, want 2", direct)
+	}
+
+	pdfs := fileRoutes(NewFileQueryBuilder(dbh).WhereFileName("*.pdf").FetchAll())
+	if len(pdfs) != 1 || pdfs[0] != "/blog/post-1/slides.pdf" {
+		t.Fatalf("WhereFileName(*.pdf) = %v", pdfs)
+	}
+
+	if first := NewFileQueryBuilder(dbh).WhereRoute("/nonexistent").First(); first != nil {
+		t.Fatalf("First() = %+v, want nil", first)
+	}
+}
+
+func TestFileQueryBuilder_OrderAndPaging(t *testing.T) {
+	dbh := setupFileQueryBuilderDB(t)
+	defer dbh.Close()
+
+	qb := NewFileQueryBuilder(dbh).OrderBy("size", "desc").PageSize(3)
+	if n := qb.NrOfPages(); n != 2 {
+		t.Fatalf("NrOfPages() = %d, want 2", n)
+	}
+	first := qb.First()
+	if first == nil || first.FileName != "slides.pdf" || first.FileSize != 9000 {
+		t.Fatalf("First() = %+v, want slides.pdf", first)
+	}
+	second := fileRoutes(qb.Page(2).FetchAll())
+	if len(second) != 1 || second[0] != "/logo.svg" {
+		t.Fatalf("Page(2) = %v, want the smallest file", second)
+	}
+
+	// the builder is immutable:
+	if n := len(qb.FetchAll()); n != 3 {
+		t.Fatalf("FetchAll() on the original builder = %d files, want 3", n)
+	}
+}
+
+func TestFileQueryBuilder_Metadata(t *testing.T) {
+	dbh := setupFileQueryBuilderDB(t)
+	defer dbh.Close()
+
+	banners := fileRoutes(NewFileQueryBuilder(dbh).WhereMetadataContains([]string{"tags"}, "banner").OrderBy("sort", "asc").FetchAll())
+	if len(banners) != 2 || banners[0] != "/blog/post-1/photo.png" || banners[1] != "/blog/header.jpg" {
+		t.Fatalf("banners by sort = %v", banners)
+	}
+
+	header := NewFileQueryBuilder(dbh).WhereMetadataEquals([]string{"caption"}, "Header").First()
+	if header == nil || header.Route != "/blog/header.jpg" || header.Metadata["caption"] != "Header" {
+		t.Fatalf("WhereMetadataEquals(caption) = %+v", header)
+	}
+
+	if n := NewFileQueryBuilder(dbh).WhereMetadataIsOneOf([]string{"tags"}, []string{"portrait", "landscape"}).Count(); n != 1 {
+		t.Fatalf("WhereMetadataIsOneOf(tags) count = %d, want 1", n)
+	}
+
+	// files without the field come first in ascending order:
+	first := NewFileQueryBuilder(dbh).OrderBy("sort", "asc").OrderBy("name", "asc").First()
+	if first == nil || first.Metadata["sort"] != nil {
+		t.Fatalf("first file by sort = %+v, want one without sort", first)
+	}
+}
@@ -0,0 +1,339 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // register GIF header decoder
+	_ "image/jpeg" // register JPEG header decoder
+	_ "image/png"  // register PNG header decoder
+	"io"
+	"io/fs"
+	"math"
+	"strings"
+	"time"
+
+	"alexi.ch/pcms/model"
+	_ "golang.org/x/image/webp" // register WebP header decoder
+)
+
+// imageHeaderSize is the number of bytes read from the start of a JPEG image
+// while looking for its EXIF data: room for the EXIF segment, which cannot be
+// larger than 64 KiB, and the segments before it.
+const imageHeaderSize = 128 << 10
+
+// exifDateLayout is the format of the EXIF date tags.
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// inspectImage sets the image properties of an indexed image file: its
+// dimensions from the image header, and for JPEG images the orientation,
+// capture date, camera and (with exifGPS) GPS position from its EXIF data.
+// Files that cannot be decoded are left as they are: a broken image is still
+// served, it just has no dimensions.
+func inspectImage(srcFS fs.FS, filePath string, record *model.IndexedFile, exifGPS bool) error {
+	file, err := srcFS.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("open image %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	// the header is decoded from a buffered reader, so that the EXIF data
+	// can be read from the start of the file again without a second open:
+	reader := bufio.NewReaderSize(file, imageHeaderSize)
+	header, err := reader.Peek(imageHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return fmt.Errorf("read image %s: %w", filePath, err)
+	}
+	header = append([]byte{}, header...)
+
+	config, format, err := image.DecodeConfig(reader)
+	if err != nil {
+		return nil
+	}
+	record.Width, record.Height = config.Width, config.Height
+	if format != "jpeg" {
+		return nil
+	}
+
+	exif, ok := readJPEGExif(header)
+	if !ok {
+		return nil
+	}
+	record.Orientation = exif.orientation
+	if exif.orientation >= 5 && exif.orientation <= 8 {
+		// rotated by 90 degrees: displayed with width and height swapped
+		record.Width, record.Height = record.Height, record.Width
+	}
+	record.CaptureDate = exif.captureDate
+	record.Camera = exif.camera()
+	if exifGPS && exif.hasLocation {
+		record.HasLocation = true
+		record.Latitude = exif.latitude
+		record.Longitude = exif.longitude
+	}
+	return nil
+}
+
+// exifData holds the EXIF fields read by readJPEGExif.
+type exifData struct {
+	orientation int
+	captureDate time.Time
+	make        string
+	model       string
+	hasLocation bool
+	latitude    float64
+	longitude   float64
+}
+
+// camera returns the camera make and model. The model often contains the make
+// already ("Canon EOS R6"), it is not repeated then.
+func (e exifData) camera() string {
+	if e.make == "" || strings.HasPrefix(strings.ToLower(e.model), strings.ToLower(e.make)) {
+		return e.model
+	}
+	if e.model == "" {
+		return e.make
+	}
+	return e.make + " " + e.model
+}
+
+// readJPEGExif reads the EXIF data from the APP1 segment of a JPEG image, given
+// the start of the image file. Returns false if the image has no (readable)
+// EXIF data in the given bytes.
+func readJPEGExif(data []byte) (exifData, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return exifData{}, false
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return exifData{}, false
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF:
+			// fill byte
+			pos++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// standalone markers without length
+			pos += 2
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// start of scan / end of image: the metadata segments are over
+			return exifData{}, false
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return exifData{}, false
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseTIFFExif(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return exifData{}, false
+}
+
+// EXIF tags read by parseTIFFExif:
+const (
+	exifTagMake              = 0x010F
+	exifTagModel             = 0x0110
+	exifTagOrientation       = 0x0112
+	exifTagDateTime          = 0x0132
+	exifTagExifIFD           = 0x8769
+	exifTagGPSIFD            = 0x8825
+	exifTagDateTimeOriginal  = 0x9003
+	exifTagDateTimeDigitized = 0x9004
+	exifTagOffsetTimeOrig    = 0x9011
+	exifTagGPSLatitudeRef    = 0x0001
+	exifTagGPSLatitude       = 0x0002
+	exifTagGPSLongitudeRef   = 0x0003
+	exifTagGPSLongitude      = 0x0004
+)
+
+// exifTypeSizes are the sizes in bytes of the EXIF value types, by type id.
+var exifTypeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// tiffReader reads the IFDs of the TIFF structure in an EXIF segment.
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is a single tag of an IFD, with its raw value bytes.
+type ifdEntry struct {
+	valueType uint16
+	count     int
+	value     []byte
+}
+
+// parseTIFFExif parses the TIFF structure of an EXIF segment: IFD0 with the
+// camera and orientation, and the EXIF and GPS IFDs it points to.
+func parseTIFFExif(data []byte) (exifData, bool) {
+	if len(data) < 8 {
+		return exifData{}, false
+	}
+	r := tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return exifData{}, false
+	}
+	if r.order.Uint16(data[2:]) != 42 {
+		return exifData{}, false
+	}
+
+	ifd0, ok := r.readIFD(int(r.order.Uint32(data[4:])))
+	if !ok {
+		return exifData{}, false
+	}
+
+	var exif exifData
+	if entry, ok := ifd0[exifTagOrientation]; ok {
+		if orientation, ok := r.uintValue(entry); ok && orientation >= 1 && orientation <= 8 {
+			exif.orientation = int(orientation)
+		}
+	}
+	exif.make = r.stringValue(ifd0[exifTagMake])
+	exif.model = r.stringValue(ifd0[exifTagModel])
+	exif.captureDate = parseExifDate(r.stringValue(ifd0[exifTagDateTime]), "")
+
+	if entry, ok := ifd0[exifTagExifIFD]; ok {
+		offset, _ := r.uintValue(entry)
+		if exifIFD, ok := r.readIFD(int(offset)); ok {
+			offsetTime := r.stringValue(exifIFD[exifTagOffsetTimeOrig])
+			for _, tag := range []uint16{exifTagDateTimeOriginal, exifTagDateTimeDigitized} {
+				if date := parseExifDate(r.stringValue(exifIFD[tag]), offsetTime); !date.IsZero() {
+					exif.captureDate = date
+					break
+				}
+			}
+		}
+	}
+
+	if entry, ok := ifd0[exifTagGPSIFD]; ok {
+		offset, _ := r.uintValue(entry)
+		if gpsIFD, ok := r.readIFD(int(offset)); ok {
+			latitude, latOK := r.gpsCoordinate(gpsIFD[exifTagGPSLatitude], r.stringValue(gpsIFD[exifTagGPSLatitudeRef]), "S")
+			longitude, lonOK := r.gpsCoordinate(gpsIFD[exifTagGPSLongitude], r.stringValue(gpsIFD[exifTagGPSLongitudeRef]), "W")
+			if latOK && lonOK {
+				exif.hasLocation = true
+				exif.latitude, exif.longitude = latitude, longitude
+			}
+		}
+	}
+
+	return exif, true
+}
+
+// readIFD reads the entries of the IFD at the given offset, by tag.
+func (r tiffReader) readIFD(offset int) (map[uint16]ifdEntry, bool) {
+	if offset < 8 || offset+2 > len(r.data) {
+		return nil, false
+	}
+	count := int(r.order.Uint16(r.data[offset:]))
+	entries := make(map[uint16]ifdEntry, count)
+	for i := 0; i < count; i++ {
+		pos := offset + 2 + i*12
+		if pos+12 > len(r.data) {
+			return nil, false
+		}
+		tag := r.order.Uint16(r.data[pos:])
+		valueType := r.order.Uint16(r.data[pos+2:])
+		valueCount := int(r.order.Uint32(r.data[pos+4:]))
+		typeSize, known := exifTypeSizes[valueType]
+		if !known || valueCount < 0 || valueCount > len(r.data) {
+			continue
+		}
+		size := typeSize * valueCount
+		valueStart := pos + 8
+		if size > 4 {
+			valueStart = int(r.order.Uint32(r.data[pos+8:]))
+		}
+		if valueStart < 0 || valueStart+size > len(r.data) {
+			continue
+		}
+		entries[tag] = ifdEntry{valueType: valueType, count: valueCount, value: r.data[valueStart : valueStart+size]}
+	}
+	return entries, true
+}
+
+// uintValue returns the first value of a SHORT or LONG entry.
+func (r tiffReader) uintValue(entry ifdEntry) (uint32, bool) {
+	switch {
+	case entry.valueType == 3 && len(entry.value) >= 2:
+		return uint32(r.order.Uint16(entry.value)), true
+	case entry.valueType == 4 && len(entry.value) >= 4:
+		return r.order.Uint32(entry.value), true
+	}
+	return 0, false
+}
+
+// stringValue returns the value of an ASCII entry, without the terminating
+// NUL and surrounding spaces. Returns "" for entries of other types.
+func (r tiffReader) stringValue(entry ifdEntry) string {
+	if entry.valueType != 2 {
+		return ""
+	}
+	value := entry.value
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// gpsCoordinate converts a GPS latitude or longitude entry (degrees, minutes
+// and seconds as RATIONALs) to decimal degrees, negative for the given
+// reference ("S" or "W").
+func (r tiffReader) gpsCoordinate(entry ifdEntry, ref string, negativeRef string) (float64, bool) {
+	if entry.valueType != 5 || entry.count != 3 {
+		return 0, false
+	}
+	var parts [3]float64
+	for i := range parts {
+		numerator := r.order.Uint32(entry.value[i*8:])
+		denominator := r.order.Uint32(entry.value[i*8+4:])
+		if denominator == 0 {
+			return 0, false
+		}
+		parts[i] = float64(numerator) / float64(denominator)
+	}
+	coordinate := parts[0] + parts[1]/60 + parts[2]/3600
+	if strings.EqualFold(ref, negativeRef) {
+		coordinate = -coordinate
+	}
+	if math.IsNaN(coordinate) || math.Abs(coordinate) > 180 {
+		return 0, false
+	}
+	return coordinate, true
+}
+
+// parseExifDate parses an EXIF date ("2024:05:01 14:30:00"), with the time
+// zone offset of the matching OffsetTime tag ("+02:00"), if there is one. A
+// date without offset is taken as UTC. Returns the zero time for an empty or
+// invalid date.
+func parseExifDate(value string, offset string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if offset != "" {
+		if date, err := time.Parse(exifDateLayout+"-07:00", value+offset); err == nil {
+			return date
+		}
+	}
+	date, err := time.Parse(exifDateLayout, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
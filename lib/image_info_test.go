@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"alexi.ch/pcms/model"
+)
+
+// testTag is an IFD entry of a test EXIF segment, see buildTestTIFF.
+type testTag struct {
+	tag       uint16
+	valueType uint16
+	count     uint32
+	value     []byte
+}
+
+func asciiTag(tag uint16, s string) testTag {
+	value := append([]byte(s), 0)
+	return testTag{tag, 2, uint32(len(value)), value}
+}
+
+func shortTag(tag uint16, v uint16) testTag {
+	value := binary.LittleEndian.AppendUint16(nil, v)
+	return testTag{tag, 3, 1, value}
+}
+
+func longTag(tag uint16, v uint32) testTag {
+	return testTag{tag, 4, 1, binary.LittleEndian.AppendUint32(nil, v)}
+}
+
+// rationalsTag takes numerator / denominator pairs.
+func rationalsTag(tag uint16, values ...uint32) testTag {
+	var value []byte
+	for _, v := range values {
+		value = binary.LittleEndian.AppendUint32(value, v)
+	}
+	return testTag{tag, 5, uint32(len(values) / 2), value}
+}
+
+func testIFDSize(tags []testTag) int {
+	size := 2 + 12*len(tags) + 4
+	for _, t := range tags {
+		if len(t.value) > 4 {
+			size += len(t.value)
+		}
+	}
+	return size
+}
+
+func encodeTestIFD(tags []testTag, offset int) []byte {
+	data := offset + 2 + 12*len(tags) + 4
+	var ifd, values []byte
+	ifd = binary.LittleEndian.AppendUint16(ifd, uint16(len(tags)))
+	for _, t := range tags {
+		ifd = binary.LittleEndian.AppendUint16(ifd, t.tag)
+		ifd = binary.LittleEndian.AppendUint16(ifd, t.valueType)
+		ifd = binary.LittleEndian.AppendUint32(ifd, t.count)
+		if len(t.value) > 4 {
+			ifd = binary.LittleEndian.AppendUint32(ifd, uint32(data+len(values)))
+			values = append(values, t.value...)
+		} else {
+			ifd = append(ifd, append(t.value, make([]byte, 4-len(t.value))...)...)
+		}
+	}
+	ifd = binary.LittleEndian.AppendUint32(ifd, 0)
+	return append(ifd, values...)
+}
+
+// buildTestTIFF builds a little-endian TIFF structure with IFD0, and the EXIF
+// and GPS IFDs IFD0 points to.
+func buildTestTIFF(ifd0 []testTag, exifIFD []testTag, gpsIFD []testTag) []byte {
+	ifd0 = append(ifd0, longTag(exifTagExifIFD, 0), longTag(exifTagGPSIFD, 0))
+	exifOffset := 8 + testIFDSize(ifd0)
+	gpsOffset := exifOffset + testIFDSize(exifIFD)
+	ifd0[len(ifd0)-2] = longTag(exifTagExifIFD, uint32(exifOffset))
+	ifd0[len(ifd0)-1] = longTag(exifTagGPSIFD, uint32(gpsOffset))
+
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = append(tiff, encodeTestIFD(ifd0, 8)...)
+	tiff = append(tiff, encodeTestIFD(exifIFD, exifOffset)...)
+	return append(tiff, encodeTestIFD(gpsIFD, gpsOffset)...)
+}
+
+// testJPEG encodes a JPEG image of the given size, with the TIFF structure as
+// EXIF segment.
+func testJPEG(t *testing.T, width int, height int, tiff []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func TestInspectImage(t *testing.T) {
+	tiff := buildTestTIFF(
+		[]testTag{asciiTag(exifTagMake, "Canon"), asciiTag(exifTagModel, "Canon EOS R6"), shortTag(exifTagOrientation, 6)},
+		[]testTag{asciiTag(exifTagDateTimeOriginal, "2024:05:01 14:30:00"), asciiTag(exifTagOffsetTimeOrig, "+02:00")},
+		[]testTag{
+			asciiTag(exifTagGPSLatitudeRef, "N"), rationalsTag(exifTagGPSLatitude, 47, 1, 22, 1, 30, 1),
+			asciiTag(exifTagGPSLongitudeRef, "W"), rationalsTag(exifTagGPSLongitude, 8, 1, 3240, 100, 0, 1),
+		},
+	)
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 5, 3))); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	srcFS := fstest.MapFS{
+		"photo.jpg":  &fstest.MapFile{Data: testJPEG(t, 4, 2, tiff)},
+		"plain.png":  &fstest.MapFile{Data: pngData.Bytes()},
+		"broken.jpg": &fstest.MapFile{Data: []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00}},
+	}
+
+	var photo model.IndexedFile
+	if err := inspectImage(srcFS, "photo.jpg", &photo, false); err != nil {
+		t.Fatalf("inspectImage(photo.jpg) error = %v", err)
+	}
+	// orientation 6 is rotated by 90 degrees: width and height are swapped
+	if photo.Width != 2 || photo.Height != 4 || photo.Orientation != 6 {
+		t.Fatalf("photo.jpg size = %dx%d, orientation %d, want 2x4, 6", photo.Width, photo.Height, photo.Orientation)
+	}
+	if photo.Camera != "Canon EOS R6" {
+		t.Fatalf("photo.jpg camera = %q", photo.Camera)
+	}
+	if want := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC); !photo.CaptureDate.Equal(want) {
+		t.Fatalf("photo.jpg capture date = %v, want %v", photo.CaptureDate, want)
+	}
+	if photo.HasLocation {
+		t.Fatalf("photo.jpg has a location without exifGPS")
+	}
+
+	var located model.IndexedFile
+	if err := inspectImage(srcFS, "photo.jpg", &located, true); err != nil {
+		t.Fatalf("inspectImage(photo.jpg) error = %v", err)
+	}
+	if !located.HasLocation || math.Abs(located.Latitude-47.375) > 1e-9 || math.Abs(located.Longitude+8.54) > 1e-9 {
+		t.Fatalf("photo.jpg location = %v %v, %v", located.HasLocation, located.Latitude, located.Longitude)
+	}
+
+	var plain model.IndexedFile
+	if err := inspectImage(srcFS, "plain.png", &plain, true); err != nil {
+		t.Fatalf("inspectImage(plain.png) error = %v", err)
+	}
+	if plain.Width != 5 || plain.Height != 3 || plain.Orientation != 0 || !plain.CaptureDate.IsZero() {
+		t.Fatalf("plain.png = %+v", plain)
+	}
+
+	// a broken image is no error, it has no properties:
+	var broken model.IndexedFile
+	if err := inspectImage(srcFS, "broken.jpg", &broken, true); err != nil || broken.Width != 0 {
+		t.Fatalf("inspectImage(broken.jpg) = %+v, error = %v", broken, err)
+	}
+
+	// the properties are stored in the index:
+	dbh, err := OpenDBH(filepath.Join(t.TempDir(), "pcms-images.db"))
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	defer dbh.Close()
+	if err := dbh.ReplacePage(model.IndexedPage{Route: "/", Enabled: true}); err != nil {
+		t.Fatalf("ReplacePage() error = %v", err)
+	}
+	located.Route, located.ParentPageRoute, located.FileName, located.Enabled = "/photo.jpg", "/", "photo.jpg", true
+	if err := dbh.ReplaceFile(located); err != nil {
+		t.Fatalf("ReplaceFile() error = %v", err)
+	}
+	stored, found, err := dbh.GetFileByRoute("/photo.jpg")
+	if err != nil || !found {
+		t.Fatalf("GetFileByRoute() found = %v, error = %v", found, err)
+	}
+	if imagePropertiesChanged(located, stored) {
+		t.Fatalf("stored image properties = %+v, want %+v", stored, located)
+	}
+}
@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"alexi.ch/pcms/stdlib"
+	"gopkg.in/yaml.v3"
+)
+
+// IndexFormat is a page index file format: a folder with an index file of a
+// registered format ("index.md", or "index.de.md" for a language variant) is a
+// page. The index uses the format to read the metadata and the full-text of the
+// page; the processor registered for the format's name renders it (see
+// processor.Register).
+type IndexFormat struct {
+	// Name of the format, e.g. "md"
+	Name string
+	// Extensions are the index file extensions of the format, e.g. ".md"
+	Extensions []string
+	// ParseFrontMatter splits the source of an index file into its metadata and
+	// its body
+	ParseFrontMatter func(source string) (map[string]any, string, error)
+	// PlainText returns the readable text of a page for the full-text index,
+	// from its metadata and body
+	PlainText func(metadata map[string]any, body string) string
+	// Fallback formats (plain text and data) only make the page if the folder
+	// has no index file of a content format: index.json next to index.md is a
+	// plain file of the Markdown page
+	Fallback bool
+}
+
+var (
+	indexFormatsMu sync.RWMutex
+	// indexFormats holds the registered formats by name, indexFormatExtensions
+	// their names by lower case extension
+	indexFormats          = map[string]IndexFormat{}
+	indexFormatExtensions = map[string]string{}
+)
+
+func init() {
+	for _, format := range []IndexFormat{
+		{Name: "html", Extensions: []string{".html"}, ParseFrontMatter: YamlFrontMatter, PlainText: templatePlainText},
+		{Name: "md", Extensions: []string{".md"}, ParseFrontMatter: YamlFrontMatter, PlainText: markdownPlainText},
+		{Name: "txt", Extensions: []string{".txt"}, ParseFrontMatter: YamlFrontMatter, PlainText: textPlainText, Fallback: true},
+		{Name: "json", Extensions: []string{".json"}, ParseFrontMatter: jsonDataPage, PlainText: dataPlainText, Fallback: true},
+		{Name: "yaml", Extensions: []string{".yaml", ".yml"}, ParseFrontMatter: yamlDataPage, PlainText: dataPlainText, Fallback: true},
+	} {
+		if err := RegisterIndexFormat(format); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterIndexFormat registers a page index file format, or replaces the
+// registered format of the same name. An extension can only belong to one
+// format.
+func RegisterIndexFormat(format IndexFormat) error {
+	if format.Name == "" || len(format.Extensions) == 0 || format.ParseFrontMatter == nil {
+		return fmt.Errorf("index format %q: name, extensions and front matter parser are required", format.Name)
+	}
+	indexFormatsMu.Lock()
+	defer indexFormatsMu.Unlock()
+
+	for _, ext := range format.Extensions {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") || strings.Count(ext, ".") != 1 {
+			return fmt.Errorf("index format %q: invalid extension %q", format.Name, ext)
+		}
+		if name, exists := indexFormatExtensions[ext]; exists && name != format.Name {
+			return fmt.Errorf("index format %q: extension %s is already registered by format %q", format.Name, ext, name)
+		}
+	}
+	if previous, exists := indexFormats[format.Name]; exists {
+		for _, ext := range previous.Extensions {
+			delete(indexFormatExtensions, strings.ToLower(ext))
+		}
+	}
+	if format.PlainText == nil {
+		format.PlainText = textPlainText
+	}
+	for _, ext := range format.Extensions {
+		indexFormatExtensions[strings.ToLower(ext)] = format.Name
+	}
+	indexFormats[format.Name] = format
+	return nil
+}
+
+// IndexFormats returns the registered index file formats, ordered by name.
+func IndexFormats() []IndexFormat {
+	indexFormatsMu.RLock()
+	defer indexFormatsMu.RUnlock()
+	formats := make([]IndexFormat, 0, len(indexFormats))
+	for _, format := range indexFormats {
+		formats = append(formats, format)
+	}
+	sort.Slice(formats, func(i, j int) bool { return formats[i].Name < formats[j].Name })
+	return formats
+}
+
+// IndexFormatOf returns the format of an index file, by its extension.
+func IndexFormatOf(indexFile string) (IndexFormat, bool) {
+	indexFormatsMu.RLock()
+	defer indexFormatsMu.RUnlock()
+	name, ok := indexFormatExtensions[strings.ToLower(path.Ext(indexFile))]
+	if !ok {
+		return IndexFormat{}, false
+	}
+	return indexFormats[name], true
+}
+
+// YamlFrontMatter splits the source of an index file into its YAML front
+// matter and its body.
+func YamlFrontMatter(source string) (map[string]any, string, error) {
+	metadata, body, err := stdlib.ExtractYamlFrontMatter(source)
+	return metadata, body, err
+}
+
+// jsonDataPage parses a data-only page: the whole JSON object is its metadata.
+func jsonDataPage(source string) (map[string]any, string, error) {
+	metadata := map[string]any{}
+	if strings.TrimSpace(source) == "" {
+		return metadata, "", nil
+	}
+	if err := json.Unmarshal([]byte(source), &metadata); err != nil {
+		return nil, "", err
+	}
+	return metadata, "", nil
+}
+
+// yamlDataPage parses a data-only page: the whole YAML document is its metadata.
+func yamlDataPage(source string) (map[string]any, string, error) {
+	metadata := map[string]any{}
+	if err := yaml.Unmarshal([]byte(source), &metadata); err != nil {
+		return nil, "", err
+	}
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	return metadata, "", nil
+}
@@ -0,0 +1,389 @@
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"alexi.ch/pcms/model"
+)
+
+// LoadIndexSnapshot reads all indexed pages and files, including their source
+// signatures (mtime, size, content hash). It is the counterpart of
+// BuildIndexSnapshot and is used to diff the DB against a fresh tree walk.
+func (h *DBH) LoadIndexSnapshot() (*model.IndexSnapshot, error) {
+	return h.loadIndexSnapshotUnder("/")
+}
+
+// loadIndexSnapshotUnder works like LoadIndexSnapshot, but only reads the pages
+// and files at the given route and below it.
+func (h *DBH) loadIndexSnapshotUnder(route string) (*model.IndexSnapshot, error) {
+	prefix := routePrefix(route)
+	snapshot := &model.IndexSnapshot{
+		Pages: make([]model.IndexedPage, 0),
+		Files: make([]model.IndexedFile, 0),
+	}
+
+	pageRows, err := h.queryIndex(`
+		SELECT `+sourcePageColumns+`
+		FROM pages
+		WHERE route = ? OR substr(route, 1, length(?)) = ?
+		ORDER BY route
+	`, route, prefix, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("query indexed pages: %w", err)
+	}
+	defer pageRows.Close()
+
+	for pageRows.Next() {
+		record, err := scanSourcePage(pageRows)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Pages = append(snapshot.Pages, record)
+	}
+	if err := pageRows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate indexed pages: %w", err)
+	}
+
+	fileRows, err := h.queryIndex(`
+		SELECT `+fileSelectColumns+`, source_mtime, source_hash
+		FROM files
+		WHERE route = ? OR substr(route, 1, length(?)) = ?
+		ORDER BY route
+	`, route, prefix, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("query indexed files: %w", err)
+	}
+	defer fileRows.Close()
+
+	for fileRows.Next() {
+		var sourceModTime, sourceHash string
+		record, err := scanFile(fileRows, &sourceModTime, &sourceHash)
+		if err != nil {
+			return nil, fmt.Errorf("scan indexed file: %w", err)
+		}
+		record.SourceHash = sourceHash
+		if record.SourceModTime, err = parseSourceModTime(sourceModTime); err != nil {
+			return nil, fmt.Errorf("parse source_mtime for file %s: %w", record.Route, err)
+		}
+		snapshot.Files = append(snapshot.Files, record)
+	}
+	if err := fileRows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate indexed files: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// sourcePageColumns are the pages columns compared by an index sync, see scanSourcePage.
+const sourcePageColumns = `route, parent_page_route, title, index_file, enabled, metadata_json, publish_date, expiry_date, source_mtime, source_size, source_hash`
+
+// scanSourcePage reads a page with its source signature, selected by sourcePageColumns.
+func scanSourcePage(row rowScanner) (model.IndexedPage, error) {
+	var record model.IndexedPage
+	var parentRoute sql.NullString
+	var metadataJSON string
+	var publishDate, expiryDate sql.NullString
+	var sourceModTime string
+	var enabledInt int
+	if err := row.Scan(
+		&record.Route,
+		&parentRoute,
+		&record.Title,
+		&record.IndexFile,
+		&enabledInt,
+		&metadataJSON,
+		&publishDate,
+		&expiryDate,
+		&sourceModTime,
+		&record.SourceSize,
+		&record.SourceHash,
+	); err != nil {
+		return record, fmt.Errorf("scan indexed page: %w", err)
+	}
+	if parentRoute.Valid {
+		r := parentRoute.String
+		record.ParentPageRoute = &r
+	}
+	record.Enabled = enabledInt != 0
+	var err error
+	if record.Metadata, err = unmarshalMetadata(metadataJSON); err != nil {
+		return record, fmt.Errorf("unmarshal metadata for page %s: %w", record.Route, err)
+	}
+	if record.PublishDate, record.ExpiryDate, err = parseScheduleDates(publishDate, expiryDate); err != nil {
+		return record, fmt.Errorf("parse schedule dates for page %s: %w", record.Route, err)
+	}
+	if record.SourceModTime, err = parseSourceModTime(sourceModTime); err != nil {
+		return record, fmt.Errorf("parse source_mtime for page %s: %w", record.Route, err)
+	}
+	return record, nil
+}
+
+// ReplacePageIfChanged stores a re-indexed page the way SyncIndex does: if its
+// content and tree position are unchanged, only its new source mtime is stored,
+// and its updated_at is kept. Reports whether the page was replaced.
+func (h *DBH) ReplacePageIfChanged(page model.IndexedPage) (bool, error) {
+	rows, err := h.queryIndex(`SELECT `+sourcePageColumns+` FROM pages WHERE route = ?`, page.Route)
+	if err != nil {
+		return false, fmt.Errorf("query indexed page %s: %w", page.Route, err)
+	}
+	var (
+		old   model.IndexedPage
+		found bool
+	)
+	if rows.Next() {
+		old, err = scanSourcePage(rows)
+		found = err == nil
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case !found || pageRecordChanged(old, page):
+		return true, h.ReplacePage(page)
+	case !old.SourceModTime.Equal(page.SourceModTime):
+		return false, h.updatePageSourceModTime(page.Route, page.SourceModTime)
+	default:
+		return false, nil
+	}
+}
+
+// SyncIndex brings the DB in line with the given snapshot without wiping it first:
+// new routes are inserted, changed ones updated and vanished ones deleted. Rows
+// whose content and structure are unchanged are left untouched.
+//
+// Should run inside an index transaction (BeginIndexRun / CommitIndexRun), so that
+// readers never see a half-synced index.
+func (h *DBH) SyncIndex(snapshot *model.IndexSnapshot) (model.IndexSyncStats, error) {
+	existing, err := h.LoadIndexSnapshot()
+	if err != nil {
+		return model.IndexSyncStats{}, err
+	}
+	return h.syncIndexSnapshot(existing, snapshot)
+}
+
+// SyncIndexRoute syncs a single route of the source tree, and everything below
+// it, with the DB: the route is walked and diffed against the rows at and below
+// it, as SyncIndex does for the whole tree. This is cheap enough to run for every
+// change a filesystem watcher reports.
+//
+// The route may point to a folder, a file, or to something that no longer exists
+// (its rows are then removed). A route pointing to a page index file syncs the
+// whole page folder, as the index file decides about the page and its files.
+// Likewise, a route pointing to a YAML file syncs its folder, as it may be the
+// metadata sidecar of a file in it (see readFileMetadata). exifGPS is passed on
+// as in BuildIncrementalIndexSnapshot. The warnings of the walk (see
+// IndexSnapshot.Warnings) are returned.
+//
+// Must run inside an index transaction (BeginIndexRun / CommitIndexRun).
+func (h *DBH) SyncIndexRoute(srcFS fs.FS, excludePatterns []string, exifGPS bool, route string) (model.IndexSyncStats, []string, error) {
+	route = path.Clean("/" + route)
+	if route != "/" && (isSupportedIndexFile(path.Base(route)) || strings.HasSuffix(route, sidecarSuffix)) {
+		route = path.Dir(route)
+	}
+
+	existing, err := h.loadIndexSnapshotUnder(route)
+	if err != nil {
+		return model.IndexSyncStats{}, nil, err
+	}
+	parent, err := h.findNearestIndexedPage(route)
+	if err != nil {
+		return model.IndexSyncStats{}, nil, err
+	}
+	snapshot, err := buildRouteIndexSnapshot(srcFS, excludePatterns, exifGPS, route, parent, existing)
+	if err != nil {
+		return model.IndexSyncStats{}, nil, err
+	}
+	stats, err := h.syncIndexSnapshot(existing, snapshot)
+	return stats, snapshot.Warnings, err
+}
+
+// findNearestIndexedPage returns the closest page above the given route, or nil
+// if there is none. Reads through the index transaction, if one is active.
+func (h *DBH) findNearestIndexedPage(route string) (*model.IndexedPage, error) {
+	for route != "/" {
+		route = path.Dir(route)
+		rows, err := h.queryIndex(`SELECT route, enabled, publish_date, expiry_date FROM pages WHERE route = ?`, route)
+		if err != nil {
+			return nil, fmt.Errorf("query page %s: %w", route, err)
+		}
+		var page *model.IndexedPage
+		if rows.Next() {
+			var enabledInt int
+			var publishDate, expiryDate sql.NullString
+			page = &model.IndexedPage{}
+			if err := rows.Scan(&page.Route, &enabledInt, &publishDate, &expiryDate); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan page %s: %w", route, err)
+			}
+			page.Enabled = enabledInt != 0
+			if page.PublishDate, page.ExpiryDate, err = parseScheduleDates(publishDate, expiryDate); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("parse schedule dates for page %s: %w", route, err)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("iterate page %s: %w", route, err)
+		}
+		if page != nil {
+			return page, nil
+		}
+	}
+	return nil, nil
+}
+
+// syncIndexSnapshot writes the difference between the existing DB rows and the
+// given snapshot, see SyncIndex.
+func (h *DBH) syncIndexSnapshot(existing *model.IndexSnapshot, snapshot *model.IndexSnapshot) (model.IndexSyncStats, error) {
+	stats := model.IndexSyncStats{}
+
+	existingPages := make(map[string]model.IndexedPage, len(existing.Pages))
+	for _, page := range existing.Pages {
+		existingPages[page.Route] = page
+	}
+	existingFiles := make(map[string]model.IndexedFile, len(existing.Files))
+	for _, file := range existing.Files {
+		existingFiles[file.Route] = file
+	}
+
+	// Pages first, in walk order (parents before children), so that the
+	// parent_page_route foreign keys of new pages and files can be satisfied:
+	seenPages := make(map[string]bool, len(snapshot.Pages))
+	for _, page := range snapshot.Pages {
+		seenPages[page.Route] = true
+		old, found := existingPages[page.Route]
+		switch {
+		case !found:
+			stats.PagesAdded++
+		case pageRecordChanged(old, page):
+			stats.PagesChanged++
+		case !old.SourceModTime.Equal(page.SourceModTime):
+			// touched, but same content: only refresh the stored signature
+			if err := h.updatePageSourceModTime(page.Route, page.SourceModTime); err != nil {
+				return stats, err
+			}
+			continue
+		default:
+			continue
+		}
+		if err := h.ReplacePage(page); err != nil {
+			return stats, err
+		}
+	}
+
+	seenFiles := make(map[string]bool, len(snapshot.Files))
+	for _, file := range snapshot.Files {
+		seenFiles[file.Route] = true
+		old, found := existingFiles[file.Route]
+		switch {
+		case !found:
+			stats.FilesAdded++
+		case fileRecordChanged(old, file):
+			stats.FilesChanged++
+		case !old.SourceModTime.Equal(file.SourceModTime):
+			// touched, but same content: only refresh the stored signature
+			if err := h.updateFileSourceModTime(file.Route, file.SourceModTime); err != nil {
+				return stats, err
+			}
+			continue
+		default:
+			continue
+		}
+		if err := h.ReplaceFile(file); err != nil {
+			return stats, err
+		}
+	}
+
+	// Deletions last: surviving files have already been moved to their new
+	// parent page, so the cascade of a deleted page only hits vanished files.
+	for _, file := range existing.Files {
+		if seenFiles[file.Route] {
+			continue
+		}
+		if err := h.DeleteFile(file.Route); err != nil {
+			return stats, err
+		}
+		stats.FilesRemoved++
+	}
+	for _, page := range existing.Pages {
+		if seenPages[page.Route] {
+			continue
+		}
+		if err := h.DeletePage(page.Route); err != nil {
+			return stats, err
+		}
+		stats.PagesRemoved++
+	}
+
+	return stats, nil
+}
+
+// routePrefix returns the prefix all routes below the given route start with.
+func routePrefix(route string) string {
+	if route == "/" {
+		return "/"
+	}
+	return route + "/"
+}
+
+// pageRecordChanged reports whether the indexed content, visibility or tree
+// position of a page differs. The source mtime alone is not considered a change.
+func pageRecordChanged(old model.IndexedPage, current model.IndexedPage) bool {
+	if old.SourceHash != current.SourceHash || old.SourceSize != current.SourceSize {
+		return true
+	}
+	if old.Title != current.Title || old.IndexFile != current.IndexFile || old.Enabled != current.Enabled {
+		return true
+	}
+	// the schedule window changes with the one of a parent page, too:
+	if formatScheduleDate(old.PublishDate) != formatScheduleDate(current.PublishDate) ||
+		formatScheduleDate(old.ExpiryDate) != formatScheduleDate(current.ExpiryDate) {
+		return true
+	}
+	if (old.ParentPageRoute == nil) != (current.ParentPageRoute == nil) {
+		return true
+	}
+	return old.ParentPageRoute != nil && *old.ParentPageRoute != *current.ParentPageRoute
+}
+
+// fileRecordChanged reports whether the indexed content, metadata, image
+// properties or tree position of a file differs. The source mtime alone is not considered a change.
+func fileRecordChanged(old model.IndexedFile, current model.IndexedFile) bool {
+	return old.SourceHash != current.SourceHash ||
+		old.FileSize != current.FileSize ||
+		old.MimeType != current.MimeType ||
+		old.ParentPageRoute != current.ParentPageRoute ||
+		old.Enabled != current.Enabled ||
+		!sameMetadata(old.Metadata, current.Metadata) ||
+		imagePropertiesChanged(old, current)
+}
+
+// imagePropertiesChanged reports whether the image properties of a file differ.
+func imagePropertiesChanged(old model.IndexedFile, current model.IndexedFile) bool {
+	return old.Width != current.Width ||
+		old.Height != current.Height ||
+		old.Orientation != current.Orientation ||
+		!old.CaptureDate.Equal(current.CaptureDate) ||
+		old.Camera != current.Camera ||
+		old.HasLocation != current.HasLocation ||
+		old.Latitude != current.Latitude ||
+		old.Longitude != current.Longitude
+}
+
+// sameMetadata reports whether two metadata maps are stored as the same JSON.
+// A nil map equals an empty one.
+func sameMetadata(a map[string]any, b map[string]any) bool {
+	aJSON, aErr := marshalMetadata(a)
+	bJSON, bErr := marshalMetadata(b)
+	return aErr == nil && bErr == nil && aJSON == bJSON
+}
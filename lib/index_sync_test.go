@@ -0,0 +1,338 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"alexi.ch/pcms/model"
+)
+
+func syncSnapshot(t *testing.T, dbh *DBH, srcFS fstest.MapFS) model.IndexSyncStats {
+	t.Helper()
+	known, err := dbh.LoadIndexSnapshot()
+	if err != nil {
+		t.Fatalf("LoadIndexSnapshot() error = %v", err)
+	}
+	snapshot, err := BuildIncrementalIndexSnapshot(srcFS, nil, false, known, nil)
+	if err != nil {
+		t.Fatalf("BuildIncrementalIndexSnapshot() error = %v", err)
+	}
+	if err := dbh.BeginIndexRun(); err != nil {
+		t.Fatalf("BeginIndexRun() error = %v", err)
+	}
+	stats, err := dbh.SyncIndex(snapshot)
+	if err != nil {
+		dbh.RollbackIndexRun()
+		t.Fatalf("SyncIndex() error = %v", err)
+	}
+	if err := dbh.CommitIndexRun(); err != nil {
+		t.Fatalf("CommitIndexRun() error = %v", err)
+	}
+	return stats
+}
+
+func TestDBHSyncIndex(t *testing.T) {
+	dbh, err := OpenDBH(filepath.Join(t.TempDir(), "pcms-sync-test.db"))
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	defer dbh.Close()
+
+	mtime := time.Date(2025, 1, 1, 12, 0, 0, 123456789, time.UTC)
+	srcFS := fstest.MapFS{
+		"index.md":           &fstest.MapFile{Data: []byte("---\ntitle: Root\n---\n# root"), ModTime: mtime},
+		"logo.png":           &fstest.MapFile{Data: []byte{0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a}, ModTime: mtime},
+		"blog/index.md":      &fstest.MapFile{Data: []byte("---\ntitle: Blog\n---\n# blog"), ModTime: mtime},
+		"blog/notes.txt":     &fstest.MapFile{Data: []byte("notes"), ModTime: mtime},
+		"old/index.md":       &fstest.MapFile{Data: []byte("# old"), ModTime: mtime},
+		"old/attachment.txt": &fstest.MapFile{Data: []byte("old"), ModTime: mtime},
+	}
+
+	stats := syncSnapshot(t, dbh, srcFS)
+	want := model.IndexSyncStats{PagesAdded: 3, FilesAdded: 3}
+	if stats != want {
+		t.Fatalf("initial sync stats = %+v, want %+v", stats, want)
+	}
+
+	// nothing changed on disk:
+	stats = syncSnapshot(t, dbh, srcFS)
+	if stats != (model.IndexSyncStats{}) {
+		t.Fatalf("no-op sync stats = %+v, want zero", stats)
+	}
+
+	// touch without content change is not reported as change, and keeps the
+	// updated_at of the page and file:
+	updatedAt := func(table string, route string) string {
+		t.Helper()
+		var value string
+		if err := dbh.db.QueryRow("SELECT updated_at FROM "+table+" WHERE route = ?", route).Scan(&value); err != nil {
+			t.Fatalf("read updated_at of %s %s: %v", table, route, err)
+		}
+		return value
+	}
+	pageUpdatedAt, fileUpdatedAt := updatedAt("pages", "/"), updatedAt("files", "/logo.png")
+	time.Sleep(5 * time.Millisecond)
+	later := mtime.Add(time.Hour)
+	srcFS["index.md"].ModTime = later
+	srcFS["logo.png"].ModTime = later
+	stats = syncSnapshot(t, dbh, srcFS)
+	if stats != (model.IndexSyncStats{}) {
+		t.Fatalf("touch sync stats = %+v, want zero", stats)
+	}
+	if got := updatedAt("pages", "/"); got != pageUpdatedAt {
+		t.Fatalf("updated_at of / after touch = %s, want %s", got, pageUpdatedAt)
+	}
+	if got := updatedAt("files", "/logo.png"); got != fileUpdatedAt {
+		t.Fatalf("updated_at of /logo.png after touch = %s, want %s", got, fileUpdatedAt)
+	}
+	// the new mtime is stored, so the next run does not touch the rows again:
+	known, err := dbh.LoadIndexSnapshot()
+	if err != nil {
+		t.Fatalf("LoadIndexSnapshot() error = %v", err)
+	}
+	for _, page := range known.Pages {
+		if page.Route == "/" && !page.SourceModTime.Equal(later) {
+			t.Fatalf("source mtime of / after touch = %v, want %v", page.SourceModTime, later)
+		}
+	}
+	for _, file := range known.Files {
+		if file.Route == "/logo.png" && !file.SourceModTime.Equal(later) {
+			t.Fatalf("source mtime of /logo.png after touch = %v, want %v", file.SourceModTime, later)
+		}
+	}
+
+	// change, add and remove content:
+	srcFS["blog/index.md"] = &fstest.MapFile{Data: []byte("---\ntitle: Blog v2\n---\n# blog"), ModTime: later}
+	srcFS["blog/new.txt"] = &fstest.MapFile{Data: []byte("new"), ModTime: later}
+	delete(srcFS, "old/index.md")
+	delete(srcFS, "old/attachment.txt")
+
+	stats = syncSnapshot(t, dbh, srcFS)
+	want = model.IndexSyncStats{PagesChanged: 1, PagesRemoved: 1, FilesAdded: 1, FilesRemoved: 1}
+	if stats != want {
+		t.Fatalf("changed sync stats = %+v, want %+v", stats, want)
+	}
+
+	page, found, err := dbh.GetPageByRoute("/blog")
+	if err != nil || !found {
+		t.Fatalf("GetPageByRoute(/blog) found = %v, error = %v", found, err)
+	}
+	if page.Title != "Blog v2" {
+		t.Fatalf("/blog title = %q, want %q", page.Title, "Blog v2")
+	}
+	if _, found, _ := dbh.GetPageByRoute("/old"); found {
+		t.Fatalf("/old should have been removed")
+	}
+	if _, found, _ := dbh.GetFileByRoute("/old/attachment.txt"); found {
+		t.Fatalf("/old/attachment.txt should have been removed")
+	}
+	if _, found, _ := dbh.GetFileByRoute("/blog/new.txt"); !found {
+		t.Fatalf("/blog/new.txt should have been added")
+	}
+}
+
+func TestDBHSyncIndexReparentsFiles(t *testing.T) {
+	dbh, err := OpenDBH(filepath.Join(t.TempDir(), "pcms-sync-reparent.db"))
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	defer dbh.Close()
+
+	srcFS := fstest.MapFS{
+		"index.md":         &fstest.MapFile{Data: []byte("# root")},
+		"section/index.md": &fstest.MapFile{Data: []byte("# section")},
+		"section/data.txt": &fstest.MapFile{Data: []byte("data")},
+	}
+	syncSnapshot(t, dbh, srcFS)
+
+	// the folder stays, but is no longer a page: its file moves to the root page.
+	delete(srcFS, "section/index.md")
+	stats := syncSnapshot(t, dbh, srcFS)
+	want := model.IndexSyncStats{PagesRemoved: 1, FilesChanged: 1}
+	if stats != want {
+		t.Fatalf("sync stats = %+v, want %+v", stats, want)
+	}
+
+	file, found, err := dbh.GetFileByRoute("/section/data.txt")
+	if err != nil || !found {
+		t.Fatalf("GetFileByRoute(/section/data.txt) found = %v, error = %v", found, err)
+	}
+	if file.ParentPageRoute != "/" {
+		t.Fatalf("/section/data.txt parent = %q, want %q", file.ParentPageRoute, "/")
+	}
+}
+
+func TestBuildIncrementalIndexSnapshotReusesKnownFiles(t *testing.T) {
+	mtime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	srcFS := fstest.MapFS{
+		"index.md":  &fstest.MapFile{Data: []byte("# root"), ModTime: mtime},
+		"data.bin":  &fstest.MapFile{Data: []byte("abc"), ModTime: mtime},
+		"other.bin": &fstest.MapFile{Data: []byte("xyz"), ModTime: mtime},
+	}
+	known := &model.IndexSnapshot{
+		Files: []model.IndexedFile{
+			// matching signature: the stored MIME type must be reused as-is
+			{Route: "/data.bin", MimeType: "application/x-known", FileSize: 3, SourceModTime: mtime, SourceHash: "stored-hash"},
+			// size mismatch: must be re-inspected
+			{Route: "/other.bin", MimeType: "application/x-known", FileSize: 99, SourceModTime: mtime, SourceHash: "stored-hash"},
+		},
+	}
+
+	snapshot, err := BuildIncrementalIndexSnapshot(srcFS, nil, false, known, nil)
+	if err != nil {
+		t.Fatalf("BuildIncrementalIndexSnapshot() error = %v", err)
+	}
+
+	filesByRoute := make(map[string]model.IndexedFile)
+	for _, file := range snapshot.Files {
+		filesByRoute[file.Route] = file
+	}
+	if got := filesByRoute["/data.bin"]; got.MimeType != "application/x-known" || got.SourceHash != "stored-hash" {
+		t.Fatalf("/data.bin = %+v, want reused mime type and hash", got)
+	}
+	if got := filesByRoute["/other.bin"]; got.MimeType == "application/x-known" || got.SourceHash == "stored-hash" {
+		t.Fatalf("/other.bin = %+v, want freshly detected mime type and hash", got)
+	}
+}
+
+func syncRoute(t *testing.T, dbh *DBH, srcFS fstest.MapFS, route string) model.IndexSyncStats {
+	t.Helper()
+	if err := dbh.BeginIndexRun(); err != nil {
+		t.Fatalf("BeginIndexRun() error = %v", err)
+	}
+	stats, _, err := dbh.SyncIndexRoute(srcFS, []string{"^/private"}, false, route)
+	if err != nil {
+		dbh.RollbackIndexRun()
+		t.Fatalf("SyncIndexRoute(%s) error = %v", route, err)
+	}
+	if err := dbh.CommitIndexRun(); err != nil {
+		t.Fatalf("CommitIndexRun() error = %v", err)
+	}
+	return stats
+}
+
+func TestDBHSyncIndexRoute(t *testing.T) {
+	dbh, err := OpenDBH(filepath.Join(t.TempDir(), "pcms-sync-route.db"))
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	defer dbh.Close()
+
+	srcFS := fstest.MapFS{
+		"index.md":       &fstest.MapFile{Data: []byte("# root")},
+		"blog/index.md":  &fstest.MapFile{Data: []byte("---\nenabled: false\n---\n# blog")},
+		"about/index.md": &fstest.MapFile{Data: []byte("# about")},
+	}
+	syncSnapshot(t, dbh, srcFS)
+
+	// a new folder below a disabled page:
+	srcFS["blog/post/index.md"] = &fstest.MapFile{Data: []byte("# post")}
+	srcFS["blog/post/image.png"] = &fstest.MapFile{Data: []byte("png")}
+	stats := syncRoute(t, dbh, srcFS, "/blog/post")
+	if want := (model.IndexSyncStats{PagesAdded: 1, FilesAdded: 1}); stats != want {
+		t.Fatalf("new folder stats = %+v, want %+v", stats, want)
+	}
+	post, found, err := dbh.GetPageByRoute("/blog/post")
+	if err != nil || !found {
+		t.Fatalf("GetPageByRoute(/blog/post) found = %v, error = %v", found, err)
+	}
+	if post.ParentPageRoute == nil || *post.ParentPageRoute != "/blog" || post.Enabled {
+		t.Fatalf("/blog/post = %+v, want disabled child of /blog", post)
+	}
+
+	// a single new file:
+	srcFS["about/cv.pdf"] = &fstest.MapFile{Data: []byte("%PDF")}
+	stats = syncRoute(t, dbh, srcFS, "/about/cv.pdf")
+	if want := (model.IndexSyncStats{FilesAdded: 1}); stats != want {
+		t.Fatalf("new file stats = %+v, want %+v", stats, want)
+	}
+
+	// removing an index file turns the page folder into a plain folder:
+	delete(srcFS, "about/index.md")
+	stats = syncRoute(t, dbh, srcFS, "/about/index.md")
+	if want := (model.IndexSyncStats{PagesRemoved: 1, FilesChanged: 1}); stats != want {
+		t.Fatalf("removed index file stats = %+v, want %+v", stats, want)
+	}
+
+	// removing a folder removes everything below it, but nothing else:
+	delete(srcFS, "blog/post/index.md")
+	delete(srcFS, "blog/post/image.png")
+	stats = syncRoute(t, dbh, srcFS, "/blog/post")
+	if want := (model.IndexSyncStats{PagesRemoved: 1, FilesRemoved: 1}); stats != want {
+		t.Fatalf("removed folder stats = %+v, want %+v", stats, want)
+	}
+	if _, found, _ := dbh.GetPageByRoute("/blog"); !found {
+		t.Fatalf("/blog should still be indexed")
+	}
+
+	// a changed sidecar updates the metadata of its file:
+	srcFS["about/cv.pdf.yaml"] = &fstest.MapFile{Data: []byte("title: Curriculum vitae\n")}
+	stats = syncRoute(t, dbh, srcFS, "/about/cv.pdf.yaml")
+	if want := (model.IndexSyncStats{FilesChanged: 1}); stats != want {
+		t.Fatalf("new sidecar stats = %+v, want %+v", stats, want)
+	}
+	cv, found, err := dbh.GetFileByRoute("/about/cv.pdf")
+	if err != nil || !found || cv.Metadata["title"] != "Curriculum vitae" {
+		t.Fatalf("GetFileByRoute(/about/cv.pdf) = %+v, found = %v, error = %v", cv, found, err)
+	}
+	if _, found, _ := dbh.GetFileByRoute("/about/cv.pdf.yaml"); found {
+		t.Fatalf("sidecar /about/cv.pdf.yaml is indexed as file")
+	}
+
+	// excluded routes are never indexed:
+	srcFS["private/index.md"] = &fstest.MapFile{Data: []byte("# private")}
+	stats = syncRoute(t, dbh, srcFS, "/private")
+	if stats != (model.IndexSyncStats{}) {
+		t.Fatalf("excluded folder stats = %+v, want zero", stats)
+	}
+}
+
+func TestDBHSyncIndexScheduledParent(t *testing.T) {
+	dbh, err := OpenDBH(filepath.Join(t.TempDir(), "pcms-sync-schedule.db"))
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	defer dbh.Close()
+
+	upcoming := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	srcFS := fstest.MapFS{
+		"index.md":            &fstest.MapFile{Data: []byte("# root")},
+		"news/index.md":       &fstest.MapFile{Data: []byte("---\npublishDate: " + upcoming.Format(time.RFC3339) + "\n---\n# news")},
+		"news/post/index.md":  &fstest.MapFile{Data: []byte("# post")},
+		"news/post/image.png": &fstest.MapFile{Data: []byte("png")},
+	}
+	syncSnapshot(t, dbh, srcFS)
+
+	// the child of an unpublished page is not visible, nor are its files:
+	post, found, err := dbh.GetPageByRoute("/news/post")
+	if err != nil || !found {
+		t.Fatalf("GetPageByRoute(/news/post) found = %v, error = %v", found, err)
+	}
+	if !post.PublishDate.Equal(upcoming) || post.IsVisibleAt(time.Now()) {
+		t.Fatalf("/news/post publish date = %v, want the one of /news", post.PublishDate)
+	}
+	if routes := pageRoutes(NewPageQueryBuilder(dbh).WhereRoute("/news/*").FetchAll()); len(routes) != 0 {
+		t.Fatalf("PageQuery(/news/*) = %v, want none", routes)
+	}
+	if files, err := dbh.GetEnabledFiles(); err != nil || len(files) != 0 {
+		t.Fatalf("GetEnabledFiles() = %+v, %v, want none", files, err)
+	}
+
+	// a changed parent schedule updates the unchanged child, in a full or a route sync:
+	srcFS["news/index.md"] = &fstest.MapFile{Data: []byte("# news")}
+	if stats := syncSnapshot(t, dbh, srcFS); stats != (model.IndexSyncStats{PagesChanged: 2}) {
+		t.Fatalf("published parent stats = %+v, want 2 changed pages", stats)
+	}
+	if routes := pageRoutes(NewPageQueryBuilder(dbh).WhereRoute("/news/*").FetchAll()); len(routes) != 2 {
+		t.Fatalf("PageQuery(/news/*) = %v, want /news and /news/post", routes)
+	}
+	srcFS["news/index.md"] = &fstest.MapFile{Data: []byte("---\nexpiryDate: 2020-01-01\n---\n# news")}
+	if stats := syncRoute(t, dbh, srcFS, "/news"); stats != (model.IndexSyncStats{PagesChanged: 2}) {
+		t.Fatalf("expired parent stats = %+v, want 2 changed pages", stats)
+	}
+	if post, _, _ := dbh.GetPageByRoute("/news/post"); post.IsVisibleAt(time.Now()) {
+		t.Fatalf("/news/post is visible below an expired page")
+	}
+}
@@ -0,0 +1,108 @@
+package lib
+
+import "fmt"
+
+// ValueCount is a single group of an aggregation query: a distinct value, and
+// the number of matching pages that have it.
+type ValueCount struct {
+	Value string
+	Count int
+}
+
+// dateGroupFormats maps the units of GroupByDate to their strftime format.
+var dateGroupFormats = map[string]string{
+	"year":  "%Y",
+	"month": "%Y-%m",
+}
+
+// DistinctValues returns the distinct values of a metadata field over all
+// matching pages, each with the number of pages that have it. Array fields are
+// unnested: a page with "tags: [go, sqlite]" counts for both "go" and "sqlite".
+// Object and null values are ignored.
+//
+// The values are ordered by count (descending), then by value. PageSize / Page
+// limit the number of returned values, e.g. to the 20 most used tags; the
+// builder's OrderBy is ignored. Returns nil for an invalid field path.
+//
+// Template example (tag cloud):
+//
+//	{% for tag in PageQuery().WhereParentRoute("/blog").DistinctValues("tags") %}
+//	    <a href="?tag={{ tag.Value|urlencode }}">{{ tag.Value }} ({{ tag.Count }})</a>
+//	{% endfor %}
+func (b *PageQueryBuilder) DistinctValues(field string) []ValueCount {
+	if !validJSONPath.MatchString(field) {
+		return nil
+	}
+	from, args := b.buildFromClause()
+	where, whereArgs := b.buildWhereClause()
+	args = append(args, whereArgs...)
+
+	query := fmt.Sprintf(`SELECT CAST(agg.value AS TEXT) AS agg_value, COUNT(DISTINCT pages.route) AS agg_count
+		FROM %s, json_each(pages.metadata_json, '$.%s') AS agg
+		WHERE %s AND agg.type NOT IN ('object', 'array', 'null')
+		GROUP BY agg_value
+		ORDER BY agg_count DESC, agg_value ASC`, from, field, where)
+	limitSQL, limitArgs := b.buildLimitOffset()
+	return b.queryValueCounts(query+limitSQL, append(args, limitArgs...))
+}
+
+// GroupByDate groups the matching pages by a date field, truncated to the given
+// unit ("year" or "month"), and returns the number of pages per group. The
+// field can be a standard page column ("updated_at", "created_at") or a
+// metadata JSON path with dates in any front matter date format (see
+// sortableMetadata); dates with a time zone are grouped in UTC.
+// Pages without a valid date in the field are left out.
+//
+// The groups are formatted as "2025" or "2025-03", newest first. PageSize /
+// Page limit the number of groups; the builder's OrderBy is ignored. Returns
+// nil for an invalid field or unit.
+//
+// Template example (monthly archive):
+//
+//	{% for month in PageQuery().WhereParentRoute("/blog").GroupByDate("publish_date", "month") %}
+//	    <li>{{ month.Value }} ({{ month.Count }})</li>
+//	{% endfor %}
+func (b *PageQueryBuilder) GroupByDate(field string, unit string) []ValueCount {
+	format, ok := dateGroupFormats[unit]
+	if !ok {
+		return nil
+	}
+	var expr string
+	if col, ok := standardColumns[field]; ok {
+		expr = "pages." + col
+	} else if validJSONPath.MatchString(field) {
+		expr = fmt.Sprintf("json_extract(pages.metadata_sort_json, '$.%s')", field)
+	} else {
+		return nil
+	}
+	from, args := b.buildFromClause()
+	where, whereArgs := b.buildWhereClause()
+	args = append([]any{format}, append(args, whereArgs...)...)
+
+	query := fmt.Sprintf(`SELECT strftime(?, %s) AS agg_value, COUNT(1) AS agg_count
+		FROM %s
+		WHERE %s AND agg_value IS NOT NULL
+		GROUP BY agg_value
+		ORDER BY agg_value DESC`, expr, from, where)
+	limitSQL, limitArgs := b.buildLimitOffset()
+	return b.queryValueCounts(query+limitSQL, append(args, limitArgs...))
+}
+
+func (b *PageQueryBuilder) queryValueCounts(query string, args []any) []ValueCount {
+	b.recorder.recordQuery(b.dbh, query, args)
+	rows, err := b.dbh.db.Query(query, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var values []ValueCount
+	for rows.Next() {
+		var v ValueCount
+		if err := rows.Scan(&v.Value, &v.Count); err != nil {
+			return nil
+		}
+		values = append(values, v)
+	}
+	return values
+}
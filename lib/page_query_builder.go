@@ -3,12 +3,15 @@ package lib
 import (
 	"database/sql"
 	"fmt"
+	"html"
 	"math"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"alexi.ch/pcms/model"
+	"alexi.ch/pcms/stdlib"
 )
 
 // sqlFilter holds a parameterized WHERE clause fragment and its bind values.
@@ -50,8 +53,15 @@ type PageQueryBuilder struct {
 	dbh      *DBH
 	filters  []sqlFilter
 	orders   []sqlOrder
-	pageSize int // 0 = no limit
-	page     int // 1-based, default 1
+	fullText string // FTS5 match expression, see WhereFullText
+	// set by WhereFullText, even if its query had no words
+	hasFullText bool
+	pageSize    int // 0 = no limit
+	page        int // 1-based, default 1
+	// language of the returned page variants, see Language
+	language string
+	// records the queries of a page render, see DependencyRecorder.PageQuery
+	recorder *DependencyRecorder
 }
 
 // NewPageQueryBuilder creates a new PageQueryBuilder using the given DBH instance.
@@ -82,18 +92,7 @@ func (b *PageQueryBuilder) copy() *PageQueryBuilder {
 //	PageQuery().WhereRoute("/blog/*").FetchAll()
 func (b *PageQueryBuilder) WhereRoute(route string) *PageQueryBuilder {
 	c := b.copy()
-	if strings.HasSuffix(route, "/*") {
-		prefix := strings.TrimSuffix(route, "*")
-		c.filters = append(c.filters, sqlFilter{
-			clause: "(route = ? OR route LIKE ?)",
-			args:   []any{strings.TrimSuffix(prefix, "/"), prefix + "%"},
-		})
-	} else {
-		c.filters = append(c.filters, sqlFilter{
-			clause: "route = ?",
-			args:   []any{route},
-		})
-	}
+	c.filters = append(c.filters, routeFilter("route", route))
 	return c
 }
 
@@ -112,6 +111,96 @@ func (b *PageQueryBuilder) WhereParentRoute(route string) *PageQueryBuilder {
 	return c
 }
 
+// Ancestors adds a filter that matches the ancestor pages of the given route:
+// its parent page, the parent's parent, and so on up to the root page. The page
+// itself is not included. As ancestor routes are prefixes of each other,
+// ordering by route lists them from the root down, e.g. for breadcrumbs.
+//
+// Template example:
+//
+//	{% for p in PageQuery().Ancestors(Page.Route).OrderBy("route","asc").FetchAll() %}
+//	    <a href="{{ Webroot(p.Route) }}">{{ p.Title }}</a> &gt;
+//	{% endfor %}
+func (b *PageQueryBuilder) Ancestors(route string) *PageQueryBuilder {
+	c := b.copy()
+	c.filters = append(c.filters, sqlFilter{
+		clause: `route IN (
+			WITH RECURSIVE ancestors(route) AS (
+				SELECT parent_page_route FROM pages WHERE route = ?
+				UNION ALL
+				SELECT p.parent_page_route FROM pages p
+				INNER JOIN ancestors a ON p.route = a.route
+			)
+			SELECT route FROM ancestors
+		)`,
+		args: []any{route},
+	})
+	return c
+}
+
+// Siblings adds a filter that matches the pages with the same parent page as
+// the given route, without the page itself. The root page has no siblings.
+//
+// Template example:
+//
+//	PageQuery().Siblings(Page.Route).OrderBy("title","asc").FetchAll()
+func (b *PageQueryBuilder) Siblings(route string) *PageQueryBuilder {
+	c := b.copy()
+	c.filters = append(c.filters, sqlFilter{
+		clause: "(route <> ? AND parent_page_route = (SELECT parent_page_route FROM pages WHERE route = ?))",
+		args:   []any{route, route},
+	})
+	return c
+}
+
+// Descendants adds a filter that matches the pages below the given route, down
+// to the given depth: 1 are the child pages, 2 the children and grandchildren,
+// and so on. A depth <= 0 matches all descendants. The page itself is not
+// included.
+//
+// Template example:
+//
+//	PageQuery().Descendants("/docs", 2).OrderBy("route","asc").FetchAll()
+func (b *PageQueryBuilder) Descendants(route string, depth int) *PageQueryBuilder {
+	c := b.copy()
+	c.filters = append(c.filters, sqlFilter{
+		clause: `route IN (
+			WITH RECURSIVE subtree(route, depth) AS (
+				SELECT route, 0 FROM pages WHERE route = ?
+				UNION ALL
+				SELECT p.route, s.depth + 1 FROM pages p
+				INNER JOIN subtree s ON p.parent_page_route = s.route
+				WHERE ? <= 0 OR s.depth < ?
+			)
+			SELECT route FROM subtree WHERE depth > 0
+		)`,
+		args: []any{route, depth, depth},
+	})
+	return c
+}
+
+// WhereFullText adds a full-text search filter on the page titles and texts.
+// All words of the query must match (case- and accent-insensitive); a word
+// ending in "*" matches as prefix, e.g. "templ*" matches "template". Quotes and
+// other FTS5 query syntax are treated as plain text.
+//
+// Results are ordered by relevance (title matches weigh more than body matches),
+// after any explicit OrderBy. The Snippet of each result page contains the
+// best-matching part of its text, with the matched words in <mark> tags.
+// A query without any words matches nothing. Non-cumulative: the last call wins.
+//
+// Template example:
+//
+//	{% for p in PageQuery().WhereFullText("sqlite index").PageSize(10).FetchAll() %}
+//	    <a href="{{ Webroot(p.Route) }}">{{ p.Title }}</a>: {{ p.Snippet|safe }}
+//	{% endfor %}
+func (b *PageQueryBuilder) WhereFullText(query string) *PageQueryBuilder {
+	c := b.copy()
+	c.fullText = fullTextMatchExpression(query)
+	c.hasFullText = true
+	return c
+}
+
 // WhereMetadataEquals adds a filter that matches pages where at least one of the
 // given JSON field paths has the exact value. Multiple paths are ORed.
 //
@@ -143,23 +232,7 @@ func (b *PageQueryBuilder) WhereMetadataEquals(fields []string, value string) *P
 //	PageQuery().WhereMetadataContains(List("tags", "categories"), "go").FetchAll()
 func (b *PageQueryBuilder) WhereMetadataContains(fields []string, value string) *PageQueryBuilder {
 	c := b.copy()
-	var orParts []string
-	var args []any
-	for _, f := range fields {
-		if !validJSONPath.MatchString(f) {
-			continue
-		}
-		extract := fmt.Sprintf("json_extract(metadata_json, '$.%s')", f)
-		// string contains: LIKE '%%value%%'
-		// array contains: use two-argument json_each(doc, path) which handles
-		// both scalar and array values without error on non-array fields.
-		part := fmt.Sprintf(
-			"(%s LIKE ? OR EXISTS(SELECT 1 FROM json_each(metadata_json, '$.%s') WHERE value = ?))",
-			extract, f,
-		)
-		orParts = append(orParts, part)
-		args = append(args, "%"+value+"%", value)
-	}
+	orParts, args := metadataContainsClauses(fields, value)
 	if len(orParts) > 0 {
 		c.filters = append(c.filters, sqlFilter{
 			clause: "(" + strings.Join(orParts, " OR ") + ")",
@@ -178,24 +251,7 @@ func (b *PageQueryBuilder) WhereMetadataContains(fields []string, value string)
 //	PageQuery().WhereMetadataIsOneOf(List("tags"), List("go", "rust")).FetchAll()
 func (b *PageQueryBuilder) WhereMetadataIsOneOf(fields []string, values []string) *PageQueryBuilder {
 	c := b.copy()
-	var orParts []string
-	var args []any
-	for _, f := range fields {
-		if !validJSONPath.MatchString(f) {
-			continue
-		}
-		extract := fmt.Sprintf("json_extract(metadata_json, '$.%s')", f)
-		for _, v := range values {
-			// exact string match OR array element match (two-argument json_each
-			// handles both scalar and array values safely)
-			part := fmt.Sprintf(
-				"(%s = ? OR EXISTS(SELECT 1 FROM json_each(metadata_json, '$.%s') WHERE value = ?))",
-				extract, f,
-			)
-			orParts = append(orParts, part)
-			args = append(args, v, v)
-		}
-	}
+	orParts, args := metadataIsOneOfClauses(fields, values)
 	if len(orParts) > 0 {
 		c.filters = append(c.filters, sqlFilter{
 			clause: "(" + strings.Join(orParts, " OR ") + ")",
@@ -206,6 +262,7 @@ func (b *PageQueryBuilder) WhereMetadataIsOneOf(fields []string, values []string
 }
 
 // WhereMetadataLT adds a filter: metadata field < value (string comparison).
+// See WhereMetadataNumberLT and WhereMetadataDateLT for typed comparisons.
 //
 // Template example:
 //
@@ -233,6 +290,145 @@ func (b *PageQueryBuilder) WhereMetadataGTE(fields []string, value string) *Page
 	return b.whereMetadataCompare(fields, ">= ?", value)
 }
 
+// WhereMetadataNumberLT adds a filter: metadata field < value, compared as
+// numbers. The value is a number, or a string with a number. Fields that are no
+// numbers (including quoted ones, like "10") do not match; an invalid value
+// matches no page.
+//
+// Template example:
+//
+//	PageQuery().WhereMetadataNumberLT(List("weight"), 10).FetchAll()
+func (b *PageQueryBuilder) WhereMetadataNumberLT(fields []string, value any) *PageQueryBuilder {
+	return b.whereMetadataNumberCompare(fields, "<", value)
+}
+
+// WhereMetadataNumberLTE adds a filter: metadata field <= value, compared as numbers.
+func (b *PageQueryBuilder) WhereMetadataNumberLTE(fields []string, value any) *PageQueryBuilder {
+	return b.whereMetadataNumberCompare(fields, "<=", value)
+}
+
+// WhereMetadataNumberGT adds a filter: metadata field > value, compared as numbers.
+func (b *PageQueryBuilder) WhereMetadataNumberGT(fields []string, value any) *PageQueryBuilder {
+	return b.whereMetadataNumberCompare(fields, ">", value)
+}
+
+// WhereMetadataNumberGTE adds a filter: metadata field >= value, compared as numbers.
+func (b *PageQueryBuilder) WhereMetadataNumberGTE(fields []string, value any) *PageQueryBuilder {
+	return b.whereMetadataNumberCompare(fields, ">=", value)
+}
+
+// WhereMetadataDateLT adds a filter: metadata field < value, compared as dates.
+// The value is a date as accepted in the front matter ("2025-06-01",
+// "2025-06-01 10:00", RFC3339, ...), or "now" for the current time at query
+// time. Fields that are no dates do not match; an invalid value matches no page.
+//
+// Template examples:
+//
+//	PageQuery().WhereMetadataDateLT(List("date"), "2025-06-01").FetchAll()
+//	PageQuery().WhereMetadataDateGTE(List("event_date"), "now").OrderBy("event_date", "asc").FetchAll()
+func (b *PageQueryBuilder) WhereMetadataDateLT(fields []string, value string) *PageQueryBuilder {
+	return b.whereMetadataDateCompare(fields, "<", value)
+}
+
+// WhereMetadataDateLTE adds a filter: metadata field <= value, compared as dates.
+func (b *PageQueryBuilder) WhereMetadataDateLTE(fields []string, value string) *PageQueryBuilder {
+	return b.whereMetadataDateCompare(fields, "<=", value)
+}
+
+// WhereMetadataDateGT adds a filter: metadata field > value, compared as dates.
+func (b *PageQueryBuilder) WhereMetadataDateGT(fields []string, value string) *PageQueryBuilder {
+	return b.whereMetadataDateCompare(fields, ">", value)
+}
+
+// WhereMetadataDateGTE adds a filter: metadata field >= value, compared as dates.
+func (b *PageQueryBuilder) WhereMetadataDateGTE(fields []string, value string) *PageQueryBuilder {
+	return b.whereMetadataDateCompare(fields, ">=", value)
+}
+
+func (b *PageQueryBuilder) whereMetadataNumberCompare(fields []string, op string, value any) *PageQueryBuilder {
+	c := b.copy()
+	number, ok := numberValue(value)
+	if !ok {
+		c.filters = append(c.filters, sqlFilter{clause: "0 = 1"})
+		return c
+	}
+	var orParts []string
+	var args []any
+	for _, f := range fields {
+		if !validJSONPath.MatchString(f) {
+			continue
+		}
+		orParts = append(orParts, fmt.Sprintf(
+			"(json_type(metadata_sort_json, '$.%s') IN ('integer', 'real') AND json_extract(metadata_sort_json, '$.%s') %s ?)",
+			f, f, op,
+		))
+		args = append(args, number)
+	}
+	if len(orParts) > 0 {
+		c.filters = append(c.filters, sqlFilter{
+			clause: "(" + strings.Join(orParts, " OR ") + ")",
+			args:   args,
+		})
+	}
+	return c
+}
+
+// numberValue converts a number argument, as passed from Go code or a pongo2
+// template (int or float literal, or a string), to a float64.
+func numberValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+func (b *PageQueryBuilder) whereMetadataDateCompare(fields []string, op string, value string) *PageQueryBuilder {
+	c := b.copy()
+	// "now" is evaluated by the query, so that a recorded query (see
+	// DependencyRecorder) returns a different result once the moment passes:
+	compareTo := "strftime('%Y-%m-%dT%H:%M:%fZ','now')"
+	var compareArgs []any
+	if !strings.EqualFold(strings.TrimSpace(value), "now") {
+		date, ok := stdlib.ParseDate(value)
+		if !ok {
+			c.filters = append(c.filters, sqlFilter{clause: "0 = 1"})
+			return c
+		}
+		compareTo = "?"
+		compareArgs = []any{date.UTC().Format(scheduleDateLayout)}
+	}
+
+	var orParts []string
+	var args []any
+	for _, f := range fields {
+		if !validJSONPath.MatchString(f) {
+			continue
+		}
+		// only values that were normalized as dates, see sortableMetadata:
+		extract := fmt.Sprintf("json_extract(metadata_sort_json, '$.%s')", f)
+		orParts = append(orParts, fmt.Sprintf(
+			"(json_type(metadata_sort_json, '$.%s') = 'text' AND %s GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T*Z' AND %s %s %s)",
+			f, extract, extract, op, compareTo,
+		))
+		args = append(args, compareArgs...)
+	}
+	if len(orParts) > 0 {
+		c.filters = append(c.filters, sqlFilter{
+			clause: "(" + strings.Join(orParts, " OR ") + ")",
+			args:   args,
+		})
+	}
+	return c
+}
+
 func (b *PageQueryBuilder) whereMetadataCompare(fields []string, op string, value string) *PageQueryBuilder {
 	c := b.copy()
 	orParts, args := metadataOrClauses(fields, op, value)
@@ -245,6 +441,104 @@ func (b *PageQueryBuilder) whereMetadataCompare(fields []string, op string, valu
 	return c
 }
 
+// Or adds a filter that matches pages which match at least one of the given
+// builders. Only the filters of the alternatives are used (all filters of one
+// alternative are ANDed, as usual), their ordering and paging are ignored. An
+// alternative without filters matches all pages. Or without alternatives adds
+// no filter.
+//
+// Template example (tagged "go" OR in /tutorials):
+//
+//	PageQuery().Or(PageQuery().WhereMetadataContains(List("tags"), "go"), PageQuery().WhereRoute("/tutorials/*")).FetchAll()
+func (b *PageQueryBuilder) Or(alternatives ...*PageQueryBuilder) *PageQueryBuilder {
+	c := b.copy()
+	var orParts []string
+	var args []any
+	for _, alt := range alternatives {
+		if alt == nil {
+			continue
+		}
+		clause, altArgs := alt.filterGroup()
+		orParts = append(orParts, clause)
+		args = append(args, altArgs...)
+	}
+	if len(orParts) > 0 {
+		c.filters = append(c.filters, sqlFilter{
+			clause: "(" + strings.Join(orParts, " OR ") + ")",
+			args:   args,
+		})
+	}
+	return c
+}
+
+// Not adds a filter that matches pages which do not match the filters of the
+// given builder. Its ordering and paging are ignored. A page without the
+// metadata field of a filter does not match that filter, so it matches the
+// negation: Not(PageQuery().WhereMetadataEquals(List("draft"), "true")) keeps
+// the pages without a draft property.
+//
+// Template example (tagged "go" OR in /tutorials, but NOT draft):
+//
+//	PageQuery().Or(PageQuery().WhereMetadataContains(List("tags"), "go"), PageQuery().WhereRoute("/tutorials/*")).Not(PageQuery().WhereMetadataEquals(List("draft"), "true")).FetchAll()
+func (b *PageQueryBuilder) Not(filter *PageQueryBuilder) *PageQueryBuilder {
+	c := b.copy()
+	if filter == nil {
+		return c
+	}
+	clause, args := filter.filterGroup()
+	// a filter on a missing field is NULL, and NOT NULL is NULL as well:
+	c.filters = append(c.filters, sqlFilter{
+		clause: "NOT coalesce(" + clause + ", 0)",
+		args:   args,
+	})
+	return c
+}
+
+// filterGroup returns the filters of the builder as a single parenthesized
+// clause, to be composed by Or and Not. A full-text filter becomes a sub-query.
+func (b *PageQueryBuilder) filterGroup() (string, []any) {
+	var clauses []string
+	var args []any
+	for _, f := range b.filters {
+		clauses = append(clauses, f.clause)
+		args = append(args, f.args...)
+	}
+	if b.fullText != "" {
+		clauses = append(clauses, `route IN (
+			SELECT t.route FROM pages_fts
+			JOIN page_texts t ON t.id = pages_fts.rowid
+			WHERE pages_fts MATCH ?
+		)`)
+		args = append(args, b.fullText)
+	} else if b.hasFullText {
+		clauses = append(clauses, "0 = 1")
+	}
+	if len(clauses) == 0 {
+		return "(1 = 1)", nil
+	}
+	return "(" + strings.Join(clauses, " AND ") + ")", args
+}
+
+// ---------- languages ----------
+
+// Language makes the query return the pages in the given language: pages with
+// a language variant (e.g. index.de.md for "de") come with its title, index
+// file and metadata, which the filters and the ordering use as well. Pages
+// without that variant are returned as they are. Non-cumulative: the last call
+// wins, "" returns all pages as they are.
+//
+// In templates, PageQuery() already returns the pages in the language of the
+// rendered page.
+//
+// Template example:
+//
+//	PageQuery().Language("de").WhereParentRoute("/blog").OrderBy("title", "asc").FetchAll()
+func (b *PageQueryBuilder) Language(language string) *PageQueryBuilder {
+	c := b.copy()
+	c.language = strings.ToLower(language)
+	return c
+}
+
 // ---------- ordering and paging ----------
 
 // standardColumns lists page table columns that can be used directly in OrderBy
@@ -262,6 +556,10 @@ var standardColumns = map[string]string{
 // JSON path (e.g. "publish_date"). The direction must be "asc" or "desc".
 // Multiple calls are cumulative.
 //
+// Metadata fields sort by type: numbers numerically, dates chronologically in
+// whatever format they were written, see sortableMetadata. Pages without the
+// field come first in ascending order.
+//
 // Template example:
 //
 //	PageQuery().OrderBy("title", "asc").FetchAll()
@@ -277,7 +575,7 @@ func (b *PageQueryBuilder) OrderBy(field string, direction string) *PageQueryBui
 		c.orders = append(c.orders, sqlOrder{expr: col, direction: dir})
 	} else if validJSONPath.MatchString(field) {
 		c.orders = append(c.orders, sqlOrder{
-			expr:      fmt.Sprintf("json_extract(metadata_json, '$.%s')", field),
+			expr:      fmt.Sprintf("json_extract(metadata_sort_json, '$.%s')", field),
 			direction: dir,
 		})
 	}
@@ -320,6 +618,7 @@ func (b *PageQueryBuilder) Page(page int) *PageQueryBuilder {
 // FetchAll executes the query and returns all matching pages.
 // The enabled flag is pre-computed during indexing, so enabled = 1 in the SQL
 // filter is sufficient — no recursive ancestor check is needed at query time.
+// Pages outside of their publishDate / expiryDate window are left out as well.
 //
 // Template example:
 //
@@ -328,7 +627,8 @@ func (b *PageQueryBuilder) Page(page int) *PageQueryBuilder {
 //	{% endfor %}
 func (b *PageQueryBuilder) FetchAll() []model.IndexedPage {
 	query, args := b.buildSelectSQL()
-	rows, err := b.dbh.queryIndex(query, args...)
+	b.recorder.recordQuery(b.dbh, query, args)
+	rows, err := b.dbh.db.Query(query, args...)
 	if err != nil {
 		return nil
 	}
@@ -351,7 +651,8 @@ func (b *PageQueryBuilder) First() *model.IndexedPage {
 	c.page = 1
 
 	query, args := c.buildSelectSQL()
-	rows, err := c.dbh.queryIndex(query, args...)
+	c.recorder.recordQuery(c.dbh, query, args)
+	rows, err := c.dbh.db.Query(query, args...)
 	if err != nil {
 		return nil
 	}
@@ -371,7 +672,8 @@ func (b *PageQueryBuilder) First() *model.IndexedPage {
 //	{{ PageQuery().WhereParentRoute("/blog").Count() }}
 func (b *PageQueryBuilder) Count() int {
 	query, args := b.buildCountSQL()
-	rows, err := b.dbh.queryIndex(query, args...)
+	b.recorder.recordQuery(b.dbh, query, args)
+	rows, err := b.dbh.db.Query(query, args...)
 	if err != nil {
 		return 0
 	}
@@ -401,45 +703,177 @@ func (b *PageQueryBuilder) NrOfPages() int {
 	return int(math.Ceil(float64(total) / float64(b.pageSize)))
 }
 
+// Prev returns the sibling page that comes before the given route, when the
+// page and its siblings are ordered by orderField ascending (a field as in
+// OrderBy; the route breaks ties). Only pages that match the builder's filters
+// are considered, its ordering and paging are ignored. Returns nil for the first
+// page, or if the page itself does not match.
+//
+// Template example:
+//
+//	{% with prev=PageQuery().Prev(Page.Route, "publish_date") %}
+//	    {% if prev %}<a href="{{ Webroot(prev.Route) }}">« {{ prev.Title }}</a>{% endif %}
+//	{% endwith %}
+func (b *PageQueryBuilder) Prev(route string, orderField string) *model.IndexedPage {
+	return b.neighbour(route, orderField, -1)
+}
+
+// Next returns the sibling page that comes after the given route, see Prev.
+//
+// Template example:
+//
+//	{% with next=PageQuery().Next(Page.Route, "publish_date") %}
+//	    {% if next %}<a href="{{ Webroot(next.Route) }}">{{ next.Title }} »</a>{% endif %}
+//	{% endwith %}
+func (b *PageQueryBuilder) Next(route string, orderField string) *model.IndexedPage {
+	return b.neighbour(route, orderField, 1)
+}
+
+// neighbour returns the page offset positions away from route in the ordered
+// list of the page and its siblings.
+func (b *PageQueryBuilder) neighbour(route string, orderField string, offset int) *model.IndexedPage {
+	c := b.copy()
+	c.filters = append(c.filters, sqlFilter{
+		clause: "parent_page_route = (SELECT parent_page_route FROM pages WHERE route = ?)",
+		args:   []any{route},
+	})
+	c.orders = nil
+	c.pageSize = 0
+	c.page = 1
+
+	pages := c.OrderBy(orderField, "asc").OrderBy("route", "asc").FetchAll()
+	for i, page := range pages {
+		if page.Route != route {
+			continue
+		}
+		if i+offset < 0 || i+offset >= len(pages) {
+			return nil
+		}
+		return &pages[i+offset]
+	}
+	return nil
+}
+
+// Translations returns the page at route in all its languages: the page
+// itself, with the language of its index file ("" if it has no language
+// suffix), and each of its language variants, ordered by language. The
+// builder's filters, ordering and paging are ignored. Returns nil if the page
+// is not visible.
+//
+// Template example (language switcher):
+//
+//	{% for t in PageQuery().Translations(Page.Route) %}
+//	    <a href="{{ LanguageUrl(t.Route, t.Language) }}" hreflang="{{ t.Language|default:Languages.0 }}">{{ t.Title }}</a>
+//	{% endfor %}
+func (b *PageQueryBuilder) Translations(route string) []model.IndexedPage {
+	query := `
+		SELECT route, parent_page_route, title, index_file, enabled, metadata_json, updated_at, publish_date, expiry_date, language, summary, word_count, reading_time
+		FROM pages
+		WHERE route = ? AND ` + visiblePagesCondition + `
+		UNION ALL
+		SELECT p.route, p.parent_page_route, t.title, t.index_file, p.enabled, t.metadata_json, p.updated_at, p.publish_date, p.expiry_date, t.language, t.summary, t.word_count, t.reading_time
+		FROM page_translations t
+		JOIN pages p ON p.route = t.route
+		WHERE t.route = ? AND ` + visiblePagesCondition + `
+		ORDER BY language`
+	args := []any{route, route}
+	b.recorder.recordQuery(b.dbh, query, args)
+	rows, err := b.dbh.db.Query(query, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var pages []model.IndexedPage
+	for rows.Next() {
+		if page, ok := scanPageRow(rows); ok {
+			pages = append(pages, page)
+		}
+	}
+	return pages
+}
+
 // ---------- SQL building ----------
 
 func (b *PageQueryBuilder) buildWhereClause() (string, []any) {
-	// Always filter for enabled = 1 at the SQL level as a first pass.
-	clauses := []string{"enabled = 1"}
+	// Always filter for visible pages (enabled, published, not expired) at the
+	// SQL level as a first pass.
+	clauses := []string{visiblePagesCondition}
 	var args []any
 
 	for _, f := range b.filters {
 		clauses = append(clauses, f.clause)
 		args = append(args, f.args...)
 	}
+	if b.hasFullText && b.fullText == "" {
+		clauses = append(clauses, "0 = 1")
+	}
 	return strings.Join(clauses, " AND "), args
 }
 
-func (b *PageQueryBuilder) buildOrderClause() string {
-	if len(b.orders) == 0 {
-		return ""
+func (b *PageQueryBuilder) buildFromClause() (string, []any) {
+	pages := "pages"
+	var args []any
+	if b.language != "" {
+		pages = pageVariantsTable
+		args = append(args, b.language)
 	}
+	if b.fullText == "" {
+		return pages, args
+	}
+	// The FTS match is wrapped in a sub-query, so that its columns do not
+	// clash with the pages columns used by the filters:
+	from := pages + ` JOIN (
+		SELECT t.route AS fts_route,
+			bm25(pages_fts, 10.0, 1.0) AS fts_rank,
+			snippet(pages_fts, 1, ?, ?, '…', 16) AS fts_snippet
+		FROM pages_fts
+		JOIN page_texts t ON t.id = pages_fts.rowid
+		WHERE pages_fts MATCH ?
+	) fts ON fts.fts_route = pages.route`
+	return from, append(args, snippetMarkStart, snippetMarkEnd, b.fullText)
+}
+
+func (b *PageQueryBuilder) buildOrderClause() string {
 	var parts []string
 	for _, o := range b.orders {
 		parts = append(parts, o.expr+" "+o.direction)
 	}
+	if b.fullText != "" {
+		parts = append(parts, "fts_rank ASC")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
 	return " ORDER BY " + strings.Join(parts, ", ")
 }
 
 func (b *PageQueryBuilder) buildLimitOffset() (string, []any) {
-	if b.pageSize <= 0 {
+	return limitOffsetSQL(b.pageSize, b.page)
+}
+
+// limitOffsetSQL returns the LIMIT / OFFSET clause for the given page size and
+// 1-based page number. A page size <= 0 means no limit.
+func limitOffsetSQL(pageSize int, page int) (string, []any) {
+	if pageSize <= 0 {
 		return "", nil
 	}
-	offset := (b.page - 1) * b.pageSize
+	offset := (page - 1) * pageSize
 	if offset > 0 {
-		return " LIMIT ? OFFSET ?", []any{b.pageSize, offset}
+		return " LIMIT ? OFFSET ?", []any{pageSize, offset}
 	}
-	return " LIMIT ?", []any{b.pageSize}
+	return " LIMIT ?", []any{pageSize}
 }
 
 func (b *PageQueryBuilder) buildSelectSQL() (string, []any) {
-	where, args := b.buildWhereClause()
-	query := "SELECT route, parent_page_route, title, index_file, enabled, metadata_json, updated_at FROM pages WHERE " + where
+	from, args := b.buildFromClause()
+	where, whereArgs := b.buildWhereClause()
+	args = append(args, whereArgs...)
+	columns := "route, parent_page_route, title, index_file, enabled, metadata_json, updated_at, publish_date, expiry_date, language, summary, word_count, reading_time"
+	if b.fullText != "" {
+		columns += ", fts_snippet"
+	}
+	query := "SELECT " + columns + " FROM " + from + " WHERE " + where
 	query += b.buildOrderClause()
 	limitSQL, limitArgs := b.buildLimitOffset()
 	query += limitSQL
@@ -448,8 +882,9 @@ func (b *PageQueryBuilder) buildSelectSQL() (string, []any) {
 }
 
 func (b *PageQueryBuilder) buildCountSQL() (string, []any) {
-	where, args := b.buildWhereClause()
-	return "SELECT COUNT(1) FROM pages WHERE " + where, args
+	from, args := b.buildFromClause()
+	where, whereArgs := b.buildWhereClause()
+	return "SELECT COUNT(1) FROM " + from + " WHERE " + where, append(args, whereArgs...)
 }
 
 // ---------- row scanning ----------
@@ -457,7 +892,7 @@ func (b *PageQueryBuilder) buildCountSQL() (string, []any) {
 func (b *PageQueryBuilder) scanRows(rows *sql.Rows) []model.IndexedPage {
 	var pages []model.IndexedPage
 	for rows.Next() {
-		page, ok := scanPageRow(rows)
+		page, ok := b.scanPage(rows)
 		if ok {
 			pages = append(pages, page)
 		}
@@ -469,18 +904,31 @@ func (b *PageQueryBuilder) scanNextRow(rows *sql.Rows) (model.IndexedPage, bool)
 	if !rows.Next() {
 		return model.IndexedPage{}, false
 	}
-	return scanPageRow(rows)
+	return b.scanPage(rows)
 }
 
-// scanPageRow scans a single row with the standard pages column set.
-func scanPageRow(rows *sql.Rows) (model.IndexedPage, bool) {
+// scanPage scans the current row, including the full-text snippet if the query has one.
+func (b *PageQueryBuilder) scanPage(rows *sql.Rows) (model.IndexedPage, bool) {
+	if b.fullText == "" {
+		return scanPageRow(rows)
+	}
+	var snippet string
+	page, ok := scanPageRow(rows, &snippet)
+	page.Snippet = formatSnippet(snippet)
+	return page, ok
+}
+
+// scanPageRow scans a single row with the standard pages column set, followed
+// by the given extra columns.
+func scanPageRow(rows *sql.Rows, extra ...any) (model.IndexedPage, bool) {
 	var record model.IndexedPage
 	var parentRoute sql.NullString
 	var metadataJSON string
 	var updatedAtStr string
+	var publishDate, expiryDate sql.NullString
 	var enabledInt int
 
-	if err := rows.Scan(
+	dest := []any{
 		&record.Route,
 		&parentRoute,
 		&record.Title,
@@ -488,7 +936,14 @@ func scanPageRow(rows *sql.Rows) (model.IndexedPage, bool) {
 		&enabledInt,
 		&metadataJSON,
 		&updatedAtStr,
-	); err != nil {
+		&publishDate,
+		&expiryDate,
+		&record.Language,
+		&record.Summary,
+		&record.WordCount,
+		&record.ReadingTime,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
 		return model.IndexedPage{}, false
 	}
 
@@ -509,11 +964,32 @@ func scanPageRow(rows *sql.Rows) (model.IndexedPage, bool) {
 		return model.IndexedPage{}, false
 	}
 
+	record.PublishDate, record.ExpiryDate, err = parseScheduleDates(publishDate, expiryDate)
+	if err != nil {
+		return model.IndexedPage{}, false
+	}
+
 	return record, true
 }
 
 // ---------- helpers ----------
 
+// routeFilter matches a route column exactly (e.g. "/foo/bar"), or, with a
+// trailing wildcard (e.g. "/foo/bar/*"), the route and all routes below it.
+func routeFilter(column string, route string) sqlFilter {
+	if strings.HasSuffix(route, "/*") {
+		prefix := strings.TrimSuffix(route, "*")
+		return sqlFilter{
+			clause: fmt.Sprintf("(%s = ? OR %s LIKE ?)", column, column),
+			args:   []any{strings.TrimSuffix(prefix, "/"), prefix + "%"},
+		}
+	}
+	return sqlFilter{
+		clause: column + " = ?",
+		args:   []any{route},
+	}
+}
+
 // metadataOrClauses builds OR-connected json_extract clauses for the given
 // field paths and comparison operator.
 func metadataOrClauses(fields []string, op string, value string) ([]string, []any) {
@@ -529,3 +1005,84 @@ func metadataOrClauses(fields []string, op string, value string) ([]string, []an
 	}
 	return parts, args
 }
+
+// metadataContainsClauses builds one clause per valid field path that matches
+// a metadata field containing the value, see WhereMetadataContains.
+func metadataContainsClauses(fields []string, value string) ([]string, []any) {
+	var parts []string
+	var args []any
+	for _, f := range fields {
+		if !validJSONPath.MatchString(f) {
+			continue
+		}
+		extract := fmt.Sprintf("json_extract(metadata_json, '$.%s')", f)
+		// string contains: LIKE '%%value%%'
+		// array contains: use two-argument json_each(doc, path) which handles
+		// both scalar and array values without error on non-array fields.
+		part := fmt.Sprintf(
+			"(%s LIKE ? OR EXISTS(SELECT 1 FROM json_each(metadata_json, '$.%s') WHERE value = ?))",
+			extract, f,
+		)
+		parts = append(parts, part)
+		args = append(args, "%"+value+"%", value)
+	}
+	return parts, args
+}
+
+// metadataIsOneOfClauses builds one clause per valid field path and value that
+// matches a metadata field with that value, see WhereMetadataIsOneOf.
+func metadataIsOneOfClauses(fields []string, values []string) ([]string, []any) {
+	var parts []string
+	var args []any
+	for _, f := range fields {
+		if !validJSONPath.MatchString(f) {
+			continue
+		}
+		extract := fmt.Sprintf("json_extract(metadata_json, '$.%s')", f)
+		for _, v := range values {
+			// exact string match OR array element match (two-argument json_each
+			// handles both scalar and array values safely)
+			part := fmt.Sprintf(
+				"(%s = ? OR EXISTS(SELECT 1 FROM json_each(metadata_json, '$.%s') WHERE value = ?))",
+				extract, f,
+			)
+			parts = append(parts, part)
+			args = append(args, v, v)
+		}
+	}
+	return parts, args
+}
+
+// Snippet highlight markers, as returned by the FTS5 snippet function. Control
+// characters are used, so that the snippet text can be HTML-escaped before the
+// markers are replaced by <mark> tags.
+const (
+	snippetMarkStart = "\x02"
+	snippetMarkEnd   = "\x03"
+)
+
+func formatSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, snippetMarkStart, "<mark>")
+	return strings.ReplaceAll(snippet, snippetMarkEnd, "</mark>")
+}
+
+// fullTextMatchExpression converts a user search query into a safe FTS5 match
+// expression: every word is quoted as a string, so that FTS5 operators and
+// special characters lose their meaning. A trailing "*" is kept as prefix match.
+func fullTextMatchExpression(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.Trim(word, "*")
+		if word == "" {
+			continue
+		}
+		term := `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
@@ -3,6 +3,7 @@ package lib
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
 	"alexi.ch/pcms/model"
 	"github.com/flosch/pongo2/v6"
@@ -41,13 +42,17 @@ func setupQueryBuilderDB(t *testing.T) *DBH {
 		{Route: "/blog", ParentPageRoute: &root, Title: "Blog", IndexFile: "index.html", Enabled: true,
 			Metadata: map[string]any{"tags": []any{"go", "tutorial"}, "publish_date": "2025-03-01", "author": "alice"}},
 		{Route: "/blog/post-1", ParentPageRoute: &blog, Title: "First Post", IndexFile: "index.md", Enabled: true,
-			Metadata: map[string]any{"tags": []any{"go"}, "publish_date": "2025-01-15", "author": "alice", "featured": "true"}},
+			Metadata:  map[string]any{"tags": []any{"go"}, "publish_date": "2025-01-15", "author": "alice", "featured": "true"},
+			PlainText: "Getting started with Go templates: a <quick> tour of the template engine."},
 		{Route: "/blog/post-2", ParentPageRoute: &blog, Title: "Second Post", IndexFile: "index.md", Enabled: true,
-			Metadata: map[string]any{"tags": []any{"rust", "tutorial"}, "publish_date": "2025-02-20", "author": "bob"}},
+			Metadata:  map[string]any{"tags": []any{"rust", "tutorial"}, "publish_date": "2025-02-20", "author": "bob"},
+			PlainText: "Rust for Go developers. Templates are not covered here."},
 		{Route: "/blog/draft", ParentPageRoute: &blog, Title: "Draft Post", IndexFile: "index.md", Enabled: false,
-			Metadata: map[string]any{"tags": []any{"go"}, "publish_date": "2025-04-01", "author": "alice"}},
+			Metadata:  map[string]any{"tags": []any{"go"}, "publish_date": "2025-04-01", "author": "alice"},
+			PlainText: "Unfinished draft about templates."},
 		{Route: "/about", ParentPageRoute: &root, Title: "About", IndexFile: "index.html", Enabled: true,
-			Metadata: map[string]any{"author": "alice"}},
+			Metadata:  map[string]any{"author": "alice"},
+			PlainText: "Über uns: the team behind this site."},
 		{Route: "/hidden", ParentPageRoute: &root, Title: "Hidden Section", IndexFile: "index.html", Enabled: false,
 			Metadata: map[string]any{}},
 		{Route: "/hidden/child", ParentPageRoute: &hidden, Title: "Hidden Child", IndexFile: "index.html", Enabled: false,
@@ -707,6 +712,391 @@ func TestPageQueryBuilder_Pongo2_NrOfPages(t *testing.T) {
 	}
 }
 
+func TestPageQueryBuilder_WhereFullText(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	pages := NewPageQueryBuilder(dbh).WhereFullText("templates").FetchAll()
+	// the disabled /blog/draft matches too, but must not be returned:
+	routes := pageRoutes(pages)
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 results, got %d: %v", len(routes), routes)
+	}
+	assertContains(t, routes, "/blog/post-1")
+	assertContains(t, routes, "/blog/post-2")
+
+	if n := NewPageQueryBuilder(dbh).WhereFullText("templates").Count(); n != 2 {
+		t.Fatalf("expected count 2, got %d", n)
+	}
+}
+
+func TestPageQueryBuilder_WhereFullText_Ranking(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	// "first" is only found in a title:
+	pages := NewPageQueryBuilder(dbh).WhereFullText("first").FetchAll()
+	if len(pages) != 1 || pages[0].Route != "/blog/post-1" {
+		t.Fatalf("expected title match /blog/post-1, got %v", pageRoutes(pages))
+	}
+
+	// post-1 mentions "template" twice in a short text:
+	pages = NewPageQueryBuilder(dbh).WhereFullText("template*").FetchAll()
+	if len(pages) != 2 || pages[0].Route != "/blog/post-1" {
+		t.Fatalf("expected /blog/post-1 ranked first, got %v", pageRoutes(pages))
+	}
+
+	// an explicit order comes before the rank:
+	pages = NewPageQueryBuilder(dbh).WhereFullText("template*").OrderBy("title", "desc").FetchAll()
+	if len(pages) != 2 || pages[0].Route != "/blog/post-2" {
+		t.Fatalf("expected title order, got %v", pageRoutes(pages))
+	}
+}
+
+func TestPageQueryBuilder_WhereFullText_Snippet(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	page := NewPageQueryBuilder(dbh).WhereFullText("quick").First()
+	if page == nil {
+		t.Fatal("expected a result")
+	}
+	// the page text is escaped, the highlight markers are not:
+	want := "Getting started with Go templates: a &lt;<mark>quick</mark>&gt; tour of the template engine."
+	if page.Snippet != want {
+		t.Fatalf("snippet = %q, want %q", page.Snippet, want)
+	}
+
+	// no snippet without full-text filter:
+	page = NewPageQueryBuilder(dbh).WhereRoute("/blog/post-1").First()
+	if page == nil || page.Snippet != "" {
+		t.Fatalf("expected no snippet, got %+v", page)
+	}
+}
+
+func TestPageQueryBuilder_WhereFullText_QuerySyntax(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{query: "ueber", want: 0},
+		{query: "uber", want: 1},           // diacritics are ignored
+		{query: "GO TEMPLATES", want: 2},   // case-insensitive, all words must match
+		{query: "rust templates", want: 1}, // all words must match
+		{query: `"quick" OR) -(`, want: 0}, // FTS5 syntax is plain text
+		{query: `"quick"`, want: 1},        // quotes are ignored
+		{query: "   ", want: 0},            // no words: nothing
+		{query: "*", want: 0},
+	}
+	for _, tt := range tests {
+		if n := NewPageQueryBuilder(dbh).WhereFullText(tt.query).Count(); n != tt.want {
+			t.Errorf("WhereFullText(%q).Count() = %d, want %d", tt.query, n, tt.want)
+		}
+	}
+
+	// the last call wins:
+	if n := NewPageQueryBuilder(dbh).WhereFullText("").WhereFullText("rust").Count(); n != 1 {
+		t.Errorf("expected the last WhereFullText call to win, got count %d", n)
+	}
+}
+
+func TestPageQueryBuilder_WhereFullText_UpdatedAndDeleted(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	if err := dbh.ReplacePage(model.IndexedPage{Route: "/about", Title: "About", IndexFile: "index.html", Enabled: true, PlainText: "Contact form"}); err != nil {
+		t.Fatalf("ReplacePage() error = %v", err)
+	}
+	if n := NewPageQueryBuilder(dbh).WhereFullText("team").Count(); n != 0 {
+		t.Fatalf("expected old text to be gone, got count %d", n)
+	}
+	if n := NewPageQueryBuilder(dbh).WhereFullText("contact").Count(); n != 1 {
+		t.Fatalf("expected new text to be found, got count %d", n)
+	}
+
+	if err := dbh.DeletePage("/about"); err != nil {
+		t.Fatalf("DeletePage() error = %v", err)
+	}
+	if n := NewPageQueryBuilder(dbh).WhereFullText("contact").Count(); n != 0 {
+		t.Fatalf("expected deleted page to be gone, got count %d", n)
+	}
+}
+
+func TestPageQueryBuilder_ScheduledPages(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	blog := "/blog"
+	now := time.Now()
+	scheduled := []model.IndexedPage{
+		{Route: "/blog/published", ParentPageRoute: &blog, Title: "Published", IndexFile: "index.md", Enabled: true,
+			PublishDate: now.Add(-time.Hour), ExpiryDate: now.Add(time.Hour)},
+		{Route: "/blog/upcoming", ParentPageRoute: &blog, Title: "Upcoming", IndexFile: "index.md", Enabled: true,
+			PublishDate: now.Add(time.Hour)},
+		{Route: "/blog/expired", ParentPageRoute: &blog, Title: "Expired", IndexFile: "index.md", Enabled: true,
+			ExpiryDate: now.Add(-time.Hour)},
+	}
+	for _, p := range scheduled {
+		if err := dbh.ReplacePage(p); err != nil {
+			t.Fatalf("ReplacePage(%s) error = %v", p.Route, err)
+		}
+	}
+
+	routes := pageRoutes(NewPageQueryBuilder(dbh).WhereParentRoute("/blog").FetchAll())
+	if len(routes) != 3 {
+		t.Fatalf("FetchAll() = %v, want post-1, post-2 and published", routes)
+	}
+	assertContains(t, routes, "/blog/published")
+
+	children, err := dbh.GetChildPages("/blog", "")
+	if err != nil {
+		t.Fatalf("GetChildPages() error = %v", err)
+	}
+	if len(children) != 3 {
+		t.Fatalf("GetChildPages() = %v, want 3 pages", pageRoutes(children))
+	}
+
+	// the dates survive the round trip, and decide at request time:
+	upcoming, found, err := dbh.GetPageByRoute("/blog/upcoming")
+	if err != nil || !found {
+		t.Fatalf("GetPageByRoute() = %v, %v", found, err)
+	}
+	if !upcoming.PublishDate.Equal(now.Add(time.Hour).Truncate(time.Millisecond)) || !upcoming.ExpiryDate.IsZero() {
+		t.Fatalf("upcoming dates = %v, %v", upcoming.PublishDate, upcoming.ExpiryDate)
+	}
+	if upcoming.IsVisibleAt(now) || !upcoming.IsVisibleAt(now.Add(2*time.Hour)) {
+		t.Fatalf("upcoming page visibility is wrong")
+	}
+}
+
+func TestPageQueryBuilder_TreeNavigation(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	ancestors := pageRoutes(NewPageQueryBuilder(dbh).Ancestors("/blog/post-1").OrderBy("route", "asc").FetchAll())
+	if len(ancestors) != 2 || ancestors[0] != "/" || ancestors[1] != "/blog" {
+		t.Fatalf("Ancestors(/blog/post-1) = %v, want [/ /blog]", ancestors)
+	}
+	if n := NewPageQueryBuilder(dbh).Ancestors("/").Count(); n != 0 {
+		t.Fatalf("Ancestors(/) count = %d, want 0", n)
+	}
+
+	// /hidden is disabled:
+	siblings := pageRoutes(NewPageQueryBuilder(dbh).Siblings("/blog").FetchAll())
+	if len(siblings) != 1 || siblings[0] != "/about" {
+		t.Fatalf("Siblings(/blog) = %v, want [/about]", siblings)
+	}
+
+	if n := NewPageQueryBuilder(dbh).Descendants("/", 1).Count(); n != 2 {
+		t.Fatalf("Descendants(/, 1) count = %d, want 2", n)
+	}
+	if n := NewPageQueryBuilder(dbh).Descendants("/", 0).Count(); n != 4 {
+		t.Fatalf("Descendants(/, 0) count = %d, want 4", n)
+	}
+}
+
+func TestPageQueryBuilder_PrevNext(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	qb := NewPageQueryBuilder(dbh).OrderBy("title", "desc").PageSize(1)
+	if prev := qb.Prev("/blog/post-2", "publish_date"); prev == nil || prev.Route != "/blog/post-1" {
+		t.Fatalf("Prev(/blog/post-2) = %v, want /blog/post-1", prev)
+	}
+	if next := qb.Next("/blog/post-1", "publish_date"); next == nil || next.Route != "/blog/post-2" {
+		t.Fatalf("Next(/blog/post-1) = %v, want /blog/post-2", next)
+	}
+	// the disabled draft would be next:
+	if next := qb.Next("/blog/post-2", "publish_date"); next != nil {
+		t.Fatalf("Next(/blog/post-2) = %v, want nil", next)
+	}
+	if prev := qb.Prev("/blog/post-1", "publish_date"); prev != nil {
+		t.Fatalf("Prev(/blog/post-1) = %v, want nil", prev)
+	}
+	// the builder's filters apply:
+	if next := qb.WhereMetadataEquals([]string{"author"}, "alice").Next("/blog/post-1", "publish_date"); next != nil {
+		t.Fatalf("Next(/blog/post-1) by alice = %v, want nil", next)
+	}
+}
+
+func TestPageQueryBuilder_DistinctValues(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	// the disabled draft's tags are not counted:
+	tags := NewPageQueryBuilder(dbh).WhereRoute("/blog/*").DistinctValues("tags")
+	if len(tags) != 3 || tags[0] != (ValueCount{"go", 2}) || tags[1] != (ValueCount{"tutorial", 2}) || tags[2] != (ValueCount{"rust", 1}) {
+		t.Fatalf("DistinctValues(tags) = %v, want [{go 2} {tutorial 2} {rust 1}]", tags)
+	}
+
+	authors := NewPageQueryBuilder(dbh).PageSize(1).DistinctValues("author")
+	if len(authors) != 1 || authors[0] != (ValueCount{"alice", 3}) {
+		t.Fatalf("DistinctValues(author) = %v, want [{alice 3}]", authors)
+	}
+
+	if values := NewPageQueryBuilder(dbh).DistinctValues("tags'); DROP TABLE pages; --"); values != nil {
+		t.Fatalf("DistinctValues() with an invalid path = %v, want nil", values)
+	}
+}
+
+func TestPageQueryBuilder_GroupByDate(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	months := NewPageQueryBuilder(dbh).WhereRoute("/blog/*").GroupByDate("publish_date", "month")
+	if len(months) != 3 || months[0] != (ValueCount{"2025-03", 1}) || months[2] != (ValueCount{"2025-01", 1}) {
+		t.Fatalf("GroupByDate(publish_date, month) = %v", months)
+	}
+
+	years := NewPageQueryBuilder(dbh).GroupByDate("publish_date", "year")
+	if len(years) != 1 || years[0] != (ValueCount{"2025", 3}) {
+		t.Fatalf("GroupByDate(publish_date, year) = %v, want [{2025 3}]", years)
+	}
+
+	if groups := NewPageQueryBuilder(dbh).GroupByDate("publish_date", "week"); groups != nil {
+		t.Fatalf("GroupByDate() with an invalid unit = %v, want nil", groups)
+	}
+}
+
+func TestPageQueryBuilder_OrNot(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	// written by bob OR about templates, but NOT featured:
+	qb := NewPageQueryBuilder(dbh).
+		Or(NewPageQueryBuilder(dbh).WhereMetadataEquals([]string{"author"}, "bob"), NewPageQueryBuilder(dbh).WhereFullText("templates")).
+		Not(NewPageQueryBuilder(dbh).WhereMetadataEquals([]string{"featured"}, "true"))
+	routes := pageRoutes(qb.OrderBy("route", "asc").FetchAll())
+	if len(routes) != 1 || routes[0] != "/blog/post-2" {
+		t.Fatalf("Or().Not() = %v, want [/blog/post-2]", routes)
+	}
+
+	// pages without the negated field match the negation:
+	if n := NewPageQueryBuilder(dbh).Not(NewPageQueryBuilder(dbh).WhereMetadataEquals([]string{"author"}, "alice")).Count(); n != 2 {
+		t.Fatalf("Not(author = alice) count = %d, want 2 (/ and /blog/post-2)", n)
+	}
+
+	// invalid field paths are dropped inside groups as well:
+	if n := NewPageQueryBuilder(dbh).Or(NewPageQueryBuilder(dbh).WhereMetadataEquals([]string{"x') OR 1=1 --"}, "a")).Count(); n != 5 {
+		t.Fatalf("Or() with an invalid path count = %d, want 5", n)
+	}
+	if n := NewPageQueryBuilder(dbh).Or().Count(); n != 5 {
+		t.Fatalf("Or() without alternatives count = %d, want 5", n)
+	}
+}
+
+func TestPageQueryBuilder_TypedComparisons(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	root := "/"
+	parent := "/events"
+	future := time.Now().AddDate(1, 0, 0).Format("2006-01-02")
+	events := []model.IndexedPage{
+		{Route: "/events", ParentPageRoute: &root, Title: "Events", IndexFile: "index.md", Enabled: true},
+		{Route: "/events/a", ParentPageRoute: &parent, Title: "A", IndexFile: "index.md", Enabled: true,
+			Metadata: map[string]any{"weight": 10, "event_date": "2025-03-01T09:00:00+02:00"}},
+		{Route: "/events/b", ParentPageRoute: &parent, Title: "B", IndexFile: "index.md", Enabled: true,
+			Metadata: map[string]any{"weight": 9, "event_date": "2025-03-01 08:00"}},
+		{Route: "/events/c", ParentPageRoute: &parent, Title: "C", IndexFile: "index.md", Enabled: true,
+			Metadata: map[string]any{"weight": "8", "event_date": future}},
+	}
+	for _, p := range events {
+		if err := dbh.ReplacePage(p); err != nil {
+			t.Fatalf("ReplacePage(%s) error = %v", p.Route, err)
+		}
+	}
+	qb := NewPageQueryBuilder(dbh).WhereParentRoute("/events")
+
+	// 09:00+02:00 is 07:00 UTC, before 08:00 UTC:
+	byDate := pageRoutes(qb.OrderBy("event_date", "asc").FetchAll())
+	if len(byDate) != 3 || byDate[0] != "/events/a" || byDate[1] != "/events/b" || byDate[2] != "/events/c" {
+		t.Fatalf("OrderBy(event_date) = %v, want [/events/a /events/b /events/c]", byDate)
+	}
+
+	// "8" is a string, not a number:
+	heavy := pageRoutes(qb.WhereMetadataNumberGTE([]string{"weight"}, 9).OrderBy("weight", "asc").FetchAll())
+	if len(heavy) != 2 || heavy[0] != "/events/b" || heavy[1] != "/events/a" {
+		t.Fatalf("WhereMetadataNumberGTE(weight, 9) = %v, want [/events/b /events/a]", heavy)
+	}
+
+	if n := qb.WhereMetadataDateLT([]string{"event_date"}, "2025-03-01 07:30").Count(); n != 1 {
+		t.Fatalf("WhereMetadataDateLT(event_date, 07:30) count = %d, want 1", n)
+	}
+	upcoming := pageRoutes(qb.WhereMetadataDateGTE([]string{"event_date"}, "now").FetchAll())
+	if len(upcoming) != 1 || upcoming[0] != "/events/c" {
+		t.Fatalf("WhereMetadataDateGTE(event_date, now) = %v, want [/events/c]", upcoming)
+	}
+	// titles are no dates, invalid values match nothing:
+	if n := qb.WhereMetadataDateLT([]string{"title"}, "now").Count(); n != 0 {
+		t.Fatalf("WhereMetadataDateLT(title, now) count = %d, want 0", n)
+	}
+	if n := qb.WhereMetadataDateLT([]string{"event_date"}, "soon").Count(); n != 0 {
+		t.Fatalf("WhereMetadataDateLT(event_date, soon) count = %d, want 0", n)
+	}
+}
+
+func TestPageQueryBuilder_Languages(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	if err := dbh.BeginIndexRun(); err != nil {
+		t.Fatalf("BeginIndexRun() error = %v", err)
+	}
+	blog := "/blog"
+	page := model.IndexedPage{Route: "/blog/post-3", ParentPageRoute: &blog, Title: "Third Post", IndexFile: "index.md", Enabled: true,
+		Metadata: map[string]any{"author": "bob"}, PlainText: "An English text.", Summary: "An English text.", WordCount: 3, ReadingTime: 1,
+		Translations: []model.PageTranslation{
+			{Language: "de", Title: "Dritter Beitrag", IndexFile: "index.de.md", Metadata: map[string]any{"author": "bob", "slug": "dritter"},
+				PlainText: "Ein deutscher Text.", Summary: "Ein deutscher Text.", WordCount: 3, ReadingTime: 1},
+		}}
+	if err := dbh.ReplacePage(page); err != nil {
+		t.Fatalf("ReplacePage() error = %v", err)
+	}
+	if err := dbh.CommitIndexRun(); err != nil {
+		t.Fatalf("CommitIndexRun() error = %v", err)
+	}
+
+	variant, found, err := dbh.GetPageVariant("/blog/post-3", "de")
+	if err != nil || !found || variant.Title != "Dritter Beitrag" || variant.IndexFile != "index.de.md" || variant.Language != "de" ||
+		variant.Summary != "Ein deutscher Text." || variant.WordCount != 3 || variant.ReadingTime != 1 {
+		t.Fatalf("GetPageVariant(de) = %+v, %v, %v", variant, found, err)
+	}
+	// no variant in that language: the page as it is
+	variant, _, _ = dbh.GetPageVariant("/blog/post-3", "fr")
+	if variant.Title != "Third Post" || variant.Language != "" || variant.Summary != "An English text." {
+		t.Fatalf("GetPageVariant(fr) = %+v", variant)
+	}
+
+	// filters and ordering use the variant's metadata and title:
+	qb := NewPageQueryBuilder(dbh).Language("de").WhereParentRoute("/blog")
+	if first := qb.WhereMetadataEquals([]string{"slug"}, "dritter").First(); first == nil || first.Title != "Dritter Beitrag" || first.Summary != "Ein deutscher Text." {
+		t.Fatalf("Language(de).WhereMetadataEquals(slug) = %+v", first)
+	}
+	if n := qb.Count(); n != 3 {
+		t.Fatalf("Language(de) count = %d, want 3 (untranslated pages included)", n)
+	}
+	children, err := dbh.GetChildPages("/blog", "de")
+	if err != nil || len(children) != 3 || children[2].Title != "Dritter Beitrag" || children[2].Summary != "Ein deutscher Text." {
+		t.Fatalf("GetChildPages(de) = %+v, %v", children, err)
+	}
+
+	translations := NewPageQueryBuilder(dbh).Translations("/blog/post-3")
+	if len(translations) != 2 || translations[0].Language != "" || translations[1].Language != "de" || translations[1].Title != "Dritter Beitrag" ||
+		translations[0].Summary != "An English text." || translations[1].Summary != "Ein deutscher Text." {
+		t.Fatalf("Translations() = %+v", translations)
+	}
+
+	// the full-text entry covers all languages:
+	result := NewPageQueryBuilder(dbh).Language("de").WhereFullText("deutscher").First()
+	if result == nil || result.Route != "/blog/post-3" || result.Title != "Dritter Beitrag" {
+		t.Fatalf("WhereFullText(deutscher) = %+v", result)
+	}
+}
+
 // --- helpers ---
 
 func pageRoutes(pages []model.IndexedPage) []string {
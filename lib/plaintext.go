@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	mdhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+var (
+	templateTagPattern   = regexp.MustCompile(`(?s)\{%.*?%\}|\{#.*?#\}`)
+	templateVarPattern   = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+	emptyMDLinkPattern   = regexp.MustCompile(`!?\[([^\]]*)\]\(\s*\)`)
+	htmlCommentPattern   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlInvisiblePattern = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>`)
+	htmlInlineTagPattern = regexp.MustCompile(`(?is)</?(a|abbr|b|bdi|bdo|cite|code|del|dfn|em|i|ins|kbd|mark|q|s|samp|small|span|strong|sub|sup|time|u|var)\b[^>]*>`)
+	htmlTagPattern       = regexp.MustCompile(`(?s)<[^>]*>`)
+	whitespacePattern    = regexp.MustCompile(`\s+`)
+)
+
+// plainTextMarkdown renders markdown for the plain text extraction, with the
+// extensions that add text (tables, footnotes, definition lists).
+var plainTextMarkdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM, extension.Footnote, extension.DefinitionList),
+	goldmark.WithRendererOptions(mdhtml.WithUnsafe()),
+)
+
+// extractPlainText returns the readable text of a page for the full-text index,
+// from the metadata and the body of its index file (without front matter), as
+// the page's index format extracts it.
+func extractPlainText(indexFile string, metadata map[string]any, body string) string {
+	format, ok := IndexFormatOf(indexFile)
+	if !ok {
+		return textPlainText(metadata, body)
+	}
+	return format.PlainText(metadata, body)
+}
+
+// templatePlainText returns the text of an HTML template: pongo2 tags are
+// dropped, and HTML markup is removed.
+func templatePlainText(_ map[string]any, body string) string {
+	return HTMLPlainText(stripTemplateTags(body))
+}
+
+// markdownPlainText returns the text of a markdown template: pongo2 tags are
+// dropped, markdown is rendered, and HTML markup is removed.
+func markdownPlainText(_ map[string]any, body string) string {
+	// links whose URL was a template variable only keep their text:
+	text := emptyMDLinkPattern.ReplaceAllString(stripTemplateTags(body), "$1")
+	var out bytes.Buffer
+	if err := plainTextMarkdown.Convert([]byte(text), &out); err != nil {
+		return textPlainText(nil, text)
+	}
+	return HTMLPlainText(out.String())
+}
+
+// textPlainText returns the body as it is, with collapsed white space.
+func textPlainText(_ map[string]any, body string) string {
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(body, " "))
+}
+
+// dataPlainText returns the string values of a data-only page, but the name of
+// its template.
+func dataPlainText(metadata map[string]any, _ string) string {
+	var texts []string
+	var collect func(value any)
+	collect = func(value any) {
+		switch v := value.(type) {
+		case string:
+			texts = append(texts, v)
+		case []any:
+			for _, item := range v {
+				collect(item)
+			}
+		case map[string]any:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				collect(v[key])
+			}
+		}
+	}
+	data := make(map[string]any, len(metadata))
+	for key, value := range metadata {
+		if key != "template" {
+			data[key] = value
+		}
+	}
+	collect(data)
+	return textPlainText(nil, strings.Join(texts, " "))
+}
+
+func stripTemplateTags(body string) string {
+	// variables are mostly used inline, so they are removed without leaving a gap:
+	text := templateVarPattern.ReplaceAllString(body, "")
+	return templateTagPattern.ReplaceAllString(text, " ")
+}
+
+// HTMLPlainText returns the readable text of HTML: comments, scripts, styles and
+// tags are removed, entities decoded and white space collapsed.
+func HTMLPlainText(text string) string {
+	text = htmlCommentPattern.ReplaceAllString(text, " ")
+	text = htmlInvisiblePattern.ReplaceAllString(text, " ")
+	// inline elements are part of the words around them, other elements separate them:
+	text = htmlInlineTagPattern.ReplaceAllString(text, "")
+	text = htmlTagPattern.ReplaceAllString(text, " ")
+	text = html.UnescapeString(text)
+	return textPlainText(nil, text)
+}
@@ -0,0 +1,39 @@
+package lib
+
+import "testing"
+
+func TestExtractPlainText(t *testing.T) {
+	tests := []struct {
+		name      string
+		indexFile string
+		body      string
+		want      string
+	}{
+		{
+			name:      "markdown",
+			indexFile: "index.md",
+			body:      "# Title\n\nSome *emphasized* text with a [link]({{ Webroot(\"/foo\") }}).\n\n{{ Config.Variables.siteTitle }}\n",
+			want:      "Title Some emphasized text with a link.",
+		},
+		{
+			name:      "html template",
+			indexFile: "index.html",
+			body:      "{% extends \"base.html\" %}{% block content %}<h1>Hello &amp; welcome</h1><script>var x = 1;</script><!-- note --><p>Body</p>{% endblock %}",
+			want:      "Hello & welcome Body",
+		},
+		{
+			name:      "empty",
+			indexFile: "index.html",
+			body:      "  \n ",
+			want:      "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractPlainText(tt.indexFile, nil, tt.body); got != tt.want {
+				t.Fatalf("extractPlainText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"alexi.ch/pcms/model"
+)
+
+// collidingRouteQuery selects an indexed page or file route that is either
+// equal to the first argument, or starts with the prefix given as second and
+// third argument (pass "" to match the exact route only).
+const collidingRouteQuery = `
+		SELECT route FROM (
+			SELECT route FROM pages
+			UNION ALL
+			SELECT route FROM files
+		)
+		WHERE route = ? OR (? <> '' AND substr(route, 1, length(?)) = ?)
+		ORDER BY route
+		LIMIT 1
+	`
+
+// GetAliasTarget returns the route of the page that has the given route as
+// alias (see the "aliases" front matter property). If several pages claim the
+// alias, the first by route wins.
+func (h *DBH) GetAliasTarget(route string) (string, bool, error) {
+	var target string
+	err := h.db.QueryRow("SELECT target_route FROM redirects WHERE source_route = ? ORDER BY target_route LIMIT 1", route).Scan(&target)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("query alias %s: %w", route, err)
+	}
+	return target, true, nil
+}
+
+// RedirectCollisions returns a warning for each page alias and redirect rule
+// whose route is also the route of an indexed page or file: the redirect wins,
+// so the page or file cannot be reached anymore. Aliases claimed by several
+// pages are reported as well.
+func (h *DBH) RedirectCollisions(rules []model.RedirectConfig) ([]string, error) {
+	var warnings []string
+
+	claims, err := h.db.Query(`
+		SELECT source_route, target_route FROM redirects
+		WHERE source_route IN (SELECT source_route FROM redirects GROUP BY source_route HAVING COUNT(1) > 1)
+		ORDER BY source_route, target_route
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query alias conflicts: %w", err)
+	}
+	var (
+		claimedAlias string
+		claimants    []string
+	)
+	reportClaims := func() {
+		if len(claimants) > 1 {
+			warnings = append(warnings, fmt.Sprintf("alias %s is claimed by the pages %s, it redirects to %s", claimedAlias, strings.Join(claimants, ", "), claimants[0]))
+		}
+	}
+	for claims.Next() {
+		var source, target string
+		if err := claims.Scan(&source, &target); err != nil {
+			claims.Close()
+			return nil, fmt.Errorf("scan alias conflict: %w", err)
+		}
+		if source != claimedAlias {
+			reportClaims()
+			claimedAlias, claimants = source, nil
+		}
+		claimants = append(claimants, target)
+	}
+	reportClaims()
+	err = claims.Err()
+	claims.Close()
+	if err != nil {
+		return nil, fmt.Errorf("iterate alias conflicts: %w", err)
+	}
+
+	rows, err := h.db.Query(`
+		SELECT source_route, target_route FROM redirects
+		WHERE source_route IN (SELECT route FROM pages UNION ALL SELECT route FROM files)
+		ORDER BY source_route, target_route
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query alias collisions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source, target string
+		if err := rows.Scan(&source, &target); err != nil {
+			return nil, fmt.Errorf("scan alias collision: %w", err)
+		}
+		warnings = append(warnings, fmt.Sprintf("alias %s of page %s collides with the existing route %s", source, target, source))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate alias collisions: %w", err)
+	}
+
+	for _, rule := range rules {
+		from, prefix := redirectRulePattern(rule.From)
+		var route string
+		err := h.db.QueryRow(collidingRouteQuery, from, prefix, prefix, prefix).Scan(&route)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("query redirect collisions of %s: %w", rule.From, err)
+		}
+		warnings = append(warnings, fmt.Sprintf("redirect %s collides with the existing route %s", rule.From, route))
+	}
+
+	return warnings, nil
+}
+
+// MatchRedirect returns the target and HTTP status of the first redirect rule
+// that matches the route. A rule route with a trailing "/*" matches the route
+// itself and all routes below it; a "*" in the target is replaced by the
+// matched remainder of the route.
+func MatchRedirect(rules []model.RedirectConfig, route string) (string, int, bool) {
+	for _, rule := range rules {
+		from, prefix := redirectRulePattern(rule.From)
+		var rest string
+		switch {
+		case route == from:
+		case prefix != "" && strings.HasPrefix(route, prefix):
+			rest = strings.TrimPrefix(route, prefix)
+		default:
+			continue
+		}
+
+		target := rule.To
+		if strings.Contains(target, "*") {
+			target = strings.Replace(target, "*", rest, 1)
+			if rest == "" && !strings.Contains(target, "://") {
+				target = path.Clean(target)
+			}
+		}
+		return target, rule.Status, true
+	}
+	return "", 0, false
+}
+
+// redirectRulePattern returns the normalized route of a redirect rule's From,
+// and, for a rule with a trailing "/*", the route prefix of the routes below it.
+func redirectRulePattern(from string) (string, string) {
+	if !strings.HasSuffix(from, "/*") {
+		return path.Clean("/" + from), ""
+	}
+	route := path.Clean("/" + strings.TrimSuffix(from, "/*"))
+	if route == "/" {
+		return route, route
+	}
+	return route, route + "/"
+}
@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"alexi.ch/pcms/model"
+)
+
+func TestPageAliases(t *testing.T) {
+	dbh := setupQueryBuilderDB(t)
+	defer dbh.Close()
+
+	root := "/"
+	if err := dbh.BeginIndexRun(); err != nil {
+		t.Fatalf("BeginIndexRun() error = %v", err)
+	}
+	page := model.IndexedPage{Route: "/contact", ParentPageRoute: &root, Title: "Contact", IndexFile: "index.html", Enabled: true,
+		Metadata: map[string]any{}, Aliases: []string{"/kontakt", "/about", "/contact"}}
+	if err := dbh.ReplacePage(page); err != nil {
+		t.Fatalf("ReplacePage() error = %v", err)
+	}
+	// both pages claim /kontakt, the first by route wins, whatever the order of indexing:
+	imprint := model.IndexedPage{Route: "/imprint", ParentPageRoute: &root, Title: "Imprint", IndexFile: "index.html", Enabled: true,
+		Metadata: map[string]any{}, Aliases: []string{"/kontakt"}}
+	if err := dbh.ReplacePage(imprint); err != nil {
+		t.Fatalf("ReplacePage() error = %v", err)
+	}
+	if err := dbh.ReplacePage(page); err != nil {
+		t.Fatalf("ReplacePage() again error = %v", err)
+	}
+	if err := dbh.CommitIndexRun(); err != nil {
+		t.Fatalf("CommitIndexRun() error = %v", err)
+	}
+
+	target, found, err := dbh.GetAliasTarget("/kontakt")
+	if err != nil || !found || target != "/contact" {
+		t.Fatalf("GetAliasTarget(/kontakt) = %q, %v, %v", target, found, err)
+	}
+	// a page is no alias of itself:
+	if _, found, _ := dbh.GetAliasTarget("/contact"); found {
+		t.Fatalf("GetAliasTarget(/contact) found, want the page itself to be skipped")
+	}
+
+	warnings, err := dbh.RedirectCollisions([]model.RedirectConfig{
+		{From: "/blog/*", To: "/articles/*", Status: http.StatusMovedPermanently},
+		{From: "/old-feed", To: "/blog", Status: http.StatusFound},
+	})
+	if err != nil {
+		t.Fatalf("RedirectCollisions() error = %v", err)
+	}
+	if len(warnings) != 3 || warnings[0] != "alias /kontakt is claimed by the pages /contact, /imprint, it redirects to /contact" ||
+		!strings.Contains(warnings[1], "alias /about") || !strings.Contains(warnings[2], "redirect /blog/*") {
+		t.Fatalf("RedirectCollisions() = %v", warnings)
+	}
+
+	if err := dbh.BeginIndexRun(); err != nil {
+		t.Fatalf("BeginIndexRun() error = %v", err)
+	}
+	if err := dbh.DeletePage("/contact"); err != nil {
+		t.Fatalf("DeletePage() error = %v", err)
+	}
+	if err := dbh.CommitIndexRun(); err != nil {
+		t.Fatalf("CommitIndexRun() error = %v", err)
+	}
+	// the other claim is kept:
+	if target, found, err := dbh.GetAliasTarget("/kontakt"); err != nil || !found || target != "/imprint" {
+		t.Fatalf("GetAliasTarget(/kontakt) after the page was deleted = %q, %v, %v", target, found, err)
+	}
+	if _, found, _ := dbh.GetAliasTarget("/about"); found {
+		t.Fatalf("GetAliasTarget(/about) found after the page was deleted")
+	}
+}
+
+func TestMatchRedirect(t *testing.T) {
+	rules := []model.RedirectConfig{
+		{From: "/old-blog/*", To: "/blog/*", Status: http.StatusMovedPermanently},
+		{From: "/docs/*", To: "https://docs.example.com/*", Status: http.StatusFound},
+		{From: "/feed", To: "/blog/atom.xml", Status: http.StatusFound},
+	}
+
+	tests := []struct {
+		route      string
+		wantTarget string
+		wantStatus int
+		wantFound  bool
+	}{
+		{"/old-blog/post-1", "/blog/post-1", http.StatusMovedPermanently, true},
+		{"/old-blog", "/blog", http.StatusMovedPermanently, true},
+		{"/old-blogger", "", 0, false},
+		{"/docs/setup/install", "https://docs.example.com/setup/install", http.StatusFound, true},
+		{"/feed", "/blog/atom.xml", http.StatusFound, true},
+		{"/feed/sub", "", 0, false},
+	}
+	for _, tt := range tests {
+		target, status, found := MatchRedirect(rules, tt.route)
+		if target != tt.wantTarget || status != tt.wantStatus || found != tt.wantFound {
+			t.Errorf("MatchRedirect(%s) = %q, %d, %v, want %q, %d, %v", tt.route, target, status, found, tt.wantTarget, tt.wantStatus, tt.wantFound)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package lib
+
+import "strings"
+
+// SummaryMarker marks the end of the summary of a page in its index file:
+// the text before it is the summary.
+const SummaryMarker = "<!--more-->"
+
+const (
+	// summaryWords is the length of a summary made from the first words of a
+	// page, if it has no summary property or marker.
+	summaryWords = 70
+	// readingWordsPerMinute is the reading speed ReadingTime assumes.
+	readingWordsPerMinute = 200
+)
+
+// CountWords returns the number of words of a plain text.
+func CountWords(text string) int {
+	return len(strings.Fields(text))
+}
+
+// ReadingTime returns the reading time of a text with the given number of
+// words, in minutes, rounded up.
+func ReadingTime(wordCount int) int {
+	return (wordCount + readingWordsPerMinute - 1) / readingWordsPerMinute
+}
+
+// Summary returns the summary of a page: its "summary" front matter property,
+// or the source up to the SummaryMarker, or the first words of the source.
+// plainText returns the readable text of (a part of) the source.
+func Summary(metadata map[string]any, source string, plainText func(source string) string) string {
+	if summary, ok := metadata["summary"].(string); ok && strings.TrimSpace(summary) != "" {
+		return strings.TrimSpace(summary)
+	}
+	if before, _, found := strings.Cut(source, SummaryMarker); found {
+		return plainText(before)
+	}
+	words := strings.Fields(plainText(source))
+	if len(words) <= summaryWords {
+		return strings.Join(words, " ")
+	}
+	return strings.Join(words[:summaryWords], " ") + " …"
+}
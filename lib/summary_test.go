@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSummary(t *testing.T) {
+	long := strings.Repeat("word ", summaryWords+5)
+	tests := []struct {
+		name     string
+		metadata map[string]any
+		source   string
+		want     string
+	}{
+		{name: "summary property", metadata: map[string]any{"summary": " Written by hand. "}, source: "Intro <!--more--> rest", want: "Written by hand."},
+		{name: "more marker", source: "<p>Intro   <b>text</b></p>\n<!--more-->\n<p>rest</p>", want: "Intro text"},
+		{name: "short text", source: "<p>Just a short page.</p>", want: "Just a short page."},
+		{name: "first words", source: long, want: strings.TrimSpace(strings.Repeat("word ", summaryWords)) + " …"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Summary(tt.metadata, tt.source, HTMLPlainText); got != tt.want {
+				t.Fatalf("Summary() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for words, want := range map[int]int{0: 0, 1: 1, readingWordsPerMinute: 1, readingWordsPerMinute + 1: 2} {
+		if got := ReadingTime(words); got != want {
+			t.Fatalf("ReadingTime(%d) = %d, want %d", words, got, want)
+		}
+	}
+}
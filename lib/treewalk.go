@@ -1,41 +1,89 @@
 package lib
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"alexi.ch/pcms/model"
 	"alexi.ch/pcms/stdlib"
 	"github.com/gabriel-vasile/mimetype"
+	"gopkg.in/yaml.v3"
 )
 
 func BuildIndexSnapshot(srcFS fs.FS, excludePatterns []string) (*model.IndexSnapshot, error) {
+	return BuildIncrementalIndexSnapshot(srcFS, excludePatterns, false, nil, os.Stdout)
+}
+
+// BuildIncrementalIndexSnapshot walks the source tree like BuildIndexSnapshot, but
+// reuses the content hash and MIME type of files in the known snapshot (usually
+// loaded via DBH.LoadIndexSnapshot) whose mtime and size did not change, and
+// the image properties of images whose content did not change.
+// A nil known snapshot hashes and inspects every file.
+//
+// exifGPS stores the GPS position of images from their EXIF data (the
+// images.exif_gps config option). entryLog receives one line per indexed page
+// and file; nil indexes quietly.
+func BuildIncrementalIndexSnapshot(srcFS fs.FS, excludePatterns []string, exifGPS bool, known *model.IndexSnapshot, entryLog io.Writer) (*model.IndexSnapshot, error) {
 	snapshot := &model.IndexSnapshot{
 		Pages: make([]model.IndexedPage, 0),
 		Files: make([]model.IndexedFile, 0),
 	}
 
-	if err := walkIndexTree(srcFS, ".", "/", nil, excludePatterns, snapshot, true); err != nil {
+	opts := &indexWalkOptions{
+		knownFiles: make(map[string]model.IndexedFile),
+		exifGPS:    exifGPS,
+		entryLog:   entryLog,
+	}
+	if opts.entryLog == nil {
+		opts.entryLog = io.Discard
+	}
+	if known != nil {
+		for _, file := range known.Files {
+			opts.knownFiles[file.Route] = file
+		}
+	}
+
+	if err := walkIndexTree(srcFS, ".", "/", nil, excludePatterns, opts, snapshot, model.IndexedPage{Enabled: true}); err != nil {
 		return nil, err
 	}
 
 	return snapshot, nil
 }
 
+// indexWalkOptions carries the optional inputs of an index walk through all
+// recursion levels of walkIndexTree.
+type indexWalkOptions struct {
+	// files of a previous index run by route, see inspectFileFromFS
+	knownFiles map[string]model.IndexedFile
+	// store the GPS position of images, see inspectImage
+	exifGPS bool
+	// receives one line per indexed page and file
+	entryLog io.Writer
+}
+
 // walkIndexTree recursively walks the source filesystem and builds the index snapshot.
-// parentEffectivelyEnabled carries the effective enabled state of the nearest ancestor
-// page so that disabled parents force all descendants to also be disabled in the index.
-func walkIndexTree(srcFS fs.FS, relDir string, route string, inheritedParentPageRoute *string, excludePatterns []string, snapshot *model.IndexSnapshot, parentEffectivelyEnabled bool) error {
+// parent carries the effective visibility (enabled flag and schedule window) of the
+// nearest ancestor page, so that disabled or scheduled parents force all descendants
+// to also be disabled or scheduled in the index.
+func walkIndexTree(srcFS fs.FS, relDir string, route string, inheritedParentPageRoute *string, excludePatterns []string, opts *indexWalkOptions, snapshot *model.IndexSnapshot, parent model.IndexedPage) error {
 	entries, err := fs.ReadDir(srcFS, relDir)
 	if err != nil {
 		return fmt.Errorf("read dir %s: %w", relDir, err)
 	}
 
-	indexFileName := ""
+	var indexFileNames []string
+	folderFiles := make(map[string]bool)
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
@@ -45,62 +93,73 @@ func walkIndexTree(srcFS fs.FS, relDir string, route string, inheritedParentPage
 		if isExcluded {
 			continue
 		}
+		folderFiles[entry.Name()] = true
 		if isSupportedIndexFile(entry.Name()) {
-			if indexFileName == "" || entry.Name() < indexFileName {
-				indexFileName = entry.Name()
-			}
+			indexFileNames = append(indexFileNames, entry.Name())
+		}
+	}
+	indexFileName, variantFileNames, ignoredFileNames := selectPageIndexFiles(indexFileNames)
+	// the index files of the language variants are no files of the page:
+	pageSourceNames := map[string]bool{indexFileName: true}
+	for _, name := range variantFileNames {
+		pageSourceNames[name] = true
+	}
+	// ignored index files of a fallback format (index.json next to index.md)
+	// are files of the page; ignored templates are never served as they are:
+	for _, name := range ignoredFileNames {
+		if isFallbackIndexFile(name) {
+			continue
 		}
+		pageSourceNames[name] = true
+		ignoredSource := name
+		if relDir != "." {
+			ignoredSource = path.Join(relDir, name)
+		}
+		snapshot.Warnings = append(snapshot.Warnings, fmt.Sprintf("page %s: index file %s is ignored, the page is made from %s", route, ignoredSource, indexFileName))
 	}
 
 	currentPageRoute := (*string)(nil)
 	if indexFileName != "" {
-		pageTitle := defaultTitleForRoute(route)
-		pageMetadata := make(stdlib.YamlFrontMatter)
-		indexPath := indexFileName
-		if relDir != "." {
-			indexPath = path.Join(relDir, indexFileName)
-		}
-		fm, err := parsePageIndexFrontmatter(srcFS, indexPath, pageTitle)
+		page, err := parsePageSources(srcFS, relDir, route, indexFileName, variantFileNames)
 		if err != nil {
 			return err
 		}
-		pageMetadata = fm.Metadata
-		pageTitle = fm.Title
-
-		// Effective enabled: own flag AND all ancestor pages must be enabled.
-		// parentEffectivelyEnabled already encodes the full ancestor chain, so
-		// a single AND is sufficient.
-		effectiveEnabled := fm.Enabled && parentEffectivelyEnabled
-
-		snapshot.Pages = append(snapshot.Pages, model.IndexedPage{
-			Route:           route,
-			ParentPageRoute: inheritedParentPageRoute,
-			Title:           pageTitle,
-			IndexFile:       indexFileName,
-			Enabled:         effectiveEnabled,
-			Metadata:        pageMetadata,
-		})
-
-		pageSource := indexFileName
-		if relDir != "." {
-			pageSource = path.Join(relDir, indexFileName)
+		page.ParentPageRoute = inheritedParentPageRoute
+		// Effective visibility: own flag AND all ancestor pages must be enabled,
+		// and the page is only visible while all ancestors are. parent already
+		// encodes the full ancestor chain, so inheriting from it is sufficient.
+		page.InheritVisibility(parent)
+		snapshot.Pages = append(snapshot.Pages, page)
+
+		for _, name := range append([]string{indexFileName}, variantFileNames...) {
+			pageSource := name
+			if relDir != "." {
+				pageSource = path.Join(relDir, name)
+			}
+			fmt.Fprintf(opts.entryLog, "type=page file=%s route=%s\n", pageSource, route)
 		}
-		fmt.Printf("type=page file=%s route=%s\n", pageSource, route)
 
 		currentRoute := route
 		currentPageRoute = &currentRoute
 	}
 
 	activeParentPageRoute := inheritedParentPageRoute
-	// childEffectivelyEnabled tracks the effective enabled state to pass into
-	// subdirectories. If this directory introduced a page, use its effective
-	// enabled state; otherwise propagate the inherited one.
-	childEffectivelyEnabled := parentEffectivelyEnabled
+	// childParent tracks the effective visibility to pass into subdirectories.
+	// If this directory introduced a page, use its effective visibility;
+	// otherwise propagate the inherited one.
+	childParent := parent
 	if currentPageRoute != nil {
 		activeParentPageRoute = currentPageRoute
-		// Look up the effective enabled that was stored for this page.
+		// Look up the effective visibility that was stored for this page.
 		// Since snapshot.Pages is append-only and we just added it, it's the last element.
-		childEffectivelyEnabled = snapshot.Pages[len(snapshot.Pages)-1].Enabled
+		childParent = snapshot.Pages[len(snapshot.Pages)-1]
+	}
+
+	var folderMetadata map[string]map[string]any
+	if activeParentPageRoute != nil {
+		if folderMetadata, err = readFolderSidecar(srcFS, relDir, folderFiles); err != nil {
+			return err
+		}
 	}
 
 	for _, entry := range entries {
@@ -115,7 +174,7 @@ func walkIndexTree(srcFS fs.FS, relDir string, route string, inheritedParentPage
 			if relDir != "." {
 				nextRelDir = path.Join(relDir, entry.Name())
 			}
-			if err := walkIndexTree(srcFS, nextRelDir, entryRoute, activeParentPageRoute, excludePatterns, snapshot, childEffectivelyEnabled); err != nil {
+			if err := walkIndexTree(srcFS, nextRelDir, entryRoute, activeParentPageRoute, excludePatterns, opts, snapshot, childParent); err != nil {
 				return err
 			}
 			continue
@@ -125,7 +184,7 @@ func walkIndexTree(srcFS fs.FS, relDir string, route string, inheritedParentPage
 		if isExcluded {
 			continue
 		}
-		if entry.Name() == indexFileName {
+		if pageSourceNames[entry.Name()] || isFileSidecar(entry.Name(), folderFiles) {
 			continue
 		}
 		if activeParentPageRoute == nil {
@@ -141,42 +200,270 @@ func walkIndexTree(srcFS fs.FS, relDir string, route string, inheritedParentPage
 		if relDir != "." {
 			filePath = path.Join(relDir, entry.Name())
 		}
-		mimeType, err := detectMimeTypeFromFS(srcFS, filePath)
+		metadata, err := readFileMetadata(srcFS, relDir, entry.Name(), folderFiles, folderMetadata)
 		if err != nil {
 			return err
 		}
+		if err := appendIndexedFile(srcFS, filePath, entryRoute, entryInfo, metadata, *activeParentPageRoute, childParent.Enabled, opts, snapshot); err != nil {
+			return err
+		}
+	}
 
-		snapshot.Files = append(snapshot.Files, model.IndexedFile{
-			Route:           entryRoute,
-			ParentPageRoute: *activeParentPageRoute,
-			FileName:        entry.Name(),
-			MimeType:        mimeType,
-			FileSize:        entryInfo.Size(),
-			Enabled:         childEffectivelyEnabled,
-		})
-		fmt.Printf("type=file file=%s route=%s mime=%s\n", filePath, entryRoute, mimeType)
+	return nil
+}
+
+// appendIndexedFile inspects a single non-page file and adds it to the snapshot,
+// with the given sidecar metadata.
+func appendIndexedFile(srcFS fs.FS, filePath string, route string, info fs.FileInfo, metadata map[string]any, parentPageRoute string, enabled bool, opts *indexWalkOptions, snapshot *model.IndexSnapshot) error {
+	known, hasKnown := opts.knownFiles[route]
+	mimeType, sourceHash, err := inspectFileFromFS(srcFS, filePath, info, known, hasKnown)
+	if err != nil {
+		return err
 	}
 
+	record := model.IndexedFile{
+		Route:           route,
+		ParentPageRoute: parentPageRoute,
+		FileName:        path.Base(filePath),
+		MimeType:        mimeType,
+		FileSize:        info.Size(),
+		Enabled:         enabled,
+		Metadata:        metadata,
+		SourceModTime:   info.ModTime().UTC(),
+		SourceHash:      sourceHash,
+	}
+	if strings.HasPrefix(mimeType, "image/") {
+		if hasKnown && known.SourceHash == sourceHash {
+			copyImageProperties(&record, known, opts.exifGPS)
+		} else if err := inspectImage(srcFS, filePath, &record, opts.exifGPS); err != nil {
+			return err
+		}
+	}
+	snapshot.Files = append(snapshot.Files, record)
+	fmt.Fprintf(opts.entryLog, "type=file file=%s route=%s mime=%s\n", filePath, route, mimeType)
 	return nil
 }
 
+// copyImageProperties takes the image properties of an unchanged image from its
+// known record. The GPS position is dropped without exifGPS; with it, a position
+// is only read for a changed image (or by a full index run).
+func copyImageProperties(record *model.IndexedFile, known model.IndexedFile, exifGPS bool) {
+	record.Width, record.Height = known.Width, known.Height
+	record.Orientation = known.Orientation
+	record.CaptureDate = known.CaptureDate
+	record.Camera = known.Camera
+	if exifGPS {
+		record.HasLocation = known.HasLocation
+		record.Latitude, record.Longitude = known.Latitude, known.Longitude
+	}
+}
+
+// buildRouteIndexSnapshot walks the source tree at the given route only: a folder
+// is walked recursively, a file results in a single file record. parent is the
+// nearest page above the route (nil if there is none); it takes the role the
+// enclosing folders play in a full walk. A missing or excluded route results in
+// an empty snapshot. Known files are reused as in BuildIncrementalIndexSnapshot.
+func buildRouteIndexSnapshot(srcFS fs.FS, excludePatterns []string, exifGPS bool, route string, parent *model.IndexedPage, known *model.IndexSnapshot) (*model.IndexSnapshot, error) {
+	snapshot := &model.IndexSnapshot{
+		Pages: make([]model.IndexedPage, 0),
+		Files: make([]model.IndexedFile, 0),
+	}
+
+	// a full walk never descends into excluded folders, so the route's
+	// ancestors have to be checked as well:
+	for r := route; r != "/"; r = path.Dir(r) {
+		if isExcluded, _ := isFileExcluded(r, excludePatterns); isExcluded {
+			return snapshot, nil
+		}
+	}
+
+	opts := &indexWalkOptions{
+		knownFiles: make(map[string]model.IndexedFile),
+		exifGPS:    exifGPS,
+		entryLog:   io.Discard,
+	}
+	if known != nil {
+		for _, file := range known.Files {
+			opts.knownFiles[file.Route] = file
+		}
+	}
+
+	relPath := strings.TrimPrefix(route, "/")
+	if relPath == "" {
+		relPath = "."
+	}
+	info, err := fs.Stat(srcFS, relPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: %w", relPath, err)
+	}
+
+	parentRoute := (*string)(nil)
+	parentVisibility := model.IndexedPage{Enabled: true}
+	if parent != nil {
+		r := parent.Route
+		parentRoute = &r
+		parentVisibility = *parent
+	}
+
+	if info.IsDir() {
+		if err := walkIndexTree(srcFS, relPath, route, parentRoute, excludePatterns, opts, snapshot, parentVisibility); err != nil {
+			return nil, err
+		}
+		return snapshot, nil
+	}
+
+	if parentRoute == nil {
+		return snapshot, nil
+	}
+
+	// the sidecars of the file are found in its folder, as in a full walk:
+	relDir := path.Dir(relPath)
+	entries, err := fs.ReadDir(srcFS, relDir)
+	if err != nil {
+		return nil, fmt.Errorf("read dir %s: %w", relDir, err)
+	}
+	folderFiles := make(map[string]bool)
+	for _, entry := range entries {
+		if isExcluded, _ := isFileExcluded(joinRoute(path.Dir(route), entry.Name()), excludePatterns); !entry.IsDir() && !isExcluded {
+			folderFiles[entry.Name()] = true
+		}
+	}
+	if isFileSidecar(info.Name(), folderFiles) {
+		return snapshot, nil
+	}
+	folderMetadata, err := readFolderSidecar(srcFS, relDir, folderFiles)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := readFileMetadata(srcFS, relDir, info.Name(), folderFiles, folderMetadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := appendIndexedFile(srcFS, relPath, route, info, metadata, *parentRoute, parentVisibility.Enabled, opts, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+const (
+	// folderSidecarName is the metadata sidecar of all files in a folder: a
+	// YAML map with the properties of each file by file name.
+	folderSidecarName = "_files.yaml"
+	// sidecarSuffix makes the metadata sidecar of a single file: photo.jpg.yaml
+	// holds the properties of photo.jpg.
+	sidecarSuffix = ".yaml"
+)
+
+// isFileSidecar reports whether the given file is a metadata sidecar, in a
+// folder with the given (non-excluded) files: the folder's _files.yaml, or a
+// YAML file named after another file of the folder. Sidecars are no files of
+// the page, they are neither indexed nor served.
+func isFileSidecar(fileName string, folderFiles map[string]bool) bool {
+	if fileName == folderSidecarName {
+		return true
+	}
+	target, isYAML := strings.CutSuffix(fileName, sidecarSuffix)
+	return isYAML && target != "" && folderFiles[target]
+}
+
+// readFolderSidecar reads the _files.yaml sidecar of the folder relDir, if the
+// folder has one: the metadata of its files by file name.
+func readFolderSidecar(srcFS fs.FS, relDir string, folderFiles map[string]bool) (map[string]map[string]any, error) {
+	if !folderFiles[folderSidecarName] {
+		return nil, nil
+	}
+	sidecarPath := path.Join(relDir, folderSidecarName)
+	content, err := fs.ReadFile(srcFS, sidecarPath)
+	if err != nil {
+		return nil, fmt.Errorf("read sidecar %s: %w", sidecarPath, err)
+	}
+	var metadata map[string]map[string]any
+	if err := yaml.Unmarshal(content, &metadata); err != nil {
+		return nil, fmt.Errorf("parse sidecar %s: %w", sidecarPath, err)
+	}
+	return metadata, nil
+}
+
+// readFileMetadata returns the metadata of a file in the folder relDir: its
+// entry in the folder's _files.yaml (see readFolderSidecar), overridden
+// property by property by its own sidecar (photo.jpg.yaml). Returns nil for a
+// file without sidecar metadata.
+func readFileMetadata(srcFS fs.FS, relDir string, fileName string, folderFiles map[string]bool, folderMetadata map[string]map[string]any) (map[string]any, error) {
+	var metadata map[string]any
+	if entry := folderMetadata[fileName]; len(entry) > 0 {
+		metadata = make(map[string]any, len(entry))
+		for key, value := range entry {
+			metadata[key] = value
+		}
+	}
+	if !folderFiles[fileName+sidecarSuffix] {
+		return metadata, nil
+	}
+
+	sidecarPath := path.Join(relDir, fileName+sidecarSuffix)
+	content, err := fs.ReadFile(srcFS, sidecarPath)
+	if err != nil {
+		return nil, fmt.Errorf("read sidecar %s: %w", sidecarPath, err)
+	}
+	var own map[string]any
+	if err := yaml.Unmarshal(content, &own); err != nil {
+		return nil, fmt.Errorf("parse sidecar %s: %w", sidecarPath, err)
+	}
+	if len(own) > 0 && metadata == nil {
+		metadata = make(map[string]any, len(own))
+	}
+	for key, value := range own {
+		metadata[key] = value
+	}
+	return metadata, nil
+}
+
 type parsedFrontmatter struct {
 	Metadata stdlib.YamlFrontMatter
 	Title    string
 	Enabled  bool
+
+	// the parsed publishDate / expiryDate properties, zero if not set
+	PublishDate time.Time
+	ExpiryDate  time.Time
+	Aliases     []string
+
+	SourceModTime time.Time
+	SourceSize    int64
+	SourceHash    string
+	PlainText     string
+	Summary       string
+	WordCount     int
 }
 
 func parsePageIndexFrontmatter(srcFS fs.FS, indexPath string, fallbackTitle string) (parsedFrontmatter, error) {
+	info, err := fs.Stat(srcFS, indexPath)
+	if err != nil {
+		return parsedFrontmatter{}, fmt.Errorf("stat index file %s: %w", indexPath, err)
+	}
 	content, err := fs.ReadFile(srcFS, indexPath)
 	if err != nil {
 		return parsedFrontmatter{}, fmt.Errorf("read index file %s: %w", indexPath, err)
 	}
+	contentHash := sha256.Sum256(content)
 
-	metadata, _, err := stdlib.ExtractYamlFrontMatter(string(content))
+	format, ok := IndexFormatOf(indexPath)
+	if !ok {
+		return parsedFrontmatter{}, fmt.Errorf("index file %s: unsupported index format", indexPath)
+	}
+	metadata, body, err := format.ParseFrontMatter(string(content))
 	if err != nil {
 		return parsedFrontmatter{}, fmt.Errorf("parse frontmatter in %s: %w", indexPath, err)
 	}
 
+	plainText := format.PlainText(metadata, body)
+	summary := Summary(metadata, body, func(source string) string {
+		return format.PlainText(metadata, source)
+	})
+
 	title := fallbackTitle
 	if rawTitle, hasTitle := metadata["title"]; hasTitle {
 		title = fmt.Sprintf("%v", rawTitle)
@@ -190,30 +477,165 @@ func parsePageIndexFrontmatter(srcFS fs.FS, indexPath string, fallbackTitle stri
 		}
 	}
 
-	return parsedFrontmatter{Metadata: metadata, Title: title, Enabled: enabled}, nil
+	publishDate, err := frontmatterDate(metadata, "publishDate")
+	if err != nil {
+		return parsedFrontmatter{}, fmt.Errorf("invalid publishDate in %s: %w", indexPath, err)
+	}
+	expiryDate, err := frontmatterDate(metadata, "expiryDate")
+	if err != nil {
+		return parsedFrontmatter{}, fmt.Errorf("invalid expiryDate in %s: %w", indexPath, err)
+	}
+
+	return parsedFrontmatter{
+		Metadata:      metadata,
+		Title:         title,
+		Enabled:       enabled,
+		PublishDate:   publishDate,
+		ExpiryDate:    expiryDate,
+		Aliases:       frontmatterAliases(metadata),
+		SourceModTime: info.ModTime().UTC(),
+		SourceSize:    int64(len(content)),
+		SourceHash:    hex.EncodeToString(contentHash[:]),
+		PlainText:     plainText,
+		Summary:       summary,
+		WordCount:     CountWords(plainText),
+	}, nil
 }
 
-// ReindexSinglePage re-reads the frontmatter from the source file and returns
-// an updated IndexedPage. The caller is responsible for persisting it via ReplacePage.
-func ReindexSinglePage(srcFS fs.FS, route string, existingPage model.IndexedPage) (model.IndexedPage, error) {
-	indexPath := existingPage.IndexFile
-	if route != "/" {
-		indexPath = path.Join(strings.TrimPrefix(route, "/"), existingPage.IndexFile)
+// frontmatterDate returns the date of the given front matter property, or the
+// zero time if it is not set. A value that is no date is an error: a typo must
+// not publish a scheduled page early.
+func frontmatterDate(metadata stdlib.YamlFrontMatter, key string) (time.Time, error) {
+	raw, ok := metadata[key]
+	if !ok || raw == nil || raw == "" {
+		return time.Time{}, nil
+	}
+	date, ok := stdlib.ParseDate(raw)
+	if !ok {
+		return time.Time{}, fmt.Errorf("not a date: %v", raw)
+	}
+	return date, nil
+}
+
+// frontmatterAliases returns the routes of the "aliases" front matter property,
+// a list of routes or a single one. Entries that are no strings are ignored.
+func frontmatterAliases(metadata stdlib.YamlFrontMatter) []string {
+	var raw []any
+	switch v := metadata["aliases"].(type) {
+	case string:
+		raw = []any{v}
+	case []any:
+		raw = v
+	}
+
+	var aliases []string
+	for _, entry := range raw {
+		alias, ok := entry.(string)
+		if !ok || strings.TrimSpace(alias) == "" {
+			continue
+		}
+		aliases = append(aliases, path.Clean("/"+strings.TrimSpace(alias)))
 	}
+	return aliases
+}
 
-	fm, err := parsePageIndexFrontmatter(srcFS, indexPath, defaultTitleForRoute(route))
+// parsePageSources reads the index file of the page at route, in the folder
+// relDir of the source FS, and the index files of its language variants.
+// The returned page has the raw enabled flag of its front matter, and no parent.
+//
+// The source signature of a page with variants covers all its index files, so
+// that a change to any of them is picked up by an index sync.
+func parsePageSources(srcFS fs.FS, relDir string, route string, indexFileName string, variantFileNames []string) (model.IndexedPage, error) {
+	sourcePath := func(name string) string {
+		if relDir == "." {
+			return name
+		}
+		return path.Join(relDir, name)
+	}
+
+	fm, err := parsePageIndexFrontmatter(srcFS, sourcePath(indexFileName), defaultTitleForRoute(route))
 	if err != nil {
 		return model.IndexedPage{}, err
 	}
+	page := model.IndexedPage{
+		Route:         route,
+		Title:         fm.Title,
+		IndexFile:     indexFileName,
+		Enabled:       fm.Enabled,
+		Metadata:      fm.Metadata,
+		PublishDate:   fm.PublishDate,
+		ExpiryDate:    fm.ExpiryDate,
+		Aliases:       fm.Aliases,
+		Language:      indexFileLanguage(indexFileName),
+		SourceModTime: fm.SourceModTime,
+		SourceSize:    fm.SourceSize,
+		SourceHash:    fm.SourceHash,
+		PlainText:     fm.PlainText,
+		Summary:       fm.Summary,
+		WordCount:     fm.WordCount,
+		ReadingTime:   ReadingTime(fm.WordCount),
+	}
+	if len(variantFileNames) == 0 {
+		return page, nil
+	}
 
-	return model.IndexedPage{
-		Route:           route,
-		ParentPageRoute: existingPage.ParentPageRoute,
-		Title:           fm.Title,
-		IndexFile:       existingPage.IndexFile,
-		Enabled:         fm.Enabled,
-		Metadata:        fm.Metadata,
-	}, nil
+	signature := sha256.New()
+	io.WriteString(signature, fm.SourceHash)
+	for _, name := range variantFileNames {
+		variant, err := parsePageIndexFrontmatter(srcFS, sourcePath(name), fm.Title)
+		if err != nil {
+			return model.IndexedPage{}, err
+		}
+		language := indexFileLanguage(name)
+		page.Translations = append(page.Translations, model.PageTranslation{
+			Language:    language,
+			Title:       variant.Title,
+			IndexFile:   name,
+			Metadata:    variant.Metadata,
+			PlainText:   variant.PlainText,
+			Summary:     variant.Summary,
+			WordCount:   variant.WordCount,
+			ReadingTime: ReadingTime(variant.WordCount),
+		})
+		if variant.SourceModTime.After(page.SourceModTime) {
+			page.SourceModTime = variant.SourceModTime
+		}
+		page.SourceSize += variant.SourceSize
+		fmt.Fprintf(signature, "\n%s:%s", language, variant.SourceHash)
+	}
+	page.SourceHash = hex.EncodeToString(signature.Sum(nil))
+	return page, nil
+}
+
+// ReindexSinglePage re-reads the index files of the page at route from the
+// source folder, including its language variants, and returns an updated
+// IndexedPage. The caller is responsible for persisting it via ReplacePage.
+func ReindexSinglePage(srcFS fs.FS, route string, existingPage model.IndexedPage) (model.IndexedPage, error) {
+	relDir := strings.TrimPrefix(route, "/")
+	if relDir == "" {
+		relDir = "."
+	}
+	entries, err := fs.ReadDir(srcFS, relDir)
+	if err != nil {
+		return model.IndexedPage{}, fmt.Errorf("read dir %s: %w", relDir, err)
+	}
+	var indexFileNames []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isSupportedIndexFile(entry.Name()) {
+			indexFileNames = append(indexFileNames, entry.Name())
+		}
+	}
+	indexFileName, variantFileNames, _ := selectPageIndexFiles(indexFileNames)
+	if indexFileName == "" {
+		return model.IndexedPage{}, fmt.Errorf("no index file in %s", relDir)
+	}
+
+	page, err := parsePageSources(srcFS, relDir, route, indexFileName, variantFileNames)
+	if err != nil {
+		return model.IndexedPage{}, err
+	}
+	page.ParentPageRoute = existingPage.ParentPageRoute
+	return page, nil
 }
 
 func joinRoute(parentRoute string, name string) string {
@@ -257,9 +679,138 @@ func detectMimeTypeFromFS(srcFS fs.FS, filePath string) (string, error) {
 	return mtype.String(), nil
 }
 
+// inspectFileFromFS returns the MIME type and content hash of a file. If the known
+// record still matches the file's mtime and size, both are taken from it without
+// reading the file. If only the content hash matches, the MIME type is reused.
+func inspectFileFromFS(srcFS fs.FS, filePath string, info fs.FileInfo, known model.IndexedFile, hasKnown bool) (string, string, error) {
+	if hasKnown && known.SourceHash != "" && known.FileSize == info.Size() && known.SourceModTime.Equal(info.ModTime()) {
+		return known.MimeType, known.SourceHash, nil
+	}
+
+	sourceHash, err := hashFileFromFS(srcFS, filePath)
+	if err != nil {
+		return "", "", err
+	}
+	if hasKnown && known.SourceHash == sourceHash {
+		return known.MimeType, sourceHash, nil
+	}
+
+	mimeType, err := detectMimeTypeFromFS(srcFS, filePath)
+	if err != nil {
+		return "", "", err
+	}
+	return mimeType, sourceHash, nil
+}
+
+func hashFileFromFS(srcFS fs.FS, filePath string) (string, error) {
+	file, err := srcFS.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("open file for hashing %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("hash file %s: %w", filePath, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// isSupportedIndexFile reports whether the given file is the index file of a
+// page: index.<ext> or, for a language variant, index.<lang>.<ext>, with the
+// extension of a registered index format (see RegisterIndexFormat).
 func isSupportedIndexFile(fileName string) bool {
 	base := strings.ToLower(filepath.Base(fileName))
-	return base == "index.html" || base == "index.md"
+	if _, ok := IndexFormatOf(base); !ok {
+		return false
+	}
+	stem := strings.TrimSuffix(base, path.Ext(base))
+	return stem == "index" || indexFileLanguage(base) != ""
+}
+
+// indexFileLanguage returns the language of a language-suffixed index file
+// (index.de.md: "de"), or "" for any other file name.
+func indexFileLanguage(fileName string) string {
+	base := strings.ToLower(filepath.Base(fileName))
+	if _, ok := IndexFormatOf(base); !ok {
+		return ""
+	}
+	language, isIndex := strings.CutPrefix(strings.TrimSuffix(base, path.Ext(base)), "index.")
+	if !isIndex || !model.IsLanguageCode(language) {
+		return ""
+	}
+	// index.md.yaml is the sidecar of an index file, not a language variant:
+	if _, isFormat := IndexFormatOf("." + language); isFormat {
+		return ""
+	}
+	return language
+}
+
+// selectPageIndexFiles picks the index file of a page folder from the supported
+// index files in it, and the index files of its language variants: an index
+// file without language suffix is preferred, otherwise the first language
+// variant by name takes its place. Of several index files of the same language,
+// one of a content format beats one of a fallback format (see
+// IndexFormat.Fallback), then the first by name wins.
+//
+// The index files that lose against another one of the same language are
+// returned as ignored.
+func selectPageIndexFiles(fileNames []string) (string, []string, []string) {
+	if len(fileNames) == 0 {
+		return "", nil, nil
+	}
+	sorted := append([]string{}, fileNames...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		fi, fj := isFallbackIndexFile(sorted[i]), isFallbackIndexFile(sorted[j])
+		if fi != fj {
+			return fj
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	indexFileName := ""
+	for _, name := range sorted {
+		if indexFileLanguage(name) == "" {
+			indexFileName = name
+			break
+		}
+	}
+	if indexFileName == "" {
+		byName := append([]string{}, fileNames...)
+		sort.Strings(byName)
+		indexFileName = byName[0]
+		// the best index file of that language takes the place of the page's:
+		for _, name := range sorted {
+			if indexFileLanguage(name) == indexFileLanguage(indexFileName) {
+				indexFileName = name
+				break
+			}
+		}
+	}
+
+	seen := map[string]bool{indexFileLanguage(indexFileName): true}
+	var variants, ignored []string
+	for _, name := range sorted {
+		language := indexFileLanguage(name)
+		if name == indexFileName {
+			continue
+		}
+		if seen[language] {
+			ignored = append(ignored, name)
+			continue
+		}
+		seen[language] = true
+		variants = append(variants, name)
+	}
+	sort.Strings(variants)
+	sort.Strings(ignored)
+	return indexFileName, variants, ignored
+}
+
+// isFallbackIndexFile reports whether the index file is of a fallback format.
+func isFallbackIndexFile(fileName string) bool {
+	format, ok := IndexFormatOf(fileName)
+	return ok && format.Fallback
 }
 
 // Checks if the given file matches a set of exclude regex patterns.
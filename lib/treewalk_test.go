@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"alexi.ch/pcms/model"
 )
@@ -124,3 +125,296 @@ func TestBuildIndexSnapshotRootFallbackTitle(t *testing.T) {
 		t.Fatalf("fixture validation failed: %v", err)
 	}
 }
+
+func TestBuildIndexSnapshotScheduleDates(t *testing.T) {
+	srcFS := fstest.MapFS{
+		"index.md":      &fstest.MapFile{Data: []byte("---\ntitle: Root\n---\n# root")},
+		"news/index.md": &fstest.MapFile{Data: []byte("---\ntitle: News\npublishDate: 2030-01-01 08:00\nexpiryDate: 2030-02-01T00:00:00+01:00\n---\n# news")},
+	}
+
+	snapshot, err := BuildIndexSnapshot(srcFS, nil)
+	if err != nil {
+		t.Fatalf("BuildIndexSnapshot() error = %v", err)
+	}
+	for _, page := range snapshot.Pages {
+		if page.Route != "/news" {
+			continue
+		}
+		if want := time.Date(2030, 1, 1, 8, 0, 0, 0, time.UTC); !page.PublishDate.Equal(want) {
+			t.Fatalf("/news PublishDate = %v, want %v", page.PublishDate, want)
+		}
+		if want := time.Date(2030, 1, 31, 23, 0, 0, 0, time.UTC); !page.ExpiryDate.Equal(want) {
+			t.Fatalf("/news ExpiryDate = %v, want %v", page.ExpiryDate, want)
+		}
+	}
+
+	srcFS["news/index.md"] = &fstest.MapFile{Data: []byte("---\ntitle: News\npublishDate: next monday\n---\n# news")}
+	if _, err := BuildIndexSnapshot(srcFS, nil); err == nil {
+		t.Fatalf("BuildIndexSnapshot() with an invalid publishDate: expected an error")
+	}
+}
+
+func TestBuildIndexSnapshotInheritsScheduleDates(t *testing.T) {
+	srcFS := fstest.MapFS{
+		"index.md":                &fstest.MapFile{Data: []byte("---\ntitle: Root\n---\n# root")},
+		"news/index.md":           &fstest.MapFile{Data: []byte("---\ntitle: News\npublishDate: 2030-01-01\nexpiryDate: 2030-03-01\n---\n# news")},
+		"news/post/index.md":      &fstest.MapFile{Data: []byte("---\ntitle: Post\n---\n# post")},
+		"news/post/more/index.md": &fstest.MapFile{Data: []byte("---\ntitle: More\n---\n# more")},
+		"news/late/index.md":      &fstest.MapFile{Data: []byte("---\ntitle: Late\npublishDate: 2030-02-01\nexpiryDate: 2031-01-01\n---\n# late")},
+	}
+
+	snapshot, err := BuildIndexSnapshot(srcFS, nil)
+	if err != nil {
+		t.Fatalf("BuildIndexSnapshot() error = %v", err)
+	}
+	pagesByRoute := make(map[string]model.IndexedPage)
+	for _, page := range snapshot.Pages {
+		pagesByRoute[page.Route] = page
+	}
+
+	publish := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiry := time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC)
+	// pages without dates inherit the window of their parents, at any depth:
+	for _, route := range []string{"/news/post", "/news/post/more"} {
+		if page := pagesByRoute[route]; !page.PublishDate.Equal(publish) || !page.ExpiryDate.Equal(expiry) {
+			t.Fatalf("%s dates = %v, %v, want %v, %v", route, page.PublishDate, page.ExpiryDate, publish, expiry)
+		}
+	}
+	// own dates narrow the window, but never widen it:
+	late := pagesByRoute["/news/late"]
+	if want := time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC); !late.PublishDate.Equal(want) || !late.ExpiryDate.Equal(expiry) {
+		t.Fatalf("/news/late dates = %v, %v, want %v, %v", late.PublishDate, late.ExpiryDate, want, expiry)
+	}
+	if root := pagesByRoute["/"]; !root.PublishDate.IsZero() || !root.ExpiryDate.IsZero() {
+		t.Fatalf("/ dates = %v, %v, want none", root.PublishDate, root.ExpiryDate)
+	}
+}
+
+func TestBuildIndexSnapshotLanguageVariants(t *testing.T) {
+	srcFS := fstest.MapFS{
+		"index.md":               &fstest.MapFile{Data: []byte("---\ntitle: Home\n---\n# home")},
+		"index.de.md":            &fstest.MapFile{Data: []byte("---\ntitle: Startseite\n---\n# Willkommen")},
+		"index.fr.html":          &fstest.MapFile{Data: []byte("---\ntitle: Accueil\n---\n<p>bienvenue</p>")},
+		"about/index.en.md":      &fstest.MapFile{Data: []byte("---\ntitle: About\n---\n# about")},
+		"about/index.de.md":      &fstest.MapFile{Data: []byte("---\ntitle: Über uns\n---\n# über")},
+		"about/index.backup.txt": &fstest.MapFile{Data: []byte("not an index file")},
+	}
+
+	snapshot, err := BuildIndexSnapshot(srcFS, nil)
+	if err != nil {
+		t.Fatalf("BuildIndexSnapshot() error = %v", err)
+	}
+	pagesByRoute := make(map[string]model.IndexedPage)
+	for _, page := range snapshot.Pages {
+		pagesByRoute[page.Route] = page
+	}
+
+	// the index file without language suffix is the page's index file:
+	root := pagesByRoute["/"]
+	if root.IndexFile != "index.md" || root.Language != "" || root.Title != "Home" {
+		t.Fatalf("root page = %q (%q) %q", root.IndexFile, root.Language, root.Title)
+	}
+	if len(root.Translations) != 2 || root.Translations[0].Language != "de" || root.Translations[0].Title != "Startseite" ||
+		root.Translations[1].Language != "fr" || root.Translations[1].IndexFile != "index.fr.html" {
+		t.Fatalf("root translations = %+v", root.Translations)
+	}
+
+	// without one, the first variant by name takes its place:
+	about := pagesByRoute["/about"]
+	if about.IndexFile != "index.de.md" || about.Language != "de" || len(about.Translations) != 1 || about.Translations[0].Language != "en" {
+		t.Fatalf("/about page = %q (%q), translations %+v", about.IndexFile, about.Language, about.Translations)
+	}
+
+	// variant index files are no page files:
+	if len(snapshot.Files) != 1 || snapshot.Files[0].Route != "/about/index.backup.txt" {
+		t.Fatalf("files = %+v, want the backup file only", snapshot.Files)
+	}
+
+	// a changed variant changes the page's source signature:
+	srcFS["index.de.md"] = &fstest.MapFile{Data: []byte("---\ntitle: Startseite\n---\n# Hallo")}
+	changed, err := BuildIndexSnapshot(srcFS, nil)
+	if err != nil {
+		t.Fatalf("BuildIndexSnapshot() error = %v", err)
+	}
+	for _, page := range changed.Pages {
+		if page.Route == "/" && page.SourceHash == root.SourceHash {
+			t.Fatalf("root source hash unchanged after editing index.de.md")
+		}
+	}
+}
+
+func TestBuildIndexSnapshotIndexFormats(t *testing.T) {
+	srcFS := fstest.MapFS{
+		"index.md":            &fstest.MapFile{Data: []byte("# home\n\nWelcome **home**.\n\n<!--more-->\n\nMore text.")},
+		"notes/index.txt":     &fstest.MapFile{Data: []byte("---\ntitle: Notes\n---\nplain   <b>text</b>\n")},
+		"menu/index.json":     &fstest.MapFile{Data: []byte(`{"title": "Menu", "template": "menu.html", "dishes": [{"name": "Soup"}, {"name": "Salad"}]}`)},
+		"team/index.yml":      &fstest.MapFile{Data: []byte("title: Team\ntemplate: team.html\n")},
+		"team/index.de.yaml":  &fstest.MapFile{Data: []byte("title: Mannschaft\ntemplate: team.html\n")},
+		"team/index.yml.yaml": &fstest.MapFile{Data: []byte("note: sidecar, no language variant\n")},
+		"broken/index.json":   &fstest.MapFile{Data: []byte(`{"title":`)},
+	}
+
+	if _, err := BuildIndexSnapshot(srcFS, nil); err == nil || !strings.Contains(err.Error(), "broken/index.json") {
+		t.Fatalf("BuildIndexSnapshot() of invalid JSON error = %v", err)
+	}
+	delete(srcFS, "broken/index.json")
+
+	snapshot, err := BuildIndexSnapshot(srcFS, nil)
+	if err != nil {
+		t.Fatalf("BuildIndexSnapshot() error = %v", err)
+	}
+	pagesByRoute := make(map[string]model.IndexedPage)
+	for _, page := range snapshot.Pages {
+		pagesByRoute[page.Route] = page
+	}
+
+	notes := pagesByRoute["/notes"]
+	if notes.IndexFile != "index.txt" || notes.Title != "Notes" || notes.PlainText != "plain <b>text</b>" {
+		t.Fatalf("/notes page = %q %q %q", notes.IndexFile, notes.Title, notes.PlainText)
+	}
+	if notes.Summary != notes.PlainText || notes.WordCount != 2 || notes.ReadingTime != 1 {
+		t.Fatalf("/notes summary = %q, %d words, %d min", notes.Summary, notes.WordCount, notes.ReadingTime)
+	}
+	if home := pagesByRoute["/"]; home.Summary != "home Welcome home." || home.WordCount != 5 {
+		t.Fatalf("/ summary = %q, %d words", home.Summary, home.WordCount)
+	}
+	menu := pagesByRoute["/menu"]
+	if menu.IndexFile != "index.json" || menu.Title != "Menu" || menu.PlainText != "Soup Salad Menu" {
+		t.Fatalf("/menu page = %q %q %q", menu.IndexFile, menu.Title, menu.PlainText)
+	}
+	team := pagesByRoute["/team"]
+	if team.IndexFile != "index.yml" || len(team.Translations) != 1 || team.Translations[0].Title != "Mannschaft" {
+		t.Fatalf("/team page = %q, translations %+v", team.IndexFile, team.Translations)
+	}
+	if len(snapshot.Files) != 0 {
+		t.Fatalf("files = %+v, want none", snapshot.Files)
+	}
+}
+
+func TestBuildIndexSnapshotIndexFileConflicts(t *testing.T) {
+	srcFS := fstest.MapFS{
+		"index.md":           &fstest.MapFile{Data: []byte("# home\n")},
+		"api/index.md":       &fstest.MapFile{Data: []byte("---\ntitle: API\n---\n# API\n")},
+		"api/index.json":     &fstest.MapFile{Data: []byte(`{"version": 2}`)},
+		"api/index.html":     &fstest.MapFile{Data: []byte("<h1>API</h1>")},
+		"api/index.de.txt":   &fstest.MapFile{Data: []byte("Schnittstelle")},
+		"api/index.de.md":    &fstest.MapFile{Data: []byte("# Schnittstelle\n")},
+		"notes/index.txt":    &fstest.MapFile{Data: []byte("Notes")},
+		"notes/index.fr.txt": &fstest.MapFile{Data: []byte("Notes fr")},
+	}
+	snapshot, err := BuildIndexSnapshot(srcFS, nil)
+	if err != nil {
+		t.Fatalf("BuildIndexSnapshot() error = %v", err)
+	}
+	pagesByRoute := make(map[string]model.IndexedPage)
+	for _, page := range snapshot.Pages {
+		pagesByRoute[page.Route] = page
+	}
+
+	// content formats beat fallback formats, then the first by name wins:
+	api := pagesByRoute["/api"]
+	if api.IndexFile != "index.html" || len(api.Translations) != 1 || api.Translations[0].IndexFile != "index.de.md" {
+		t.Fatalf("/api page = %q, translations %+v", api.IndexFile, api.Translations)
+	}
+	if notes := pagesByRoute["/notes"]; notes.IndexFile != "index.txt" || len(notes.Translations) != 1 {
+		t.Fatalf("/notes page = %q, translations %+v", notes.IndexFile, notes.Translations)
+	}
+
+	// ignored data and text index files are files of the page, ignored
+	// templates are not served:
+	var files []string
+	for _, file := range snapshot.Files {
+		files = append(files, file.Route)
+	}
+	if strings.Join(files, ",") != "/api/index.de.txt,/api/index.json" {
+		t.Fatalf("files = %v", files)
+	}
+	if len(snapshot.Warnings) != 1 || !strings.Contains(snapshot.Warnings[0], "api/index.md is ignored") {
+		t.Fatalf("warnings = %v", snapshot.Warnings)
+	}
+}
+
+func TestRegisterIndexFormat(t *testing.T) {
+	if err := RegisterIndexFormat(IndexFormat{Name: "markdown", Extensions: []string{".md"}, ParseFrontMatter: YamlFrontMatter}); err == nil {
+		t.Fatalf("RegisterIndexFormat() of a registered extension succeeded")
+	}
+	if err := RegisterIndexFormat(IndexFormat{Name: "tar", Extensions: []string{".tar.gz"}, ParseFrontMatter: YamlFrontMatter}); err == nil {
+		t.Fatalf("RegisterIndexFormat() of a double extension succeeded")
+	}
+	if err := RegisterIndexFormat(IndexFormat{Name: "adoc", Extensions: []string{".ADOC"}, ParseFrontMatter: YamlFrontMatter}); err != nil {
+		t.Fatalf("RegisterIndexFormat() error = %v", err)
+	}
+	t.Cleanup(func() {
+		indexFormatsMu.Lock()
+		defer indexFormatsMu.Unlock()
+		delete(indexFormats, "adoc")
+		delete(indexFormatExtensions, ".adoc")
+	})
+
+	for name, want := range map[string]bool{"index.adoc": true, "index.de.adoc": true, "Index.ADOC": true, "page.adoc": false, "index.rst": false} {
+		if got := isSupportedIndexFile(name); got != want {
+			t.Fatalf("isSupportedIndexFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+	format, ok := IndexFormatOf("index.de.adoc")
+	if !ok || format.Name != "adoc" || format.PlainText(nil, " some\n text ") != "some text" {
+		t.Fatalf("IndexFormatOf() = %+v, %v", format, ok)
+	}
+}
+
+func TestBuildIndexSnapshotFileSidecars(t *testing.T) {
+	srcFS := fstest.MapFS{
+		"index.md":                &fstest.MapFile{Data: []byte("# home")},
+		"photo.jpg":               &fstest.MapFile{Data: []byte("jpg")},
+		"photo.jpg.yaml":          &fstest.MapFile{Data: []byte("caption: Sunset\ncredit: Jane\n")},
+		"report.pdf":              &fstest.MapFile{Data: []byte("%PDF")},
+		"config.yaml":             &fstest.MapFile{Data: []byte("not: a sidecar\n")},
+		"_files.yaml":             &fstest.MapFile{Data: []byte("photo.jpg:\n  caption: Old\n  sort: 2\nreport.pdf:\n  title: Annual report\n")},
+		"gallery/a.png":           &fstest.MapFile{Data: []byte("png")},
+		"gallery/a.png.yaml":      &fstest.MapFile{Data: []byte("alt: A\n")},
+		"gallery/orphan.png.yaml": &fstest.MapFile{Data: []byte("alt: no file\n")},
+	}
+
+	snapshot, err := BuildIndexSnapshot(srcFS, nil)
+	if err != nil {
+		t.Fatalf("BuildIndexSnapshot() error = %v", err)
+	}
+	filesByRoute := make(map[string]model.IndexedFile)
+	for _, file := range snapshot.Files {
+		filesByRoute[file.Route] = file
+	}
+
+	// sidecars are no files, a YAML file without file of its name is:
+	for _, route := range []string{"/photo.jpg.yaml", "/_files.yaml", "/gallery/a.png.yaml"} {
+		if _, found := filesByRoute[route]; found {
+			t.Fatalf("sidecar %s is indexed as file", route)
+		}
+	}
+	for _, route := range []string{"/config.yaml", "/gallery/orphan.png.yaml"} {
+		if _, found := filesByRoute[route]; !found {
+			t.Fatalf("%s is not indexed as file", route)
+		}
+	}
+
+	// the file's own sidecar overrides its _files.yaml entry property by property:
+	photo := filesByRoute["/photo.jpg"].Metadata
+	if photo["caption"] != "Sunset" || photo["credit"] != "Jane" || photo["sort"] != 2 {
+		t.Fatalf("/photo.jpg metadata = %v", photo)
+	}
+	if report := filesByRoute["/report.pdf"].Metadata; report["title"] != "Annual report" {
+		t.Fatalf("/report.pdf metadata = %v", report)
+	}
+	// a folder without page uses its own sidecars:
+	if a := filesByRoute["/gallery/a.png"].Metadata; a["alt"] != "A" {
+		t.Fatalf("/gallery/a.png metadata = %v", a)
+	}
+	if meta := filesByRoute["/config.yaml"].Metadata; meta != nil {
+		t.Fatalf("/config.yaml metadata = %v, want none", meta)
+	}
+
+	srcFS["broken.txt"] = &fstest.MapFile{Data: []byte("text")}
+	srcFS["broken.txt.yaml"] = &fstest.MapFile{Data: []byte("- not\n- a map\n")}
+	if _, err := BuildIndexSnapshot(srcFS, nil); err == nil {
+		t.Fatalf("BuildIndexSnapshot() with an invalid sidecar: want error")
+	}
+}
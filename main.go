@@ -9,6 +9,7 @@ import (
 	"alexi.ch/pcms/commands"
 	"alexi.ch/pcms/lib"
 	"alexi.ch/pcms/model"
+	"alexi.ch/pcms/processor"
 )
 
 // embed the site-template/ dir into the binary:
@@ -48,6 +49,8 @@ The following commands are supported:
 * serve-doc: Serves the embedded (binary-built-in) documentation
 * init: initializes a directory with a skeleton page
 * index: initializes/updates the local pcms db structure
+* build: exports the site as static files
+* db migrate: applies pending db schema migrations, or lists them with -status
 */
 func parseCmdArgs() model.CmdArgs {
 	args := model.CmdArgs{}
@@ -102,15 +105,28 @@ func parseCmdArgs() model.CmdArgs {
 
 	// index command:
 	indexCmd := flag.NewFlagSet("index", flag.ExitOnError)
+	indexCmd.Bool("full", false, "wipe the index and rebuild it from scratch instead of syncing only the changes")
 	prevIndexUsage := indexCmd.Usage
 	indexCmd.Usage = func() {
 		fmt.Fprintf(os.Stderr, "index:      initializes or updates the local pcms db schema\n")
 		prevIndexUsage()
-		fmt.Fprintln(os.Stderr, "index: creates or migrates the pcms.db to the current schema version (path configurable via database_path in pcms-config.yaml)")
+		fmt.Fprintln(os.Stderr, "index [-full]: creates or migrates the pcms.db to the current schema version and syncs the page index (path configurable via database_path in pcms-config.yaml)")
 		fmt.Fprintln(os.Stderr, "")
 	}
 	subCommands[indexCmd.Name()] = indexCmd
 
+	// build command:
+	buildCmd := flag.NewFlagSet("build", flag.ExitOnError)
+	buildCmd.String("out", "", "output dir of the static site export")
+	prevBuildUsage := buildCmd.Usage
+	buildCmd.Usage = func() {
+		fmt.Fprintf(os.Stderr, "build:      exports the site as static files\n")
+		prevBuildUsage()
+		fmt.Fprintln(os.Stderr, "build -out <dir>: syncs the index, then renders all enabled pages and copies all enabled files and resized images into the given dir")
+		fmt.Fprintln(os.Stderr, "")
+	}
+	subCommands[buildCmd.Name()] = buildCmd
+
 	// cache-clear command:
 	cacheClearCmd := flag.NewFlagSet("cache-clear", flag.ExitOnError)
 	prevCacheClearUsage := cacheClearCmd.Usage
@@ -144,6 +160,15 @@ func parseCmdArgs() model.CmdArgs {
 	}
 	subCommands[disablePageCmd.Name()] = disablePageCmd
 
+	// db command:
+	dbCmd := flag.NewFlagSet("db", flag.ExitOnError)
+	dbCmd.Usage = func() {
+		fmt.Fprintf(os.Stderr, "db:      manages the local pcms db\n")
+		fmt.Fprintln(os.Stderr, "db migrate [-status]: applies all pending schema migrations to the pcms.db, or only lists the applied and pending migrations with -status")
+		fmt.Fprintln(os.Stderr, "")
+	}
+	subCommands[dbCmd.Name()] = dbCmd
+
 	if *helpFlag || flag.CommandLine.NArg() < 1 {
 		printUsage(subCommands)
 		os.Exit(1)
@@ -170,6 +195,11 @@ func main() {
 
 	config := model.NewConfig(confFilePath, args, embeddedDocFS)
 	lib.SetDBPath(config.DatabasePath)
+	// the index and the renderers must know the index files of the external processors:
+	if err := processor.RegisterExternalProcessors(config); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 	var err error
 
 	switch args.FlagSet.Name() {
@@ -184,7 +214,10 @@ func main() {
 	case "init":
 		commands.RunInitCmd(args, &templateContent)
 	case "index":
-		err = commands.RunIndexCmd(config)
+		full := args.FlagSet.Lookup("full").Value.String() == "true"
+		err = commands.RunIndexCmd(config, full)
+	case "build":
+		err = commands.RunBuildCmd(config, args.FlagSet.Lookup("out").Value.String())
 	case "cache-clear":
 		err = commands.RunCacheClearCmd(config)
 	case "enable-page":
@@ -200,6 +233,15 @@ func main() {
 			os.Exit(1)
 		}
 		err = commands.RunDisablePageCmd(config, args.FlagSet.Arg(0))
+	case "db":
+		if args.FlagSet.Arg(0) != "migrate" {
+			fmt.Fprintln(os.Stderr, "db: missing or unknown sub-command, expected: db migrate [-status]")
+			os.Exit(1)
+		}
+		migrateCmd := flag.NewFlagSet("db migrate", flag.ExitOnError)
+		status := migrateCmd.Bool("status", false, "only list the applied and pending migrations, do not apply them")
+		migrateCmd.Parse(args.FlagSet.Args()[1:])
+		err = commands.RunDBMigrateCmd(config, *status)
 	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
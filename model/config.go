@@ -9,6 +9,9 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/flosch/pongo2/v6"
 	"gopkg.in/yaml.v3"
@@ -25,6 +28,56 @@ type LoggingConfigEntry struct {
 	Level  string `yaml:"level"`
 }
 
+// SearchConfig configures the JSON search endpoint (/_search).
+type SearchConfig struct {
+	// front matter fields returned with each search result
+	MetadataFields []string `yaml:"metadata_fields"`
+	// number of results per page
+	PageSize int `yaml:"page_size"`
+}
+
+// FeedConfig configures the Atom, RSS and JSON feeds of a page. It is set in the
+// "feeds" section of pcms-config.yaml, or in the "feed" front matter property of
+// the page.
+type FeedConfig struct {
+	// feed title and description, default to the page's title and description
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	// feed author, if the entries have no "author" front matter property
+	Author string `yaml:"author"`
+	// route pattern of the feed entries (see PageQuery().WhereRoute()). Defaults
+	// to the direct child pages of the feed page.
+	Source string `yaml:"source"`
+	// field to sort the entries by, and the sort direction ("desc" by default)
+	OrderBy string `yaml:"orderBy"`
+	Order   string `yaml:"order"`
+	// front matter field holding the publication date of an entry
+	DateField string `yaml:"dateField"`
+	// max. number of entries
+	Limit int `yaml:"limit"`
+}
+
+// RedirectConfig is a redirect rule in the "redirects" section of
+// pcms-config.yaml.
+type RedirectConfig struct {
+	// route to redirect. A trailing "/*" matches the route and all routes below it.
+	From string `yaml:"from"`
+	// target route or absolute URL. A "*" is replaced by the part of the
+	// requested route matched by the wildcard of From.
+	To string `yaml:"to"`
+	// HTTP status, 301 (default) or 302
+	Status int `yaml:"status"`
+}
+
+// languageCodePattern matches the lower case language codes of the languages
+// config and of language-suffixed index files: "de", "en", "de-ch", ...
+var languageCodePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// IsLanguageCode reports whether s is a valid lower case language code.
+func IsLanguageCode(s string) bool {
+	return languageCodePattern.MatchString(s)
+}
+
 const (
 	SERVE_MODE_FILES        = "FILES"
 	SERVE_MODE_EMBEDDED_DOC = "EMBEDDED_DOC"
@@ -32,12 +85,15 @@ const (
 
 type Config struct {
 	Server struct {
-		Listen      string        `yaml:"listen"`
-		Watch       bool          `yaml:"watch"`
-		Prefix      string        `yaml:"prefix"`
-		CacheDir    string        `yaml:"cache_dir"`
-		MaxBodySize int64         `yaml:"max_body_size"`
-		Logging     LoggingConfig `yaml:"logging"`
+		Listen        string        `yaml:"listen"`
+		BaseURL       string        `yaml:"base_url"`
+		Watch         bool          `yaml:"watch"`
+		Prefix        string        `yaml:"prefix"`
+		CacheDir      string        `yaml:"cache_dir"`
+		MaxBodySize   int64         `yaml:"max_body_size"`
+		IndexInterval time.Duration `yaml:"index_interval"`
+		Search        SearchConfig  `yaml:"search"`
+		Logging       LoggingConfig `yaml:"logging"`
 	} `yaml:"server"`
 	Variables       map[string]interface{} `yaml:"variables"`
 	ConfigFile      string
@@ -45,16 +101,73 @@ type Config struct {
 	TemplateDir     string   `yaml:"template_dir"`
 	DatabasePath    string   `yaml:"database_path"`
 	ExcludePatterns []string `yaml:"exclude_patterns"`
-	Processors      struct {
+	// feeds by page route
+	Feeds map[string]FeedConfig `yaml:"feeds"`
+	// redirect rules, checked in order before the page aliases
+	Redirects []RedirectConfig `yaml:"redirects"`
+	// language codes of a multilingual site, the first one is the default language
+	Languages []string `yaml:"languages"`
+	// image properties read at index time
+	Images struct {
+		// store the GPS position of photos from their EXIF data
+		ExifGPS bool `yaml:"exif_gps"`
+	} `yaml:"images"`
+	Processors struct {
 		Html struct{} `yaml:"html"`
 		Scss struct {
 			SassBin string `yaml:"sass_bin"`
 		} `yaml:"scss"`
+		Markdown MarkdownConfig            `yaml:"markdown"`
+		External []ExternalProcessorConfig `yaml:"external"`
+		// max. run time of an external program, see ProcessorTimeout
+		Timeout time.Duration `yaml:"timeout"`
 	} `yaml:"processors"`
 	EmbeddedDocFS embed.FS
 	ServeMode     string
 }
 
+// MarkdownConfig configures the rendering of Markdown pages, for the whole site
+// in processors.markdown, and per page in the "markdown" front matter property,
+// which overrides the site config.
+type MarkdownConfig struct {
+	// Extensions switches Markdown extensions and options on or off, by name
+	// (e.g. "typographer": true); unset ones keep their default.
+	Extensions map[string]bool `yaml:"extensions"`
+	// HighlightStyle is the chroma style of the code highlighting stylesheet,
+	// "github" by default.
+	HighlightStyle string `yaml:"highlight_style"`
+}
+
+// ExternalProcessorConfig configures a processor that renders the index files
+// with the given extension (e.g. ".adoc") by piping their body through an
+// external command, given as program and arguments.
+type ExternalProcessorConfig struct {
+	Extension string   `yaml:"extension"`
+	Command   []string `yaml:"command"`
+}
+
+// DefaultProcessorTimeout is the max. run time of an external program, if
+// processors.timeout is not set.
+const DefaultProcessorTimeout = 30 * time.Second
+
+// ProcessorTimeout returns the max. run time of the external programs that
+// render pages and stylesheets (the external processors and the sass binary).
+func (c Config) ProcessorTimeout() time.Duration {
+	if c.Processors.Timeout <= 0 {
+		return DefaultProcessorTimeout
+	}
+	return c.Processors.Timeout
+}
+
+// DefaultLanguage returns the first configured language, or "" for a site
+// without languages.
+func (c Config) DefaultLanguage() string {
+	if len(c.Languages) == 0 {
+		return ""
+	}
+	return c.Languages[0]
+}
+
 func NewConfig(conffilePath string, cliArgs CmdArgs, embeddedDocFS embed.FS) Config {
 	config := Config{}
 	config.ConfigFile = conffilePath
@@ -67,7 +180,7 @@ func NewConfig(conffilePath string, cliArgs CmdArgs, embeddedDocFS embed.FS) Con
 		serveMode = SERVE_MODE_FILES
 	case "serve-doc":
 		serveMode = SERVE_MODE_EMBEDDED_DOC
-	case "index":
+	case "index", "build":
 		serveMode = SERVE_MODE_FILES
 		// config.ServeMode = SERVE_MODE_EMBEDDED_DOC
 	case "init":
@@ -125,9 +238,29 @@ func NewConfig(conffilePath string, cliArgs CmdArgs, embeddedDocFS embed.FS) Con
 	if config.Server.MaxBodySize == 0 {
 		config.Server.MaxBodySize = 32 * 1024 * 1024 // 32 MB
 	}
+	if config.Server.Search.PageSize <= 0 {
+		config.Server.Search.PageSize = 10
+	}
 	if config.DatabasePath == "" {
 		config.DatabasePath = "pcms.db"
 	}
+	for i, redirect := range config.Redirects {
+		if redirect.Status == 0 {
+			config.Redirects[i].Status = http.StatusMovedPermanently
+		} else if redirect.Status != http.StatusMovedPermanently && redirect.Status != http.StatusFound {
+			log.Fatal(fmt.Errorf("redirect %s: invalid status %d, must be 301 or 302", redirect.From, redirect.Status))
+		}
+		if redirect.From == "" || redirect.To == "" {
+			log.Fatal(fmt.Errorf("redirect %d: from and to must be set", i+1))
+		}
+	}
+
+	for i, language := range config.Languages {
+		config.Languages[i] = strings.ToLower(language)
+		if !IsLanguageCode(config.Languages[i]) {
+			log.Fatal(fmt.Errorf("languages: invalid language code %q", language))
+		}
+	}
 
 	// Set current working dir to the conf file dir for subsequent commands,
 	// except when serving embedded docs.
@@ -151,7 +284,7 @@ func NewConfig(conffilePath string, cliArgs CmdArgs, embeddedDocFS embed.FS) Con
 		if err != nil {
 			log.Fatal(err)
 		}
-		if cliArgs.FlagSet.Name() == "serve" {
+		if cliArgs.FlagSet.Name() == "serve" || cliArgs.FlagSet.Name() == "build" {
 			// template dir is relative to the working dir, or an absolute path:
 			config.TemplateDir, err = filepath.Abs(config.TemplateDir)
 			if err != nil {
@@ -160,20 +293,24 @@ func NewConfig(conffilePath string, cliArgs CmdArgs, embeddedDocFS embed.FS) Con
 		}
 	}
 
-	if cliArgs.FlagSet.Name() == "serve" {
+	if cliArgs.FlagSet.Name() == "serve" || cliArgs.FlagSet.Name() == "build" {
 		config.Server.CacheDir, err = filepath.Abs(config.Server.CacheDir)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 
-	if cliArgs.FlagSet.Name() == "serve" || cliArgs.FlagSet.Name() == "serve-doc" {
+	if cliArgs.FlagSet.Name() == "serve" || cliArgs.FlagSet.Name() == "serve-doc" || cliArgs.FlagSet.Name() == "build" {
 		configPongoTemplatePathLoader(config)
 	}
 
 	return config
 }
 
+// TemplateLoaders are the template loaders of pongo2.DefaultSet, in lookup
+// order. Page renders use them for their own template sets.
+var TemplateLoaders = []pongo2.TemplateLoader{pongo2.DefaultLoader}
+
 func configPongoTemplatePathLoader(conf Config) {
 	if conf.ServeMode == SERVE_MODE_EMBEDDED_DOC {
 		templateRoot := path.Clean(path.Join(path.Dir(conf.ConfigFile), conf.TemplateDir))
@@ -182,6 +319,7 @@ func configPongoTemplatePathLoader(conf Config) {
 			log.Fatal(fmt.Errorf("configure embedded pongo2 loader (%s): %w", templateRoot, err))
 		}
 		pongo2.DefaultSet.AddLoader(loader)
+		TemplateLoaders = append(TemplateLoaders, loader)
 		return
 	}
 
@@ -190,6 +328,7 @@ func configPongoTemplatePathLoader(conf Config) {
 		log.Fatal(fmt.Errorf("configure local pongo2 loader (%s): %w", conf.TemplateDir, err))
 	}
 	pongo2.DefaultSet.AddLoader(loader)
+	TemplateLoaders = append(TemplateLoaders, loader)
 }
 
 func GetEmbeddedSourceFS(config Config) (fs.FS, string, error) {
@@ -10,6 +10,85 @@ type IndexedPage struct {
 	Enabled         bool
 	Metadata        map[string]any
 	UpdatedAt       time.Time
+
+	// scheduled publishing, from the publishDate / expiryDate front matter
+	// properties, limited to the window of the parent page (see
+	// InheritVisibility). Zero if not set.
+	PublishDate time.Time
+	ExpiryDate  time.Time
+
+	// old routes of the page, from the aliases front matter property, stored as
+	// redirects to the page. Only set while indexing, like PlainText.
+	Aliases []string
+
+	// language of the index file, from its suffix (index.de.md). Empty for an
+	// index file without language suffix.
+	Language string
+	// the other language variants of the page (index.en.md, ...). Only set
+	// while indexing, like PlainText.
+	Translations []PageTranslation
+
+	// source signature of the index file, used for incremental index syncs:
+	SourceModTime time.Time
+	SourceSize    int64
+	SourceHash    string
+
+	// readable text of the page body, stored in the full-text index. Only set
+	// while indexing, it is not read back from the DB.
+	PlainText string
+	// summary of the page for listings: the summary front matter property, the
+	// text up to the <!--more--> marker, or the first words of the page text
+	Summary string
+	// number of words of the page text, and its reading time in minutes
+	WordCount   int
+	ReadingTime int
+	// highlighted excerpt of the page body matching a full-text query
+	// (PageQueryBuilder.WhereFullText), HTML-escaped with <mark> tags around the
+	// matched terms. Empty for all other queries.
+	Snippet string
+}
+
+// IsVisibleAt reports whether the page is served at the given time: it must be
+// enabled, published (publishDate reached) and not expired (expiryDate not
+// reached yet).
+func (p IndexedPage) IsVisibleAt(t time.Time) bool {
+	if !p.Enabled {
+		return false
+	}
+	if !p.PublishDate.IsZero() && p.PublishDate.After(t) {
+		return false
+	}
+	return p.ExpiryDate.IsZero() || p.ExpiryDate.After(t)
+}
+
+// InheritVisibility restricts the page to the visibility of its parent page: it
+// is enabled only if the parent is, it is published not before the parent and
+// expires not after it. Applied at index time to the parent's effective values,
+// it makes Enabled, PublishDate and ExpiryDate cover the whole ancestor chain.
+func (p *IndexedPage) InheritVisibility(parent IndexedPage) {
+	p.Enabled = p.Enabled && parent.Enabled
+	if parent.PublishDate.After(p.PublishDate) {
+		p.PublishDate = parent.PublishDate
+	}
+	if !parent.ExpiryDate.IsZero() && (p.ExpiryDate.IsZero() || parent.ExpiryDate.Before(p.ExpiryDate)) {
+		p.ExpiryDate = parent.ExpiryDate
+	}
+}
+
+// PageTranslation is a language variant of a page: the content of a
+// language-suffixed index file next to the page's index file. The tree position,
+// the enabled flag and the schedule dates are those of the page.
+type PageTranslation struct {
+	Language  string
+	Title     string
+	IndexFile string
+	Metadata  map[string]any
+	// readable text of the variant, added to the page's full-text entry
+	PlainText string
+	// summary, word count and reading time of the variant, see IndexedPage
+	Summary     string
+	WordCount   int
+	ReadingTime int
 }
 
 type IndexedFile struct {
@@ -19,9 +98,46 @@ type IndexedFile struct {
 	MimeType        string
 	FileSize        int64
 	Enabled         bool
+	// properties of the file from its sidecar files: photo.jpg.yaml next to
+	// photo.jpg, and the file's entry in the folder's _files.yaml
+	Metadata map[string]any
+
+	// dimensions of an image file in pixels, as displayed: swapped for an
+	// image whose EXIF orientation rotates it by 90 degrees. Zero for other
+	// files.
+	Width  int
+	Height int
+	// EXIF orientation of the image (1-8, 1 is upright), 0 if not set
+	Orientation int
+	// from the EXIF data of a JPEG image, zero / empty if not set:
+	// time the photo was taken, in UTC if the image has no time zone offset
+	CaptureDate time.Time
+	// camera make and model, e.g. "Canon EOS R6"
+	Camera string
+	// GPS position in decimal degrees. Only read with the images.exif_gps
+	// config option.
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+
+	// source signature of the file, used for incremental index syncs:
+	SourceModTime time.Time
+	SourceHash    string
 }
 
 type IndexSnapshot struct {
 	Pages []IndexedPage
 	Files []IndexedFile
+	// Warnings about the source tree, e.g. index files that are ignored
+	Warnings []string
+}
+
+// IndexSyncStats reports what an incremental index sync changed in the DB.
+type IndexSyncStats struct {
+	PagesAdded   int
+	PagesChanged int
+	PagesRemoved int
+	FilesAdded   int
+	FilesChanged int
+	FilesRemoved int
 }
@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+	"github.com/flosch/pongo2/v6"
+)
+
+/*
+The DataProcessor processes data-only pages (index.json, index.yaml): the file
+holds no content, just data, and a template renders the page.
+
+The template is named by the `template` key of the data; the data is available
+to it as `Data` variable (as well as via the page.Metadata template object).
+
+Example (index.yaml):
+
+	title: Lunch menu
+	template: menu.html
+	dishes:
+	  - name: Soup
+	    price: 8.50
+	  - name: Salad
+	    price: 12
+*/
+type DataProcessor struct {
+}
+
+func (p DataProcessor) RenderFileForServe(siteFS fs.FS, sourceFSPath string, sourceFile string, config model.Config, pageInfo PageInfo, deps *lib.DependencyRecorder) ([]byte, error) {
+	format, ok := lib.IndexFormatOf(sourceFile)
+	if !ok {
+		return nil, fmt.Errorf("unsupported page index file type: %s", sourceFile)
+	}
+	sourceBytes, err := fs.ReadFile(siteFS, sourceFSPath)
+	if err != nil {
+		return nil, fmt.Errorf("read data source %s: %w", sourceFSPath, err)
+	}
+	data, _, err := format.ParseFrontMatter(string(sourceBytes))
+	if err != nil {
+		return nil, fmt.Errorf("parse data in %s: %w", sourceFSPath, err)
+	}
+	template, ok := data["template"].(string)
+	if !ok || template == "" {
+		return nil, fmt.Errorf("data page %s: no template defined, a data page needs a 'template' key", sourceFSPath)
+	}
+
+	context, err := prepareTemplateContext(config, pageInfo, deps)
+	if err != nil {
+		return nil, err
+	}
+	context.Update(pongo2.Context{"Data": data})
+
+	templateSet := newTemplateSet(deps)
+	tpl, err := templateSet.FromFile(template)
+	if err != nil {
+		return nil, err
+	}
+	out, err := templateSet.Execute(tpl, context)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
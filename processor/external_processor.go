@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+	"alexi.ch/pcms/stdlib"
+)
+
+/*
+The ExternalProcessor renders index files by an external program, configured in
+processors.external (see RegisterExternalProcessors), e.g. asciidoctor for
+index.adoc files.
+
+The body of the index file (without its YAML front matter) is piped to the
+program's stdin, and the program writes the page's HTML content to stdout.
+The program runs in the page's source folder, if it is on disk, and is killed
+if it runs longer than processors.timeout (30s by default). Like with
+markdown, the content is injected to the template defined as `template` key
+in the front matter, as `content` variable.
+
+Example config:
+
+	processors:
+	  external:
+	    - extension: .adoc
+	      command: ["asciidoctor", "--no-header-footer", "-o", "-", "-"]
+*/
+type ExternalProcessor struct {
+	// Command is the program to run, and its arguments
+	Command []string
+}
+
+func (p ExternalProcessor) RenderFileForServe(siteFS fs.FS, sourceFSPath string, sourceFile string, config model.Config, pageInfo PageInfo, deps *lib.DependencyRecorder) ([]byte, error) {
+	if len(p.Command) == 0 {
+		return nil, fmt.Errorf("external processor for %s: no command configured", sourceFile)
+	}
+	sourceBytes, err := fs.ReadFile(siteFS, sourceFSPath)
+	if err != nil {
+		return nil, fmt.Errorf("read source %s: %w", sourceFSPath, err)
+	}
+	metadata, body, err := stdlib.ExtractYamlFrontMatter(string(sourceBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	dir := ""
+	if info, err := os.Stat(pageInfo.AbsSourceDir); err == nil && info.IsDir() {
+		dir = pageInfo.AbsSourceDir
+	}
+	content, err := runExternalProgram(config.ProcessorTimeout(), dir, strings.NewReader(body), p.Command[0], p.Command[1:]...)
+	if err != nil {
+		return nil, fmt.Errorf("external processor for %s: %w", sourceFSPath, err)
+	}
+
+	context, err := prepareTemplateContext(config, pageInfo, deps)
+	if err != nil {
+		return nil, err
+	}
+	return renderContentTemplate(newTemplateSet(deps), context, metadata, string(content))
+}
+
+// runExternalProgram runs a program in dir (the working dir if empty) with the
+// given stdin, and returns its stdout. The program is killed if it runs longer
+// than timeout, so that a hanging program cannot block a request forever.
+func runExternalProgram(timeout time.Duration, dir string, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stdin = stdin
+	// don't wait for child processes that keep the output open after the kill:
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s did not finish within %s (processors.timeout)", name, timeout)
+		}
+		return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
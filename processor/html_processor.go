@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"io/fs"
 
+	"alexi.ch/pcms/lib"
 	"alexi.ch/pcms/model"
 	"alexi.ch/pcms/stdlib"
-	"github.com/flosch/pongo2/v6"
 )
 
 /*
@@ -26,16 +26,16 @@ It also supports a YAML front matter:
 type HtmlProcessor struct {
 }
 
-func (p HtmlProcessor) RenderFileForServe(siteFS fs.FS, sourceFSPath string, sourceFile string, config model.Config, pageInfo PageInfo) ([]byte, error) {
+func (p HtmlProcessor) RenderFileForServe(siteFS fs.FS, sourceFSPath string, sourceFile string, config model.Config, pageInfo PageInfo, deps *lib.DependencyRecorder) ([]byte, error) {
 	sourceBytes, err := fs.ReadFile(siteFS, sourceFSPath)
 	if err != nil {
 		return nil, fmt.Errorf("read html source %s: %w", sourceFSPath, err)
 	}
 
-	return p.render(sourceFile, string(sourceBytes), config, pageInfo)
+	return p.render(sourceFile, string(sourceBytes), config, pageInfo, deps)
 }
 
-func (p HtmlProcessor) render(sourceFile string, sourceString string, config model.Config, pageInfo PageInfo) ([]byte, error) {
+func (p HtmlProcessor) render(sourceFile string, sourceString string, config model.Config, pageInfo PageInfo, deps *lib.DependencyRecorder) ([]byte, error) {
 	// Extract yaml frontmatter (strip it from source):
 	_, sourceString, err := stdlib.ExtractYamlFrontMatter(sourceString)
 	if err != nil {
@@ -43,17 +43,18 @@ func (p HtmlProcessor) render(sourceFile string, sourceString string, config mod
 	}
 
 	// create template from input file
-	tpl, err := pongo2.FromString(sourceString)
+	templateSet := newTemplateSet(deps)
+	tpl, err := templateSet.FromString(sourceString)
 	if err != nil {
 		return nil, err
 	}
 
-	context, err := prepareTemplateContext(config, pageInfo)
+	context, err := prepareTemplateContext(config, pageInfo, deps)
 	if err != nil {
 		return nil, err
 	}
 
-	out, err := tpl.Execute(context)
+	out, err := templateSet.Execute(tpl, context)
 	if err != nil {
 		return nil, err
 	}
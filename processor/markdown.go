@@ -0,0 +1,298 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	gohtml "html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"gopkg.in/yaml.v3"
+)
+
+// markdownExtensionDefaults are the Markdown extensions and options that can be
+// switched on or off in a MarkdownConfig, with their default state.
+var markdownExtensionDefaults = map[string]bool{
+	// GitHub flavored markdown:
+	"table":         true,
+	"strikethrough": true,
+	"linkify":       true,
+	"tasklist":      true,
+	// PHP Markdown Extra:
+	"footnote":        true,
+	"definition_list": true,
+	// smart quotes, dashes and ellipses:
+	"typographer": false,
+	// line breaks between CJK characters are no spaces:
+	"cjk": false,
+	// heading ids generated from the heading text, and set by {#id .class} attributes:
+	"heading_ids": true,
+	"attributes":  true,
+	// "#" links to the headings, after their text:
+	"heading_anchors": false,
+	// newlines in paragraphs are line breaks:
+	"hard_wraps": false,
+	// syntax highlighting of fenced code blocks, with CSS classes:
+	"highlight":    true,
+	"line_numbers": false,
+}
+
+// defaultHighlightStyle is the chroma style of HighlightCSS, if none is configured.
+const defaultHighlightStyle = "github"
+
+// markdownOptions returns the Markdown config of a page: the defaults,
+// overridden by the site's processors.markdown config, overridden by the page's
+// "markdown" front matter property.
+func markdownOptions(config model.Config, metadata map[string]any) (model.MarkdownConfig, error) {
+	opts := model.MarkdownConfig{Extensions: map[string]bool{}, HighlightStyle: defaultHighlightStyle}
+	for name, enabled := range markdownExtensionDefaults {
+		opts.Extensions[name] = enabled
+	}
+	if err := mergeMarkdownConfig(&opts, config.Processors.Markdown); err != nil {
+		return opts, fmt.Errorf("processors.markdown: %w", err)
+	}
+
+	raw, ok := metadata["markdown"]
+	if !ok {
+		return opts, nil
+	}
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return opts, fmt.Errorf("markdown front matter: %w", err)
+	}
+	var pageConfig model.MarkdownConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&pageConfig); err != nil {
+		return opts, fmt.Errorf("markdown front matter: %w", err)
+	}
+	if err := mergeMarkdownConfig(&opts, pageConfig); err != nil {
+		return opts, fmt.Errorf("markdown front matter: %w", err)
+	}
+	return opts, nil
+}
+
+func mergeMarkdownConfig(opts *model.MarkdownConfig, override model.MarkdownConfig) error {
+	for name, enabled := range override.Extensions {
+		if _, known := markdownExtensionDefaults[name]; !known {
+			return fmt.Errorf("unknown extension %q, supported are: %s", name, strings.Join(markdownExtensionNames(), ", "))
+		}
+		opts.Extensions[name] = enabled
+	}
+	if override.HighlightStyle != "" {
+		if _, known := styles.Registry[strings.ToLower(override.HighlightStyle)]; !known {
+			return fmt.Errorf("unknown highlight_style %q", override.HighlightStyle)
+		}
+		opts.HighlightStyle = override.HighlightStyle
+	}
+	return nil
+}
+
+func markdownExtensionNames() []string {
+	names := make([]string, 0, len(markdownExtensionDefaults))
+	for name := range markdownExtensionDefaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newMarkdown creates the goldmark Markdown renderer of the given config. Raw
+// HTML in the Markdown is rendered as it is.
+func newMarkdown(opts model.MarkdownConfig) goldmark.Markdown {
+	extensions := map[string]goldmark.Extender{
+		"table":           extension.Table,
+		"strikethrough":   extension.Strikethrough,
+		"linkify":         extension.Linkify,
+		"tasklist":        extension.TaskList,
+		"footnote":        extension.Footnote,
+		"definition_list": extension.DefinitionList,
+		"typographer":     extension.Typographer,
+		"cjk":             extension.CJK,
+	}
+	var extenders []goldmark.Extender
+	for _, name := range markdownExtensionNames() {
+		if extender, ok := extensions[name]; ok && opts.Extensions[name] {
+			extenders = append(extenders, extender)
+		}
+	}
+	if opts.Extensions["highlight"] {
+		extenders = append(extenders, highlighting.NewHighlighting(
+			highlighting.WithStyle(opts.HighlightStyle),
+			highlighting.WithFormatOptions(
+				chromahtml.WithClasses(true),
+				chromahtml.WithLineNumbers(opts.Extensions["line_numbers"]),
+			),
+		))
+	}
+
+	var parserOptions []parser.Option
+	if opts.Extensions["heading_ids"] {
+		parserOptions = append(parserOptions, parser.WithAutoHeadingID())
+	}
+	if opts.Extensions["attributes"] {
+		parserOptions = append(parserOptions, parser.WithAttribute())
+	}
+	rendererOptions := []goldmark.Option{}
+	if opts.Extensions["hard_wraps"] {
+		rendererOptions = append(rendererOptions, goldmark.WithRendererOptions(html.WithHardWraps()))
+	}
+
+	return goldmark.New(append(rendererOptions,
+		goldmark.WithExtensions(extenders...),
+		goldmark.WithParserOptions(parserOptions...),
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)...)
+}
+
+// TocEntry is a heading of a Markdown page in its table of contents, with the
+// headings of the next levels below it as children.
+type TocEntry struct {
+	// Level of the heading, 1 to 6
+	Level int
+	// ID of the heading element, empty if heading_ids is off
+	ID string
+	// Title is the text of the heading
+	Title    string
+	Children []*TocEntry
+}
+
+// renderMarkdown converts Markdown to HTML, with the given config, and returns
+// the table of contents of its headings.
+func renderMarkdown(source string, opts model.MarkdownConfig) (string, []*TocEntry, error) {
+	md := newMarkdown(opts)
+	src := []byte(source)
+	doc := md.Parser().Parse(text.NewReader(src))
+
+	var headings []*ast.Heading
+	err := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if heading, ok := n.(*ast.Heading); ok && entering {
+			headings = append(headings, heading)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("render markdown: %w", err)
+	}
+
+	var toc []*TocEntry
+	// open holds the last entry of each level above the current heading:
+	var open []*TocEntry
+	for _, heading := range headings {
+		entry := &TocEntry{Level: heading.Level, ID: headingID(heading), Title: gohtml.UnescapeString(inlineText(heading, src))}
+		for len(open) > 0 && open[len(open)-1].Level >= entry.Level {
+			open = open[:len(open)-1]
+		}
+		if len(open) == 0 {
+			toc = append(toc, entry)
+		} else {
+			parent := open[len(open)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		open = append(open, entry)
+
+		if opts.Extensions["heading_anchors"] && entry.ID != "" {
+			anchor := ast.NewLink()
+			anchor.Destination = []byte("#" + entry.ID)
+			anchor.SetAttributeString("class", []byte("heading-anchor"))
+			anchor.AppendChild(anchor, ast.NewString([]byte("#")))
+			heading.AppendChild(heading, ast.NewString([]byte(" ")))
+			heading.AppendChild(heading, anchor)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := md.Renderer().Render(&out, src, doc); err != nil {
+		return "", nil, fmt.Errorf("render markdown: %w", err)
+	}
+	return out.String(), toc, nil
+}
+
+func headingID(heading *ast.Heading) string {
+	id, ok := heading.AttributeString("id")
+	if !ok {
+		return ""
+	}
+	switch v := id.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+// inlineText returns the text of the inline content of a node, without markup.
+// Entities are kept, as in the source.
+func inlineText(n ast.Node, source []byte) string {
+	var b strings.Builder
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		switch v := child.(type) {
+		case *ast.Text:
+			b.Write(v.Segment.Value(source))
+			if v.SoftLineBreak() || v.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(v.Value)
+		case *ast.RawHTML:
+			// inline HTML tags are no text
+		default:
+			b.WriteString(inlineText(child, source))
+		}
+	}
+	return b.String()
+}
+
+// headingAnchorPattern matches the heading anchors of the heading_anchors option.
+var headingAnchorPattern = regexp.MustCompile(`<a href="[^"]*" class="heading-anchor">#</a>`)
+
+// markdownPlainText returns the readable text of rendered Markdown, without
+// heading anchors.
+func markdownPlainText(html string) string {
+	return lib.HTMLPlainText(headingAnchorPattern.ReplaceAllString(html, ""))
+}
+
+// highlightCSS returns the stylesheet of the CSS classes of highlighted code
+// blocks, in the given chroma style.
+func highlightCSS(style string) string {
+	var out bytes.Buffer
+	if err := chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(&out, styles.Get(style)); err != nil {
+		return ""
+	}
+	return out.String()
+}
+
+// highlightCSSFunc returns the HighlightCSS template function: it returns the
+// highlighting stylesheet in the given style, or, without argument, in the
+// default style.
+func highlightCSSFunc(defaultStyle string) func(style ...string) string {
+	return func(style ...string) string {
+		if len(style) > 0 && style[0] != "" {
+			return highlightCSS(style[0])
+		}
+		return highlightCSS(defaultStyle)
+	}
+}
+
+// siteHighlightStyle returns the highlight style of the processors.markdown
+// config, or the default style.
+func siteHighlightStyle(config model.Config) string {
+	if style := config.Processors.Markdown.HighlightStyle; style != "" {
+		return style
+	}
+	return defaultHighlightStyle
+}
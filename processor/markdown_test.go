@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"alexi.ch/pcms/model"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	source := "# Hello World {#hello .intro}\n\n## Second \"one\"\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\nterm\n: definition\n\n```go\nfunc main() {}\n```\n\n<div class=\"raw\">raw html</div>\n"
+
+	config := model.Config{}
+	opts, err := markdownOptions(config, nil)
+	if err != nil {
+		t.Fatalf("markdownOptions() error = %v", err)
+	}
+	out, _, err := renderMarkdown(source, opts)
+	if err != nil {
+		t.Fatalf("renderMarkdown() error = %v", err)
+	}
+	for _, want := range []string{
+		`<h1 id="hello" class="intro">Hello World</h1>`,
+		`<h2 id="second-one">Second &quot;one&quot;</h2>`,
+		`<table>`,
+		`<dd>definition</dd>`,
+		`<pre class="chroma"><code><span class="line"><span class="cl"><span class="kd">func</span>`,
+		`<div class="raw">raw html</div>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("renderMarkdown() = %s\nwant it to contain %s", out, want)
+		}
+	}
+
+	// the page's front matter overrides the site config:
+	config.Processors.Markdown.Extensions = map[string]bool{"typographer": true, "highlight": false}
+	opts, err = markdownOptions(config, map[string]any{"markdown": map[string]any{"extensions": map[string]any{"highlight": true}}})
+	if err != nil {
+		t.Fatalf("markdownOptions() error = %v", err)
+	}
+	if !opts.Extensions["typographer"] || !opts.Extensions["highlight"] || opts.Extensions["line_numbers"] {
+		t.Fatalf("markdownOptions() extensions = %v", opts.Extensions)
+	}
+	out, _, err = renderMarkdown(source, opts)
+	if err != nil {
+		t.Fatalf("renderMarkdown() error = %v", err)
+	}
+	if !strings.Contains(out, "Second &ldquo;one&rdquo;") || !strings.Contains(out, `class="chroma"`) {
+		t.Fatalf("renderMarkdown() with typographer = %s", out)
+	}
+
+	for _, metadata := range []map[string]any{
+		{"markdown": map[string]any{"extensions": map[string]any{"smartypants": true}}},
+		{"markdown": map[string]any{"highlight_style": "no-such-style"}},
+		{"markdown": map[string]any{"extension": map[string]any{"typographer": true}}},
+	} {
+		if _, err := markdownOptions(model.Config{}, metadata); err == nil {
+			t.Fatalf("markdownOptions(%v) succeeded", metadata)
+		}
+	}
+}
+
+func TestRenderMarkdownToc(t *testing.T) {
+	source := "Intro\n\n## Install *pcms*\n\n### From `source`\n\n#### Build\n\n### Docker\n\n# Usage &amp; more\n\n### Skipped level\n"
+	opts, err := markdownOptions(model.Config{}, map[string]any{"markdown": map[string]any{"extensions": map[string]any{"heading_anchors": true}}})
+	if err != nil {
+		t.Fatalf("markdownOptions() error = %v", err)
+	}
+	out, toc, err := renderMarkdown(source, opts)
+	if err != nil {
+		t.Fatalf("renderMarkdown() error = %v", err)
+	}
+
+	var flatten func(entries []*TocEntry, depth int) []string
+	flatten = func(entries []*TocEntry, depth int) []string {
+		var lines []string
+		for _, e := range entries {
+			lines = append(lines, fmt.Sprintf("%s%d %s #%s", strings.Repeat("  ", depth), e.Level, e.Title, e.ID))
+			lines = append(lines, flatten(e.Children, depth+1)...)
+		}
+		return lines
+	}
+	want := []string{
+		"2 Install pcms #install-pcms",
+		"  3 From source #from-source",
+		"    4 Build #build",
+		"  3 Docker #docker",
+		"1 Usage & more #usage-amp-more",
+		"  3 Skipped level #skipped-level",
+	}
+	if got := flatten(toc, 0); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("renderMarkdown() toc =\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if !strings.Contains(out, `<h4 id="build">Build <a href="#build" class="heading-anchor">#</a></h4>`) {
+		t.Fatalf("renderMarkdown() = %s, want heading anchors", out)
+	}
+	if text := markdownPlainText(out); strings.Contains(text, "#") {
+		t.Fatalf("markdownPlainText() = %q, want no heading anchors", text)
+	}
+}
+
+func TestHighlightCSS(t *testing.T) {
+	css := highlightCSSFunc(defaultHighlightStyle)()
+	if !strings.Contains(css, ".chroma .kd {") {
+		t.Fatalf("HighlightCSS() = %s", css)
+	}
+	if highlightCSSFunc(defaultHighlightStyle)("monokai") == css {
+		t.Fatalf("HighlightCSS(monokai) is the default style")
+	}
+}
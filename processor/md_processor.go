@@ -4,20 +4,26 @@ import (
 	"fmt"
 	"io/fs"
 
+	"alexi.ch/pcms/lib"
 	"alexi.ch/pcms/model"
 	"alexi.ch/pcms/stdlib"
 	"github.com/flosch/pongo2/v6"
-	"github.com/russross/blackfriday/v2"
 )
 
 /*
 The MdProcessor processes Markdown (.md) files to HTML.
 
  1. The input md is processed as pongo2 template,
-    including yaml frontmatter support (see example below)
- 2. the resulting processed Markdown is converted to HTML
- 3. then it is injected to a template as the `content` variable. The template
-    needs to be defined in the YAML frontmatter, as `template` key.
+    including yaml frontmatter support (see example below). Shortcodes
+    ({{< figure src="a.jpg" >}}) are cut out before, and rendered by their
+    templates afterwards (see Shortcode)
+ 2. the resulting processed Markdown is converted to HTML, with the Markdown
+    extensions configured in processors.markdown, or in the page's `markdown`
+    front matter property (see markdownOptions)
+ 3. then it is injected to a template as the `content` variable, with the
+    table of contents as `toc`, and `wordCount`, `readingTime` (in minutes) and
+    `summary` of the content. The template needs to be defined in the YAML
+    frontmatter, as `template` key.
 
 Example:
 
@@ -35,68 +41,55 @@ Example:
 type MdProcessor struct {
 }
 
-func (p MdProcessor) RenderFileForServe(siteFS fs.FS, sourceFSPath string, sourceFile string, config model.Config, filePaths PageInfo) ([]byte, error) {
+func (p MdProcessor) RenderFileForServe(siteFS fs.FS, sourceFSPath string, sourceFile string, config model.Config, filePaths PageInfo, deps *lib.DependencyRecorder) ([]byte, error) {
 	sourceBytes, err := fs.ReadFile(siteFS, sourceFSPath)
 	if err != nil {
 		return nil, fmt.Errorf("read markdown source %s: %w", sourceFSPath, err)
 	}
 
-	return p.render(sourceFile, string(sourceBytes), config, filePaths)
+	return p.render(sourceFile, string(sourceBytes), config, filePaths, deps)
 }
 
-func (p MdProcessor) render(sourceFile string, sourceString string, config model.Config, filePaths PageInfo) ([]byte, error) {
+func (p MdProcessor) render(sourceFile string, sourceString string, config model.Config, filePaths PageInfo, deps *lib.DependencyRecorder) ([]byte, error) {
 	// Extract yaml frontmatter:
 	yamlFrontMatter, sourceString, err := stdlib.ExtractYamlFrontMatter(sourceString)
 	if err != nil {
 		return nil, err
 	}
 
-	context, err := prepareTemplateContext(config, filePaths)
+	context, err := prepareTemplateContext(config, filePaths, deps)
 	if err != nil {
 		return nil, err
 	}
 
-	// now, we need to process the Markdown source as template:
-	mdTemplate, err := pongo2.FromString(sourceString)
+	// Markdown config of the page:
+	opts, err := markdownOptions(config, yamlFrontMatter)
 	if err != nil {
 		return nil, err
 	}
-
-	sourceString, err = mdTemplate.Execute(context)
+	// now, process the Markdown source as template, convert it to html and
+	// render its shortcodes:
+	templateSet := newTemplateSet(deps)
+	htmlString, toc, err := renderMarkdownContent(sourceString, context, templateSet, opts)
 	if err != nil {
 		return nil, err
 	}
-	// now, convert filled markdown to html:
-	result := blackfriday.Run([]byte(sourceString), blackfriday.WithExtensions(
-		blackfriday.AutoHeadingIDs|blackfriday.Autolink|blackfriday.CommonExtensions|blackfriday.Footnotes,
-	))
-	htmlString := string(result[:])
-	context.Update(pongo2.Context{"content": htmlString})
+	plainText := markdownPlainText(htmlString)
+	wordCount := lib.CountWords(plainText)
+	context.Update(pongo2.Context{
+		"HighlightCSS": highlightCSSFunc(opts.HighlightStyle),
+		// the headings, as tree of TocEntry:
+		"toc":         toc,
+		"wordCount":   wordCount,
+		"readingTime": lib.ReadingTime(wordCount),
+		"summary":     lib.Summary(yamlFrontMatter, htmlString, markdownPlainText),
+	})
 
 	// Wrap processed markdown in an HTML template:
 	// For markdown files, we need a 'template' file to embed the md content.
 	// The template file must be defined as 'template' front matter variable.
 	// If not set, we just use a very simple content.
 	// The markdown content is injected as 'content' variable.
-	var tpl *pongo2.Template
-	template, ok := yamlFrontMatter["template"]
-	if ok {
-		tpl, err = pongo2.FromFile(template.(string))
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		tpl, err = pongo2.FromString("{{ content | safe }}")
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	out, err := tpl.Execute(context)
-	if err != nil {
-		return nil, err
-	}
-
-	return []byte(out), nil
+	return renderContentTemplate(templateSet, context, yamlFrontMatter, htmlString)
 }
 
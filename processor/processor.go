@@ -14,11 +14,14 @@ import (
 
 // Processor is the interface for processors that can render a page's index file.
 type Processor interface {
-	RenderFileForServe(siteFS fs.FS, sourceFSPath string, sourceFile string, config model.Config, filePaths PageInfo) ([]byte, error)
+	// deps records the templates and index queries the page depends on; it may be nil.
+	RenderFileForServe(siteFS fs.FS, sourceFSPath string, sourceFile string, config model.Config, filePaths PageInfo, deps *lib.DependencyRecorder) ([]byte, error)
 }
 type PageInfo struct {
 	// the actual page record from the index
 	ActPage model.IndexedPage `yaml:"-"`
+	// the language the page is rendered in, "" on a site without languages
+	Language string `yaml:"language"`
 
 	// file paths:
 	// start / top path of the source folder
@@ -105,27 +108,120 @@ func BuildPageTemplateVariables(route string, indexFile string, config model.Con
 	return result, nil
 }
 
-// GetProcessor returns the appropriate PageRenderer for the given index file,
-// based on its file extension.
-func GetProcessor(indexFile string) (Processor, error) {
-	switch strings.ToLower(path.Ext(indexFile)) {
-	case ".html":
-		return HtmlProcessor{}, nil
-	case ".md":
-		return MdProcessor{}, nil
-	default:
-		return nil, fmt.Errorf("unsupported page index file type: %s", indexFile)
+// renderContentTemplate renders the HTML content of a page, made from its index
+// file, into the template named by the page's 'template' front matter variable,
+// as 'content' variable. Without template, the content is rendered as it is.
+func renderContentTemplate(templateSet *templateSet, context pongo2.Context, metadata map[string]any, content string) ([]byte, error) {
+	context.Update(pongo2.Context{"content": content})
+	var (
+		tpl *pongo2.Template
+		err error
+	)
+	if template, ok := metadata["template"]; ok {
+		name, isString := template.(string)
+		if !isString {
+			return nil, fmt.Errorf("template must be a string, got %v", template)
+		}
+		tpl, err = templateSet.FromFile(name)
+	} else {
+		tpl, err = templateSet.FromString("{{ content | safe }}")
+	}
+	if err != nil {
+		return nil, err
 	}
+	out, err := templateSet.Execute(tpl, context)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
 }
 
 func AbsUrl(relPath string, Webroot string) string {
 	return path.Clean(path.Join("/", Webroot, relPath))
 }
 
+// languageUrlFunc returns the LanguageUrl template function: it creates the
+// absolute, webroot-based url of a route in a language, e.g. "/de/about" for
+// LanguageUrl("/about", "de"). Without language argument, the current language
+// is used; "" stands for the default language. On a site without languages, it
+// works like Webroot.
+func languageUrlFunc(config model.Config, webroot string, current string) func(route string, language ...string) string {
+	return func(route string, language ...string) string {
+		lang := current
+		if len(language) > 0 {
+			lang = language[0]
+		}
+		if lang == "" {
+			lang = config.DefaultLanguage()
+		}
+		if lang == "" {
+			return AbsUrl(route, webroot)
+		}
+		return AbsUrl(path.Join("/", lang, route), webroot)
+	}
+}
+
+// imageResizerParams are the image resizer params, in the order they are put in
+// resizer URLs.
+var imageResizerParams = []string{"width", "height", "maxWidth", "maxHeight", "fit", "fillColor", "format", "jpgQuality", "webpQuality"}
+
+// imageResizerUrlFunc returns the ImageResizerUrl template function: it creates
+// the image resizer URL of an image, e.g. "/_imageResizer/width:400/images/a.jpg"
+// for ImageResizerUrl("/images/a.jpg", "width:400"). The params are a resizer
+// params string, or a map, of which the image resizer params are used (e.g. the
+// params of a shortcode). A relative image path is relative to the page route.
+// Remote images, and images without params, are returned as they are.
+func imageResizerUrlFunc(webroot string, pageRoute string) func(src string, params any) string {
+	return func(src string, params any) string {
+		if src == "" || strings.Contains(src, "://") || strings.HasPrefix(src, "//") || strings.HasPrefix(src, "data:") {
+			return src
+		}
+		var paramStr string
+		switch v := params.(type) {
+		case string:
+			paramStr = v
+		case map[string]string:
+			paramStr = imageResizerParamString(func(key string) string { return v[key] })
+		case map[string]any:
+			paramStr = imageResizerParamString(func(key string) string {
+				if value, ok := v[key]; ok && value != nil {
+					return fmt.Sprint(value)
+				}
+				return ""
+			})
+		}
+		if paramStr == "" {
+			return src
+		}
+		route := src
+		if !strings.HasPrefix(src, "/") {
+			route = path.Join("/", pageRoute, src)
+		}
+		return AbsUrl(path.Join("/_imageResizer", paramStr, route), webroot)
+	}
+}
+
+func imageResizerParamString(param func(key string) string) string {
+	var parts []string
+	for _, key := range imageResizerParams {
+		if value := param(key); value != "" {
+			parts = append(parts, key+":"+value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
 // BuildGlobalTemplateContext builds the template context entries that are not
-// specific to a single page: Config, Webroot, helper functions, and PageQuery.
+// specific to a single page: Config, Webroot, helper functions, PageQuery and
+// FileQuery.
 // It is suitable for use in both normal page rendering and error pages.
 func BuildGlobalTemplateContext(config model.Config) (pongo2.Context, error) {
+	return buildGlobalTemplateContext(config, nil)
+}
+
+// buildGlobalTemplateContext builds the global template context, recording the
+// index queries of the PageQuery(), Search() and FileQuery() builders in deps.
+func buildGlobalTemplateContext(config model.Config, deps *lib.DependencyRecorder) (pongo2.Context, error) {
 	dbh, err := lib.GetDBH()
 	if err != nil {
 		return nil, err
@@ -143,8 +239,26 @@ func BuildGlobalTemplateContext(config model.Config) (pongo2.Context, error) {
 		"EndsWith": strings.HasSuffix,
 		// PageQuery returns a new PageQueryBuilder for querying indexed pages.
 		"PageQuery": func() *lib.PageQueryBuilder {
-			return lib.NewPageQueryBuilder(dbh)
+			return deps.PageQuery(dbh)
+		},
+		// Search returns a PageQueryBuilder with a full-text filter for the given
+		// query, ordered by relevance (see PageQueryBuilder.WhereFullText).
+		"Search": func(query string) *lib.PageQueryBuilder {
+			return deps.PageQuery(dbh).WhereFullText(query)
 		},
+		// FileQuery returns a new FileQueryBuilder for querying indexed files.
+		"FileQuery": func() *lib.FileQueryBuilder {
+			return deps.FileQuery(dbh)
+		},
+		// the languages of a multilingual site, the first one is the default language
+		"Language":    config.DefaultLanguage(),
+		"Languages":   config.Languages,
+		"LanguageUrl": languageUrlFunc(config, webroot, ""),
+		// HighlightCSS returns the stylesheet of highlighted code blocks in Markdown,
+		// in the configured highlight_style, or in the given chroma style.
+		"HighlightCSS": highlightCSSFunc(siteHighlightStyle(config)),
+		// ImageResizerUrl creates the image resizer URL of an image.
+		"ImageResizerUrl": imageResizerUrlFunc(webroot, "/"),
 		// List creates a string slice from its arguments.
 		"List": func(items ...string) []string {
 			return items
@@ -152,21 +266,21 @@ func BuildGlobalTemplateContext(config model.Config) (pongo2.Context, error) {
 	}, nil
 }
 
-func prepareTemplateContext(config model.Config, fileInfo PageInfo) (pongo2.Context, error) {
+func prepareTemplateContext(config model.Config, fileInfo PageInfo, deps *lib.DependencyRecorder) (pongo2.Context, error) {
 	dbh, err := lib.GetDBH()
 	if err != nil {
 		return nil, err
 	}
-	childPages, err := dbh.GetChildPages(fileInfo.ActPage.Route)
+	childPages, err := deps.ChildPages(dbh, fileInfo.ActPage.Route, fileInfo.Language)
 	if err != nil {
 		return nil, err
 	}
-	childFiles, err := dbh.GetChildFiles(fileInfo.ActPage.Route)
+	childFiles, err := deps.ChildFiles(dbh, fileInfo.ActPage.Route)
 	if err != nil {
 		return nil, err
 	}
 
-	globalCtx, err := BuildGlobalTemplateContext(config)
+	globalCtx, err := buildGlobalTemplateContext(config, deps)
 	if err != nil {
 		return nil, err
 	}
@@ -175,6 +289,18 @@ func prepareTemplateContext(config model.Config, fileInfo PageInfo) (pongo2.Cont
 	globalCtx["Webroot"] = func(relPath string) string {
 		return AbsUrl(relPath, fileInfo.Webroot)
 	}
+	globalCtx["LanguageUrl"] = languageUrlFunc(config, fileInfo.Webroot, fileInfo.Language)
+	globalCtx["ImageResizerUrl"] = imageResizerUrlFunc(fileInfo.Webroot, fileInfo.ActPage.Route)
+	// queries return the pages in the language of the page:
+	if fileInfo.Language != "" {
+		globalCtx["Language"] = fileInfo.Language
+		globalCtx["PageQuery"] = func() *lib.PageQueryBuilder {
+			return deps.PageQuery(dbh).Language(fileInfo.Language)
+		}
+		globalCtx["Search"] = func(query string) *lib.PageQueryBuilder {
+			return deps.PageQuery(dbh).Language(fileInfo.Language).WhereFullText(query)
+		}
+	}
 
 	globalCtx.Update(pongo2.Context{
 		"Page": fileInfo.ActPage,
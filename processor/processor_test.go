@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"alexi.ch/pcms/model"
+)
+
+func TestGetProcessor(t *testing.T) {
+	tests := map[string]Processor{
+		"index.html":    HtmlProcessor{},
+		"index.de.md":   MdProcessor{},
+		"index.txt":     TextProcessor{},
+		"index.json":    DataProcessor{},
+		"index.fr.yaml": DataProcessor{},
+		"index.yml":     DataProcessor{},
+	}
+	for indexFile, want := range tests {
+		got, err := GetProcessor(indexFile)
+		if err != nil {
+			t.Fatalf("GetProcessor(%q) error = %v", indexFile, err)
+		}
+		if got != want {
+			t.Fatalf("GetProcessor(%q) = %T, want %T", indexFile, got, want)
+		}
+	}
+	if _, err := GetProcessor("index.rtf"); err == nil {
+		t.Fatalf("GetProcessor(index.rtf) succeeded")
+	}
+}
+
+func TestRegisterExternalProcessors(t *testing.T) {
+	config := model.Config{}
+	config.Processors.External = []model.ExternalProcessorConfig{{Extension: "rst", Command: []string{"rst2html"}}}
+	if err := RegisterExternalProcessors(config); err != nil {
+		t.Fatalf("RegisterExternalProcessors() error = %v", err)
+	}
+	p, err := GetProcessor("index.rst")
+	if err != nil {
+		t.Fatalf("GetProcessor(index.rst) error = %v", err)
+	}
+	if external, ok := p.(ExternalProcessor); !ok || external.Command[0] != "rst2html" {
+		t.Fatalf("GetProcessor(index.rst) = %#v", p)
+	}
+
+	config.Processors.External = []model.ExternalProcessorConfig{{Extension: ".md", Command: []string{"pandoc"}}}
+	if err := RegisterExternalProcessors(config); err == nil {
+		t.Fatalf("RegisterExternalProcessors() of the .md extension succeeded")
+	}
+	config.Processors.External = []model.ExternalProcessorConfig{{Extension: ".org"}}
+	if err := RegisterExternalProcessors(config); err == nil {
+		t.Fatalf("RegisterExternalProcessors() without command succeeded")
+	}
+}
+
+func TestRunExternalProgram(t *testing.T) {
+	for _, program := range []string{"cat", "sh", "sleep"} {
+		if _, err := exec.LookPath(program); err != nil {
+			t.Skipf("%s not available: %v", program, err)
+		}
+	}
+	out, err := runExternalProgram(time.Minute, "", strings.NewReader("<p>hi</p>"), "cat")
+	if err != nil || string(out) != "<p>hi</p>" {
+		t.Fatalf("runExternalProgram(cat) = %q, %v", out, err)
+	}
+	if _, err := runExternalProgram(time.Minute, "", nil, "sh", "-c", "echo broken >&2; exit 3"); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("runExternalProgram() of a failing program error = %v, want its stderr", err)
+	}
+
+	// a hanging program is killed:
+	start := time.Now()
+	if _, err := runExternalProgram(50*time.Millisecond, "", nil, "sleep", "10"); err == nil || !strings.Contains(err.Error(), "processors.timeout") {
+		t.Fatalf("runExternalProgram() of a hanging program error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("runExternalProgram() of a hanging program took %s", elapsed)
+	}
+}
+
+func TestTextToHTML(t *testing.T) {
+	got := textToHTML("\nfirst line\r\nsecond <line>\r\n  \r\n\n\nlast & least\n")
+	want := "<p>first line\nsecond &lt;line&gt;</p>\n<p>last &amp; least</p>\n"
+	if got != want {
+		t.Fatalf("textToHTML() = %q, want %q", got, want)
+	}
+}
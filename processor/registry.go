@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+)
+
+var (
+	processorsMu sync.RWMutex
+	// processors holds the processors of the index formats, by format name
+	// (see lib.IndexFormat)
+	processors = map[string]Processor{
+		"html": HtmlProcessor{},
+		"md":   MdProcessor{},
+		"txt":  TextProcessor{},
+		"json": DataProcessor{},
+		"yaml": DataProcessor{},
+	}
+)
+
+// Register registers a processor for a page index file format: from now on,
+// folders with an index file of one of the format's extensions are pages, and
+// the processor renders them. A processor registered before for the format is
+// replaced.
+func Register(format lib.IndexFormat, p Processor) error {
+	if p == nil {
+		return fmt.Errorf("index format %q: processor is required", format.Name)
+	}
+	if err := lib.RegisterIndexFormat(format); err != nil {
+		return err
+	}
+	processorsMu.Lock()
+	defer processorsMu.Unlock()
+	processors[format.Name] = p
+	return nil
+}
+
+// GetProcessor returns the processor that renders the given index file, by the
+// index format of its file extension.
+func GetProcessor(indexFile string) (Processor, error) {
+	format, ok := lib.IndexFormatOf(indexFile)
+	if !ok {
+		return nil, fmt.Errorf("unsupported page index file type: %s", indexFile)
+	}
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+	p, ok := processors[format.Name]
+	if !ok {
+		return nil, fmt.Errorf("no processor registered for index format %q: %s", format.Name, indexFile)
+	}
+	return p, nil
+}
+
+// RegisterExternalProcessors registers the external-command processors of the
+// processors.external config. Their index files have a YAML front matter, and
+// are indexed by their body as plain text.
+func RegisterExternalProcessors(config model.Config) error {
+	for _, external := range config.Processors.External {
+		ext := strings.ToLower(external.Extension)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if len(external.Command) == 0 {
+			return fmt.Errorf("processors.external: no command configured for %s", ext)
+		}
+		if format, exists := lib.IndexFormatOf("index" + ext); exists {
+			return fmt.Errorf("processors.external: extension %s is already registered by format %q", ext, format.Name)
+		}
+		format := lib.IndexFormat{
+			Name:             strings.TrimPrefix(ext, "."),
+			Extensions:       []string{ext},
+			ParseFrontMatter: lib.YamlFrontMatter,
+		}
+		if err := Register(format, ExternalProcessor{Command: external.Command}); err != nil {
+			return fmt.Errorf("processors.external: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,848 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The built-in SCSS compiler compiles the SCSS most site stylesheets use, without
+// an external sass binary:
+//
+//   - variables ($name: value, with !default and !global), #{} interpolation
+//   - nested rules with the & parent selector, nested @media and @supports
+//   - @import and @use ("as" namespaces, namespace.$variable) of partials
+//   - @mixin / @include with arguments, default values and @content
+//   - +, - and * of numbers with the same unit (or without unit)
+//
+// Other Sass features (@extend, control directives, functions, modules like
+// sass:math) are reported as compile errors: configure processors.scss.sass_bin
+// for those. Comments are removed from the output.
+
+// scssNode is a statement of a parsed .scss file: a declaration or at-rule
+// statement (without children), or a block (rule, at-rule) with children.
+type scssNode struct {
+	text     string
+	block    bool
+	children []*scssNode
+}
+
+type scssMixin struct {
+	params []scssParam
+	body   []*scssNode
+}
+
+type scssParam struct {
+	name       string
+	defaultVal string
+	hasDefault bool
+}
+
+// scssScope holds the variables and mixins of a block, and links to the
+// enclosing block's scope.
+type scssScope struct {
+	vars   map[string]string
+	mixins map[string]*scssMixin
+	parent *scssScope
+}
+
+func newScssScope(parent *scssScope) *scssScope {
+	return &scssScope{vars: map[string]string{}, mixins: map[string]*scssMixin{}, parent: parent}
+}
+
+func (s *scssScope) root() *scssScope {
+	for s.parent != nil {
+		s = s.parent
+	}
+	return s
+}
+
+func (s *scssScope) lookupVar(name string) (string, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func (s *scssScope) lookupMixin(name string) (*scssMixin, bool) {
+	for ; s != nil; s = s.parent {
+		if m, ok := s.mixins[name]; ok {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// cssItem is a rule or a raw statement of the compiled CSS, within the @media /
+// @supports blocks given by wrappers (outermost first).
+type cssItem struct {
+	wrappers []string
+	selector string
+	decls    []string
+	raw      string
+}
+
+// scssContent is the content block passed to a mixin by @include, compiled with
+// the scope of the including block.
+type scssContent struct {
+	nodes []*scssNode
+	scope *scssScope
+}
+
+type scssContext struct {
+	selectors []string
+	wrappers  []string
+	scope     *scssScope
+	content   *scssContent
+	// the rule that receives the declarations, nil where none are allowed
+	rule *cssItem
+	out  *[]*cssItem
+}
+
+type scssCompiler struct {
+	srcFS   fs.FS
+	sources []string
+	seen    map[string]bool
+	// namespaces of the modules loaded by @use, by fs path
+	modules map[string]*scssScope
+	// the fs path of the file being compiled, for error messages and imports
+	file string
+}
+
+// scssDivision marks a "/" next to a variable, which is a division: other
+// slashes are separators, as in "font: 12px/1.5".
+const scssDivision = "\x00"
+
+var (
+	scssVariablePattern    = regexp.MustCompile(`^(?:([a-zA-Z_][\w-]*)\.)?\$([a-zA-Z_][\w-]*)`)
+	scssMultiplyPattern    = regexp.MustCompile(`(^|[\s(,])(-?\d*\.?\d+)([a-zA-Z%]*)\s+(\*)\s+(-?\d*\.?\d+)([a-zA-Z%]*)`)
+	scssAddPattern         = regexp.MustCompile(`(^|[\s(,])(-?\d*\.?\d+)([a-zA-Z%]*)\s+([+-])\s+(-?\d*\.?\d+)([a-zA-Z%]*)`)
+	scssDividePattern      = regexp.MustCompile(`(^|[\s(,])(-?\d*\.?\d+)([a-zA-Z%]*)\s*(` + scssDivision + `)\s*(-?\d*\.?\d+)([a-zA-Z%]*)`)
+	scssPlaceholderPattern = regexp.MustCompile(`(^|[\s,>+~&])%[a-zA-Z_]`)
+	scssUnsupported        = []string{"@extend", "@if", "@else", "@each", "@for", "@while", "@function", "@return", "@debug", "@warn", "@error", "@at-root"}
+)
+
+// scssNestedPropertyPattern matches the head of a nested property block:
+// "font: {" or "font: bold {", but no selector with a pseudo class, like
+// "a:hover {".
+var scssNestedPropertyPattern = regexp.MustCompile(`^(?:[a-zA-Z-][\w-]*|#\{[^}]*\}[\w-]*):(?:\s.*)?$`)
+
+// compileScssBuiltin compiles fsPath with the built-in compiler, and returns the
+// CSS and the fs paths of all sources it was compiled from.
+func compileScssBuiltin(srcFS fs.FS, fsPath string) ([]byte, []string, error) {
+	c := &scssCompiler{srcFS: srcFS, seen: map[string]bool{}, modules: map[string]*scssScope{}}
+	var items []*cssItem
+	ctx := scssContext{scope: newScssScope(nil), out: &items}
+	if err := c.compileFile(fsPath, ctx); err != nil {
+		return nil, nil, err
+	}
+	return []byte(renderCSS(items)), c.sources, nil
+}
+
+func (c *scssCompiler) compileFile(fsPath string, ctx scssContext) error {
+	src, err := fs.ReadFile(c.srcFS, fsPath)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", fsPath, err)
+	}
+	if !c.seen[fsPath] {
+		c.seen[fsPath] = true
+		c.sources = append(c.sources, fsPath)
+	}
+	nodes, err := parseScss(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %w", fsPath, err)
+	}
+
+	prevFile := c.file
+	c.file = fsPath
+	defer func() { c.file = prevFile }()
+	if err := c.compileNodes(nodes, ctx); err != nil {
+		return fmt.Errorf("%s: %w", fsPath, err)
+	}
+	return nil
+}
+
+func (c *scssCompiler) compileNodes(nodes []*scssNode, ctx scssContext) error {
+	for _, node := range nodes {
+		var err error
+		switch {
+		case strings.HasPrefix(node.text, "$"):
+			err = c.compileVariable(node, ctx)
+		case strings.HasPrefix(node.text, "@"):
+			err = c.compileAtRule(node, ctx)
+		case node.block && scssNestedPropertyPattern.MatchString(node.text):
+			err = c.compileNestedProperty(node, ctx)
+		case node.block:
+			err = c.compileRule(node, ctx)
+		default:
+			err = c.compileDeclaration(node, ctx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *scssCompiler) compileVariable(node *scssNode, ctx scssContext) error {
+	if node.block {
+		return fmt.Errorf("unexpected block after %s", node.text)
+	}
+	name, value, ok := strings.Cut(node.text[1:], ":")
+	if !ok {
+		return fmt.Errorf("invalid variable declaration: %s", node.text)
+	}
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+
+	scope := ctx.scope
+	isDefault, isGlobal := false, false
+	for {
+		if v, found := strings.CutSuffix(value, "!default"); found {
+			value, isDefault = strings.TrimSpace(v), true
+		} else if v, found := strings.CutSuffix(value, "!global"); found {
+			value, isGlobal = strings.TrimSpace(v), true
+		} else {
+			break
+		}
+	}
+	if isGlobal {
+		scope = scope.root()
+	}
+	if isDefault {
+		if _, defined := scope.lookupVar(name); defined {
+			return nil
+		}
+	}
+
+	evaluated, err := evalScssValue(value, ctx.scope)
+	if err != nil {
+		return err
+	}
+	scope.vars[name] = evaluated
+	return nil
+}
+
+func (c *scssCompiler) compileDeclaration(node *scssNode, ctx scssContext) error {
+	prop, value, ok := cutScssDeclaration(node.text)
+	if !ok {
+		return fmt.Errorf("invalid declaration: %s", node.text)
+	}
+	if ctx.rule == nil {
+		return fmt.Errorf("declaration outside of a rule: %s", node.text)
+	}
+	prop, err := evalScssInterpolation(prop, ctx.scope)
+	if err != nil {
+		return err
+	}
+	value, err = evalScssValue(value, ctx.scope)
+	if err != nil {
+		return err
+	}
+	ctx.rule.decls = append(ctx.rule.decls, prop+": "+value)
+	return nil
+}
+
+// compileNestedProperty compiles a nested property block: "font: { family: x; }"
+// is "font-family: x". A value before the block is the value of the property
+// itself.
+func (c *scssCompiler) compileNestedProperty(node *scssNode, ctx scssContext) error {
+	prop, value, _ := cutScssDeclaration(node.text)
+	if value != "" {
+		if err := c.compileDeclaration(&scssNode{text: node.text}, ctx); err != nil {
+			return err
+		}
+	}
+	for _, child := range node.children {
+		if strings.HasPrefix(child.text, "$") || strings.HasPrefix(child.text, "@") || (child.block && !scssNestedPropertyPattern.MatchString(child.text)) {
+			return fmt.Errorf("unexpected %s in nested property %s", child.text, prop)
+		}
+		nested := &scssNode{text: prop + "-" + child.text, block: child.block, children: child.children}
+		var err error
+		if child.block {
+			err = c.compileNestedProperty(nested, ctx)
+		} else {
+			err = c.compileDeclaration(nested, ctx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *scssCompiler) compileRule(node *scssNode, ctx scssContext) error {
+	selector, err := evalScssInterpolation(node.text, ctx.scope)
+	if err != nil {
+		return err
+	}
+	selectors := resolveScssSelectors(ctx.selectors, splitScssList(selector))
+
+	rule := &cssItem{wrappers: ctx.wrappers, selector: strings.Join(selectors, ", ")}
+	// placeholder selectors are only used by @extend, and never output:
+	if !scssPlaceholderPattern.MatchString(rule.selector) {
+		*ctx.out = append(*ctx.out, rule)
+	}
+
+	inner := ctx
+	inner.selectors = selectors
+	inner.scope = newScssScope(ctx.scope)
+	inner.rule = rule
+	return c.compileNodes(node.children, inner)
+}
+
+func (c *scssCompiler) compileAtRule(node *scssNode, ctx scssContext) error {
+	name, prelude, _ := strings.Cut(node.text, " ")
+	if i := strings.IndexByte(name, '('); i > 0 {
+		name, prelude = name[:i], name[i:]+" "+prelude
+	}
+	prelude = strings.TrimSpace(prelude)
+	for _, unsupported := range scssUnsupported {
+		if name == unsupported {
+			return fmt.Errorf("%s is not supported by the built-in scss compiler, configure processors.scss.sass_bin", name)
+		}
+	}
+
+	switch name {
+	case "@import":
+		return c.compileImport(node, prelude, ctx)
+	case "@use", "@forward":
+		return c.compileUse(node, prelude, ctx)
+	case "@mixin":
+		return c.defineMixin(node, prelude, ctx)
+	case "@include":
+		return c.compileInclude(node, prelude, ctx)
+	case "@content":
+		if ctx.content == nil {
+			return nil
+		}
+		inner := ctx
+		inner.scope = newScssScope(ctx.content.scope)
+		inner.content = nil
+		return c.compileNodes(ctx.content.nodes, inner)
+	case "@media", "@supports":
+		if !node.block {
+			return fmt.Errorf("missing block after %s", node.text)
+		}
+		query, err := evalScssValue(prelude, ctx.scope)
+		if err != nil {
+			return err
+		}
+		inner := ctx
+		inner.wrappers = appendScssWrapper(ctx.wrappers, name, query)
+		inner.scope = newScssScope(ctx.scope)
+		inner.rule = nil
+		if len(ctx.selectors) > 0 {
+			// a nested @media block applies to the enclosing rule:
+			inner.rule = &cssItem{wrappers: inner.wrappers, selector: strings.Join(ctx.selectors, ", ")}
+			*ctx.out = append(*ctx.out, inner.rule)
+		}
+		return c.compileNodes(node.children, inner)
+	}
+
+	text, err := evalScssValue(node.text, ctx.scope)
+	if err != nil {
+		return err
+	}
+	if !node.block {
+		// @charset, @namespace, ...
+		*ctx.out = append(*ctx.out, &cssItem{wrappers: ctx.wrappers, raw: text + ";"})
+		return nil
+	}
+
+	// other at-rules with a block (@font-face, @keyframes, @page, ...) are output
+	// as they are, with their contents compiled on their own:
+	var items []*cssItem
+	own := &cssItem{}
+	inner := scssContext{scope: newScssScope(ctx.scope), rule: own, out: &items}
+	if err := c.compileNodes(node.children, inner); err != nil {
+		return err
+	}
+	var body strings.Builder
+	for _, decl := range own.decls {
+		body.WriteString("  " + decl + ";\n")
+	}
+	if rendered := renderCSS(items); rendered != "" {
+		body.WriteString(indentCSS(rendered, "  "))
+	}
+	*ctx.out = append(*ctx.out, &cssItem{wrappers: ctx.wrappers, raw: text + " {\n" + body.String() + "}"})
+	return nil
+}
+
+func (c *scssCompiler) compileImport(node *scssNode, prelude string, ctx scssContext) error {
+	for _, target := range splitScssList(prelude) {
+		if isPlainCSSImport(unquoteScss(target)) {
+			*ctx.out = append(*ctx.out, &cssItem{raw: "@import " + target + ";"})
+			continue
+		}
+		resolved, ok := resolveScssImport(c.srcFS, path.Dir(c.file), unquoteScss(target))
+		if !ok {
+			return fmt.Errorf("import not found: %s", target)
+		}
+		if err := c.compileFile(resolved, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileUse loads a module: its CSS is output once, its variables and mixins
+// are available under its namespace ("colors.$primary" for "colors.scss"), or
+// without one with "as *". @forward is treated as "@use ... as *".
+func (c *scssCompiler) compileUse(node *scssNode, prelude string, ctx scssContext) error {
+	target, rest, _ := strings.Cut(prelude, " ")
+	target = unquoteScss(target)
+	if strings.HasPrefix(target, "sass:") {
+		return fmt.Errorf("the %s module is not supported by the built-in scss compiler, configure processors.scss.sass_bin", target)
+	}
+	resolved, ok := resolveScssImport(c.srcFS, path.Dir(c.file), target)
+	if !ok {
+		return fmt.Errorf("module not found: %s", target)
+	}
+
+	namespace := strings.TrimPrefix(strings.TrimSuffix(path.Base(target), ".scss"), "_")
+	if strings.HasPrefix(node.text, "@forward") {
+		namespace = "*"
+	}
+	if strings.Contains(rest, "with") || strings.Contains(rest, "show") || strings.Contains(rest, "hide") {
+		return fmt.Errorf("%s is not supported by the built-in scss compiler, configure processors.scss.sass_bin", node.text)
+	}
+	if as, found := strings.CutPrefix(strings.TrimSpace(rest), "as "); found {
+		namespace = strings.TrimSpace(as)
+	}
+
+	module, loaded := c.modules[resolved]
+	if !loaded {
+		module = newScssScope(nil)
+		c.modules[resolved] = module
+		if err := c.compileFile(resolved, scssContext{scope: module, out: ctx.out}); err != nil {
+			return err
+		}
+	}
+
+	prefix := namespace + "."
+	if namespace == "*" {
+		prefix = ""
+	}
+	root := ctx.scope.root()
+	for name, value := range module.vars {
+		root.vars[prefix+name] = value
+	}
+	for name, mixin := range module.mixins {
+		root.mixins[prefix+name] = mixin
+	}
+	return nil
+}
+
+func (c *scssCompiler) defineMixin(node *scssNode, prelude string, ctx scssContext) error {
+	if !node.block {
+		return fmt.Errorf("missing block after %s", node.text)
+	}
+	name, args := splitScssCall(prelude)
+	mixin := &scssMixin{body: node.children}
+	for _, arg := range args {
+		paramName, defaultVal, hasDefault := strings.Cut(arg, ":")
+		mixin.params = append(mixin.params, scssParam{
+			name:       strings.TrimPrefix(strings.TrimSpace(paramName), "$"),
+			defaultVal: strings.TrimSpace(defaultVal),
+			hasDefault: hasDefault,
+		})
+	}
+	ctx.scope.mixins[name] = mixin
+	return nil
+}
+
+func (c *scssCompiler) compileInclude(node *scssNode, prelude string, ctx scssContext) error {
+	name, args := splitScssCall(prelude)
+	mixin, ok := ctx.scope.lookupMixin(name)
+	if !ok {
+		return fmt.Errorf("undefined mixin: %s", name)
+	}
+
+	scope := newScssScope(ctx.scope)
+	named := map[string]string{}
+	var positional []string
+	for _, arg := range args {
+		if m := scssVariablePattern.FindStringSubmatch(arg); m != nil && m[1] == "" {
+			if rest := strings.TrimSpace(arg[len(m[0]):]); strings.HasPrefix(rest, ":") {
+				named[m[2]] = strings.TrimSpace(rest[1:])
+				continue
+			}
+		}
+		positional = append(positional, arg)
+	}
+	if len(positional) > len(mixin.params) {
+		return fmt.Errorf("too many arguments for mixin %s", name)
+	}
+	// the arguments are evaluated in the including block, the default values
+	// in the mixin, where they can refer to the preceding parameters:
+	for i, param := range mixin.params {
+		value, valueScope := "", ctx.scope
+		if i < len(positional) {
+			value = positional[i]
+		} else if v, ok := named[param.name]; ok {
+			value = v
+		} else if param.hasDefault {
+			value, valueScope = param.defaultVal, scope
+		} else {
+			return fmt.Errorf("missing argument $%s for mixin %s", param.name, name)
+		}
+		evaluated, err := evalScssValue(value, valueScope)
+		if err != nil {
+			return err
+		}
+		scope.vars[param.name] = evaluated
+	}
+
+	inner := ctx
+	inner.scope = scope
+	inner.content = nil
+	if node.block {
+		inner.content = &scssContent{nodes: node.children, scope: ctx.scope}
+	}
+	return c.compileNodes(mixin.body, inner)
+}
+
+// parseScss parses the source into a tree of statements and blocks.
+func parseScss(src string) ([]*scssNode, error) {
+	src = stripScssComments(src)
+	root := &scssNode{block: true}
+	stack := []*scssNode{root}
+	var buf strings.Builder
+	parenDepth := 0
+
+	flush := func() {
+		if text := strings.TrimSpace(buf.String()); text != "" {
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, &scssNode{text: collapseScssSpace(text)})
+		}
+		buf.Reset()
+	}
+
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case ch == '"' || ch == '\'':
+			end := scanScssString(src, i)
+			buf.WriteString(src[i:end])
+			i = end - 1
+		case ch == '#' && i+1 < len(src) && src[i+1] == '{':
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed interpolation")
+			}
+			buf.WriteString(src[i : i+end+1])
+			i += end
+		case ch == '(':
+			parenDepth++
+			buf.WriteByte(ch)
+		case ch == ')':
+			if parenDepth > 0 {
+				parenDepth--
+			}
+			buf.WriteByte(ch)
+		case ch == ';' && parenDepth == 0:
+			flush()
+		case ch == '{':
+			node := &scssNode{text: collapseScssSpace(strings.TrimSpace(buf.String())), block: true}
+			buf.Reset()
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case ch == '}':
+			flush()
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("unexpected }")
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	flush()
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("unclosed block: %s", stack[len(stack)-1].text)
+	}
+	return root.children, nil
+}
+
+// evalScssValue replaces the interpolations and variables of a value, and
+// computes simple arithmetic.
+func evalScssValue(value string, scope *scssScope) (string, error) {
+	value, err := evalScssInterpolation(value, scope)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case ch == '"' || ch == '\'':
+			end := scanScssString(value, i)
+			out.WriteString(value[i:end])
+			i = end - 1
+		case ch == '$' || (isScssNameStart(ch) && (i == 0 || !isScssNameChar(value[i-1]))):
+			m := scssVariablePattern.FindStringSubmatch(value[i:])
+			if m == nil {
+				out.WriteByte(ch)
+				continue
+			}
+			name := m[2]
+			if m[1] != "" {
+				name = m[1] + "." + m[2]
+			}
+			v, ok := scope.lookupVar(name)
+			if !ok {
+				return "", fmt.Errorf("undefined variable: $%s", name)
+			}
+			// a slash next to a variable divides:
+			if before := strings.TrimRight(out.String(), " "); strings.HasSuffix(before, "/") {
+				written := out.String()
+				slash := strings.LastIndex(written, "/")
+				out.Reset()
+				out.WriteString(written[:slash] + scssDivision + written[slash+1:])
+			}
+			out.WriteString(v)
+			i += len(m[0]) - 1
+			if after := strings.TrimLeft(value[i+1:], " "); strings.HasPrefix(after, "/") {
+				spaces := len(value[i+1:]) - len(after)
+				out.WriteString(value[i+1:i+1+spaces] + scssDivision)
+				i += spaces + 1
+			}
+		default:
+			out.WriteByte(ch)
+		}
+	}
+	result := evalScssArithmetic(out.String())
+	if strings.Contains(result, scssDivision) {
+		return "", fmt.Errorf("the division %s is not supported by the built-in scss compiler, configure processors.scss.sass_bin", strings.ReplaceAll(result, scssDivision, "/"))
+	}
+	return result, nil
+}
+
+// evalScssInterpolation replaces the #{...} interpolations of the text by their
+// (unquoted) value.
+func evalScssInterpolation(text string, scope *scssScope) (string, error) {
+	for {
+		start := strings.Index(text, "#{")
+		if start < 0 {
+			return text, nil
+		}
+		end := strings.IndexByte(text[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unclosed interpolation: %s", text)
+		}
+		value, err := evalScssValue(text[start+2:start+end], scope)
+		if err != nil {
+			return "", err
+		}
+		text = text[:start] + unquoteScss(value) + text[start+end+1:]
+	}
+}
+
+// evalScssArithmetic computes "a * b", "a + b" and "a - b" of numbers with the
+// same unit, or where one number has no unit, and divisions (see scssDivision)
+// by a number without unit or of the same unit. Other expressions are kept.
+func evalScssArithmetic(value string) string {
+	for _, pattern := range []*regexp.Regexp{scssMultiplyPattern, scssDividePattern, scssAddPattern} {
+		for range 100 {
+			changed := false
+			value = pattern.ReplaceAllStringFunc(value, func(expr string) string {
+				m := pattern.FindStringSubmatch(expr)
+				op := m[4]
+				if changed {
+					return expr
+				}
+				a, errA := strconv.ParseFloat(m[2], 64)
+				b, errB := strconv.ParseFloat(m[5], 64)
+				unit := m[3]
+				if m[6] != "" {
+					if unit != "" && unit != m[6] {
+						return expr
+					}
+					unit = m[6]
+				}
+				if errA != nil || errB != nil || (op == "*" && m[3] != "" && m[6] != "") || (op == scssDivision && b == 0) {
+					return expr
+				}
+				// 10px / 2px is the number 5:
+				if op == scssDivision && m[3] != "" && m[6] != "" {
+					unit = ""
+				} else if op == scssDivision && m[3] == "" && m[6] != "" {
+					return expr
+				}
+				var result float64
+				switch op {
+				case "*":
+					result = a * b
+				case "+":
+					result = a + b
+				case "-":
+					result = a - b
+				case scssDivision:
+					result = a / b
+				}
+				changed = true
+				result = math.Round(result*1e5) / 1e5
+				return m[1] + strconv.FormatFloat(result, 'f', -1, 64) + unit
+			})
+			if !changed {
+				break
+			}
+		}
+	}
+	return value
+}
+
+// resolveScssSelectors combines the parent selectors with the nested ones: "&"
+// is replaced by the parent selector, other selectors become descendants.
+func resolveScssSelectors(parents []string, selectors []string) []string {
+	if len(parents) == 0 {
+		return selectors
+	}
+	var resolved []string
+	for _, parent := range parents {
+		for _, selector := range selectors {
+			if strings.Contains(selector, "&") {
+				resolved = append(resolved, strings.ReplaceAll(selector, "&", parent))
+			} else {
+				resolved = append(resolved, parent+" "+selector)
+			}
+		}
+	}
+	return resolved
+}
+
+// appendScssWrapper adds a @media / @supports block to the wrappers. Nested
+// @media queries are combined with "and".
+func appendScssWrapper(wrappers []string, name string, query string) []string {
+	result := append([]string{}, wrappers...)
+	if n := len(result); n > 0 && name == "@media" && strings.HasPrefix(result[n-1], "@media ") {
+		result[n-1] += " and " + query
+		return result
+	}
+	return append(result, name+" "+query)
+}
+
+// splitScssList splits a comma separated list, ignoring commas in parentheses
+// and strings.
+func splitScssList(list string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '"', '\'':
+			i = scanScssString(list, i) - 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if last := strings.TrimSpace(list[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+// splitScssCall splits "name(arg1, arg2)" into the name and the arguments.
+func splitScssCall(call string) (string, []string) {
+	name, args, found := strings.Cut(call, "(")
+	name = strings.TrimSpace(name)
+	if !found {
+		return name, nil
+	}
+	return name, splitScssList(strings.TrimSuffix(strings.TrimSpace(args), ")"))
+}
+
+// cutScssDeclaration splits a declaration at the first ":" outside of an
+// interpolation.
+func cutScssDeclaration(text string) (string, string, bool) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func unquoteScss(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func collapseScssSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func isScssNameStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isScssNameChar(ch byte) bool {
+	return isScssNameStart(ch) || ch == '-' || (ch >= '0' && ch <= '9')
+}
+
+// renderCSS renders the compiled items, one block per rule. Rules without
+// declarations are left out.
+func renderCSS(items []*cssItem) string {
+	var blocks []string
+	for _, item := range items {
+		var block string
+		switch {
+		case item.raw != "":
+			block = item.raw
+		case item.selector != "" && len(item.decls) > 0:
+			block = item.selector + " {\n"
+			for _, decl := range item.decls {
+				block += "  " + decl + ";\n"
+			}
+			block += "}"
+		default:
+			continue
+		}
+		for i := len(item.wrappers) - 1; i >= 0; i-- {
+			block = item.wrappers[i] + " {\n" + indentCSS(block+"\n", "  ") + "}"
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		return ""
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+func indentCSS(css string, indent string) string {
+	lines := strings.SplitAfter(css, "\n")
+	var out strings.Builder
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			out.WriteString(indent)
+		}
+		out.WriteString(line)
+	}
+	return out.String()
+}
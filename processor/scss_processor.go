@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// scssImportPattern finds the @import, @use and @forward rules of a .scss file,
+// see scssImports.
+var scssImportPattern = regexp.MustCompile(`@(?:import|use|forward)\s+([^;{}]+)`)
+
+// scssQuotedPattern finds the quoted strings of an import rule.
+var scssQuotedPattern = regexp.MustCompile(`"([^"]*)"|'([^']*)'`)
+
+// IsScssSource reports whether the file is a .scss source file. The sources are
+// never served as they are: a request for the .css route of a .scss file gets
+// the compiled stylesheet instead.
+func IsScssSource(fileName string) bool {
+	return strings.EqualFold(path.Ext(fileName), ".scss")
+}
+
+// IsScssPartial reports whether the file is a .scss partial ("_variables.scss"):
+// partials are only imported by other .scss files, and not compiled on their own.
+func IsScssPartial(fileName string) bool {
+	return IsScssSource(fileName) && strings.HasPrefix(path.Base(fileName), "_")
+}
+
+// CompileScss compiles the .scss file fsPath of srcFS to CSS. If sassBin is set,
+// the file is compiled by that sass binary (dart-sass or sassc), with sourceDir,
+// the directory of srcFS on disk, as load path, and is killed if it runs longer
+// than timeout. Otherwise, the built-in compiler is used, which supports a subset
+// of SCSS (see compileScssBuiltin).
+//
+// Returns the CSS and the fs paths of all sources it was compiled from: the
+// file itself and all the partials it imports, directly or indirectly.
+func CompileScss(srcFS fs.FS, fsPath string, sassBin string, sourceDir string, timeout time.Duration) ([]byte, []string, error) {
+	if sassBin == "" || sourceDir == "" {
+		return compileScssBuiltin(srcFS, fsPath)
+	}
+
+	sources, err := scssSources(srcFS, fsPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	css, err := runExternalProgram(timeout, "", nil, sassBin, "-I", sourceDir, filepath.Join(sourceDir, filepath.FromSlash(fsPath)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("compile %s: %w", fsPath, err)
+	}
+	return css, sources, nil
+}
+
+// scssSources returns fsPath and the fs paths of all partials it imports,
+// directly or indirectly.
+func scssSources(srcFS fs.FS, fsPath string) ([]string, error) {
+	sources := []string{fsPath}
+	seen := map[string]bool{fsPath: true}
+	for i := 0; i < len(sources); i++ {
+		src, err := fs.ReadFile(srcFS, sources[i])
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", sources[i], err)
+		}
+		for _, target := range scssImports(string(src)) {
+			resolved, ok := resolveScssImport(srcFS, path.Dir(sources[i]), target)
+			if ok && !seen[resolved] {
+				seen[resolved] = true
+				sources = append(sources, resolved)
+			}
+		}
+	}
+	return sources, nil
+}
+
+// scssImports returns the import targets of all @import, @use and @forward rules
+// of the source.
+func scssImports(src string) []string {
+	var targets []string
+	for _, rule := range scssImportPattern.FindAllStringSubmatch(stripScssComments(src), -1) {
+		for _, quoted := range scssQuotedPattern.FindAllStringSubmatch(rule[1], -1) {
+			targets = append(targets, quoted[1]+quoted[2])
+		}
+	}
+	return targets
+}
+
+// isPlainCSSImport reports whether an @import target is left to the browser, as
+// plain CSS import: a URL, or a .css file.
+func isPlainCSSImport(target string) bool {
+	return strings.HasPrefix(target, "url(") || strings.Contains(target, "://") || strings.HasPrefix(target, "//") ||
+		strings.HasSuffix(strings.ToLower(target), ".css")
+}
+
+// resolveScssImport returns the fs path of the .scss file an import target
+// refers to, as sass does: relative to the importing file's dir, then to the
+// source root, with or without "_" partial prefix and .scss extension, or the
+// _index.scss of a folder.
+func resolveScssImport(srcFS fs.FS, fromDir string, target string) (string, bool) {
+	if isPlainCSSImport(target) || strings.HasPrefix(target, "sass:") {
+		return "", false
+	}
+	for _, baseDir := range []string{fromDir, "."} {
+		base := path.Join(baseDir, target)
+		dir, name := path.Split(base)
+		var candidates []string
+		if IsScssSource(name) {
+			candidates = []string{base, path.Join(dir, "_"+name)}
+		} else {
+			candidates = []string{
+				base + ".scss",
+				path.Join(dir, "_"+name+".scss"),
+				path.Join(base, "_index.scss"),
+				path.Join(base, "index.scss"),
+			}
+		}
+		for _, candidate := range candidates {
+			if info, err := fs.Stat(srcFS, candidate); err == nil && !info.IsDir() {
+				return candidate, true
+			}
+		}
+	}
+	return "", false
+}
+
+// stripScssComments removes the // and /* */ comments from the source. Quoted
+// strings and url(...) values are kept as they are.
+func stripScssComments(src string) string {
+	var out strings.Builder
+	parenDepth := 0
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'':
+			end := scanScssString(src, i)
+			out.WriteString(src[i:end])
+			i = end - 1
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return out.String()
+			}
+			i += end + 3
+		case c == '/' && i+1 < len(src) && src[i+1] == '/' && parenDepth == 0:
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				return out.String()
+			}
+			i += end - 1
+		default:
+			if c == '(' {
+				parenDepth++
+			} else if c == ')' && parenDepth > 0 {
+				parenDepth--
+			}
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// scanScssString returns the index after the quoted string starting at src[start].
+func scanScssString(src string, start int) int {
+	quote := src[start]
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+	return len(src)
+}
@@ -0,0 +1,183 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCompileScssBuiltin(t *testing.T) {
+	srcFS := fstest.MapFS{
+		"css/main.scss": {Data: []byte(`
+@use "colors";
+@import "mixins";
+@import url("https://fonts.example.com/font.css");
+
+$gap: 8px !default;
+
+// nav styles:
+nav {
+	padding: $gap * 2 $gap;
+	a, button {
+		color: colors.$primary;
+		&:hover { color: darken; }
+		&.active { font-weight: bold; }
+	}
+	@media (max-width: 600px) {
+		padding: $gap - 2px;
+		.menu-#{colors.$name} { display: none; }
+	}
+	@include rounded(4px) {
+		border: 1px solid colors.$primary;
+	}
+}
+
+@keyframes spin {
+	from { transform: rotate(0deg); }
+	to { transform: rotate(360deg); }
+}
+`)},
+		"css/_colors.scss": {Data: []byte(`$primary: #336699; $name: "dark";`)},
+		"_mixins.scss": {Data: []byte(`
+@mixin rounded($radius, $border: $radius * 2) {
+	border-radius: $radius;
+	outline-offset: $border;
+	@content;
+}
+%hidden { display: none; }
+`)},
+	}
+
+	css, sources, err := CompileScss(srcFS, "css/main.scss", "", "", 0)
+	if err != nil {
+		t.Fatalf("CompileScss() error = %v", err)
+	}
+	want := `@import url("https://fonts.example.com/font.css");
+
+nav {
+  padding: 16px 8px;
+  border-radius: 4px;
+  outline-offset: 8px;
+  border: 1px solid #336699;
+}
+
+nav a, nav button {
+  color: #336699;
+}
+
+nav a:hover, nav button:hover {
+  color: darken;
+}
+
+nav a.active, nav button.active {
+  font-weight: bold;
+}
+
+@media (max-width: 600px) {
+  nav {
+    padding: 6px;
+  }
+}
+
+@media (max-width: 600px) {
+  nav .menu-dark {
+    display: none;
+  }
+}
+
+@keyframes spin {
+  from {
+    transform: rotate(0deg);
+  }
+
+  to {
+    transform: rotate(360deg);
+  }
+}
+`
+	if string(css) != want {
+		t.Fatalf("CompileScss() css =\n%s\nwant:\n%s", css, want)
+	}
+	if strings.Join(sources, ",") != "css/main.scss,css/_colors.scss,_mixins.scss" {
+		t.Fatalf("CompileScss() sources = %v", sources)
+	}
+
+	unsupported := fstest.MapFS{"a.scss": {Data: []byte(`a { @extend .b; }`)}}
+	if _, _, err := CompileScss(unsupported, "a.scss", "", "", 0); err == nil || !strings.Contains(err.Error(), "sass_bin") {
+		t.Fatalf("CompileScss() of @extend error = %v, want a hint to sass_bin", err)
+	}
+}
+
+func TestCompileScssBuiltinNestedPropertiesAndDivision(t *testing.T) {
+	srcFS := fstest.MapFS{"a.scss": {Data: []byte(`
+$x: 10px;
+$gap: 4px;
+$half: $x / 2;
+.a {
+	font: { family: serif; size: $half; }
+	border: 1px solid { left: { width: $x/2; } }
+	height: $x / $gap;
+	margin: 20px / $gap;
+	font: 12px/1.5 serif;
+	grid-area: 1 / 3;
+	a:hover { color: blue; }
+}
+`)}}
+	css, _, err := CompileScss(srcFS, "a.scss", "", "", 0)
+	if err != nil {
+		t.Fatalf("CompileScss() error = %v", err)
+	}
+	want := `.a {
+  font-family: serif;
+  font-size: 5px;
+  border: 1px solid;
+  border-left-width: 5px;
+  height: 2.5;
+  margin: 5;
+  font: 12px/1.5 serif;
+  grid-area: 1 / 3;
+}
+
+.a a:hover {
+  color: blue;
+}
+`
+	if string(css) != want {
+		t.Fatalf("CompileScss() css =\n%s\nwant:\n%s", css, want)
+	}
+
+	for _, source := range []string{
+		`$x: 10px; a { width: $x / auto; }`,
+		`$x: 10px; a { width: 2 / $x; }`,
+		`a { font: { $size: 2px; } }`,
+	} {
+		unsupported := fstest.MapFS{"a.scss": {Data: []byte(source)}}
+		if _, _, err := CompileScss(unsupported, "a.scss", "", "", 0); err == nil {
+			t.Fatalf("CompileScss(%q) succeeded", source)
+		}
+	}
+}
+
+func TestCompileScssSassBinTimeout(t *testing.T) {
+	sourceDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(sourceDir, "a.scss"), []byte("a { color: red; }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// a sass binary that hangs:
+	sassBin := filepath.Join(t.TempDir(), "sass")
+	if err := os.WriteFile(sassBin, []byte("#!/bin/sh\nsleep 10\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, _, err := CompileScss(os.DirFS(sourceDir), "a.scss", sassBin, sourceDir, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "processors.timeout") {
+		t.Fatalf("CompileScss() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("CompileScss() took %s", elapsed)
+	}
+}
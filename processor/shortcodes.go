@@ -0,0 +1,372 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	gohtml "html"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"alexi.ch/pcms/model"
+	"github.com/flosch/pongo2/v6"
+)
+
+// shortcodeTemplateDir is the folder in the template_dir that holds the
+// shortcode templates.
+const shortcodeTemplateDir = "shortcodes"
+
+// Shortcode is a shortcode call, available to its template as `shortcode`.
+// Shortcodes are template snippets called from Markdown content, instead of raw
+// pongo2 tags:
+//
+//	{{< figure src="photo.jpg" width=600 caption="A photo" >}}
+//
+//	{{< note type="warning" >}}
+//	Some **Markdown** content.
+//	{{< /note >}}
+//
+// A shortcode is rendered by the template shortcodes/<name>.html in the
+// template_dir, with the page's template context and a `shortcode` variable (see
+// Shortcode). A shortcode is paired if a closing {{< /name >}} follows it; its
+// content is rendered like the page content, so it can contain Markdown and other
+// shortcodes. {{< name />}} is never paired. {{</* name */>}} is not called, but
+// shown as {{< name >}}.
+//
+// Shortcodes are cut out of the source before it is processed as pongo2 template,
+// so their arguments are plain strings.
+type Shortcode struct {
+	// Name of the shortcode, e.g. "figure"
+	Name string
+	// Params are the named arguments, e.g. src="photo.jpg" or width=600
+	Params map[string]string
+	// Args are the positional arguments, in their order
+	Args []string
+	// Paired is true if the shortcode has a closing tag
+	Paired bool
+	// Inner is the content of a paired shortcode, rendered to HTML. The <p>
+	// around a content of a single paragraph is removed.
+	Inner string
+	// InnerSource is the content of a paired shortcode, as it is in the source
+	InnerSource string
+}
+
+// shortcodeTagPattern matches a shortcode tag: {{< name args >}}, {{< /name >}},
+// {{< name args />}}, and the escaped {{</* name args */>}}.
+var shortcodeTagPattern = regexp.MustCompile(`(?s)\{\{<(/\*)?(.*?)(?:\*/)?>\}\}`)
+
+// shortcodeArgPattern matches a single shortcode argument: key=value,
+// key="quoted value", "quoted value" or value.
+var shortcodeArgPattern = regexp.MustCompile(`^(?:([A-Za-z_][\w-]*)=)?(?:"((?:[^"\\]|\\.)*)"|'([^']*)'|([^\s"']\S*))`)
+
+var shortcodeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(?:/[A-Za-z0-9_-]+)*$`)
+
+// shortcodePlaceholderPattern matches the placeholders the shortcodes are
+// replaced with while the Markdown is rendered: as paragraph of its own, or
+// inline. The placeholders are plain words, so they survive Markdown rendering
+// and code highlighting unchanged.
+var shortcodePlaceholderPattern = regexp.MustCompile(`<p>PCMSSHORTCODE(\d+)X</p>\n?|PCMSSHORTCODE(\d+)X`)
+
+func shortcodePlaceholder(index int) string {
+	return fmt.Sprintf("PCMSSHORTCODE%dX", index)
+}
+
+// shortcodeTag is a parsed shortcode tag in the source.
+type shortcodeTag struct {
+	start, end int
+	name       string
+	closing    bool
+	selfClosed bool
+	// escaped tags are shown literally, as text
+	escaped bool
+	literal string
+	params  map[string]string
+	args    []string
+}
+
+// shortcodeCall is a shortcode found in the source, or an escaped tag.
+type shortcodeCall struct {
+	Shortcode
+	escaped bool
+	literal string
+}
+
+// parseShortcodeTags finds all shortcode tags in the source.
+func parseShortcodeTags(source string) ([]shortcodeTag, error) {
+	var tags []shortcodeTag
+	for _, match := range shortcodeTagPattern.FindAllStringSubmatchIndex(source, -1) {
+		tag := shortcodeTag{start: match[0], end: match[1]}
+		if match[2] >= 0 {
+			tag.escaped = true
+			tag.literal = "{{<" + source[match[4]:match[5]] + ">}}"
+			tags = append(tags, tag)
+			continue
+		}
+		body := strings.TrimSpace(source[match[4]:match[5]])
+		if rest, ok := strings.CutPrefix(body, "/"); ok {
+			tag.closing = true
+			body = strings.TrimSpace(rest)
+		} else {
+			body, tag.selfClosed = cutSelfClosing(body)
+		}
+		tag.name = body
+		if i := strings.IndexAny(body, " \t\r\n"); i >= 0 {
+			tag.name, body = body[:i], body[i:]
+		} else {
+			body = ""
+		}
+		if !shortcodeNamePattern.MatchString(tag.name) {
+			return nil, fmt.Errorf("shortcode %s: invalid name %q", source[match[0]:match[1]], tag.name)
+		}
+		if tag.closing {
+			if strings.TrimSpace(body) != "" {
+				return nil, fmt.Errorf("shortcode %s: closing tag with arguments", source[match[0]:match[1]])
+			}
+			tags = append(tags, tag)
+			continue
+		}
+		params, args, err := parseShortcodeArgs(body)
+		if err != nil {
+			return nil, fmt.Errorf("shortcode %s: %w", source[match[0]:match[1]], err)
+		}
+		tag.params, tag.args = params, args
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// cutSelfClosing removes the "/" of a self-closing tag from the end of the tag
+// body. A slash at the end of an unquoted argument belongs to the argument.
+func cutSelfClosing(body string) (string, bool) {
+	rest, ok := strings.CutSuffix(body, "/")
+	if !ok {
+		return body, false
+	}
+	trimmed := strings.TrimRight(rest, " \t\r\n")
+	if trimmed != rest || strings.HasSuffix(rest, `"`) || strings.HasSuffix(rest, "'") || !strings.ContainsAny(rest, " \t\r\n") {
+		return trimmed, true
+	}
+	return body, false
+}
+
+// parseShortcodeArgs parses the arguments of a shortcode tag into the named
+// and positional arguments.
+func parseShortcodeArgs(body string) (map[string]string, []string, error) {
+	params := map[string]string{}
+	var args []string
+	body = strings.TrimSpace(body)
+	for body != "" {
+		match := shortcodeArgPattern.FindStringSubmatchIndex(body)
+		if match == nil {
+			return nil, nil, fmt.Errorf("invalid argument %q", body)
+		}
+		var value string
+		switch {
+		case match[4] >= 0:
+			unquoted, err := strconv.Unquote(`"` + body[match[4]:match[5]] + `"`)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid argument %q: %w", body[match[0]:match[1]], err)
+			}
+			value = unquoted
+		case match[6] >= 0:
+			value = body[match[6]:match[7]]
+		default:
+			value = body[match[8]:match[9]]
+			// an unquoted positional argument with "=" is a broken named one:
+			if match[2] < 0 && strings.Contains(value, "=") {
+				return nil, nil, fmt.Errorf("invalid argument %q", value)
+			}
+		}
+		if match[2] >= 0 {
+			params[body[match[2]:match[3]]] = value
+		} else {
+			args = append(args, value)
+		}
+		rest := body[match[1]:]
+		if rest != "" && rest[0] != ' ' && rest[0] != '\t' && rest[0] != '\n' && rest[0] != '\r' {
+			return nil, nil, fmt.Errorf("invalid argument %q", body)
+		}
+		body = strings.TrimSpace(rest)
+	}
+	return params, args, nil
+}
+
+// extractShortcodes replaces the top level shortcodes in the source with
+// placeholders, and returns the source and the shortcodes, by placeholder
+// index. The content of paired shortcodes is kept in their InnerSource.
+func extractShortcodes(source string) (string, []shortcodeCall, error) {
+	tags, err := parseShortcodeTags(source)
+	if err != nil {
+		return "", nil, err
+	}
+	var (
+		out   strings.Builder
+		calls []shortcodeCall
+		pos   int
+	)
+	for i := 0; i < len(tags); i++ {
+		tag := tags[i]
+		out.WriteString(source[pos:tag.start])
+		out.WriteString(shortcodePlaceholder(len(calls)))
+		pos = tag.end
+
+		if tag.escaped {
+			calls = append(calls, shortcodeCall{escaped: true, literal: tag.literal})
+			continue
+		}
+		if tag.closing {
+			return "", nil, fmt.Errorf("shortcode {{< /%s >}} closes no open shortcode", tag.name)
+		}
+		call := shortcodeCall{Shortcode: Shortcode{Name: tag.name, Params: tag.params, Args: tag.args}}
+		if !tag.selfClosed {
+			if closing := matchingShortcodeClose(tags, i); closing >= 0 {
+				call.Paired = true
+				call.InnerSource = source[tag.end:tags[closing].start]
+				pos = tags[closing].end
+				i = closing
+			}
+		}
+		calls = append(calls, call)
+	}
+	out.WriteString(source[pos:])
+	return out.String(), calls, nil
+}
+
+// matchingShortcodeClose returns the index of the closing tag of the tag at
+// index open, or -1 if there is none. Shortcodes of the same name may be nested.
+func matchingShortcodeClose(tags []shortcodeTag, open int) int {
+	depth := 0
+	for i := open + 1; i < len(tags); i++ {
+		tag := tags[i]
+		if tag.escaped || tag.name != tags[open].name {
+			continue
+		}
+		switch {
+		case tag.closing && depth == 0:
+			return i
+		case tag.closing:
+			depth--
+		case !tag.selfClosed:
+			depth++
+		}
+	}
+	return -1
+}
+
+// renderMarkdownContent renders the Markdown content of a page, or of a paired
+// shortcode, to HTML: the shortcodes are cut out, the rest is processed as
+// pongo2 template and converted to HTML, then the rendered shortcodes are put
+// in place.
+func renderMarkdownContent(source string, context pongo2.Context, templateSet *templateSet, opts model.MarkdownConfig) (string, []*TocEntry, error) {
+	source, calls, err := extractShortcodes(source)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mdTemplate, err := templateSet.FromString(source)
+	if err != nil {
+		return "", nil, err
+	}
+	source, err = templateSet.Execute(mdTemplate, context)
+	if err != nil {
+		return "", nil, err
+	}
+	htmlString, toc, err := renderMarkdown(source, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(calls) == 0 {
+		return htmlString, toc, nil
+	}
+
+	rendered := make([]string, len(calls))
+	for i, call := range calls {
+		if call.escaped {
+			rendered[i] = gohtml.EscapeString(call.literal)
+			continue
+		}
+		rendered[i], err = renderShortcode(call.Shortcode, context, templateSet, opts)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	htmlString = shortcodePlaceholderPattern.ReplaceAllStringFunc(htmlString, func(placeholder string) string {
+		match := shortcodePlaceholderPattern.FindStringSubmatch(placeholder)
+		index, _ := strconv.Atoi(match[1] + match[2])
+		if index >= len(calls) {
+			return placeholder
+		}
+		// escaped tags are text, and keep their paragraph:
+		if match[1] != "" && calls[index].escaped {
+			return "<p>" + rendered[index] + "</p>\n"
+		}
+		if match[1] != "" {
+			return rendered[index] + "\n"
+		}
+		return rendered[index]
+	})
+	return htmlString, toc, nil
+}
+
+// renderShortcode renders a shortcode with its template, or with the built-in
+// template of the same name.
+func renderShortcode(shortcode Shortcode, context pongo2.Context, templateSet *templateSet, opts model.MarkdownConfig) (string, error) {
+	if shortcode.Paired {
+		inner, _, err := renderMarkdownContent(shortcode.InnerSource, context, templateSet, opts)
+		if err != nil {
+			return "", fmt.Errorf("shortcode %s: %w", shortcode.Name, err)
+		}
+		shortcode.Inner = unwrapParagraph(inner)
+	}
+
+	tpl, err := templateSet.FromFile(path.Join(shortcodeTemplateDir, shortcode.Name+".html"))
+	var pongoErr *pongo2.Error
+	if errors.As(err, &pongoErr) && pongoErr.Sender == "fromfile" {
+		builtin, ok := builtinShortcodes[shortcode.Name]
+		if !ok {
+			return "", fmt.Errorf("shortcode %s: no template %s/%s.html found", shortcode.Name, shortcodeTemplateDir, shortcode.Name)
+		}
+		tpl, err = templateSet.FromString(builtin)
+	}
+	if err != nil {
+		return "", fmt.Errorf("shortcode %s: %w", shortcode.Name, err)
+	}
+
+	shortcodeContext := pongo2.Context{}
+	shortcodeContext.Update(context)
+	shortcodeContext["shortcode"] = shortcode
+	out, err := templateSet.Execute(tpl, shortcodeContext)
+	if err != nil {
+		return "", fmt.Errorf("shortcode %s: %w", shortcode.Name, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// unwrapParagraph removes the <p> around HTML that is a single paragraph.
+func unwrapParagraph(html string) string {
+	trimmed := strings.TrimSpace(html)
+	inner, ok := strings.CutPrefix(trimmed, "<p>")
+	if !ok {
+		return trimmed
+	}
+	inner, ok = strings.CutSuffix(inner, "</p>")
+	if !ok || strings.Contains(inner, "<p>") || strings.Contains(inner, "</p>") {
+		return trimmed
+	}
+	return inner
+}
+
+// builtinShortcodes are the templates of the shortcodes that are available
+// without a template in the template_dir. A template of the same name in the
+// template_dir replaces them.
+var builtinShortcodes = map[string]string{
+	// figure shows an image with a caption: the paired content, or the caption
+	// param. The image is resized by the image resizer if one of its params is
+	// given, e.g. width=600 or width=400 height=300 fit=cover.
+	"figure": `<figure{% if shortcode.Params.class %} class="{{ shortcode.Params.class }}"{% endif %}>
+{% if shortcode.Params.link %}<a href="{{ shortcode.Params.link }}">{% endif %}<img src="{{ ImageResizerUrl(shortcode.Params.src, shortcode.Params) }}" alt="{{ shortcode.Params.alt|default:shortcode.Params.caption }}"{% if shortcode.Params.title %} title="{{ shortcode.Params.title }}"{% endif %}>{% if shortcode.Params.link %}</a>{% endif %}
+{% if shortcode.Inner %}<figcaption>{{ shortcode.Inner|safe }}</figcaption>
+{% elif shortcode.Params.caption %}<figcaption>{{ shortcode.Params.caption }}</figcaption>
+{% endif %}</figure>`,
+}
@@ -0,0 +1,106 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"alexi.ch/pcms/model"
+	"github.com/flosch/pongo2/v6"
+)
+
+func TestRenderShortcodes(t *testing.T) {
+	templateDir := t.TempDir()
+	templates := map[string]string{
+		"note.html":      `<div class="note {{ shortcode.Params.type }}">{{ shortcode.Inner|safe }}</div>`,
+		"args.html":      `{{ shortcode.Args|join:"|" }}/{{ shortcode.Params.b }}/{{ Title }}`,
+		"docs/link.html": `<a href="{{ shortcode.Args.0 }}">{{ shortcode.Inner|safe }}</a>`,
+		"uses-page.html": `{{ ImageResizerUrl("a.jpg", "width:10") }}`,
+	}
+	for name, content := range templates {
+		file := filepath.Join(templateDir, shortcodeTemplateDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	templateSet := &templateSet{shared: newSharedTemplates(pongo2.MustNewLocalFileSystemLoader(templateDir))}
+	context := pongo2.Context{
+		"Title":           "Page 1",
+		"ImageResizerUrl": imageResizerUrlFunc("/site", "/blog/post"),
+	}
+	opts, err := markdownOptions(model.Config{}, nil)
+	if err != nil {
+		t.Fatalf("markdownOptions() error = %v", err)
+	}
+
+	source := `# Shortcodes of {{ Title }}
+
+{{< figure src="photo.jpg" width=400 height=300 fit=cover caption="A \"nice\" photo" >}}
+
+{{< note type="warning" >}}
+Some **bold** {{< docs/link "/about" >}}link{{< /docs/link >}}.
+
+{{< note >}}nested{{< /note >}}
+{{< /note >}}
+
+Inline {{< args one "two words" b='x y' />}} and {{< uses-page >}}, shown as {{</*/* note */*/>}}.
+
+` + "```\n{{</* figure src=\"a.jpg\" */>}}\n```\n"
+
+	out, toc, err := renderMarkdownContent(source, context, templateSet, opts)
+	if err != nil {
+		t.Fatalf("renderMarkdownContent() error = %v", err)
+	}
+	for _, want := range []string{
+		`<h1 id="shortcodes-of-page-1">Shortcodes of Page 1</h1>`,
+		"<figure>\n<img src=\"/site/_imageResizer/width:400,height:300,fit:cover/blog/post/photo.jpg\" alt=\"A &quot;nice&quot; photo\">\n<figcaption>A &quot;nice&quot; photo</figcaption>\n</figure>\n<div class=\"note warning\">",
+		"<p>Some <strong>bold</strong> <a href=\"/about\">link</a>.</p>\n<div class=\"note \">nested</div></div>\n<p>Inline",
+		`<p>Inline one|two words/x y/Page 1 and /site/_imageResizer/width:10/blog/post/a.jpg, shown as {{&lt;/* note */&gt;}}.</p>`,
+		`<pre><code>{{&lt; figure src=&#34;a.jpg&#34; &gt;}}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("renderMarkdownContent() = %s\nwant it to contain %s", out, want)
+		}
+	}
+	if strings.Contains(out, "PCMSSHORTCODE") || strings.Contains(out, "<p><figure>") {
+		t.Fatalf("renderMarkdownContent() = %s", out)
+	}
+	if len(toc) != 1 || toc[0].Title != "Shortcodes of Page 1" {
+		t.Fatalf("renderMarkdownContent() toc = %v", toc)
+	}
+
+	for _, source := range []string{
+		"{{< missing >}}",
+		"{{< /note >}}",
+		"{{< note type=\"open >}}",
+		"{{< figure\" >}}",
+	} {
+		if _, _, err := renderMarkdownContent(source, context, templateSet, opts); err == nil {
+			t.Fatalf("renderMarkdownContent(%q) succeeded", source)
+		}
+	}
+}
+
+func TestImageResizerUrl(t *testing.T) {
+	resizerUrl := imageResizerUrlFunc("/", "/gallery")
+	tests := []struct {
+		src    string
+		params any
+		want   string
+	}{
+		{"/images/a.jpg", "width:400", "/_imageResizer/width:400/images/a.jpg"},
+		{"a.jpg", map[string]string{"fit": "cover", "height": "300", "width": "400", "caption": "x"}, "/_imageResizer/width:400,height:300,fit:cover/gallery/a.jpg"},
+		{"a.jpg", map[string]any{"format": "webp"}, "/_imageResizer/format:webp/gallery/a.jpg"},
+		{"a.jpg", map[string]string{"caption": "no resize params"}, "a.jpg"},
+		{"https://example.com/a.jpg", "width:400", "https://example.com/a.jpg"},
+	}
+	for _, test := range tests {
+		if got := resizerUrl(test.src, test.params); got != test.want {
+			t.Fatalf("ImageResizerUrl(%q, %v) = %q, want %q", test.src, test.params, got, test.want)
+		}
+	}
+}
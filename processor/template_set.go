@@ -0,0 +1,211 @@
+package processor
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+	"github.com/flosch/pongo2/v6"
+)
+
+// recordingLoader wraps a pongo2 template loader, and reports the template
+// files it loads from disk to the shared templates. Templates from the embedded
+// doc FS never change, so they are not reported.
+type recordingLoader struct {
+	pongo2.TemplateLoader
+	templates *sharedTemplates
+}
+
+func (l recordingLoader) Get(path string) (io.Reader, error) {
+	reader, err := l.TemplateLoader.Get(path)
+	if err == nil && filepath.IsAbs(path) {
+		l.templates.recordLoad(path)
+	}
+	return reader, err
+}
+
+// sharedTemplates is the pongo2 template set all page renders of a run share.
+// It finds templates like pongo2.DefaultSet does, caches the parsed template
+// files, and knows the files each template was parsed from.
+type sharedTemplates struct {
+	set *pongo2.TemplateSet
+	// number of model.TemplateLoaders the set was created with
+	numLoaders int
+
+	// parseMu serializes the parsing of templates, so that the files the
+	// loaders read can be attributed to the template being parsed.
+	parseMu sync.Mutex
+
+	mu sync.Mutex
+	// files read by the current parse, nil outside of a parse
+	parsing *[]string
+	// files read outside of a parse: includes with a file name from a
+	// variable, loaded when the template is executed. One that is read while
+	// another template is parsed counts as a file of that template instead.
+	lazy  map[string]bool
+	cache map[string]cachedTemplate
+}
+
+// cachedTemplate is a parsed template file, with the files it was parsed from.
+type cachedTemplate struct {
+	tpl      *pongo2.Template
+	files    []string
+	parsedAt time.Time
+}
+
+var (
+	sharedTemplatesMu sync.Mutex
+	currentTemplates  *sharedTemplates
+)
+
+// getSharedTemplates returns the shared templates of the run. They are created
+// on first use, and again after new template loaders were configured.
+func getSharedTemplates() *sharedTemplates {
+	sharedTemplatesMu.Lock()
+	defer sharedTemplatesMu.Unlock()
+	if currentTemplates == nil || currentTemplates.numLoaders != len(model.TemplateLoaders) {
+		currentTemplates = newSharedTemplates(model.TemplateLoaders...)
+	}
+	return currentTemplates
+}
+
+// newSharedTemplates creates a template set that finds templates with the
+// given loaders, in lookup order.
+func newSharedTemplates(loaders ...pongo2.TemplateLoader) *sharedTemplates {
+	templates := &sharedTemplates{
+		numLoaders: len(loaders),
+		lazy:       make(map[string]bool),
+		cache:      make(map[string]cachedTemplate),
+	}
+	recordingLoaders := make([]pongo2.TemplateLoader, 0, len(loaders))
+	for _, loader := range loaders {
+		recordingLoaders = append(recordingLoaders, recordingLoader{TemplateLoader: loader, templates: templates})
+	}
+	templates.set = pongo2.NewSet("pages", recordingLoaders...)
+	return templates
+}
+
+// InvalidateTemplates drops the shared templates with all parsed template
+// files, e.g. after a template change. The next render parses them again.
+func InvalidateTemplates() {
+	sharedTemplatesMu.Lock()
+	defer sharedTemplatesMu.Unlock()
+	currentTemplates = nil
+}
+
+func (t *sharedTemplates) recordLoad(path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.parsing != nil {
+		*t.parsing = append(*t.parsing, path)
+	} else {
+		t.lazy[path] = true
+	}
+}
+
+// parse runs parseFn, and returns the template with the files it was parsed from.
+func (t *sharedTemplates) parse(parseFn func() (*pongo2.Template, error)) (cachedTemplate, error) {
+	t.parseMu.Lock()
+	defer t.parseMu.Unlock()
+
+	entry := cachedTemplate{files: make([]string, 0), parsedAt: time.Now()}
+	t.mu.Lock()
+	t.parsing = &entry.files
+	t.mu.Unlock()
+	tpl, err := parseFn()
+	t.mu.Lock()
+	t.parsing = nil
+	t.mu.Unlock()
+
+	entry.tpl = tpl
+	return entry, err
+}
+
+func (t *sharedTemplates) lazyFiles() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	files := make([]string, 0, len(t.lazy))
+	for path := range t.lazy {
+		files = append(files, path)
+	}
+	return files
+}
+
+// outdated reports whether one of the files of the template has changed since
+// it was parsed.
+func (c cachedTemplate) outdated() bool {
+	for _, path := range c.files {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(c.parsedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// templateSet is the view of a single page render on the shared templates: it
+// records the template files the render uses in deps.
+type templateSet struct {
+	shared *sharedTemplates
+	deps   *lib.DependencyRecorder
+}
+
+// newTemplateSet creates the template set of a single page render, which
+// records the templates it uses in deps (nil records nothing).
+func newTemplateSet(deps *lib.DependencyRecorder) *templateSet {
+	return &templateSet{shared: getSharedTemplates(), deps: deps}
+}
+
+// FromFile returns the parsed template file: from the cache, unless one of its
+// files has changed since it was parsed.
+func (s *templateSet) FromFile(name string) (*pongo2.Template, error) {
+	s.shared.mu.Lock()
+	entry, found := s.shared.cache[name]
+	s.shared.mu.Unlock()
+
+	if !found || entry.outdated() {
+		var err error
+		entry, err = s.shared.parse(func() (*pongo2.Template, error) {
+			return s.shared.set.FromFile(name)
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.shared.mu.Lock()
+		s.shared.cache[name] = entry
+		s.shared.mu.Unlock()
+	}
+	s.record(entry.files)
+	return entry.tpl, nil
+}
+
+// FromString parses a template source, e.g. the content of a page.
+func (s *templateSet) FromString(source string) (*pongo2.Template, error) {
+	entry, err := s.shared.parse(func() (*pongo2.Template, error) {
+		return s.shared.set.FromString(source)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.record(entry.files)
+	return entry.tpl, nil
+}
+
+// Execute renders a template of the set. The files it includes by a variable
+// name are only known after it ran: the render depends on all files ever
+// included that way.
+func (s *templateSet) Execute(tpl *pongo2.Template, context pongo2.Context) (string, error) {
+	out, err := tpl.Execute(context)
+	s.record(s.shared.lazyFiles())
+	return out, err
+}
+
+func (s *templateSet) record(files []string) {
+	for _, path := range files {
+		s.deps.RecordTemplate(path)
+	}
+}
@@ -0,0 +1,72 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"alexi.ch/pcms/lib"
+	"github.com/flosch/pongo2/v6"
+)
+
+func TestTemplateSet(t *testing.T) {
+	templateDir := t.TempDir()
+	templates := map[string]string{
+		"base.html":    "<main>{% block content %}{% endblock %}</main>",
+		"page.html":    `{% extends "base.html" %}{% block content %}{{ content }}{% endblock %}`,
+		"part.html":    "part",
+		"dynamic.html": "dynamic",
+	}
+	for name, content := range templates {
+		if err := os.WriteFile(filepath.Join(templateDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shared := newSharedTemplates(pongo2.MustNewLocalFileSystemLoader(templateDir))
+
+	// a template file is parsed once, and every render records its files:
+	deps := lib.NewDependencyRecorder()
+	first, err := (&templateSet{shared: shared, deps: deps}).FromFile("page.html")
+	if err != nil {
+		t.Fatalf("FromFile() error = %v", err)
+	}
+	otherDeps := lib.NewDependencyRecorder()
+	second, err := (&templateSet{shared: shared, deps: otherDeps}).FromFile("page.html")
+	if err != nil {
+		t.Fatalf("FromFile() error = %v", err)
+	}
+	if first != second {
+		t.Fatalf("FromFile() parsed the template file again")
+	}
+	for _, recorded := range []lib.RenderDependencies{deps.Dependencies(), otherDeps.Dependencies()} {
+		if len(recorded.Templates) != 2 {
+			t.Fatalf("recorded templates = %v, want page.html and base.html", recorded.Templates)
+		}
+	}
+
+	// a changed file of the template is parsed again:
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(templateDir, "base.html"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if third, err := (&templateSet{shared: shared}).FromFile("page.html"); err != nil || third == first {
+		t.Fatalf("FromFile() = %p, %v, want a new template after a change", third, err)
+	}
+
+	// template sources record their includes, and includes by a variable name
+	// are recorded when the template runs:
+	deps = lib.NewDependencyRecorder()
+	set := &templateSet{shared: shared, deps: deps}
+	tpl, err := set.FromString(`{% include "part.html" %} {% include name %}`)
+	if err != nil {
+		t.Fatalf("FromString() error = %v", err)
+	}
+	out, err := set.Execute(tpl, pongo2.Context{"name": "dynamic.html"})
+	if err != nil || out != "part dynamic" {
+		t.Fatalf("Execute() = %q, %v", out, err)
+	}
+	if recorded := deps.Dependencies(); len(recorded.Templates) != 2 {
+		t.Fatalf("recorded templates = %v, want part.html and dynamic.html", recorded.Templates)
+	}
+}
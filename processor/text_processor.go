@@ -0,0 +1,67 @@
+package processor
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+	"alexi.ch/pcms/stdlib"
+)
+
+/*
+The TextProcessor processes plain text (.txt) files.
+
+The text is no template: it is HTML-escaped, and each block of lines separated
+by empty lines becomes a paragraph. The paragraphs are injected to the template
+defined as `template` key in the YAML front matter, as `content` variable, or
+are rendered as they are.
+
+Example:
+
+	---
+	title: Release notes
+	template: base-text.html
+	---
+	Version 1.2 fixes the <br> tags in titles.
+
+	Thanks to everyone who reported it!
+*/
+type TextProcessor struct {
+}
+
+var blankLinePattern = regexp.MustCompile(`\n[ \t]*\n`)
+
+func (p TextProcessor) RenderFileForServe(siteFS fs.FS, sourceFSPath string, sourceFile string, config model.Config, pageInfo PageInfo, deps *lib.DependencyRecorder) ([]byte, error) {
+	sourceBytes, err := fs.ReadFile(siteFS, sourceFSPath)
+	if err != nil {
+		return nil, fmt.Errorf("read text source %s: %w", sourceFSPath, err)
+	}
+	metadata, body, err := stdlib.ExtractYamlFrontMatter(string(sourceBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	context, err := prepareTemplateContext(config, pageInfo, deps)
+	if err != nil {
+		return nil, err
+	}
+	return renderContentTemplate(newTemplateSet(deps), context, metadata, textToHTML(body))
+}
+
+// textToHTML converts plain text to HTML paragraphs.
+func textToHTML(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var out strings.Builder
+	for _, paragraph := range blankLinePattern.Split(text, -1) {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		fmt.Fprintf(&out, "<p>%s</p>\n", html.EscapeString(paragraph))
+	}
+	return out.String()
+}
@@ -0,0 +1,30 @@
+package stdlib
+
+import "time"
+
+// dateLayouts are the date formats accepted in front matter, most specific first.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Parses a front matter date value: a time.Time, or a string in RFC3339 format
+// ("2024-03-01T10:00:00+01:00") or a shorter form ("2024-03-01 10:00",
+// "2024-03-01"). Dates without a time zone are UTC.
+// Returns false if the value is no date.
+func ParseDate(value any) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
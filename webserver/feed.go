@@ -0,0 +1,463 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+	"alexi.ch/pcms/processor"
+	"alexi.ch/pcms/stdlib"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	atomFeedName = "atom.xml"
+	rssFeedName  = "rss.xml"
+	jsonFeedName = "feed.json"
+
+	defaultFeedLimit     = 20
+	defaultFeedDateField = "date"
+	// max. length of an entry summary taken from the page text, in runes
+	feedSummaryLength = 300
+)
+
+// FeedNames lists the file names of the feeds below the route of a feed page.
+var FeedNames = []string{atomFeedName, rssFeedName, jsonFeedName}
+
+var feedContentTypes = map[string]string{
+	atomFeedName: "application/atom+xml; charset=utf-8",
+	rssFeedName:  "application/rss+xml; charset=utf-8",
+	jsonFeedName: "application/feed+json; charset=utf-8",
+}
+
+// feed is the format-independent content of a feed, with absolute URLs.
+type feed struct {
+	Title       string
+	Description string
+	Author      string
+	PageURL     string
+	FeedURL     string
+	Updated     time.Time
+	Entries     []feedEntry
+}
+
+type feedEntry struct {
+	Title     string
+	URL       string
+	Summary   string
+	Author    string
+	Published time.Time
+	Updated   time.Time
+}
+
+// serveFeed serves the feed at route in the given language, if route names a
+// feed of an enabled feed page. Returns false if it does not.
+func (h *RequestHandler) serveFeed(w http.ResponseWriter, req *http.Request, route string, language string) bool {
+	content, contentType, found, err := h.RenderFeed(route, language, h.ServerConfig.Server.BaseURL)
+	if err != nil {
+		h.errorHandler(w, err, http.StatusInternalServerError)
+		return true
+	}
+	if !found {
+		return false
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, req, path.Base(route), time.Time{}, bytes.NewReader(content))
+	return true
+}
+
+// RenderFeed renders the feed at the given route, e.g. "/blog/atom.xml", and
+// returns it with its content type. baseURL ("https://example.com") is prepended
+// to all URLs in the feed, as feed readers need absolute URLs. found is false if
+// the route does not name a feed of a visible feed page.
+//
+// With a language, the feed is the one below the language prefix
+// ("/de/blog/atom.xml"): the feed page and its entries come with the front
+// matter of their language variants, and link below the prefix. Without, the
+// pages are taken as they are.
+func (h *RequestHandler) RenderFeed(route string, language string, baseURL string) (content []byte, contentType string, found bool, err error) {
+	name := path.Base(route)
+	contentType, ok := feedContentTypes[name]
+	if !ok {
+		return nil, "", false, nil
+	}
+
+	page, found, err := h.DBH.GetPageVariant(path.Dir(route), language)
+	if err != nil || !found || !page.IsVisibleAt(time.Now()) {
+		return nil, "", false, err
+	}
+	feedConfig, ok, err := pageFeedConfig(h.ServerConfig, page)
+	if err != nil || !ok {
+		return nil, "", false, err
+	}
+
+	f, err := h.buildFeed(page, feedConfig, language, baseURL)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("build feed %s: %w", route, err)
+	}
+	f.FeedURL = absoluteURL(baseURL, processor.AbsUrl(path.Join("/", language, route), h.ServerConfig.Server.Prefix))
+
+	switch name {
+	case atomFeedName:
+		content, err = encodeAtomFeed(f)
+	case rssFeedName:
+		content, err = encodeRSSFeed(f)
+	default:
+		content, err = encodeJSONFeed(f)
+	}
+	if err != nil {
+		return nil, "", false, fmt.Errorf("encode feed %s: %w", route, err)
+	}
+	return content, contentType, true, nil
+}
+
+// pageFeedConfig returns the feed configuration of a page: the "feed" front
+// matter property (a map, or true for the defaults) takes precedence over the
+// "feeds" entry in pcms-config.yaml.
+func pageFeedConfig(config model.Config, page model.IndexedPage) (model.FeedConfig, bool, error) {
+	switch value := page.Metadata["feed"].(type) {
+	case bool:
+		return model.FeedConfig{}, value, nil
+	case map[string]any:
+		// the front matter is already parsed, so decode the map via YAML once more:
+		raw, err := yaml.Marshal(value)
+		if err != nil {
+			return model.FeedConfig{}, false, err
+		}
+		var feedConfig model.FeedConfig
+		if err := yaml.Unmarshal(raw, &feedConfig); err != nil {
+			return model.FeedConfig{}, false, fmt.Errorf("invalid feed front matter in page %s: %w", page.Route, err)
+		}
+		return feedConfig, true, nil
+	}
+	feedConfig, ok := config.Feeds[page.Route]
+	return feedConfig, ok, nil
+}
+
+// buildFeed queries the entries of a feed page, in the given language.
+func (h *RequestHandler) buildFeed(page model.IndexedPage, feedConfig model.FeedConfig, language string, baseURL string) (feed, error) {
+	dateField := feedConfig.DateField
+	if dateField == "" {
+		dateField = defaultFeedDateField
+	}
+	orderBy := feedConfig.OrderBy
+	if orderBy == "" {
+		orderBy = dateField
+	}
+	order := feedConfig.Order
+	if order == "" {
+		order = "desc"
+	}
+	limit := feedConfig.Limit
+	if limit <= 0 {
+		limit = defaultFeedLimit
+	}
+
+	qb := lib.NewPageQueryBuilder(h.DBH).Language(language)
+	if feedConfig.Source != "" {
+		qb = qb.WhereRoute(feedConfig.Source)
+	} else {
+		qb = qb.WhereParentRoute(page.Route)
+	}
+	// one more than needed, as a source pattern may include the feed page itself:
+	pages := qb.OrderBy(orderBy, order).OrderBy("route", "asc").PageSize(limit + 1).FetchAll()
+
+	f := feed{
+		Title:       feedConfig.Title,
+		Description: feedConfig.Description,
+		Author:      feedConfig.Author,
+		PageURL:     h.pageURL(baseURL, path.Join("/", language, page.Route)),
+		Updated:     page.UpdatedAt,
+		Entries:     make([]feedEntry, 0, len(pages)),
+	}
+	if f.Title == "" {
+		f.Title = page.Title
+	}
+	if f.Description == "" {
+		f.Description = metadataString(page.Metadata, "description")
+	}
+
+	for _, p := range pages {
+		if p.Route == page.Route {
+			continue
+		}
+		if len(f.Entries) == limit {
+			break
+		}
+		entry := feedEntry{
+			Title:     p.Title,
+			URL:       h.pageURL(baseURL, path.Join("/", language, p.Route)),
+			Summary:   metadataString(p.Metadata, "description", "summary"),
+			Author:    metadataString(p.Metadata, "author"),
+			Published: p.UpdatedAt,
+			Updated:   p.UpdatedAt,
+		}
+		if published, ok := stdlib.ParseDate(p.Metadata[dateField]); ok {
+			entry.Published = published
+			if published.After(entry.Updated) {
+				entry.Updated = published
+			}
+		}
+		if entry.Summary == "" {
+			text, err := h.DBH.GetPageText(p.Route)
+			if err != nil {
+				return feed{}, err
+			}
+			entry.Summary = truncateText(text, feedSummaryLength)
+		}
+		if entry.Updated.After(f.Updated) {
+			f.Updated = entry.Updated
+		}
+		f.Entries = append(f.Entries, entry)
+	}
+	return f, nil
+}
+
+// pageURL returns the absolute URL of a page, with the trailing slash pages are
+// served with.
+func (h *RequestHandler) pageURL(baseURL string, route string) string {
+	pageURL := processor.AbsUrl(route, h.ServerConfig.Server.Prefix)
+	if !strings.HasSuffix(pageURL, "/") {
+		pageURL += "/"
+	}
+	return absoluteURL(baseURL, pageURL)
+}
+
+func absoluteURL(baseURL string, absPath string) string {
+	return strings.TrimSuffix(baseURL, "/") + absPath
+}
+
+// metadataString returns the first non-empty string value of the given fields.
+func metadataString(metadata map[string]any, fields ...string) string {
+	for _, field := range fields {
+		if value, ok := metadata[field].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// truncateText shortens text to at most maxLen runes, at a word boundary.
+func truncateText(text string, maxLen int) string {
+	if utf8.RuneCountInString(text) <= maxLen {
+		return text
+	}
+	runes := []rune(text)
+	truncated := string(runes[:maxLen])
+	// cut off the last word, unless it ends right at maxLen:
+	if i := strings.LastIndex(truncated, " "); i > 0 && runes[maxLen] != ' ' {
+		truncated = truncated[:i]
+	}
+	return strings.TrimRight(truncated, " .,;:") + "…"
+}
+
+// ---------- Atom ----------
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Author   *atomAuthor `xml:"author,omitempty"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Links     []atomLink  `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    *atomAuthor `xml:"author,omitempty"`
+	Summary   string      `xml:"summary,omitempty"`
+}
+
+func encodeAtomFeed(f feed) ([]byte, error) {
+	doc := atomFeed{
+		Title:    f.Title,
+		Subtitle: f.Description,
+		ID:       f.PageURL,
+		Updated:  f.Updated.UTC().Format(time.RFC3339),
+		Author:   newAtomAuthor(f.Author),
+		Links: []atomLink{
+			{Rel: "self", Type: feedContentTypes[atomFeedName], Href: f.FeedURL},
+			{Rel: "alternate", Type: "text/html", Href: f.PageURL},
+		},
+	}
+	for _, entry := range f.Entries {
+		doc.Entries = append(doc.Entries, atomEntry{
+			Title:     entry.Title,
+			ID:        entry.URL,
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: entry.URL}},
+			Published: entry.Published.UTC().Format(time.RFC3339),
+			Updated:   entry.Updated.UTC().Format(time.RFC3339),
+			Author:    newAtomAuthor(entry.Author),
+			Summary:   entry.Summary,
+		})
+	}
+	return encodeXMLDocument(doc)
+}
+
+func newAtomAuthor(name string) *atomAuthor {
+	if name == "" {
+		return nil
+	}
+	return &atomAuthor{Name: name}
+}
+
+// ---------- RSS 2.0 ----------
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	AtomLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func encodeRSSFeed(f feed) ([]byte, error) {
+	description := f.Description
+	if description == "" {
+		// required in RSS:
+		description = f.Title
+	}
+	doc := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.PageURL,
+			Description:   description,
+			AtomLink:      atomLink{Rel: "self", Type: feedContentTypes[rssFeedName], Href: f.FeedURL},
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, entry := range f.Entries {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.URL,
+			GUID:        rssGUID{IsPermaLink: "true", Value: entry.URL},
+			PubDate:     entry.Published.UTC().Format(time.RFC1123Z),
+			Description: entry.Summary,
+		})
+	}
+	return encodeXMLDocument(doc)
+}
+
+func encodeXMLDocument(doc any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// ---------- JSON Feed 1.1 ----------
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentText   string           `json:"content_text"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+}
+
+func encodeJSONFeed(f feed) ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.PageURL,
+		FeedURL:     f.FeedURL,
+		Description: f.Description,
+		Authors:     newJSONFeedAuthors(f.Author),
+		Items:       make([]jsonFeedItem, 0, len(f.Entries)),
+	}
+	for _, entry := range f.Entries {
+		doc.Items = append(doc.Items, jsonFeedItem{
+			ID:            entry.URL,
+			URL:           entry.URL,
+			Title:         entry.Title,
+			ContentText:   entry.Summary,
+			DatePublished: entry.Published.UTC().Format(time.RFC3339),
+			DateModified:  entry.Updated.UTC().Format(time.RFC3339),
+			Authors:       newJSONFeedAuthors(entry.Author),
+		})
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func newJSONFeedAuthors(name string) []jsonFeedAuthor {
+	if name == "" {
+		return nil
+	}
+	return []jsonFeedAuthor{{Name: name}}
+}
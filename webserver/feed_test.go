@@ -0,0 +1,170 @@
+package webserver
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"alexi.ch/pcms/model"
+)
+
+func setupFeedHandler(t *testing.T) *RequestHandler {
+	config := model.Config{}
+	config.Server.Prefix = "/site"
+	config.Server.BaseURL = "https://example.com/"
+	config.Feeds = map[string]model.FeedConfig{"/news": {Title: "Site news", Source: "/blog/*", OrderBy: "title", Order: "asc"}}
+	return setupTestHandler(t, config, map[string]string{
+		"index.md":            "---\ntitle: Home\n---\n",
+		"blog/index.md":       "---\ntitle: Blog\ndescription: All posts\nfeed:\n  source: /blog/*\n  limit: 2\n---\n",
+		"blog/old/index.md":   "---\ntitle: Old post\ndate: \"2024-01-01\"\n---\nAn old post.\n",
+		"blog/new/index.md":   "---\ntitle: New & shiny\ndate: \"2024-03-01T10:00:00Z\"\ndescription: <b>Fresh</b>\nauthor: Alex\n---\n",
+		"blog/mid/index.md":   "---\ntitle: Mid post\ndate: \"2024-02-01\"\n---\n",
+		"blog/draft/index.md": "---\ntitle: Draft\ndate: \"2025-01-01\"\nenabled: false\n---\n",
+		"news/index.md":       "---\ntitle: News\n---\n",
+		"plain/index.md":      "---\ntitle: Plain\n---\n",
+	})
+}
+
+func getFeed(t *testing.T, h *RequestHandler, target string, wantContentType string) []byte {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s status = %d, want %d", target, rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != wantContentType {
+		t.Fatalf("GET %s content type = %q, want %q", target, ct, wantContentType)
+	}
+	return rec.Body.Bytes()
+}
+
+func TestServeAtomFeed(t *testing.T) {
+	h := setupFeedHandler(t)
+	body := getFeed(t, h, "/blog/atom.xml", "application/atom+xml; charset=utf-8")
+
+	var doc atomFeed
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("invalid Atom XML: %v\n%s", err, body)
+	}
+	if doc.Title != "Blog" || doc.Subtitle != "All posts" || doc.ID != "https://example.com/site/blog/" {
+		t.Fatalf("feed = %+v", doc)
+	}
+	if doc.Links[0].Rel != "self" || doc.Links[0].Href != "https://example.com/site/blog/atom.xml" {
+		t.Fatalf("self link = %+v", doc.Links[0])
+	}
+	// newest first, limited to 2, without the feed page and the disabled draft:
+	if len(doc.Entries) != 2 || doc.Entries[0].Title != "New & shiny" || doc.Entries[1].Title != "Mid post" {
+		t.Fatalf("entries = %+v", doc.Entries)
+	}
+	entry := doc.Entries[0]
+	if entry.ID != "https://example.com/site/blog/new/" || entry.Published != "2024-03-01T10:00:00Z" {
+		t.Fatalf("entry = %+v", entry)
+	}
+	if entry.Summary != "<b>Fresh</b>" || entry.Author == nil || entry.Author.Name != "Alex" {
+		t.Fatalf("entry summary/author = %q / %+v", entry.Summary, entry.Author)
+	}
+}
+
+func TestServeRSSFeed(t *testing.T) {
+	h := setupFeedHandler(t)
+	body := getFeed(t, h, "/blog/rss.xml", "application/rss+xml; charset=utf-8")
+
+	if !strings.Contains(string(body), `<atom:link rel="self" type="application/rss+xml; charset=utf-8" href="https://example.com/site/blog/rss.xml">`) {
+		t.Fatalf("missing atom:link self reference:\n%s", body)
+	}
+	var doc rssFeed
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("invalid RSS XML: %v\n%s", err, body)
+	}
+	if len(doc.Channel.Items) != 2 {
+		t.Fatalf("items = %+v", doc.Channel.Items)
+	}
+	item := doc.Channel.Items[0]
+	if item.Link != "https://example.com/site/blog/new/" || item.PubDate != "Fri, 01 Mar 2024 10:00:00 +0000" {
+		t.Fatalf("item = %+v", item)
+	}
+}
+
+func TestServeJSONFeed(t *testing.T) {
+	h := setupFeedHandler(t)
+	// configured in pcms-config.yaml instead of the front matter:
+	body := getFeed(t, h, "/news/feed.json", "application/feed+json; charset=utf-8")
+
+	var doc jsonFeed
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("invalid JSON feed: %v\n%s", err, body)
+	}
+	if doc.Title != "Site news" || doc.FeedURL != "https://example.com/site/news/feed.json" || doc.HomePageURL != "https://example.com/site/news/" {
+		t.Fatalf("feed = %+v", doc)
+	}
+	// ordered by title, the page text is the fallback summary:
+	if len(doc.Items) != 4 || doc.Items[0].Title != "Blog" || doc.Items[3].Title != "Old post" {
+		t.Fatalf("items = %+v", doc.Items)
+	}
+	if doc.Items[3].ContentText != "An old post." {
+		t.Fatalf("content_text = %q", doc.Items[3].ContentText)
+	}
+}
+
+func TestServeFeedLanguages(t *testing.T) {
+	config := model.Config{Languages: []string{"en", "de"}}
+	config.Server.BaseURL = "https://example.com"
+	h := setupTestHandler(t, config, map[string]string{
+		"blog/index.md":          "---\ntitle: Blog\nfeed: true\n---\n",
+		"blog/index.de.md":       "---\ntitle: Blog (de)\nfeed: true\n---\n",
+		"blog/hello/index.md":    "---\ntitle: Hello\ndate: \"2024-03-01\"\n---\n",
+		"blog/hello/index.de.md": "---\ntitle: Hallo\ndate: \"2024-03-01\"\n---\n",
+		"blog/only/index.md":     "---\ntitle: English only\ndate: \"2024-02-01\"\n---\n",
+	})
+
+	// below a language prefix, with the variants and prefixed URLs:
+	var doc atomFeed
+	if err := xml.Unmarshal(getFeed(t, h, "/de/blog/atom.xml", "application/atom+xml; charset=utf-8"), &doc); err != nil {
+		t.Fatalf("invalid Atom XML: %v", err)
+	}
+	if doc.Title != "Blog (de)" || doc.ID != "https://example.com/de/blog/" || doc.Links[0].Href != "https://example.com/de/blog/atom.xml" {
+		t.Fatalf("feed = %+v", doc)
+	}
+	if len(doc.Entries) != 2 || doc.Entries[0].Title != "Hallo" || doc.Entries[0].ID != "https://example.com/de/blog/hello/" || doc.Entries[1].Title != "English only" {
+		t.Fatalf("entries = %+v", doc.Entries)
+	}
+
+	// without prefix, the pages as they are, whatever the Accept-Language header says:
+	req := httptest.NewRequest(http.MethodGet, "/blog/atom.xml", nil)
+	req.Header.Set("Accept-Language", "de")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	doc = atomFeed{}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid Atom XML: %v\n%s", err, rec.Body.String())
+	}
+	if doc.Title != "Blog" || doc.ID != "https://example.com/blog/" || len(doc.Entries) != 2 || doc.Entries[0].Title != "Hello" {
+		t.Fatalf("feed = %+v", doc)
+	}
+}
+
+func TestServeFeedNotFound(t *testing.T) {
+	h := setupFeedHandler(t)
+	for _, target := range []string{"/plain/atom.xml", "/blog/draft/rss.xml", "/blog/other.xml", "/missing/feed.json"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("GET %s status = %d, want %d", target, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	if got := truncateText("short", 10); got != "short" {
+		t.Fatalf("truncateText() = %q", got)
+	}
+	if got := truncateText("Hello wonderful world", 12); got != "Hello…" {
+		t.Fatalf("truncateText() = %q", got)
+	}
+	if got := truncateText("Hello wonderful world", 15); got != "Hello wonderful…" {
+		t.Fatalf("truncateText() = %q", got)
+	}
+}
@@ -1,6 +1,7 @@
 package webserver
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"alexi.ch/pcms/lib"
@@ -25,6 +27,18 @@ type RequestHandler struct {
 	DBH          *lib.DBH
 	// the site FS is the root of the served file system
 	siteFS fs.FS
+	// verifiedPages holds a pageVerification by cache file: the cached pages
+	// whose index queries are known to return the same result still.
+	verifiedPages sync.Map
+}
+
+// pageVerification records that the index queries of a cached page returned
+// the same result as at render time, at the given index generation. It holds
+// until the index changes, or until the next scheduled page is published or
+// expires (validUntil, zero if there is none).
+type pageVerification struct {
+	generation uint64
+	validUntil time.Time
 }
 
 func NewRequestHandler(
@@ -55,8 +69,16 @@ func (h *RequestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	rawRoutePath := req.URL.Path
+	language, languagePrefix, rawRoutePath := h.requestLanguage(req, req.URL.Path)
 	route := normalizeRoute(rawRoutePath)
+	if language != "" && languagePrefix == "" {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+
+	if rawRoutePath == searchRoute {
+		h.serveSearch(w, req, language, languagePrefix)
+		return
+	}
 
 	if strings.HasPrefix(rawRoutePath, imageResizerPrefix) {
 		tail := strings.TrimPrefix(rawRoutePath, imageResizerPrefix)
@@ -64,21 +86,26 @@ func (h *RequestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	page, found, err := h.DBH.GetPageByRoute(route)
+	// redirect rules and page aliases win over pages and files:
+	if h.serveRedirect(w, req, route) {
+		return
+	}
+
+	page, found, err := h.DBH.GetPageVariant(route, language)
 	if err != nil {
 		h.errorHandler(w, err, http.StatusInternalServerError)
 		return
 	}
 	if found {
-		if !page.Enabled {
+		if !page.IsVisibleAt(time.Now()) {
 			h.errorHandler(w, fmt.Errorf("not found: %s", route), http.StatusNotFound)
 			return
 		}
 		if rawRoutePath != "/" && !strings.HasSuffix(rawRoutePath, "/") {
-			http.Redirect(w, req, rawRoutePath+"/", http.StatusMovedPermanently)
+			http.Redirect(w, req, languagePrefix+rawRoutePath+"/", http.StatusMovedPermanently)
 			return
 		}
-		h.servePage(w, req, route, page)
+		h.servePage(w, req, route, page, language)
 		return
 	}
 
@@ -93,8 +120,17 @@ func (h *RequestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		h.errorHandler(w, err, http.StatusInternalServerError)
 		return
 	}
+	// .scss sources are only served compiled, by their .css route:
+	if found && processor.IsScssSource(file.FileName) {
+		found = false
+	}
 	if found {
-		if !file.Enabled {
+		visible, err := h.isFileVisible(file)
+		if err != nil {
+			h.errorHandler(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !visible {
 			h.errorHandler(w, fmt.Errorf("not found: %s", route), http.StatusNotFound)
 			return
 		}
@@ -102,9 +138,45 @@ func (h *RequestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if h.serveStylesheet(w, req, fileRoute) {
+		return
+	}
+
+	// feeds, the sitemap and robots.txt are generated, unless a real file of
+	// the same name exists. Feeds without language prefix take the pages as
+	// they are, whatever language the request negotiates:
+	feedLanguage := ""
+	if languagePrefix != "" {
+		feedLanguage = language
+	}
+	if h.serveFeed(w, req, fileRoute, feedLanguage) {
+		return
+	}
+	switch fileRoute {
+	case SitemapRoute:
+		h.serveSitemap(w, req)
+		return
+	case RobotsTxtRoute:
+		h.serveRobotsTxt(w, req)
+		return
+	}
+
 	h.errorHandler(w, fmt.Errorf("not found: %s", route), http.StatusNotFound)
 }
 
+// isFileVisible reports whether an indexed file is served right now: it must be
+// enabled, and its page must be visible (published and not expired).
+func (h *RequestHandler) isFileVisible(file model.IndexedFile) (bool, error) {
+	if !file.Enabled {
+		return false, nil
+	}
+	page, found, err := h.DBH.GetPageByRoute(file.ParentPageRoute)
+	if err != nil {
+		return false, err
+	}
+	return found && page.IsVisibleAt(time.Now()), nil
+}
+
 func normalizeRoute(rawPath string) string {
 	cleaned := path.Clean("/" + rawPath)
 	if cleaned == "." || cleaned == "" {
@@ -129,7 +201,9 @@ func routeToFSPath(route string) string {
 	return trimmed
 }
 
-func (h *RequestHandler) servePage(w http.ResponseWriter, req *http.Request, route string, page model.IndexedPage) {
+// servePage serves the rendered page, in the given language ("" on a site
+// without languages).
+func (h *RequestHandler) servePage(w http.ResponseWriter, req *http.Request, route string, page model.IndexedPage, language string) {
 	sourceFSPath := path.Clean(path.Join(strings.TrimPrefix(route, "/"), page.IndexFile))
 	if route == "/" {
 		sourceFSPath = page.IndexFile
@@ -151,6 +225,23 @@ func (h *RequestHandler) servePage(w http.ResponseWriter, req *http.Request, rou
 		h.errorHandler(w, err, http.StatusInternalServerError)
 		return
 	}
+	if reindexed && language != "" {
+		var found bool
+		page, found, err = h.DBH.GetPageVariant(route, language)
+		if err != nil {
+			h.errorHandler(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			h.errorHandler(w, fmt.Errorf("not found: %s", route), http.StatusNotFound)
+			return
+		}
+	}
+	// the refreshed front matter may have disabled or rescheduled the page:
+	if reindexed && !page.IsVisibleAt(time.Now()) {
+		h.errorHandler(w, fmt.Errorf("not found: %s", route), http.StatusNotFound)
+		return
+	}
 
 	// Build template variables with current (possibly refreshed) page data:
 	fileInfo, err := processor.BuildPageTemplateVariables(route, page.IndexFile, h.ServerConfig, page)
@@ -158,10 +249,9 @@ func (h *RequestHandler) servePage(w http.ResponseWriter, req *http.Request, rou
 		h.errorHandler(w, err, http.StatusInternalServerError)
 		return
 	}
+	fileInfo.Language = language
 
-	// Compute cache file path from route:
-	cacheRelPath := filepath.Join(filepath.FromSlash(strings.TrimPrefix(route, "/")), "index.html")
-	cachePath := filepath.Join(h.ServerConfig.Server.CacheDir, cacheRelPath)
+	cachePath := pageCachePath(h.ServerConfig.Server.CacheDir, route, language)
 
 	// If re-indexed, invalidate the cache so it gets rebuilt with fresh metadata:
 	if reindexed {
@@ -173,9 +263,18 @@ func (h *RequestHandler) servePage(w http.ResponseWriter, req *http.Request, rou
 		h.errorHandler(w, err, http.StatusInternalServerError)
 		return
 	}
+	if isValid {
+		isValid, err = h.arePageDependenciesUnchanged(cachePath)
+		if err != nil {
+			h.errorHandler(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
 
 	if !isValid {
-		rendered, err := h.renderPage(page.IndexFile, sourceFSPath, fileInfo)
+		generation, renderedAt := h.DBH.IndexGeneration(), time.Now()
+		deps := lib.NewDependencyRecorder()
+		rendered, err := h.renderPage(page.IndexFile, sourceFSPath, fileInfo, deps)
 		if err != nil {
 			h.errorHandler(w, err, http.StatusInternalServerError)
 			return
@@ -184,54 +283,80 @@ func (h *RequestHandler) servePage(w http.ResponseWriter, req *http.Request, rou
 			h.errorHandler(w, err, http.StatusInternalServerError)
 			return
 		}
+		if err := writeRenderDependencies(cachePath, deps.Dependencies()); err != nil {
+			h.errorHandler(w, err, http.StatusInternalServerError)
+			return
+		}
+		h.markPageVerified(cachePath, generation, renderedAt)
 	}
 
 	http.ServeFile(w, req, cachePath)
 }
 
-// reindexPageIfStale checks if the source file is newer than the DB record's updated_at.
-// If so, it re-reads the frontmatter, persists the updated page, and returns it.
+// reindexPageIfStale checks if the source file is newer than the source mtime
+// stored in the index. If so, it re-reads the page sources and persists the
+// page, and returns it. A page that was only touched keeps its updated_at.
 func (h *RequestHandler) reindexPageIfStale(route string, page model.IndexedPage, sourceModTime time.Time) (model.IndexedPage, bool, error) {
-	if !sourceModTime.After(page.UpdatedAt) {
+	if !sourceModTime.After(page.SourceModTime) {
 		return page, false, nil
 	}
 
-	// Source is newer than DB record: re-index the page
+	// Source is newer than DB record: re-index the page, with all its language variants
 	updatedPage, err := lib.ReindexSinglePage(h.siteFS, route, page)
 	if err != nil {
 		return page, false, fmt.Errorf("re-index page %s: %w", route, err)
 	}
 
-	// Apply effective enabled: the raw frontmatter flag is true only if the
-	// parent chain is also enabled. The parent's Enabled in DB already encodes
+	// Apply effective visibility: the raw frontmatter flag and schedule dates
+	// are limited by the parent chain. The parent's values in DB already encode
 	// its full ancestor chain (pre-computed at index time), so one lookup suffices.
-	if updatedPage.Enabled && updatedPage.ParentPageRoute != nil {
+	if updatedPage.ParentPageRoute != nil {
 		parent, found, err := h.DBH.GetPageByRoute(*updatedPage.ParentPageRoute)
 		if err != nil {
 			return page, false, fmt.Errorf("lookup parent for re-index %s: %w", route, err)
 		}
-		if found && !parent.Enabled {
-			updatedPage.Enabled = false
+		if found {
+			updatedPage.InheritVisibility(parent)
 		}
 	}
 
-	if err := h.DBH.ReplacePage(updatedPage); err != nil {
+	// The background index worker may be in the middle of an index run: wait
+	// for it instead of writing into its transaction.
+	var replaced bool
+	err = h.DBH.WithIndexLock(func() error {
+		var err error
+		replaced, err = h.DBH.ReplacePageIfChanged(updatedPage)
+		return err
+	})
+	if err != nil {
 		return page, false, fmt.Errorf("persist re-indexed page %s: %w", route, err)
 	}
+	if !replaced {
+		// touched, but same content:
+		return page, false, nil
+	}
 
 	if h.ErrorLogger != nil {
 		h.ErrorLogger.Info("re-indexed stale page: %s (index file: %s)", route, page.IndexFile)
+		// changed aliases may collide with other routes or aliases:
+		collisions, err := h.DBH.RedirectCollisions(h.ServerConfig.Redirects)
+		if err != nil {
+			return page, false, fmt.Errorf("check redirect collisions of %s: %w", route, err)
+		}
+		for _, warning := range collisions {
+			h.ErrorLogger.Warning("%s", warning)
+		}
 	}
 
 	return updatedPage, true, nil
 }
 
-func (h *RequestHandler) renderPage(indexFile string, sourceFSPath string, fileInfo processor.PageInfo) ([]byte, error) {
+func (h *RequestHandler) renderPage(indexFile string, sourceFSPath string, fileInfo processor.PageInfo, deps *lib.DependencyRecorder) ([]byte, error) {
 	renderer, err := processor.GetProcessor(indexFile)
 	if err != nil {
 		return nil, err
 	}
-	return renderer.RenderFileForServe(h.siteFS, sourceFSPath, fileInfo.AbsSourcePath, h.ServerConfig, fileInfo)
+	return renderer.RenderFileForServe(h.siteFS, sourceFSPath, fileInfo.AbsSourcePath, h.ServerConfig, fileInfo, deps)
 }
 
 func (h *RequestHandler) serveFile(w http.ResponseWriter, req *http.Request, file model.IndexedFile) {
@@ -278,6 +403,149 @@ func isPageCacheValid(cacheFile string, sourceModTime time.Time) (bool, error) {
 	return !cacheInfo.ModTime().Before(sourceModTime), nil
 }
 
+// arePageDependenciesUnchanged checks the render dependencies stored with a
+// cached page: the cache is outdated if a template it was rendered with has
+// changed since, or if one of the index queries it ran returns a different
+// result now. Pages cached without dependencies are outdated, too.
+// The queries only run again after an index change (or a schedule change, see
+// pageVerification), not on every request.
+func (h *RequestHandler) arePageDependenciesUnchanged(cacheFile string) (bool, error) {
+	data, err := os.ReadFile(renderDependenciesPath(cacheFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var deps lib.RenderDependencies
+	if err := json.Unmarshal(data, &deps); err != nil {
+		// just render the page again:
+		return false, nil
+	}
+
+	for _, templateFile := range deps.Templates {
+		info, err := os.Stat(templateFile)
+		if err != nil || info.ModTime().After(deps.RenderedAt) {
+			return false, nil
+		}
+	}
+
+	generation, checkedAt := h.DBH.IndexGeneration(), time.Now()
+	if v, ok := h.verifiedPages.Load(cacheFile); ok {
+		verification := v.(pageVerification)
+		if verification.generation == generation && (verification.validUntil.IsZero() || checkedAt.Before(verification.validUntil)) {
+			return true, nil
+		}
+	}
+	if h.DBH.QueryDependenciesChanged(deps.Queries) {
+		h.verifiedPages.Delete(cacheFile)
+		return false, nil
+	}
+	h.markPageVerified(cacheFile, generation, checkedAt)
+	return true, nil
+}
+
+// markPageVerified records that the index queries of a cached page return the
+// result they returned at the given generation and time.
+func (h *RequestHandler) markPageVerified(cacheFile string, generation uint64, at time.Time) {
+	validUntil, err := h.DBH.NextScheduleChange(at)
+	if err != nil {
+		// check the queries again next time:
+		h.verifiedPages.Delete(cacheFile)
+		return
+	}
+	h.verifiedPages.Store(cacheFile, pageVerification{generation: generation, validUntil: validUntil})
+}
+
+// renderDependenciesPath returns the path of the file that holds the render
+// dependencies of the cached page cacheFile: index.deps.json for index.html.
+func renderDependenciesPath(cacheFile string) string {
+	return strings.TrimSuffix(cacheFile, ".html") + ".deps.json"
+}
+
+func writeRenderDependencies(cacheFile string, deps lib.RenderDependencies) error {
+	data, err := json.Marshal(deps)
+	if err != nil {
+		return fmt.Errorf("marshal render dependencies: %w", err)
+	}
+	return writeCacheFile(renderDependenciesPath(cacheFile), data)
+}
+
+// pageCachePath returns the cache file path of the rendered page at route:
+// index.html, or index.<language>.html for a page rendered in a language.
+func pageCachePath(cacheDir string, route string, language string) string {
+	cacheFile := "index.html"
+	if language != "" {
+		cacheFile = "index." + language + ".html"
+	}
+	cacheRelPath := filepath.Join(filepath.FromSlash(strings.TrimPrefix(route, "/")), cacheFile)
+	return filepath.Join(cacheDir, cacheRelPath)
+}
+
+// removeCachedPage removes the cached page at route in all its languages.
+func removeCachedPage(cacheDir string, route string) error {
+	dir := filepath.Dir(pageCachePath(cacheDir, route, ""))
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "index.") || !strings.HasSuffix(name, ".html") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidatePageCache removes the cached pages at and below the given route, and
+// the cached pages of all its ancestor routes, as those may list it.
+// Cached resized images are kept.
+func InvalidatePageCache(cacheDir string, route string) error {
+	if cacheDir == "" {
+		return nil
+	}
+	route = normalizeRoute(route)
+
+	if route == "/" {
+		entries, err := os.ReadDir(cacheDir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Name() == "_imageResizer" {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(cacheDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := os.RemoveAll(filepath.Join(cacheDir, filepath.FromSlash(strings.TrimPrefix(route, "/")))); err != nil {
+		return err
+	}
+	for ancestor := path.Dir(route); ; ancestor = path.Dir(ancestor) {
+		if err := removeCachedPage(cacheDir, ancestor); err != nil {
+			return err
+		}
+		if ancestor == "/" {
+			break
+		}
+	}
+	return nil
+}
+
 func writeCacheFile(cacheFile string, content []byte) error {
 	dir := filepath.Dir(cacheFile)
 	if err := os.MkdirAll(dir, 0o777); err != nil {
@@ -1,6 +1,68 @@
 package webserver
 
-import "testing"
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+)
+
+func TestMain(m *testing.M) {
+	// the page processors render with the global DBH: keep it off the disk.
+	lib.SetDBPath(":memory:")
+	os.Exit(m.Run())
+}
+
+// setupTestHandler writes the given source files into a new source folder,
+// indexes them into a new index DB, and returns a handler that serves them with
+// the given config. The source folder is the config's SourcePath.
+func setupTestHandler(t *testing.T, config model.Config, sources map[string]string) *RequestHandler {
+	t.Helper()
+	dbh, err := lib.OpenDBH(filepath.Join(t.TempDir(), "pcms-test.db"))
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	t.Cleanup(func() { dbh.Close() })
+
+	sourceDir := t.TempDir()
+	for name, content := range sources {
+		file := filepath.Join(sourceDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	siteFS := os.DirFS(sourceDir)
+	snapshot, err := lib.BuildIncrementalIndexSnapshot(siteFS, nil, false, nil, io.Discard)
+	if err != nil {
+		t.Fatalf("BuildIncrementalIndexSnapshot() error = %v", err)
+	}
+	if err := dbh.BeginIndexRun(); err != nil {
+		t.Fatalf("BeginIndexRun() error = %v", err)
+	}
+	if _, err := dbh.SyncIndex(snapshot); err != nil {
+		t.Fatalf("SyncIndex() error = %v", err)
+	}
+	if err := dbh.CommitIndexRun(); err != nil {
+		t.Fatalf("CommitIndexRun() error = %v", err)
+	}
+
+	config.SourcePath = sourceDir
+	if config.Server.CacheDir == "" {
+		config.Server.CacheDir = t.TempDir()
+	}
+	return NewRequestHandler(config, nil, nil, siteFS, dbh)
+}
 
 func TestNormalizeFileLookupRoute(t *testing.T) {
 	tests := []struct {
@@ -27,3 +89,177 @@ func TestNormalizeFileLookupRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestInvalidatePageCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	cached := []string{
+		pageCachePath(cacheDir, "/", ""),
+		pageCachePath(cacheDir, "/blog", ""),
+		pageCachePath(cacheDir, "/blog/post", ""),
+		pageCachePath(cacheDir, "/blog/post/sub", ""),
+		pageCachePath(cacheDir, "/about", ""),
+		filepath.Join(cacheDir, "_imageResizer", "resized.png"),
+	}
+	for _, file := range cached {
+		if err := writeCacheFile(file, []byte("cached")); err != nil {
+			t.Fatalf("writeCacheFile(%s) error = %v", file, err)
+		}
+	}
+
+	if err := InvalidatePageCache(cacheDir, "/blog/post"); err != nil {
+		t.Fatalf("InvalidatePageCache(/blog/post) error = %v", err)
+	}
+	for file, wantExists := range map[string]bool{
+		cached[0]: false,
+		cached[1]: false,
+		cached[2]: false,
+		cached[3]: false,
+		cached[4]: true,
+		cached[5]: true,
+	} {
+		if _, err := os.Stat(file); (err == nil) != wantExists {
+			t.Fatalf("%s exists = %v, want %v", file, err == nil, wantExists)
+		}
+	}
+
+	if err := InvalidatePageCache(cacheDir, "/"); err != nil {
+		t.Fatalf("InvalidatePageCache(/) error = %v", err)
+	}
+	if _, err := os.Stat(cached[4]); err == nil {
+		t.Fatalf("%s should have been removed", cached[4])
+	}
+	if _, err := os.Stat(cached[5]); err != nil {
+		t.Fatalf("resized images should be kept: %v", err)
+	}
+}
+
+func TestServeTouchedPage(t *testing.T) {
+	h := setupTestHandler(t, model.Config{}, map[string]string{
+		"index.html":       "<p>Home</p>",
+		"about/index.html": "<p>About</p>",
+	})
+	get := func() string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/about/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /about/ = %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+	getPage := func() model.IndexedPage {
+		t.Helper()
+		page, found, err := h.DBH.GetPageByRoute("/about")
+		if err != nil || !found {
+			t.Fatalf("GetPageByRoute(/about) = %v, %v", found, err)
+		}
+		return page
+	}
+	get()
+	indexed := getPage()
+
+	// a touched source is not re-indexed as a changed page, only its mtime is stored:
+	source := filepath.Join(h.ServerConfig.SourcePath, "about", "index.html")
+	touched := time.Now().Add(time.Minute)
+	if err := os.Chtimes(source, touched, touched); err != nil {
+		t.Fatal(err)
+	}
+	if body := get(); body != "<p>About</p>" {
+		t.Fatalf("GET /about/ after touch = %q", body)
+	}
+	page := getPage()
+	if !page.UpdatedAt.Equal(indexed.UpdatedAt) || !page.SourceModTime.After(indexed.SourceModTime) {
+		t.Fatalf("after touch: updated_at %v, source mtime %v, want updated_at %v and a newer mtime", page.UpdatedAt, page.SourceModTime, indexed.UpdatedAt)
+	}
+
+	// a changed source is:
+	if err := os.WriteFile(source, []byte("<p>About us</p>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changed := touched.Add(time.Minute)
+	if err := os.Chtimes(source, changed, changed); err != nil {
+		t.Fatal(err)
+	}
+	if body := get(); !strings.Contains(body, "About us") {
+		t.Fatalf("GET /about/ after change = %q", body)
+	}
+	if page := getPage(); !page.UpdatedAt.After(indexed.UpdatedAt) {
+		t.Fatalf("after change: updated_at %v, want it after %v", page.UpdatedAt, indexed.UpdatedAt)
+	}
+}
+
+func TestServeScheduledPage(t *testing.T) {
+	upcoming := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	h := setupTestHandler(t, model.Config{}, map[string]string{
+		"index.md":                    "---\ntitle: Home\n---\n",
+		"upcoming/index.md":           "---\ntitle: Upcoming\npublishDate: \"" + upcoming + "\"\n---\n",
+		"upcoming/teaser.txt":         "soon",
+		"upcoming/part-1/index.md":    "---\ntitle: Part 1\n---\n",
+		"upcoming/part-1/chapter.txt": "soon",
+		"archive/index.md":            "---\ntitle: Archive\nexpiryDate: \"" + expired + "\"\n---\n",
+		"archive/2020/index.md":       "---\ntitle: 2020\n---\n",
+	})
+
+	// the pages and their files are not served before the publishDate or after
+	// the expiryDate of the page or of one of its parents:
+	for _, route := range []string{"/upcoming/", "/upcoming/teaser.txt", "/upcoming/part-1/", "/upcoming/part-1/chapter.txt", "/archive/", "/archive/2020/"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, route, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("GET %s = %d, want %d", route, rec.Code, http.StatusNotFound)
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET / = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// the children are left out of the sitemap, too:
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil))
+	var doc sitemapURLSet
+	if err := xml.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid sitemap XML: %v\n%s", err, rec.Body.String())
+	}
+	if len(doc.URLs) != 1 || doc.URLs[0].Loc != "/" {
+		t.Fatalf("sitemap urls = %+v, want the home page only", doc.URLs)
+	}
+}
+
+func TestServeRedirects(t *testing.T) {
+	config := model.Config{}
+	config.Server.Prefix = "/site"
+	config.Redirects = []model.RedirectConfig{
+		{From: "/old/*", To: "/*", Status: http.StatusFound},
+		{From: "/about", To: "/imprint", Status: http.StatusMovedPermanently},
+	}
+	h := setupTestHandler(t, config, map[string]string{
+		"index.md":         "---\ntitle: Home\n---\n",
+		"about/index.md":   "---\ntitle: About\naliases: [/ueber-uns]\n---\n",
+		"contact/index.md": "---\ntitle: Contact\naliases: [/kontakt]\n---\n",
+		"imprint/index.md": "---\ntitle: Imprint\naliases: [/kontakt]\n---\n",
+		"robots.txt":       "User-agent: *\n",
+	})
+
+	tests := []struct {
+		route        string
+		wantStatus   int
+		wantLocation string
+	}{
+		// page aliases, the first page by route wins an alias claimed by several pages:
+		{"/ueber-uns", http.StatusMovedPermanently, "/site/about/"},
+		{"/kontakt", http.StatusMovedPermanently, "/site/contact/"},
+		// config rules win over pages, and keep the query string:
+		{"/about/", http.StatusMovedPermanently, "/site/imprint/"},
+		{"/old/robots.txt?v=2", http.StatusFound, "/site/robots.txt?v=2"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.route, nil))
+		if rec.Code != tt.wantStatus || rec.Header().Get("Location") != tt.wantLocation {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.route, rec.Code, rec.Header().Get("Location"), tt.wantStatus, tt.wantLocation)
+		}
+	}
+}
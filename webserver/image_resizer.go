@@ -258,6 +258,28 @@ func computeTargetDimensions(src image.Image, p ResizeParams) (op string, w, h i
 	}
 }
 
+// applyOrientation turns an image with the given EXIF orientation (see
+// model.IndexedFile.Orientation) upright.
+func applyOrientation(src image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(src)
+	case 3:
+		return imaging.Rotate180(src)
+	case 4:
+		return imaging.FlipV(src)
+	case 5:
+		return imaging.Transpose(src)
+	case 6:
+		return imaging.Rotate270(src)
+	case 7:
+		return imaging.Transverse(src)
+	case 8:
+		return imaging.Rotate90(src)
+	}
+	return src
+}
+
 func applyResize(src image.Image, op string, w, h int, p ResizeParams) image.Image {
 	filter := imaging.Lanczos
 	switch op {
@@ -320,41 +342,65 @@ func cachedContentType(cachePath string, params ResizeParams) string {
 }
 
 func (h *RequestHandler) serveResizedImage(w http.ResponseWriter, req *http.Request, rawPath string) {
+	cachePath, contentType, status, err := h.resizeImage(rawPath)
+	if err != nil {
+		h.errorHandler(w, err, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	http.ServeFile(w, req, cachePath)
+}
+
+// RenderResizedImage resizes an image like the image resizer endpoint does, and
+// returns the path of the result in the cache dir and its content type.
+// rawPath is the part of the resizer URL after the /_imageResizer/ prefix:
+// "<params>/<image-path>".
+func (h *RequestHandler) RenderResizedImage(rawPath string) (string, string, error) {
+	cachePath, contentType, _, err := h.resizeImage(rawPath)
+	return cachePath, contentType, err
+}
+
+// resizeImage resizes the image for the given resizer path into the cache dir,
+// unless a valid cached version exists. Returns the cache file path and content
+// type, or an error with the matching HTTP status code.
+func (h *RequestHandler) resizeImage(rawPath string) (string, string, int, error) {
 	idx := strings.Index(rawPath, "/")
 	if idx < 0 {
-		h.errorHandler(w, fmt.Errorf("malformed image resizer path: %q", rawPath), http.StatusBadRequest)
-		return
+		return "", "", http.StatusBadRequest, fmt.Errorf("malformed image resizer path: %q", rawPath)
 	}
 	paramStr := rawPath[:idx]
 	urlTail, err := url.PathUnescape(rawPath[idx+1:])
 	if err != nil {
-		h.errorHandler(w, fmt.Errorf("invalid URL encoding in image path"), http.StatusBadRequest)
-		return
+		return "", "", http.StatusBadRequest, fmt.Errorf("invalid URL encoding in image path")
 	}
 
 	if urlTail == "" {
-		h.errorHandler(w, fmt.Errorf("missing image path in resizer URL"), http.StatusBadRequest)
-		return
+		return "", "", http.StatusBadRequest, fmt.Errorf("missing image path in resizer URL")
 	}
 
 	params, err := parseResizeParams(paramStr)
 	if err != nil {
-		h.errorHandler(w, fmt.Errorf("parse resize params: %w", err), http.StatusBadRequest)
-		return
+		return "", "", http.StatusBadRequest, fmt.Errorf("parse resize params: %w", err)
 	}
 
-	// Only serve files that are indexed in the DB and enabled.
+	// Only serve files that are indexed in the DB and visible.
 	// This enforces the existing content security model and prevents SSRF
 	// by rejecting remote URLs and un-indexed paths alike.
 	fileRoute := normalizeRoute(urlTail)
 	file, found, err := h.DBH.GetFileByRoute(fileRoute)
 	if err != nil {
-		h.errorHandler(w, err, http.StatusInternalServerError)
-		return
+		return "", "", http.StatusInternalServerError, err
 	}
-	if !found || !file.Enabled {
-		h.errorHandler(w, fmt.Errorf("not found: %s", fileRoute), http.StatusNotFound)
-		return
+	if !found {
+		return "", "", http.StatusNotFound, fmt.Errorf("not found: %s", fileRoute)
+	}
+	visible, err := h.isFileVisible(file)
+	if err != nil {
+		return "", "", http.StatusInternalServerError, err
+	}
+	if !visible {
+		return "", "", http.StatusNotFound, fmt.Errorf("not found: %s", fileRoute)
 	}
 
 	fsPath := routeToFSPath(fileRoute)
@@ -364,57 +410,47 @@ func (h *RequestHandler) serveResizedImage(w http.ResponseWriter, req *http.Requ
 
 	info, statErr := fs.Stat(h.siteFS, fsPath)
 	if statErr != nil {
-		h.errorHandler(w, statErr, http.StatusInternalServerError)
-		return
+		return "", "", http.StatusInternalServerError, statErr
 	}
 	if info.Size() > h.ServerConfig.Server.MaxBodySize {
-		h.errorHandler(w, fmt.Errorf("image too large"), http.StatusRequestEntityTooLarge)
-		return
+		return "", "", http.StatusRequestEntityTooLarge, fmt.Errorf("image too large")
 	}
 	sourceModTime := info.ModTime()
 
 	cacheValid, err := isPageCacheValid(cachePath, sourceModTime)
 	if err != nil {
-		h.errorHandler(w, err, http.StatusInternalServerError)
-		return
+		return "", "", http.StatusInternalServerError, err
 	}
 
 	if cacheValid {
-		contentType := cachedContentType(cachePath, params)
-		w.Header().Set("Content-Type", contentType)
-		http.ServeFile(w, req, cachePath)
-		return
+		return cachePath, cachedContentType(cachePath, params), http.StatusOK, nil
 	}
 
 	f, err := h.siteFS.Open(fsPath)
 	if err != nil {
-		h.errorHandler(w, err, http.StatusInternalServerError)
-		return
+		return "", "", http.StatusInternalServerError, err
 	}
 	defer f.Close()
 
 	img, srcFmt, err := image.Decode(f)
 	if err != nil {
-		h.errorHandler(w, err, http.StatusInternalServerError)
-		return
+		return "", "", http.StatusInternalServerError, err
 	}
+	// the resized image has no EXIF data anymore, so it is turned upright:
+	img = applyOrientation(img, file.Orientation)
 
 	op, tw, th := computeTargetDimensions(img, params)
 	resized := applyResize(img, op, tw, th, params)
 
 	data, contentType, err := encodeImage(resized, params, srcFmt)
 	if err != nil {
-		h.errorHandler(w, fmt.Errorf("encode image: %w", err), http.StatusInternalServerError)
-		return
+		return "", "", http.StatusInternalServerError, fmt.Errorf("encode image: %w", err)
 	}
 
 	if err := writeCacheFile(cachePath, data); err != nil {
-		h.errorHandler(w, fmt.Errorf("write image cache: %w", err), http.StatusInternalServerError)
-		return
+		return "", "", http.StatusInternalServerError, fmt.Errorf("write image cache: %w", err)
 	}
 	_ = writeCacheFile(cachePath+".ct", []byte(contentType))
 
-	w.Header().Set("Content-Type", contentType)
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
+	return cachePath, contentType, http.StatusOK, nil
 }
@@ -293,3 +293,31 @@ func TestComputeTargetDimensions(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyOrientation(t *testing.T) {
+	// a 3x1 image, with a white pixel at the left end:
+	src := image.NewGray(image.Rect(0, 0, 3, 1))
+	src.SetGray(0, 0, color.Gray{Y: 255})
+	isMarked := func(img image.Image, x, y int) bool {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return r > 0
+	}
+
+	if got := applyOrientation(src, 1); got != image.Image(src) {
+		t.Fatalf("orientation 1 should return the image as it is")
+	}
+	// 6: rotated 90 degrees clockwise, the left end goes up
+	rotated := applyOrientation(src, 6)
+	if b := rotated.Bounds(); b.Dx() != 1 || b.Dy() != 3 || !isMarked(rotated, 0, 0) {
+		t.Fatalf("orientation 6: bounds %v, top marked %v", b, isMarked(rotated, 0, 0))
+	}
+	// 8: rotated 90 degrees counter-clockwise, the left end goes down
+	rotated = applyOrientation(src, 8)
+	if b := rotated.Bounds(); b.Dx() != 1 || b.Dy() != 3 || !isMarked(rotated, 0, 2) {
+		t.Fatalf("orientation 8: bounds %v, bottom marked %v", b, isMarked(rotated, 0, 2))
+	}
+	// 2: mirrored, the marked pixel goes right
+	if mirrored := applyOrientation(src, 2); !isMarked(mirrored, 2, 0) {
+		t.Fatalf("orientation 2: right pixel not marked")
+	}
+}
@@ -0,0 +1,78 @@
+package webserver
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// requestLanguage determines the language of a request on a multilingual site.
+// A configured language as first path segment ("/de/about/") wins: it is
+// returned with its prefix ("/de"), and the path without it ("/about/").
+// Otherwise, the Accept-Language header decides, falling back to the default
+// language. On a site without languages, the language is "".
+func (h *RequestHandler) requestLanguage(req *http.Request, rawPath string) (string, string, string) {
+	languages := h.ServerConfig.Languages
+	if len(languages) == 0 {
+		return "", "", rawPath
+	}
+
+	segment, _, _ := strings.Cut(strings.TrimPrefix(rawPath, "/"), "/")
+	for _, language := range languages {
+		if strings.ToLower(segment) == language {
+			// "/de" results in "", so that it is redirected to "/de/" like any page
+			return language, "/" + segment, strings.TrimPrefix(rawPath, "/"+segment)
+		}
+	}
+	return negotiateLanguage(req.Header.Get("Accept-Language"), languages), "", rawPath
+}
+
+// negotiateLanguage returns the language of the given ones that matches the
+// Accept-Language header best, or the first one if none matches. A language
+// range matches a language of the same primary tag, too: "de-CH" matches "de",
+// and "de" matches "de-ch".
+func negotiateLanguage(header string, languages []string) string {
+	type languageRange struct {
+		tag     string
+		quality float64
+	}
+	var ranges []languageRange
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if value, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = value
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, languageRange{tag, quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	primaryTag := func(tag string) string {
+		primary, _, _ := strings.Cut(tag, "-")
+		return primary
+	}
+	for _, r := range ranges {
+		for _, language := range languages {
+			if r.tag == language {
+				return language
+			}
+		}
+		for _, language := range languages {
+			if primaryTag(r.tag) == primaryTag(language) {
+				return language
+			}
+		}
+	}
+	return languages[0]
+}
@@ -0,0 +1,62 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"alexi.ch/pcms/model"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	languages := []string{"en", "de", "fr-ch"}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", "en"},
+		{"de", "de"},
+		{"de-CH,de;q=0.9,en;q=0.8", "de"},
+		{"en;q=0.5, de;q=0.7", "de"},
+		{"fr", "fr-ch"},
+		{"it, *;q=0.1", "en"},
+		{"de;q=0, fr-CH", "fr-ch"},
+	}
+	for _, tt := range tests {
+		if got := negotiateLanguage(tt.header, languages); got != tt.want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRequestLanguage(t *testing.T) {
+	config := model.Config{Languages: []string{"en", "de"}}
+	h := NewRequestHandler(config, nil, nil, nil, nil)
+
+	tests := []struct {
+		path           string
+		acceptLanguage string
+		wantLanguage   string
+		wantPrefix     string
+		wantPath       string
+	}{
+		{"/de/about/", "en", "de", "/de", "/about/"},
+		{"/de", "", "de", "/de", ""},
+		{"/about/", "de-AT", "de", "", "/about/"},
+		{"/deutsch/", "", "en", "", "/deutsch/"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Accept-Language", tt.acceptLanguage)
+		language, prefix, path := h.requestLanguage(req, req.URL.Path)
+		if language != tt.wantLanguage || prefix != tt.wantPrefix || path != tt.wantPath {
+			t.Errorf("requestLanguage(%s) = %q, %q, %q, want %q, %q, %q", tt.path, language, prefix, path, tt.wantLanguage, tt.wantPrefix, tt.wantPath)
+		}
+	}
+
+	// a site without languages:
+	h = NewRequestHandler(model.Config{}, nil, nil, nil, nil)
+	if language, prefix, path := h.requestLanguage(httptest.NewRequest(http.MethodGet, "/de/about/", nil), "/de/about/"); language != "" || prefix != "" || path != "/de/about/" {
+		t.Errorf("requestLanguage() without languages = %q, %q, %q", language, prefix, path)
+	}
+}
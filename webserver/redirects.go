@@ -0,0 +1,58 @@
+package webserver
+
+import (
+	"net/http"
+	"strings"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/processor"
+)
+
+// serveRedirect answers the request with a redirect, if a redirect rule of the
+// config, or else the alias of a page, matches the route. Returns false if
+// there is no redirect for the route.
+func (h *RequestHandler) serveRedirect(w http.ResponseWriter, req *http.Request, route string) bool {
+	target, status, found := lib.MatchRedirect(h.ServerConfig.Redirects, route)
+	if !found {
+		pageRoute, isAlias, err := h.DBH.GetAliasTarget(route)
+		if err != nil {
+			h.errorHandler(w, err, http.StatusInternalServerError)
+			return true
+		}
+		if !isAlias {
+			return false
+		}
+		target, status = pageRoute, http.StatusMovedPermanently
+	}
+
+	location := h.redirectLocation(target)
+	if req.URL.RawQuery != "" {
+		separator := "?"
+		if strings.Contains(location, "?") {
+			separator = "&"
+		}
+		location += separator + req.URL.RawQuery
+	}
+	http.Redirect(w, req, location, status)
+	return true
+}
+
+// redirectLocation returns the Location of a redirect target: absolute URLs are
+// used as they are, routes get the server prefix, and page routes the trailing
+// slash of the page URL, to save the extra redirect.
+func (h *RequestHandler) redirectLocation(target string) string {
+	if strings.Contains(target, "://") {
+		return target
+	}
+	targetPath, suffix := target, ""
+	if i := strings.IndexAny(target, "?#"); i >= 0 {
+		targetPath, suffix = target[:i], target[i:]
+	}
+
+	route := normalizeRoute(targetPath)
+	location := processor.AbsUrl(route, h.ServerConfig.Server.Prefix)
+	if _, isPage, err := h.DBH.GetPageByRoute(route); err == nil && isPage && !strings.HasSuffix(location, "/") {
+		location += "/"
+	}
+	return location + suffix
+}
@@ -0,0 +1,98 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/processor"
+)
+
+const searchRoute = "/_search"
+
+// SearchResponse is the JSON document returned by the search endpoint.
+type SearchResponse struct {
+	Query    string         `json:"query"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"pageSize"`
+	Total    int            `json:"total"`
+	Pages    int            `json:"pages"`
+	Results  []SearchResult `json:"results"`
+}
+
+// SearchResult is a single page found by the search endpoint.
+type SearchResult struct {
+	Route string `json:"route"`
+	// the route, prefixed with the server's webroot prefix
+	URL     string `json:"url"`
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
+	// only the fields listed in server.search.metadata_fields
+	Metadata map[string]any `json:"metadata"`
+}
+
+// serveSearch answers full-text searches on /_search?q=...&page=N with JSON.
+// Only enabled pages are searched, and only the configured metadata fields are
+// returned, so the endpoint never exposes more than the rendered site does.
+// On a multilingual site, the results are the pages in the request language,
+// linked with the language prefix of the request, if it has one.
+func (h *RequestHandler) serveSearch(w http.ResponseWriter, req *http.Request, language string, languagePrefix string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.errorHandler(w, fmt.Errorf("method not allowed: %s", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	searchConfig := h.ServerConfig.Server.Search
+	pageSize := searchConfig.PageSize
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+	query := req.URL.Query().Get("q")
+	page, err := strconv.Atoi(req.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	qb := lib.NewPageQueryBuilder(h.DBH).Language(language).WhereFullText(query).PageSize(pageSize)
+	response := SearchResponse{
+		Query:    query,
+		Page:     page,
+		PageSize: pageSize,
+		Total:    qb.Count(),
+		Results:  make([]SearchResult, 0),
+	}
+	response.Pages = (response.Total + pageSize - 1) / pageSize
+
+	for _, p := range qb.Page(page).FetchAll() {
+		metadata := make(map[string]any)
+		for _, field := range searchConfig.MetadataFields {
+			if value, ok := p.Metadata[field]; ok {
+				metadata[field] = value
+			}
+		}
+		response.Results = append(response.Results, SearchResult{
+			Route:    p.Route,
+			URL:      processor.AbsUrl(path.Join("/", languagePrefix, p.Route), h.ServerConfig.Server.Prefix),
+			Title:    p.Title,
+			Snippet:  p.Snippet,
+			Metadata: metadata,
+		})
+	}
+
+	// the snippets are HTML already, no need to escape their <mark> tags once more:
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		h.errorHandler(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,86 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"alexi.ch/pcms/model"
+)
+
+func setupSearchHandler(t *testing.T) *RequestHandler {
+	config := model.Config{}
+	config.Server.Prefix = "/site"
+	config.Server.Search.PageSize = 2
+	config.Server.Search.MetadataFields = []string{"tags"}
+	return setupTestHandler(t, config, map[string]string{
+		"index.md":        "---\ntitle: Home\n---\nWelcome\n",
+		"a/index.md":      "---\ntitle: Apples\ntags: [fruit]\nsecret: s1\n---\nAll about fruit: apples.\n",
+		"b/index.md":      "---\ntitle: Bananas\ntags: [fruit]\n---\nBananas are fruit, too.\n",
+		"c/index.md":      "---\ntitle: Cherries\n---\nCherry fruit.\n",
+		"hidden/index.md": "---\ntitle: Hidden\nenabled: false\n---\nSecret fruit.\n",
+	})
+}
+
+func search(t *testing.T, h *RequestHandler, target string) SearchResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s status = %d, want %d", target, rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Fatalf("GET %s content type = %q", target, ct)
+	}
+	var response SearchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("GET %s: invalid JSON: %v", target, err)
+	}
+	return response
+}
+
+func TestServeSearch(t *testing.T) {
+	h := setupSearchHandler(t)
+
+	// 3 enabled pages match, the disabled one is never returned:
+	response := search(t, h, "/_search?q=fruit")
+	if response.Total != 3 || response.Pages != 2 || response.Page != 1 || len(response.Results) != 2 {
+		t.Fatalf("page 1 = %+v, want 3 total, 2 pages, 2 results", response)
+	}
+	response = search(t, h, "/_search?q=fruit&page=2")
+	if response.Page != 2 || len(response.Results) != 1 {
+		t.Fatalf("page 2 = %+v, want 1 result", response)
+	}
+
+	response = search(t, h, "/_search?q=apples")
+	if len(response.Results) != 1 {
+		t.Fatalf("results = %+v, want 1", response.Results)
+	}
+	result := response.Results[0]
+	if result.Route != "/a" || result.URL != "/site/a" || result.Title != "Apples" {
+		t.Fatalf("result = %+v", result)
+	}
+	if result.Snippet != "All about fruit: <mark>apples</mark>." {
+		t.Fatalf("snippet = %q", result.Snippet)
+	}
+	// only configured metadata fields are exposed:
+	if _, ok := result.Metadata["secret"]; ok || result.Metadata["tags"] == nil {
+		t.Fatalf("metadata = %+v, want tags only", result.Metadata)
+	}
+
+	// an empty query is no error, it just finds nothing:
+	response = search(t, h, "/_search?q=&page=abc")
+	if response.Total != 0 || response.Page != 1 || response.Results == nil {
+		t.Fatalf("empty query = %+v", response)
+	}
+}
+
+func TestServeSearchMethodNotAllowed(t *testing.T) {
+	h := setupSearchHandler(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_search?q=fruit", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
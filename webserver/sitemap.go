@@ -0,0 +1,163 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+	"alexi.ch/pcms/processor"
+)
+
+const (
+	SitemapRoute   = "/sitemap.xml"
+	RobotsTxtRoute = "/robots.txt"
+)
+
+// sitemapChangeFreqs lists the valid <changefreq> values of the sitemap protocol.
+var sitemapChangeFreqs = map[string]bool{
+	"always":  true,
+	"hourly":  true,
+	"daily":   true,
+	"weekly":  true,
+	"monthly": true,
+	"yearly":  true,
+	"never":   true,
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	// the namespace of the language alternates, on a multilingual site
+	XHTMLNamespace string       `xml:"xmlns:xhtml,attr,omitempty"`
+	URLs           []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string                `xml:"loc"`
+	LastMod    string                `xml:"lastmod"`
+	ChangeFreq string                `xml:"changefreq,omitempty"`
+	Priority   string                `xml:"priority,omitempty"`
+	Alternates []sitemapAlternateURL `xml:"xhtml:link"`
+}
+
+// sitemapAlternateURL is the URL of a page in another language.
+type sitemapAlternateURL struct {
+	Rel      string `xml:"rel,attr"`
+	HrefLang string `xml:"hreflang,attr"`
+	Href     string `xml:"href,attr"`
+}
+
+const xhtmlNamespace = "http://www.w3.org/1999/xhtml"
+
+func (h *RequestHandler) serveSitemap(w http.ResponseWriter, req *http.Request) {
+	content, err := h.RenderSitemap(h.ServerConfig.Server.BaseURL)
+	if err != nil {
+		h.errorHandler(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	http.ServeContent(w, req, "sitemap.xml", time.Time{}, bytes.NewReader(content))
+}
+
+func (h *RequestHandler) serveRobotsTxt(w http.ResponseWriter, req *http.Request) {
+	content := h.RenderRobotsTxt(h.ServerConfig.Server.BaseURL)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeContent(w, req, "robots.txt", time.Time{}, bytes.NewReader(content))
+}
+
+// RenderSitemap renders the sitemap.xml of all visible pages, except the ones
+// with a "sitemap: false" front matter property. The last modification date of
+// a page is the time it was last updated in the index. baseURL is prepended to
+// the page URLs, see RenderFeed.
+//
+// On a multilingual site, each page is listed in every language, below its
+// language prefix and with its language variant's front matter. The entries
+// link to the page in the other languages, and to the route without prefix
+// (which negotiates the language) as "x-default".
+func (h *RequestHandler) RenderSitemap(baseURL string) ([]byte, error) {
+	urlSet := sitemapURLSet{URLs: make([]sitemapURL, 0)}
+	languages := h.ServerConfig.Languages
+	if len(languages) == 0 {
+		for _, page := range lib.NewPageQueryBuilder(h.DBH).OrderBy("route", "asc").FetchAll() {
+			entry, ok := pageSitemapURL(page)
+			if !ok {
+				continue
+			}
+			entry.Loc = h.pageURL(baseURL, page.Route)
+			urlSet.URLs = append(urlSet.URLs, entry)
+		}
+	} else {
+		urlSet.XHTMLNamespace = xhtmlNamespace
+		// the pages in every language, by route:
+		variants := make([]map[string]model.IndexedPage, len(languages))
+		for i, language := range languages {
+			variants[i] = make(map[string]model.IndexedPage)
+			for _, page := range lib.NewPageQueryBuilder(h.DBH).Language(language).FetchAll() {
+				variants[i][page.Route] = page
+			}
+		}
+		for _, page := range lib.NewPageQueryBuilder(h.DBH).OrderBy("route", "asc").FetchAll() {
+			entries := make([]sitemapURL, 0, len(languages))
+			alternates := make([]sitemapAlternateURL, 0, len(languages)+1)
+			for i, language := range languages {
+				variant, found := variants[i][page.Route]
+				if !found {
+					continue
+				}
+				entry, ok := pageSitemapURL(variant)
+				if !ok {
+					continue
+				}
+				entry.Loc = h.pageURL(baseURL, path.Join("/", language, page.Route))
+				entries = append(entries, entry)
+				alternates = append(alternates, sitemapAlternateURL{Rel: "alternate", HrefLang: language, Href: entry.Loc})
+			}
+			if len(entries) == 0 {
+				continue
+			}
+			alternates = append(alternates, sitemapAlternateURL{Rel: "alternate", HrefLang: "x-default", Href: h.pageURL(baseURL, page.Route)})
+			for _, entry := range entries {
+				entry.Alternates = alternates
+				urlSet.URLs = append(urlSet.URLs, entry)
+			}
+		}
+	}
+
+	content, err := encodeXMLDocument(urlSet)
+	if err != nil {
+		return nil, fmt.Errorf("encode sitemap: %w", err)
+	}
+	return content, nil
+}
+
+// RenderRobotsTxt renders a robots.txt that allows everything and points to the
+// sitemap.
+func (h *RequestHandler) RenderRobotsTxt(baseURL string) []byte {
+	sitemapURL := absoluteURL(baseURL, processor.AbsUrl(SitemapRoute, h.ServerConfig.Server.Prefix))
+	return []byte("User-agent: *\nDisallow:\n\nSitemap: " + sitemapURL + "\n")
+}
+
+// pageSitemapURL returns the sitemap entry of a page, without its location.
+// The "sitemap" front matter property is either false, to leave the page out,
+// or a map with the optional "priority" (0.0 - 1.0) and "changefreq" entries.
+// Invalid values are ignored.
+func pageSitemapURL(page model.IndexedPage) (sitemapURL, bool) {
+	entry := sitemapURL{LastMod: page.UpdatedAt.UTC().Format(time.RFC3339)}
+	switch value := page.Metadata["sitemap"].(type) {
+	case bool:
+		return entry, value
+	case map[string]any:
+		if changeFreq, ok := value["changefreq"].(string); ok && sitemapChangeFreqs[changeFreq] {
+			entry.ChangeFreq = changeFreq
+		}
+		if priority, ok := value["priority"].(float64); ok && priority >= 0 && priority <= 1 {
+			entry.Priority = strconv.FormatFloat(priority, 'f', -1, 64)
+		}
+	}
+	return entry, true
+}
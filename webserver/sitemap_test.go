@@ -0,0 +1,131 @@
+package webserver
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"alexi.ch/pcms/model"
+)
+
+func setupSitemapHandler(t *testing.T) *RequestHandler {
+	upcoming := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	config := model.Config{}
+	config.Server.Prefix = "/site"
+	return setupTestHandler(t, config, map[string]string{
+		"index.md":            "---\ntitle: Home\nsitemap:\n  priority: 1\n  changefreq: daily\n---\n",
+		"about/index.md":      "---\ntitle: About\naliases: [/ueber-uns]\nsitemap:\n  priority: 2\n  changefreq: sometimes\n---\n",
+		"hidden/index.md":     "---\ntitle: Hidden\nenabled: false\n---\n",
+		"imprint/index.md":    "---\ntitle: Imprint\nsitemap: false\n---\n",
+		"upcoming/index.md":   "---\ntitle: Upcoming\npublishDate: \"" + upcoming + "\"\n---\n# Upcoming\n",
+		"upcoming/teaser.txt": "soon",
+		// a real robots.txt in the source tree:
+		"robots.txt": "User-agent: *\nDisallow: /\n",
+	})
+}
+
+func TestServeSitemap(t *testing.T) {
+	h := setupSitemapHandler(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/sitemap.xml", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/xml; charset=utf-8" {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	var doc sitemapURLSet
+	if err := xml.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid sitemap XML: %v\n%s", err, rec.Body.String())
+	}
+	// disabled, unpublished and opted-out pages are missing, invalid values are ignored:
+	if len(doc.URLs) != 2 {
+		t.Fatalf("urls = %+v, want 2", doc.URLs)
+	}
+	home, about := doc.URLs[0], doc.URLs[1]
+	// without server.base_url, the locations are root-relative, whatever the Host header says:
+	if home.Loc != "/site/" || home.Priority != "1" || home.ChangeFreq != "daily" || home.LastMod == "" {
+		t.Fatalf("home = %+v", home)
+	}
+	if about.Loc != "/site/about/" || about.Priority != "" || about.ChangeFreq != "" {
+		t.Fatalf("about = %+v", about)
+	}
+}
+
+func TestRenderRobotsTxt(t *testing.T) {
+	h := setupSitemapHandler(t)
+	want := "User-agent: *\nDisallow:\n\nSitemap: https://example.com/site/sitemap.xml\n"
+	if got := string(h.RenderRobotsTxt("https://example.com")); got != want {
+		t.Fatalf("RenderRobotsTxt() = %q, want %q", got, want)
+	}
+}
+
+func TestServeRobotsTxtFromSource(t *testing.T) {
+	h := setupSitemapHandler(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "User-agent: *\nDisallow: /\n" {
+		t.Fatalf("GET /robots.txt = %d %q, want the source file", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServeSitemapLanguages(t *testing.T) {
+	config := model.Config{Languages: []string{"en", "de"}}
+	config.Server.BaseURL = "https://example.com"
+	h := setupTestHandler(t, config, map[string]string{
+		"index.md":            "---\ntitle: Home\n---\n",
+		"index.de.md":         "---\ntitle: Startseite\nsitemap:\n  priority: 1\n---\n",
+		"about/index.md":      "---\ntitle: About\n---\n",
+		"imprint/index.md":    "---\ntitle: Imprint\nsitemap: false\n---\n",
+		"imprint/index.de.md": "---\ntitle: Impressum\n---\n",
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var doc struct {
+		URLs []struct {
+			Loc        string `xml:"loc"`
+			Priority   string `xml:"priority"`
+			Alternates []struct {
+				HrefLang string `xml:"hreflang,attr"`
+				Href     string `xml:"href,attr"`
+			} `xml:"http://www.w3.org/1999/xhtml link"`
+		} `xml:"url"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid sitemap XML: %v\n%s", err, rec.Body.String())
+	}
+	// every page in every language, with the front matter of its variant: the
+	// imprint is only listed in German, as the English page opts out.
+	want := []struct {
+		loc        string
+		priority   string
+		alternates string
+	}{
+		{"https://example.com/en/", "", "en=https://example.com/en/ de=https://example.com/de/ x-default=https://example.com/"},
+		{"https://example.com/de/", "1", "en=https://example.com/en/ de=https://example.com/de/ x-default=https://example.com/"},
+		{"https://example.com/en/about/", "", "en=https://example.com/en/about/ de=https://example.com/de/about/ x-default=https://example.com/about/"},
+		{"https://example.com/de/about/", "", "en=https://example.com/en/about/ de=https://example.com/de/about/ x-default=https://example.com/about/"},
+		{"https://example.com/de/imprint/", "", "de=https://example.com/de/imprint/ x-default=https://example.com/imprint/"},
+	}
+	if len(doc.URLs) != len(want) {
+		t.Fatalf("urls = %+v, want %d", doc.URLs, len(want))
+	}
+	for i, w := range want {
+		u := doc.URLs[i]
+		alternates := make([]string, 0, len(u.Alternates))
+		for _, a := range u.Alternates {
+			alternates = append(alternates, a.HrefLang+"="+a.Href)
+		}
+		if u.Loc != w.loc || u.Priority != w.priority || strings.Join(alternates, " ") != w.alternates {
+			t.Errorf("url %d = %s %q %v, want %s %q %s", i, u.Loc, u.Priority, alternates, w.loc, w.priority, w.alternates)
+		}
+	}
+}
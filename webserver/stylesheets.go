@@ -0,0 +1,134 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"alexi.ch/pcms/model"
+	"alexi.ch/pcms/processor"
+)
+
+// stylesheetCacheDir is the folder below server.cache_dir that holds the
+// stylesheets compiled from .scss files.
+const stylesheetCacheDir = "_scss"
+
+// serveStylesheet serves the stylesheet compiled from the .scss source of a
+// .css route. Returns false if the route has no .scss source.
+func (h *RequestHandler) serveStylesheet(w http.ResponseWriter, req *http.Request, cssRoute string) bool {
+	cachePath, found, err := h.RenderStylesheet(cssRoute)
+	if !found {
+		return false
+	}
+	if err != nil {
+		h.errorHandler(w, err, http.StatusInternalServerError)
+		return true
+	}
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	http.ServeFile(w, req, cachePath)
+	return true
+}
+
+// RenderStylesheet compiles the .scss source of a .css route ("/css/main.css"
+// for "/css/main.scss") into the cache dir, unless a valid cached version
+// exists, and returns the cache file path. The .scss source must be indexed and
+// visible, and must not be a partial. found is false if there is no such source.
+//
+// The sass binary configured in processors.scss.sass_bin compiles the
+// stylesheet, or the built-in compiler if none is set. The cached stylesheet is
+// compiled again when the source or one of the partials it imports changes.
+func (h *RequestHandler) RenderStylesheet(cssRoute string) (string, bool, error) {
+	if !strings.HasSuffix(cssRoute, ".css") {
+		return "", false, nil
+	}
+	scssRoute := strings.TrimSuffix(cssRoute, ".css") + ".scss"
+	if processor.IsScssPartial(scssRoute) {
+		return "", false, nil
+	}
+	file, found, err := h.DBH.GetFileByRoute(scssRoute)
+	if err != nil {
+		return "", true, err
+	}
+	if !found {
+		return "", false, nil
+	}
+	visible, err := h.isFileVisible(file)
+	if err != nil {
+		return "", true, err
+	}
+	if !visible {
+		return "", false, nil
+	}
+
+	cachePath := filepath.Join(h.ServerConfig.Server.CacheDir, stylesheetCacheDir, filepath.FromSlash(strings.TrimPrefix(cssRoute, "/")))
+	valid, err := h.isStylesheetCacheValid(cachePath)
+	if err != nil {
+		return "", true, err
+	}
+	if valid {
+		return cachePath, true, nil
+	}
+
+	// the external sass binary needs the sources on disk:
+	sourceDir := h.ServerConfig.SourcePath
+	if h.ServerConfig.ServeMode == model.SERVE_MODE_EMBEDDED_DOC {
+		sourceDir = ""
+	}
+	css, sources, err := processor.CompileScss(h.siteFS, routeToFSPath(scssRoute), h.ServerConfig.Processors.Scss.SassBin, sourceDir, h.ServerConfig.ProcessorTimeout())
+	if err != nil {
+		return "", true, err
+	}
+	if err := writeCacheFile(cachePath, css); err != nil {
+		return "", true, fmt.Errorf("write stylesheet cache: %w", err)
+	}
+	data, err := json.Marshal(sources)
+	if err != nil {
+		return "", true, err
+	}
+	if err := writeCacheFile(stylesheetSourcesPath(cachePath), data); err != nil {
+		return "", true, fmt.Errorf("write stylesheet cache: %w", err)
+	}
+	return cachePath, true, nil
+}
+
+// isStylesheetCacheValid reports whether the cached stylesheet is newer than
+// all the sources it was compiled from.
+func (h *RequestHandler) isStylesheetCacheValid(cachePath string) (bool, error) {
+	cacheInfo, err := os.Stat(cachePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	data, err := os.ReadFile(stylesheetSourcesPath(cachePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var sources []string
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return false, nil
+	}
+	for _, source := range sources {
+		info, err := fs.Stat(h.siteFS, source)
+		if err != nil || info.ModTime().After(cacheInfo.ModTime()) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// stylesheetSourcesPath returns the path of the file listing the sources of the
+// cached stylesheet: main.css.sources.json for main.css.
+func stylesheetSourcesPath(cachePath string) string {
+	return cachePath + ".sources.json"
+}
@@ -0,0 +1,86 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"alexi.ch/pcms/lib"
+	"alexi.ch/pcms/model"
+)
+
+func TestServeStylesheet(t *testing.T) {
+	dbh, err := lib.OpenDBH(filepath.Join(t.TempDir(), "pcms-scss-test.db"))
+	if err != nil {
+		t.Fatalf("OpenDBH() error = %v", err)
+	}
+	t.Cleanup(func() { dbh.Close() })
+
+	sourceDir := t.TempDir()
+	sources := map[string]string{
+		"css/main.scss":  "@import \"vars\";\nbody { color: $text; }\n",
+		"css/_vars.scss": "$text: #111;\n",
+	}
+	for name, content := range sources {
+		if err := os.MkdirAll(filepath.Join(sourceDir, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := "/"
+	if err := dbh.ReplacePage(model.IndexedPage{Route: "/", IndexFile: "index.md", Enabled: true}); err != nil {
+		t.Fatalf("ReplacePage() error = %v", err)
+	}
+	if err := dbh.ReplacePage(model.IndexedPage{Route: "/css", ParentPageRoute: &root, Enabled: true}); err != nil {
+		t.Fatalf("ReplacePage() error = %v", err)
+	}
+	for _, name := range []string{"main.scss", "_vars.scss"} {
+		if err := dbh.ReplaceFile(model.IndexedFile{Route: "/css/" + name, ParentPageRoute: "/css", FileName: name, Enabled: true}); err != nil {
+			t.Fatalf("ReplaceFile() error = %v", err)
+		}
+	}
+
+	config := model.Config{}
+	config.Server.CacheDir = t.TempDir()
+	h := NewRequestHandler(config, nil, nil, os.DirFS(sourceDir), dbh)
+
+	get := func(route string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, route, nil))
+		return rec
+	}
+
+	rec := get("/css/main.css")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "color: #111;") {
+		t.Fatalf("GET /css/main.css = %d %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/css; charset=utf-8" {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	// the sources and partials are not served:
+	for _, route := range []string{"/css/main.scss", "/css/_vars.scss", "/css/_vars.css"} {
+		if rec := get(route); rec.Code != http.StatusNotFound {
+			t.Fatalf("GET %s = %d, want %d", route, rec.Code, http.StatusNotFound)
+		}
+	}
+
+	// a changed partial invalidates the cached stylesheet:
+	partial := filepath.Join(sourceDir, "css", "_vars.scss")
+	if err := os.WriteFile(partial, []byte("$text: #222;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(partial, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get("/css/main.css"); !strings.Contains(rec.Body.String(), "color: #222;") {
+		t.Fatalf("GET /css/main.css after a partial change = %q", rec.Body.String())
+	}
+}